@@ -0,0 +1,167 @@
+// Package artifacts keeps built function binaries as content
+// addressed artifacts that can be shared between nodes
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Errors returned by stores
+var (
+	ErrNotFound      = errors.New("artifact not found")
+	ErrNotConfigured = errors.New("no artifact store configured")
+)
+
+// Metadata describes a stored binary. Digest is the hex encoded
+// sha256 of the binary and Key the binary cache key it was built for
+type Metadata struct {
+	Digest    string    `json:"digest"`
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	Call      string    `json:"call"`
+	GoVersion string    `json:"go_version"`
+	Commit    string    `json:"commit,omitempty"`
+	Node      string    `json:"node,omitempty"`
+	Size      int64     `json:"size"`
+	Created   time.Time `json:"created"`
+}
+
+// Backend is somewhere artifacts are kept
+type Backend interface {
+	// Put stores the binary read from r. The metadata's digest, if
+	// set, must match the binary. Digest and size are set on return
+	Put(ctx context.Context, m *Metadata, r io.Reader) error
+	// Get returns the binary with the given digest
+	Get(ctx context.Context, digest string) (io.ReadCloser, error)
+	// Lookup returns the metadata of the binary stored under the
+	// given cache key
+	Lookup(ctx context.Context, key string) (*Metadata, error)
+}
+
+// DigestError is returned when a binary doesn't match the digest it
+// was stored under
+type DigestError struct {
+	Expected string
+	Actual   string
+}
+
+// Error implements the error interface
+func (d *DigestError) Error() string {
+	return fmt.Sprintf("digest mismatch: expected %s got %s", d.Expected, d.Actual)
+}
+
+// ValidDigest reports whether the given digest is a hex encoded sha256
+func ValidDigest(digest string) bool {
+	b, err := hex.DecodeString(digest)
+	return err == nil && len(b) == sha256.Size
+}
+
+// bucket is a flat store of named objects that artifacts are laid out
+// in by objects
+type bucket interface {
+	get(ctx context.Context, name string) (io.ReadCloser, error)
+	put(ctx context.Context, name string, r io.ReadSeeker, size int64) error
+}
+
+// objects keeps binaries in a bucket by digest, with the metadata of
+// each kept by cache key
+type objects struct {
+	b bucket
+}
+
+// blobName returns the name of the object holding the given binary
+func blobName(digest string) string {
+	return "sha256/" + digest
+}
+
+// keyName returns the name of the object holding the metadata of the
+// binary stored under the given cache key
+func keyName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "keys/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// Put implements Backend. The binary is spooled to a temporary file
+// so its digest is verified before anything is stored
+func (o *objects) Put(ctx context.Context, m *Metadata, r io.Reader) error {
+	f, err := ioutil.TempFile("", "artifact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	if m.Digest != "" && m.Digest != digest {
+		return &DigestError{Expected: m.Digest, Actual: digest}
+	}
+	m.Digest, m.Size = digest, size
+	if m.Created.IsZero() {
+		m.Created = time.Now().UTC()
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := o.b.put(ctx, blobName(digest), f, size); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return o.b.put(ctx, keyName(m.Key), bytes.NewReader(b), int64(len(b)))
+}
+
+// Get implements Backend
+func (o *objects) Get(ctx context.Context, digest string) (io.ReadCloser, error) {
+	if !ValidDigest(digest) {
+		return nil, ErrNotFound
+	}
+	return o.b.get(ctx, blobName(digest))
+}
+
+// Lookup implements Backend
+func (o *objects) Lookup(ctx context.Context, key string) (*Metadata, error) {
+	rc, err := o.b.get(ctx, keyName(key))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var m Metadata
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// transfer copies the binary stored under the given cache key from
+// src to dst, verifying its digest
+func transfer(ctx context.Context, dst, src Backend, key string) (*Metadata, error) {
+	m, err := src.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := src.Get(ctx, m.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := dst.Put(ctx, m, rc); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,91 @@
+package artifacts
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testBinary writes a binary to the given directory and returns its path
+func testBinary(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "bin")
+	if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestStore_Binary verifies a binary added on one node is pulled
+// through the shared remote by another
+func TestStore_Binary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	remote, err := NewDir(filepath.Join(dir, "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewStore(filepath.Join(dir, "a"), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewStore(filepath.Join(dir, "b"), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := b.Binary(ctx, "github.com/briandowns/smile.Greet().1.9.2"); err != ErrNotFound {
+		t.Fatalf("expected not found got %v", err)
+	}
+
+	m := &Metadata{Key: "github.com/briandowns/smile.Greet().1.9.2", URL: "github.com/briandowns/smile", Node: "a"}
+	if err := a.Add(ctx, m, testBinary(t, dir, "binary")); err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd" || m.Size != 6 {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+	path, err := b.Binary(ctx, m.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != b.Path(m.Digest) {
+		t.Errorf("expected %s got %s", b.Path(m.Digest), path)
+	}
+	if c, _ := ioutil.ReadFile(path); string(c) != "binary" {
+		t.Errorf("unexpected binary %q", c)
+	}
+	ms, err := b.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms[0].Node != "a" || ms[0].Digest != m.Digest {
+		t.Errorf("unexpected artifacts %+v", ms)
+	}
+}
+
+// TestPut_DigestMismatch verifies a binary not matching its digest
+// isn't stored
+func TestPut_DigestMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Metadata{Key: "k", Digest: strings.Repeat("0", 64)}
+	if _, ok := s.Put(context.Background(), m, strings.NewReader("binary")).(*DigestError); !ok {
+		t.Error("expected digest error")
+	}
+	if _, err := s.Lookup(context.Background(), "k"); err != ErrNotFound {
+		t.Errorf("expected not found got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package artifacts
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// dir is a bucket in a directory
+type dir struct {
+	path string
+}
+
+// NewDir creates a backend keeping artifacts in the given directory,
+// such as a mount shared by the nodes
+func NewDir(path string) (Backend, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &objects{b: &dir{path: path}}, nil
+}
+
+// file returns the path of the named object
+func (d *dir) file(name string) string {
+	return filepath.Join(d.path, filepath.FromSlash(name))
+}
+
+// get implements bucket
+func (d *dir) get(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(d.file(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// put implements bucket. Objects are written to a temporary file
+// and renamed into place so they're never seen partly written
+func (d *dir) put(ctx context.Context, name string, r io.ReadSeeker, size int64) error {
+	path := d.file(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
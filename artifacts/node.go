@@ -0,0 +1,107 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MetadataHeader carries the JSON metadata of a binary put to a node
+const MetadataHeader = "X-Sky-Island-Artifact"
+
+// NodePath is the path of the artifact endpoints of a node
+const NodePath = "/api/v1/cluster/artifacts"
+
+// node is the artifact store of another node, reached through its
+// API with the given token header
+type node struct {
+	url    string
+	header string
+	token  string
+	client *http.Client
+}
+
+// NewNode creates a backend keeping artifacts in the store of the
+// node at the given URL, authenticating with the given header and
+// token
+func NewNode(url, header, token string) Backend {
+	return &node{
+		url:    strings.TrimSuffix(url, "/") + NodePath,
+		header: header,
+		token:  token,
+		client: &http.Client{},
+	}
+}
+
+// do sends a request to the node, returning ErrNotFound for a 404 and
+// an error for any response other than a 200
+func (n *node) do(ctx context.Context, method, url string, body io.Reader, m *Metadata) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(n.header, n.token)
+	if m != nil {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(MetadataHeader, string(b))
+	}
+	res, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res, nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	return nil, fmt.Errorf("node: %s: %s", res.Status, strings.TrimSpace(string(b)))
+}
+
+// Put implements Backend
+func (n *node) Put(ctx context.Context, m *Metadata, r io.Reader) error {
+	res, err := n.do(ctx, http.MethodPut, n.url, r, m)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(m)
+}
+
+// Get implements Backend
+func (n *node) Get(ctx context.Context, digest string) (io.ReadCloser, error) {
+	if !ValidDigest(digest) {
+		return nil, ErrNotFound
+	}
+	res, err := n.do(ctx, http.MethodGet, n.url+"/"+digest, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Lookup implements Backend
+func (n *node) Lookup(ctx context.Context, key string) (*Metadata, error) {
+	res, err := n.do(ctx, http.MethodGet, n.url+"?key="+url.QueryEscape(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var m Metadata
+	if err := json.NewDecoder(res.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
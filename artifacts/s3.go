@@ -0,0 +1,73 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3 is a bucket in an S3 compatible store, such as MinIO, addressed
+// by path so any endpoint works
+type s3 struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3 creates a backend keeping artifacts in the given bucket of
+// the S3 compatible store at endpoint
+func NewS3(endpoint, bucket, region, accessKey, secretKey string) (Backend, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || u.Path != "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q, want scheme://host[:port]", endpoint)
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:       u.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &objects{b: &s3{client: client, bucket: bucket}}, nil
+}
+
+// get implements bucket
+func (s *s3) get(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	// the object is requested by the first call on it
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s3Error(err)
+	}
+	return obj, nil
+}
+
+// put implements bucket
+func (s *s3) put(ctx context.Context, name string, r io.ReadSeeker, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, name, r, size, minio.PutObjectOptions{})
+	return s3Error(err)
+}
+
+// s3Error returns ErrNotFound for missing objects, otherwise the
+// given error
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return fmt.Errorf("s3: %v", err)
+}
@@ -0,0 +1,172 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/briandowns/sky-island/cluster"
+	"github.com/briandowns/sky-island/config"
+)
+
+// Store is the node's own artifact store, kept in a directory, and
+// the remote backend, if any, artifacts are shared through
+type Store struct {
+	local  *objects
+	dir    *dir
+	remote Backend
+}
+
+// NewStore creates a new value of type Store pointer keeping
+// artifacts in the given directory. A nil remote keeps artifacts on
+// this node only
+func NewStore(path string, remote Backend) (*Store, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	d := &dir{path: path}
+	return &Store{local: &objects{b: d}, dir: d, remote: remote}, nil
+}
+
+// Remote returns the remote backend, nil if there's none
+func (s *Store) Remote() Backend {
+	return s.remote
+}
+
+// Path returns the path of the binary with the given digest
+func (s *Store) Path(digest string) string {
+	return s.dir.file(blobName(digest))
+}
+
+// Put implements Backend
+func (s *Store) Put(ctx context.Context, m *Metadata, r io.Reader) error {
+	return s.local.Put(ctx, m, r)
+}
+
+// Get implements Backend
+func (s *Store) Get(ctx context.Context, digest string) (io.ReadCloser, error) {
+	return s.local.Get(ctx, digest)
+}
+
+// Lookup implements Backend
+func (s *Store) Lookup(ctx context.Context, key string) (*Metadata, error) {
+	return s.local.Lookup(ctx, key)
+}
+
+// Binary returns the path of the binary stored under the given cache
+// key. A binary only the remote has is pulled first
+func (s *Store) Binary(ctx context.Context, key string) (string, error) {
+	m, err := s.local.Lookup(ctx, key)
+	switch {
+	case err == nil:
+		if _, err := os.Stat(s.Path(m.Digest)); err == nil {
+			return s.Path(m.Digest), nil
+		}
+	case err != ErrNotFound:
+		return "", err
+	}
+	if s.remote == nil {
+		return "", ErrNotFound
+	}
+	if m, err = s.Pull(ctx, key, s.remote); err != nil {
+		return "", err
+	}
+	return s.Path(m.Digest), nil
+}
+
+// Add stores the binary at the given path and pushes it to the
+// remote. The metadata's digest is set once the binary is stored
+// here, even if pushing it fails
+func (s *Store) Add(ctx context.Context, m *Metadata, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.local.Put(ctx, m, f); err != nil {
+		m.Digest = ""
+		return err
+	}
+	if s.remote == nil {
+		return nil
+	}
+	_, err = s.Push(ctx, m.Key, s.remote)
+	return err
+}
+
+// Push copies the binary stored under the given cache key to dst
+func (s *Store) Push(ctx context.Context, key string, dst Backend) (*Metadata, error) {
+	return transfer(ctx, dst, s.local, key)
+}
+
+// Pull copies the binary stored under the given cache key from src
+func (s *Store) Pull(ctx context.Context, key string, src Backend) (*Metadata, error) {
+	return transfer(ctx, s.local, src, key)
+}
+
+// List returns the metadata of every stored binary sorted by cache key
+func (s *Store) List(ctx context.Context) ([]*Metadata, error) {
+	fis, err := ioutil.ReadDir(s.dir.file("keys"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ms []*Metadata
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		rc, err := s.dir.get(ctx, "keys/"+fi.Name())
+		if err != nil {
+			return nil, err
+		}
+		var m Metadata
+		err = json.NewDecoder(rc).Decode(&m)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, &m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Key < ms[j].Key })
+	return ms, nil
+}
+
+// FromConfig creates the store configured by the artifact store
+// section, in the state directory unless a directory is given. No
+// store is kept without either
+func FromConfig(conf *config.Config) (*Store, error) {
+	var path string
+	var remote Backend
+	if conf.ArtifactStore != nil {
+		path = conf.ArtifactStore.Dir
+		if r := conf.ArtifactStore.Remote; r != nil {
+			var err error
+			switch r.Type {
+			case config.StoreDir:
+				remote, err = NewDir(r.Dir)
+			case config.StoreS3:
+				remote, err = NewS3(r.URL, r.Bucket, r.Region, r.AccessKeyID, r.SecretAccessKey)
+			case config.StoreNode:
+				remote = NewNode(r.URL, cluster.TokenHeader, conf.Cluster.Token)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if path == "" && conf.StateDir != "" {
+		path = filepath.Join(conf.StateDir, "artifacts")
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return NewStore(path, remote)
+}
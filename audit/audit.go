@@ -0,0 +1,388 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Files, in the store's directory, entries are appended to
+const (
+	invocationsFile = "invocations.ndjson"
+	actionsFile     = "actions.ndjson"
+)
+
+// DefaultRetention is how long entries are kept if not configured
+const DefaultRetention = 30 * 24 * time.Hour
+
+// compactInterval is how often expired entries are dropped
+const compactInterval = time.Hour
+
+// Statuses of an invocation or admin action
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusTimeout = "timeout"
+)
+
+// Invocation records a single run of a function
+type Invocation struct {
+	ID         string    `json:"id"`
+	Caller     string    `json:"caller"`
+	Function   string    `json:"function,omitempty"`
+	URL        string    `json:"url"`
+	Commit     string    `json:"commit,omitempty"`
+	Jail       string    `json:"jail"`
+	IP         string    `json:"ip,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	ExitCode   int       `json:"exit_code"`
+	OutputSize int       `json:"output_size"`
+	CacheHit   bool      `json:"cache_hit"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Action records an admin API call that changed the system
+type Action struct {
+	Time   time.Time `json:"time"`
+	Caller string    `json:"caller"`
+	Action string    `json:"action"`
+	Target string    `json:"target,omitempty"`
+	Status string    `json:"status"`
+	Code   int       `json:"code"`
+}
+
+// Query filters invocations. Function matches the registered name
+// or repo URL. Zero fields match every invocation
+type Query struct {
+	Function string
+	Status   string
+	Since    time.Time
+	Limit    int
+}
+
+// match checks whether the given invocation matches the query
+func (q *Query) match(inv *Invocation) bool {
+	if q.Function != "" && q.Function != inv.Function && q.Function != inv.URL {
+		return false
+	}
+	if q.Status != "" && q.Status != inv.Status {
+		return false
+	}
+	return q.Since.IsZero() || !inv.StartedAt.Before(q.Since)
+}
+
+// Store holds the invocations and admin actions recorded within the
+// retention period, appending each to a file in the given directory
+// as a line of JSON. Only the newest tailSize entries of each are
+// kept in memory, older ones are read from the files when queried
+type Store struct {
+	mu          sync.RWMutex
+	dir         string
+	retention   time.Duration
+	compacted   time.Time
+	invocations []*Invocation
+	actions     []*Action
+
+	// invocationsSpilled and actionsSpilled report whether the files
+	// hold entries older than those kept in memory
+	invocationsSpilled bool
+	actionsSpilled     bool
+}
+
+// tailSize is how many entries of each kind are kept in memory
+const tailSize = 1000
+
+// NewStore creates a new value of type Store pointer and loads the
+// newest entries within the retention period from the given
+// directory. An empty directory keeps the store in memory only, up
+// to tailSize entries of each kind
+func NewStore(dir string, retention time.Duration) (*Store, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	s := &Store{dir: dir, retention: retention}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s, s.compact()
+}
+
+// readLines calls fn for each JSON value in the given file until
+// the end of the file. A missing file has no values. A partial value
+// ending the file, left by a crash while appending, is skipped and
+// reported
+func readLines(path string, fn func(*json.Decoder) error) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		if err := fn(dec); err != nil {
+			switch err {
+			case io.EOF:
+				return false, nil
+			case io.ErrUnexpectedEOF:
+				return true, nil
+			}
+			return false, err
+		}
+	}
+}
+
+// RecordInvocation records the given invocation
+func (s *Store) RecordInvocation(inv *Invocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invocations = append(s.invocations, inv)
+	if len(s.invocations) > tailSize {
+		s.invocations = s.invocations[1:]
+		s.invocationsSpilled = true
+	}
+	if err := s.append(invocationsFile, inv); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// RecordAction records the given admin action
+func (s *Store) RecordAction(a *Action) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, a)
+	if len(s.actions) > tailSize {
+		s.actions = s.actions[1:]
+		s.actionsSpilled = true
+	}
+	if err := s.append(actionsFile, a); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// Invocations returns the invocations matching the given query,
+// newest first. They're read from the file unless the invocations
+// in memory answer the query
+func (s *Store) Invocations(q *Query) []*Invocation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var tail []*Invocation
+	for i := len(s.invocations) - 1; i >= 0; i-- {
+		if q.match(s.invocations[i]) {
+			tail = append(tail, s.invocations[i])
+			if q.Limit > 0 && len(tail) == q.Limit {
+				return tail
+			}
+		}
+	}
+	// invocations are appended as they finish, so those in the file
+	// finished before the oldest in memory
+	if !s.invocationsSpilled || (!q.Since.IsZero() && s.invocations[0].FinishedAt.Before(q.Since)) {
+		return tail
+	}
+	var invs []*Invocation
+	_, err := readLines(filepath.Join(s.dir, invocationsFile), func(dec *json.Decoder) error {
+		var inv Invocation
+		if err := dec.Decode(&inv); err != nil {
+			return err
+		}
+		if q.match(&inv) {
+			invs = append(invs, &inv)
+			if q.Limit > 0 && len(invs) > q.Limit {
+				invs = invs[1:]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return tail
+	}
+	for i, j := 0, len(invs)-1; i < j; i, j = i+1, j-1 {
+		invs[i], invs[j] = invs[j], invs[i]
+	}
+	return invs
+}
+
+// Actions returns the admin actions since the given time, newest
+// first. They're read from the file unless the actions in memory
+// answer the query
+func (s *Store) Actions(since time.Time) []*Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var tail []*Action
+	for i := len(s.actions) - 1; i >= 0; i-- {
+		if s.actions[i].Time.Before(since) {
+			return tail
+		}
+		tail = append(tail, s.actions[i])
+	}
+	if !s.actionsSpilled {
+		return tail
+	}
+	var actions []*Action
+	_, err := readLines(filepath.Join(s.dir, actionsFile), func(dec *json.Decoder) error {
+		var a Action
+		if err := dec.Decode(&a); err != nil {
+			return err
+		}
+		if !a.Time.Before(since) {
+			actions = append(actions, &a)
+		}
+		return nil
+	})
+	if err != nil {
+		return tail
+	}
+	for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+		actions[i], actions[j] = actions[j], actions[i]
+	}
+	return actions
+}
+
+// append writes the given value as a line to the given file. The
+// caller must hold the lock
+func (s *Store) append(name string, v interface{}) error {
+	if s.dir == "" {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maybeCompact compacts the store if it hasn't been compacted within
+// the compact interval. The caller must hold the lock
+func (s *Store) maybeCompact() error {
+	if time.Since(s.compacted) < compactInterval {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact drops the invocations that finished and the actions taken
+// before the retention period, rewriting the files without them and
+// reloading the newest entries into memory. Entries aren't assumed to
+// be in order. The caller must hold the lock
+func (s *Store) compact() error {
+	s.compacted = time.Now()
+	cutoff := s.compacted.Add(-s.retention)
+	if s.dir == "" {
+		var invs []*Invocation
+		for _, inv := range s.invocations {
+			if !inv.FinishedAt.Before(cutoff) {
+				invs = append(invs, inv)
+			}
+		}
+		var actions []*Action
+		for _, a := range s.actions {
+			if !a.Time.Before(cutoff) {
+				actions = append(actions, a)
+			}
+		}
+		s.invocations, s.actions = invs, actions
+		return nil
+	}
+
+	var invs []*Invocation
+	var invsSpilled bool
+	err := s.compactFile(invocationsFile, func(dec *json.Decoder) (interface{}, bool, error) {
+		var inv Invocation
+		if err := dec.Decode(&inv); err != nil {
+			return nil, false, err
+		}
+		if inv.FinishedAt.Before(cutoff) {
+			return nil, false, nil
+		}
+		if invs = append(invs, &inv); len(invs) > tailSize {
+			invs = invs[1:]
+			invsSpilled = true
+		}
+		return &inv, true, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.invocations, s.invocationsSpilled = invs, invsSpilled
+
+	var actions []*Action
+	var actionsSpilled bool
+	err = s.compactFile(actionsFile, func(dec *json.Decoder) (interface{}, bool, error) {
+		var a Action
+		if err := dec.Decode(&a); err != nil {
+			return nil, false, err
+		}
+		if a.Time.Before(cutoff) {
+			return nil, false, nil
+		}
+		if actions = append(actions, &a); len(actions) > tailSize {
+			actions = actions[1:]
+			actionsSpilled = true
+		}
+		return &a, true, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.actions, s.actionsSpilled = actions, actionsSpilled
+	return nil
+}
+
+// compactFile streams the given file through fn, which decodes the
+// next value and reports whether it's kept, and atomically replaces
+// the file with the kept values if any were dropped. A partial value
+// ending the file is dropped too so entries appended later aren't
+// joined to it. The caller must hold the lock
+func (s *Store) compactFile(name string, fn func(*json.Decoder) (interface{}, bool, error)) error {
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	var dropped int
+	partial, err := readLines(path, func(dec *json.Decoder) error {
+		v, keep, err := fn(dec)
+		if err != nil {
+			return err
+		}
+		if !keep {
+			dropped++
+			return nil
+		}
+		return enc.Encode(v)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || (dropped == 0 && !partial) {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestStore verifies recorded entries are queried newest first and
+// reloaded from disk
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	invs := []*Invocation{
+		{ID: "1", Function: "hello", URL: "github.com/a/hello", StartedAt: now.Add(-2 * time.Hour), FinishedAt: now.Add(-2 * time.Hour), Status: StatusOK},
+		{ID: "2", URL: "github.com/a/geo", StartedAt: now.Add(-time.Hour), FinishedAt: now.Add(-time.Hour), Status: StatusFailed},
+		{ID: "3", Function: "hello", URL: "github.com/a/hello", StartedAt: now, FinishedAt: now, Status: StatusTimeout},
+	}
+	for _, inv := range invs {
+		if err := s.RecordInvocation(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RecordAction(&Action{Time: now, Action: "jail.kill", Status: StatusOK}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query    *Query
+		expected []string
+	}{
+		{&Query{}, []string{"3", "2", "1"}},
+		{&Query{Function: "hello"}, []string{"3", "1"}},
+		{&Query{Function: "github.com/a/geo"}, []string{"2"}},
+		{&Query{Status: StatusTimeout}, []string{"3"}},
+		{&Query{Since: now.Add(-90 * time.Minute)}, []string{"3", "2"}},
+		{&Query{Limit: 1}, []string{"3"}},
+	}
+	for _, test := range tests {
+		var ids []string
+		for _, inv := range s.Invocations(test.query) {
+			ids = append(ids, inv.ID)
+		}
+		if len(ids) != len(test.expected) {
+			t.Errorf("%+v: expected %v got %v", test.query, test.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("%+v: expected %v got %v", test.query, test.expected, ids)
+				break
+			}
+		}
+	}
+	if actions := s.Actions(time.Time{}); len(actions) != 1 || actions[0].Action != "jail.kill" {
+		t.Errorf("unexpected actions %+v", actions)
+	}
+}
+
+// TestStore_Retention verifies entries that finished before the
+// retention period are dropped when loaded, whatever their order
+func TestStore_Retention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	s.RecordInvocation(&Invocation{ID: "new", StartedAt: now, FinishedAt: now})
+	// entries are appended as they finish, not as they start
+	s.RecordInvocation(&Invocation{ID: "old", StartedAt: now.Add(-3 * time.Hour), FinishedAt: now.Add(-2 * time.Hour)})
+
+	if s, err = NewStore(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	invs := s.Invocations(&Query{})
+	if len(invs) != 1 || invs[0].ID != "new" {
+		t.Errorf("expected only the new invocation got %+v", invs)
+	}
+	if s, err = NewStore(dir, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if invs := s.Invocations(&Query{}); len(invs) != 1 {
+		t.Errorf("expected expired invocation removed from disk got %+v", invs)
+	}
+}
+
+// TestStore_Spilled verifies entries no longer kept in memory are
+// read from the files
+func TestStore_Spilled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	n := tailSize + 10
+	for i := 0; i < n; i++ {
+		at := now.Add(time.Duration(i-n) * time.Minute)
+		if err := s.RecordInvocation(&Invocation{ID: strconv.Itoa(i), StartedAt: at, FinishedAt: at}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RecordAction(&Action{Time: at, Action: "jail.kill"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.invocations) != tailSize || len(s.actions) != tailSize {
+		t.Fatalf("expected %d entries in memory got %d and %d", tailSize, len(s.invocations), len(s.actions))
+	}
+	invs := s.Invocations(&Query{})
+	if len(invs) != n || invs[0].ID != strconv.Itoa(n-1) || invs[n-1].ID != "0" {
+		t.Errorf("expected %d invocations newest first got %d", n, len(invs))
+	}
+	if invs := s.Invocations(&Query{Status: StatusOK, Limit: 5}); len(invs) != 0 {
+		t.Errorf("expected no ok invocations got %d", len(invs))
+	}
+	if actions := s.Actions(time.Time{}); len(actions) != n {
+		t.Errorf("expected %d actions got %d", n, len(actions))
+	}
+
+	if s, err = NewStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.invocations) != tailSize || !s.invocationsSpilled {
+		t.Errorf("expected %d invocations in memory got %d", tailSize, len(s.invocations))
+	}
+	if invs := s.Invocations(&Query{Limit: 2}); len(invs) != 2 || invs[0].ID != strconv.Itoa(n-1) {
+		t.Errorf("unexpected invocations %+v", invs)
+	}
+	if invs := s.Invocations(&Query{Since: now.Add(-time.Duration(n) * time.Minute)}); len(invs) != n {
+		t.Errorf("expected %d invocations got %d", n, len(invs))
+	}
+}
+
+// TestStore_PartialLine verifies a partial entry ending a file, as left
+// by a crash while appending, is dropped when the store is loaded
+func TestStore_PartialLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewStore(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	if err := s.RecordInvocation(&Invocation{ID: "1", StartedAt: now, FinishedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, invocationsFile), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":"2","started_at":"`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if s, err = NewStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordInvocation(&Invocation{ID: "3", StartedAt: now, FinishedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewStore(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	invs := s.Invocations(&Query{})
+	if len(invs) != 2 || invs[0].ID != "3" || invs[1].ID != "1" {
+		t.Errorf("expected invocations 3 and 1 got %+v", invs)
+	}
+}
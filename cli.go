@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	skyisland "github.com/briandowns/sky-island/clients/go"
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// target is where an operator command is carried out, the local
+// services or, when a server is given, a server through the client
+type target struct {
+	configFile  string
+	server      string
+	token       string
+	tokenHeader string
+	jsonOutput  bool
+	fs          *flag.FlagSet
+}
+
+// addFlags adds the flags selecting the target to the given flag set.
+// The server and token default to SKYISLAND_SERVER and SKYISLAND_TOKEN
+func (t *target) addFlags(fs *flag.FlagSet) {
+	t.fs = fs
+	fs.StringVar(&t.configFile, "c", defaultConfigFile, "sky-island configuration file")
+	fs.StringVar(&t.server, "server", os.Getenv("SKYISLAND_SERVER"), "sky-island server to use instead of the local services, e.g. http://host:3280")
+	fs.StringVar(&t.token, "token", os.Getenv("SKYISLAND_TOKEN"), "admin API token")
+	fs.StringVar(&t.tokenHeader, "token-header", config.DefaultAdminTokenHeader, "header the admin API token is sent in, that of the configuration file if it loads")
+	fs.BoolVar(&t.jsonOutput, "json", false, "print JSON instead of a table")
+}
+
+// remote reports whether the command is carried out by a server
+func (t *target) remote() bool {
+	return t.server != ""
+}
+
+// client returns a client for the server. Unless the token header
+// is given it's the admin token header of the configuration file,
+// when the file loads
+func (t *target) client() *skyisland.Client {
+	opts := []skyisland.Option{}
+	if t.token != "" {
+		opts = append(opts, skyisland.WithAdminToken(t.header(), t.token))
+	}
+	return skyisland.New(t.server, opts...)
+}
+
+// header returns the header the admin token is sent in
+func (t *target) header() string {
+	if t.fs != nil {
+		set := false
+		t.fs.Visit(func(f *flag.Flag) {
+			set = set || f.Name == "token-header"
+		})
+		if set {
+			return t.tokenHeader
+		}
+	}
+	if conf, err := config.Load(t.configFile); err == nil {
+		return conf.AdminTokenHeader
+	}
+	return t.tokenHeader
+}
+
+// local holds the local services
+type local struct {
+	conf    *config.Config
+	logger  gklog.Logger
+	wrapper utils.Wrapper
+	jsvc    jail.JailServicer
+}
+
+// local loads the config and creates the local services. Nothing is
+// logged and no metrics are sent
+func (t *target) local() (*local, error) {
+	conf, err := config.Load(t.configFile)
+	if err != nil {
+		return nil, err
+	}
+	logger := gklog.NewNopLogger()
+	return &local{
+		conf:    conf,
+		logger:  logger,
+		wrapper: utils.Wrap{},
+		jsvc:    jail.NewJailService(conf, logger, metrics.Discard(), utils.Wrap{}),
+	}, nil
+}
+
+// printJSON writes the given value as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	return enc.Encode(v)
+}
+
+// newTable returns a writer aligning tab separated columns
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// subcommand splits the given args into the subcommand and its args,
+// checking the subcommand is one of the given ones
+func subcommand(args []string, valid ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("missing subcommand, one of %v", valid)
+	}
+	for _, v := range valid {
+		if args[0] == v {
+			return args[0], args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown subcommand %q, one of %v", args[0], valid)
+}
+
+// jid parses the given jail id
+func jid(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid jail id %q", s)
+	}
+	return id, nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	skyisland "github.com/briandowns/sky-island/clients/go"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/metrics"
+)
+
+// errRemoteOnly is returned by commands that only a server can carry
+// out since the state they change is kept by the server
+var errRemoteOnly = errors.New("the state is kept by the server, -server required")
+
+// jailsCmd lists, inspects and kills running jails
+func jailsCmd(args []string) error {
+	sub, args, err := subcommand(args, "ls", "inspect", "kill")
+	if err != nil {
+		return err
+	}
+	var t target
+	fs := flag.NewFlagSet("jails "+sub, flag.ContinueOnError)
+	t.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch sub {
+	case "ls":
+		jails, err := listJails(ctx, &t)
+		if err != nil {
+			return err
+		}
+		if t.jsonOutput {
+			return printJSON(jails)
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "JID\tNAME\tIP4\tPATH")
+		for _, j := range jails {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", j.JID, j.Name, j.IP4, j.Path)
+		}
+		return tw.Flush()
+	case "inspect":
+		if fs.NArg() != 1 {
+			return errors.New("usage: jails inspect <jid>")
+		}
+		id, err := jid(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if t.remote() {
+			j, err := t.client().Jail(ctx, id)
+			if err != nil {
+				return err
+			}
+			return printJSON(j)
+		}
+		l, err := t.local()
+		if err != nil {
+			return err
+		}
+		j, err := l.jsvc.JailDetails(id)
+		if err != nil {
+			return err
+		}
+		return printJSON(j)
+	default:
+		if fs.NArg() != 1 {
+			return errors.New("usage: jails kill <jid>|all")
+		}
+		return killJails(ctx, &t, fs.Arg(0))
+	}
+}
+
+// listJails returns the running jails
+func listJails(ctx context.Context, t *target) ([]*skyisland.Jail, error) {
+	if t.remote() {
+		return t.client().Jails(ctx)
+	}
+	l, err := t.local()
+	if err != nil {
+		return nil, err
+	}
+	jls, err := jail.JLSRun(l.wrapper)
+	if err != nil {
+		return nil, err
+	}
+	jails := make([]*skyisland.Jail, 0, len(jls))
+	for _, j := range jls {
+		jails = append(jails, &skyisland.Jail{
+			Host:      j.Host,
+			IP4:       j.IP4,
+			IP6:       j.IP6,
+			JID:       j.JID,
+			Name:      j.Name,
+			OSRelease: j.OSRelease,
+			Path:      j.Path,
+			Hostname:  j.Hostname,
+		})
+	}
+	return jails, nil
+}
+
+// killJails kills the jail with the given id, or every jail for all
+func killJails(ctx context.Context, t *target, which string) error {
+	if t.remote() {
+		if which == "all" {
+			return t.client().KillAllJails(ctx)
+		}
+		id, err := jid(which)
+		if err != nil {
+			return err
+		}
+		return t.client().KillJail(ctx, id)
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	l, err := t.local()
+	if err != nil {
+		return err
+	}
+	if which != "all" {
+		id, err := jid(which)
+		if err != nil {
+			return err
+		}
+		return l.jsvc.KillJail(id)
+	}
+	jls, err := jail.JLSRun(l.wrapper)
+	if err != nil {
+		return err
+	}
+	for _, j := range jls {
+		if err := l.jsvc.KillJail(j.JID); err != nil {
+			return err
+		}
+		fmt.Println("killed " + j.Name)
+	}
+	return nil
+}
+
+// ipsCmd lists and releases the addresses of the IP pool
+func ipsCmd(args []string) error {
+	sub, args, err := subcommand(args, "ls", "release")
+	if err != nil {
+		return err
+	}
+	var t target
+	fs := flag.NewFlagSet("ips "+sub, flag.ContinueOnError)
+	t.addFlags(fs)
+	state := fs.String("state", "", "only list addresses in the given state, available or unavailable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if sub == "release" {
+		if fs.NArg() != 1 {
+			return errors.New("usage: ips release <ip>")
+		}
+		if !t.remote() {
+			return errRemoteOnly
+		}
+		return t.client().ReleaseIP(ctx, fs.Arg(0))
+	}
+	if *state != "" && *state != skyisland.IPAvailable && *state != skyisland.IPUnavailable {
+		return fmt.Errorf("unknown state %q", *state)
+	}
+	pool, err := ipPool(ctx, &t)
+	if err != nil {
+		return err
+	}
+	ips := make([]string, 0, len(pool))
+	for ip, owner := range pool {
+		if *state == skyisland.IPAvailable && owner != "" || *state == skyisland.IPUnavailable && owner == "" {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	if t.jsonOutput {
+		filtered := make(map[string]string, len(ips))
+		for _, ip := range ips {
+			filtered[ip] = pool[ip]
+		}
+		return printJSON(filtered)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "IP\tJAIL")
+	for _, ip := range ips {
+		fmt.Fprintf(tw, "%s\t%s\n", ip, pool[ip])
+	}
+	return tw.Flush()
+}
+
+// ipPool returns the addresses of the pool with the jail each is
+// allocated to. The pool is kept by the server so, locally, the
+// addresses of the running jails are taken as allocated
+func ipPool(ctx context.Context, t *target) (map[string]string, error) {
+	if t.remote() {
+		return t.client().IPPool(ctx)
+	}
+	l, err := t.local()
+	if err != nil {
+		return nil, err
+	}
+	n, err := jail.NewNetworkService(l.conf, l.logger, metrics.Discard())
+	if err != nil {
+		return nil, err
+	}
+	pool := make(map[string]string)
+	for ip := range n.Pool() {
+		pool[ip] = ""
+	}
+	jls, err := jail.JLSRun(l.wrapper)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jls {
+		if _, ok := pool[j.IP4]; ok {
+			pool[j.IP4] = j.Name
+		}
+	}
+	return pool, nil
+}
+
+// cacheCmd lists and purges the cached binaries. The cache is kept
+// by the server, purging the files under it would leave entries
+// pointing at binaries that are gone
+func cacheCmd(args []string) error {
+	sub, args, err := subcommand(args, "ls", "purge")
+	if err != nil {
+		return err
+	}
+	var t target
+	fs := flag.NewFlagSet("cache "+sub, flag.ContinueOnError)
+	t.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !t.remote() {
+		return errRemoteOnly
+	}
+	ctx := context.Background()
+	if sub == "purge" {
+		n, err := t.client().PurgeCache(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d binaries\n", n)
+		return nil
+	}
+	entries, err := t.client().Cache(ctx)
+	if err != nil {
+		return err
+	}
+	if t.jsonOutput {
+		return printJSON(entries)
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tw := newTable()
+	fmt.Fprintln(tw, "KEY\tBINARY")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", k, entries[k])
+	}
+	return tw.Flush()
+}
+
+// artifactsCmd lists the binaries of the artifact store and copies
+// them between nodes
+func artifactsCmd(args []string) error {
+	sub, args, err := subcommand(args, "ls", "push", "pull")
+	if err != nil {
+		return err
+	}
+	var t target
+	fs := flag.NewFlagSet("artifacts "+sub, flag.ContinueOnError)
+	t.addFlags(fs)
+	node := fs.String("node", "", "URL of the node to copy to or from, the remote store when not set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !t.remote() {
+		return errRemoteOnly
+	}
+	ctx := context.Background()
+	if sub != "ls" {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: artifacts %s <key>", sub)
+		}
+		var a *skyisland.Artifact
+		if sub == "push" {
+			a, err = t.client().PushArtifact(ctx, fs.Arg(0), *node)
+		} else {
+			a, err = t.client().PullArtifact(ctx, fs.Arg(0), *node)
+		}
+		if err != nil {
+			return err
+		}
+		if t.jsonOutput {
+			return printJSON(a)
+		}
+		fmt.Printf("%sed %s %s\n", sub, a.Key, a.Digest)
+		return nil
+	}
+	as, err := t.client().Artifacts(ctx)
+	if err != nil {
+		return err
+	}
+	if t.jsonOutput {
+		return printJSON(as)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "KEY\tDIGEST\tSIZE\tNODE\tCOMMIT\tCREATED")
+	for _, a := range as {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", a.Key, a.Digest[:12], a.Size, a.Node, a.Commit, a.Created.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	return tw.Flush()
+}
+
+// gcCmd removes the datasets of function jails left behind
+func gcCmd(args []string) error {
+	var t target
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	t.addFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "print the jails that would be removed without removing them")
+	minAge := fs.Duration("min-age", time.Hour, "skip datasets created more recently, the jails of invocations being set up aren't running yet")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if t.remote() {
+		return errors.New("gc removes local datasets, run it on the server host without -server")
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	l, err := t.local()
+	if err != nil {
+		return err
+	}
+	orphans, err := l.jsvc.Orphans(*minAge)
+	if err != nil {
+		return err
+	}
+	for _, id := range orphans {
+		if *dryRun {
+			fmt.Println("would remove " + id)
+			continue
+		}
+		if err := l.jsvc.RemoveJail(id); err != nil {
+			return err
+		}
+		fmt.Println("removed " + id)
+	}
+	return nil
+}
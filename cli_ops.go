@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	skyisland "github.com/briandowns/sky-island/clients/go"
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/metrics"
+)
+
+// listFlag is a flag that can be given more than once
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// invokeCmd runs a function on a server and prints its output
+func invokeCmd(args []string) error {
+	var (
+		t             target
+		env, packages listFlag
+	)
+	fs := flag.NewFlagSet("invoke", flag.ContinueOnError)
+	t.addFlags(fs)
+	req := &skyisland.RunRequest{}
+	fs.StringVar(&req.URL, "url", "", "import path of the function's repository")
+	fs.StringVar(&req.Call, "call", "", "function call, e.g. 'Greet(\"world\")'")
+	input := fs.String("input", "", "JSON input of the function, - reads it from stdin")
+	fs.Var(&env, "env", "environment variable of the function, key=value, may be repeated")
+	fs.Var(&packages, "package", "package installed in the function's jail, may be repeated")
+	fs.BoolVar(&req.IP4, "ip4", false, "give the function's jail an IPv4 address")
+	fs.BoolVar(&req.CacheBust, "cache-bust", false, "rebuild the function even if it's cached")
+	fs.StringVar(&req.Version, "version", "", "version of the function's repository")
+	fs.StringVar(&req.GoVersion, "go-version", "", "Go version the function is built with")
+	fs.StringVar(&req.Release, "release", "", "FreeBSD release of the function's jail")
+	fs.StringVar(&req.Timeout, "timeout", "", "execution timeout of the function, e.g. 30s")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if req.URL == "" || req.Call == "" {
+		return errors.New("-url and -call required")
+	}
+	if len(env) > 0 {
+		req.Env = make(map[string]string, len(env))
+		for _, kv := range env {
+			i := strings.Index(kv, "=")
+			if i < 1 {
+				return fmt.Errorf("invalid env %q, must be key=value", kv)
+			}
+			req.Env[kv[:i]] = kv[i+1:]
+		}
+	}
+	req.Packages = packages
+	if *input != "" {
+		raw := []byte(*input)
+		if *input == "-" {
+			var err error
+			if raw, err = ioutil.ReadAll(os.Stdin); err != nil {
+				return err
+			}
+		}
+		if !json.Valid(raw) {
+			return errors.New("-input must be JSON")
+		}
+		req.Input = raw
+	}
+	// functions only run on a server, the local one unless told otherwise
+	if !t.remote() {
+		conf, err := config.Load(t.configFile)
+		if err != nil {
+			return err
+		}
+		t.server = "http://localhost:" + strconv.Itoa(conf.HTTPPort)
+	}
+	data, err := t.client().Run(context.Background(), req)
+	if err != nil {
+		if e, ok := err.(*skyisland.Error); ok && e.Data != "" {
+			fmt.Print(e.Data)
+		}
+		return err
+	}
+	if t.jsonOutput {
+		return printJSON(data)
+	}
+	fmt.Print(data.Data)
+	return nil
+}
+
+// configCmd checks config files and reloads a server's
+func configCmd(args []string) error {
+	sub, args, err := subcommand(args, "validate", "reload")
+	if err != nil {
+		return err
+	}
+	var t target
+	fs := flag.NewFlagSet("config "+sub, flag.ContinueOnError)
+	t.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if sub == "reload" {
+		if !t.remote() {
+			return errors.New("reload requires -server, or send SIGHUP to the server")
+		}
+		changed, err := t.client().ReloadConfig(context.Background())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reloaded, %d fields changed\n", len(changed))
+		for _, f := range changed {
+			fmt.Println("  " + f)
+		}
+		return nil
+	}
+	if _, err := config.Load(t.configFile); err != nil {
+		if verr, ok := err.(*config.ValidationError); ok {
+			for _, p := range verr.Problems {
+				fmt.Fprintln(os.Stderr, p)
+			}
+			return fmt.Errorf("%d problems found in %s", len(verr.Problems), t.configFile)
+		}
+		return err
+	}
+	fmt.Println(t.configFile + " is valid")
+	return nil
+}
+
+// doctorCmd checks everything functions depend on is usable and fails
+// if anything critical isn't
+func doctorCmd(args []string) error {
+	var t target
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	t.addFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	report, err := healthReport(&t)
+	if err != nil {
+		return err
+	}
+	if t.jsonOutput {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		tw := newTable()
+		fmt.Fprintln(tw, "CHECK\tSTATUS\tCRITICAL\tMESSAGE")
+		for _, c := range report.Checks {
+			fmt.Fprintf(tw, "%s\t%s\t%t\t%s\n", c.Name, c.Status, c.Critical, c.Message)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if report.Status == jail.HealthFail {
+		return errors.New("not ready")
+	}
+	return nil
+}
+
+// healthReport checks the readiness of the server or, locally, of the
+// host the command runs on
+func healthReport(t *target) (*skyisland.HealthReport, error) {
+	if t.remote() {
+		report, err := t.client().Ready(context.Background())
+		if report != nil {
+			return report, nil
+		}
+		return nil, err
+	}
+	l, err := t.local()
+	if err != nil {
+		return nil, err
+	}
+	n, err := jail.NewNetworkService(l.conf, l.logger, metrics.Discard())
+	if err != nil {
+		return nil, err
+	}
+	tc := jail.NewToolchainService(l.conf, l.logger, metrics.Discard())
+	r := jail.NewHealthService(l.conf, l.logger, metrics.Discard(), l.wrapper, n, tc).Ready()
+	report := &skyisland.HealthReport{Status: r.Status}
+	for _, c := range r.Checks {
+		report.Checks = append(report.Checks, &skyisland.HealthCheck{
+			Name:     c.Name,
+			Status:   c.Status,
+			Message:  c.Message,
+			Critical: c.Critical,
+		})
+	}
+	return report, nil
+}
@@ -0,0 +1,265 @@
+package skyisland
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Jail is a running jail
+type Jail struct {
+	Host      string `json:"host"`
+	IP4       string `json:"ip4"`
+	IP6       string `json:"ip6"`
+	JID       int    `json:"jid"`
+	Name      string `json:"name"`
+	OSRelease string `json:"OSRelease"`
+	Path      string `json:"path"`
+	Hostname  string `json:"hostname"`
+}
+
+// Jails returns the running jails
+func (c *Client) Jails(ctx context.Context) ([]*Jail, error) {
+	var res struct {
+		Jails []*Jail `json:"jails"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/jails", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Jails, nil
+}
+
+// Jail returns the details of the jail with the given id
+func (c *Client) Jail(ctx context.Context, id int) (*Jail, error) {
+	var res struct {
+		Details *Jail `json:"details"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/jail/"+strconv.Itoa(id), nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Details, nil
+}
+
+// KillJail stops the jail with the given id
+func (c *Client) KillJail(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, apiPrefix+"/admin/jail/"+strconv.Itoa(id), nil, nil)
+}
+
+// KillAllJails stops every running jail
+func (c *Client) KillAllJails(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, apiPrefix+"/admin/jails", nil, nil)
+}
+
+// States of the addresses in the IP pool
+const (
+	IPAvailable   = "available"
+	IPUnavailable = "unavailable"
+)
+
+// IPPool returns every address in the IP pool with the id of the
+// jail it's allocated to, empty if it's available
+func (c *Client) IPPool(ctx context.Context) (map[string]string, error) {
+	var res map[string][]byte
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/network/ips", nil, &res); err != nil {
+		return nil, err
+	}
+	pool := make(map[string]string, len(res))
+	for ip, owner := range res {
+		pool[ip] = string(owner)
+	}
+	return pool, nil
+}
+
+// IPs returns the addresses in the IP pool with the given state
+func (c *Client) IPs(ctx context.Context, state string) ([]string, error) {
+	var res map[string][]string
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/network/ips?state="+state, nil, &res); err != nil {
+		return nil, err
+	}
+	return res[state], nil
+}
+
+// ReleaseIP returns the given address to the IP pool
+func (c *Client) ReleaseIP(ctx context.Context, ip string) error {
+	return c.do(ctx, http.MethodPut, apiPrefix+"/admin/network/ip", map[string]string{"ip": ip}, nil)
+}
+
+// Cache returns the paths of the cached binaries by cache key
+func (c *Client) Cache(ctx context.Context) (map[string]string, error) {
+	var res struct {
+		Binaries map[string]string `json:"binaries"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/cache", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Binaries, nil
+}
+
+// PurgeCache empties the binary cache and returns how many binaries
+// were removed
+func (c *Client) PurgeCache(ctx context.Context) (int, error) {
+	var res struct {
+		Purged int `json:"purged"`
+	}
+	if err := c.do(ctx, http.MethodDelete, apiPrefix+"/admin/cache", nil, &res); err != nil {
+		return 0, err
+	}
+	return res.Purged, nil
+}
+
+// ReloadConfig reloads the server's config file and returns the
+// fields that changed. Changes requiring a restart are returned as
+// an error and not applied
+func (c *Client) ReloadConfig(ctx context.Context) ([]string, error) {
+	var res struct {
+		Changed []string `json:"changed"`
+	}
+	if err := c.do(ctx, http.MethodPost, apiPrefix+"/admin/config/reload", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Changed, nil
+}
+
+// Artifact describes a binary in a node's artifact store
+type Artifact struct {
+	Digest    string    `json:"digest"`
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	Call      string    `json:"call"`
+	GoVersion string    `json:"go_version"`
+	Commit    string    `json:"commit"`
+	Node      string    `json:"node"`
+	Size      int64     `json:"size"`
+	Created   time.Time `json:"created"`
+}
+
+// Artifacts returns the binaries in the server's artifact store
+func (c *Client) Artifacts(ctx context.Context) ([]*Artifact, error) {
+	var res struct {
+		Artifacts []*Artifact `json:"artifacts"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/artifacts", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Artifacts, nil
+}
+
+// PushArtifact copies the binary stored under the given cache key to
+// the node at the given URL or, if empty, the server's remote store
+func (c *Client) PushArtifact(ctx context.Context, key, node string) (*Artifact, error) {
+	return c.transferArtifact(ctx, "push", key, node)
+}
+
+// PullArtifact copies the binary stored under the given cache key
+// from the node at the given URL or, if empty, the server's remote
+// store
+func (c *Client) PullArtifact(ctx context.Context, key, node string) (*Artifact, error) {
+	return c.transferArtifact(ctx, "pull", key, node)
+}
+
+// transferArtifact pushes or pulls an artifact
+func (c *Client) transferArtifact(ctx context.Context, direction, key, node string) (*Artifact, error) {
+	var a Artifact
+	req := map[string]string{"key": key, "node": node}
+	if err := c.do(ctx, http.MethodPost, apiPrefix+"/admin/artifacts/"+direction, req, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Node is the capacity of a node, as reported by a worker or, for the
+// nodes of a cluster, heartbeated to the coordinator
+type Node struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	FreeIPs      int       `json:"free_ips"`
+	RunningJails int       `json:"running_jails"`
+	Load         float64   `json:"load"`
+	Cached       []string  `json:"cached"`
+	LastSeen     time.Time `json:"last_seen"`
+	Alive        bool      `json:"alive"`
+	InFlight     int       `json:"in_flight"`
+}
+
+// Node returns the capacity of the server's node
+func (c *Client) Node(ctx context.Context) (*Node, error) {
+	var n Node
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/node", nil, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ClusterNodes returns the worker nodes known to a cluster coordinator
+func (c *Client) ClusterNodes(ctx context.Context) ([]*Node, error) {
+	var res struct {
+		Nodes []*Node `json:"nodes"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/cluster/nodes", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Nodes, nil
+}
+
+// Stats contains the API statistics
+type Stats struct {
+	PID                    int            `json:"pid"`
+	Uptime                 string         `json:"uptime"`
+	UptimeSec              float64        `json:"uptime_sec"`
+	Time                   string         `json:"time"`
+	TimeUnix               int64          `json:"unixtime"`
+	StatusCodeCount        map[string]int `json:"status_code_count"`
+	TotalStatusCodeCount   map[string]int `json:"total_status_code_count"`
+	Count                  int            `json:"count"`
+	TotalCount             int            `json:"total_count"`
+	TotalResponseTime      string         `json:"total_response_time"`
+	TotalResponseTimeSec   float64        `json:"total_response_time_sec"`
+	AverageResponseTime    string         `json:"average_response_time"`
+	AverageResponseTimeSec float64        `json:"average_response_time_sec"`
+}
+
+// Stats returns the API statistics
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var s Stats
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/api-stats", nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// HealthCheck is the result of checking one component
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+// HealthReport is the result of checking every component
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// Ready returns the readiness of the service. A service that isn't
+// ready returns an error along with the report
+func (c *Client) Ready(ctx context.Context) (*HealthReport, error) {
+	res, err := c.send(ctx, http.MethodGet, "/readyz", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusServiceUnavailable {
+		return nil, decode(res, nil)
+	}
+	var report HealthReport
+	if err := json.NewDecoder(res.Body).Decode(&report); err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusServiceUnavailable {
+		return &report, &Error{StatusCode: res.StatusCode, Message: "not ready"}
+	}
+	return &report, nil
+}
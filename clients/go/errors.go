@@ -0,0 +1,57 @@
+package skyisland
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Error is returned when the API answers with an error status
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"error"`
+
+	// Data is the output of a function that timed out before it
+	// finished, if any
+	Data string `json:"data,omitempty"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return "sky-island: " + strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+// hasStatus reports whether the given error is an *Error with the
+// given status
+func hasStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == status
+}
+
+// IsNotFound reports whether the requested resource doesn't exist
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsForbidden reports whether the admin token, or the caller, was
+// refused
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsTimeout reports whether the function's build or execution
+// timed out
+func IsTimeout(err error) bool {
+	return hasStatus(err, http.StatusGatewayTimeout)
+}
+
+// IsBadRequest reports whether the request was invalid
+func IsBadRequest(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// IsUnavailable reports whether the service was unavailable, after
+// retrying
+func IsUnavailable(err error) bool {
+	return hasStatus(err, http.StatusServiceUnavailable) || hasStatus(err, http.StatusTooManyRequests)
+}
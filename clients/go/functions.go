@@ -0,0 +1,261 @@
+package skyisland
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Data holds the response from the API
+type Data struct {
+	Timestamp int64  `json:"timestamp"`
+	Data      string `json:"data"`
+}
+
+// RunRequest contains the data sent to build and execute a function
+type RunRequest struct {
+	URL       string            `json:"url"`
+	Call      string            `json:"call"`
+	IP4       bool              `json:"ip4,omitempty"`
+	CacheBust bool              `json:"cache_bust,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Input     json.RawMessage   `json:"input,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	GoVersion string            `json:"go_version,omitempty"`
+	Release   string            `json:"release,omitempty"`
+	Packages  []string          `json:"packages,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+}
+
+// Run builds and executes the given function and returns its output
+func (c *Client) Run(ctx context.Context, req *RunRequest) (*Data, error) {
+	var data Data
+	if err := c.do(ctx, http.MethodPost, apiPrefix+"/function", req, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// Function makes the call to the API
+func (c *Client) Function(url, call string) (*Data, error) {
+	return c.Run(context.Background(), &RunRequest{URL: url, Call: call})
+}
+
+// Job is a function run in the background
+type Job struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	data   *Data
+	err    error
+}
+
+// Start runs the given function in the background. The run is
+// cancelled when the given context is done or the job cancelled
+func (c *Client) Start(ctx context.Context, req *RunRequest) *Job {
+	ctx, cancel := context.WithCancel(ctx)
+	j := &Job{done: make(chan struct{}), cancel: cancel}
+	go func() {
+		defer close(j.done)
+		defer cancel()
+		j.data, j.err = c.Run(ctx, req)
+	}()
+	return j
+}
+
+// Done is closed when the job finishes
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait waits for the job to finish and returns its result
+func (j *Job) Wait() (*Data, error) {
+	<-j.done
+	return j.data, j.err
+}
+
+// Cancel cancels the job
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Function is a function registered under a name
+type Function struct {
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	Kind      string            `json:"kind,omitempty"`
+	Call      string            `json:"call"`
+	IP4       bool              `json:"ip4"`
+	Env       map[string]string `json:"env,omitempty"`
+	Secrets   []string          `json:"secrets,omitempty"`
+	GoVersion string            `json:"go_version,omitempty"`
+	Release   string            `json:"release,omitempty"`
+	Packages  []string          `json:"packages,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+	Schedule  *Schedule         `json:"schedule,omitempty"`
+	Webhook   *Webhook          `json:"webhook,omitempty"`
+	Queue     *Queue            `json:"queue,omitempty"`
+}
+
+// Schedule runs a registered function on a cron expression
+type Schedule struct {
+	Cron     string          `json:"cron"`
+	Timezone string          `json:"timezone,omitempty"`
+	Jitter   string          `json:"jitter,omitempty"`
+	Missed   string          `json:"missed,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
+}
+
+// Webhook accepts deliveries to /hooks/{name} signed with the named
+// secret granted to the function
+type Webhook struct {
+	Secret          string `json:"secret"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	Header          string `json:"header,omitempty"`
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+	NonceHeader     string `json:"nonce_header,omitempty"`
+	Tolerance       string `json:"tolerance,omitempty"`
+}
+
+// Queue runs a registered function with each message of a NATS
+// subject or Redis stream
+type Queue struct {
+	Broker      string `json:"broker"`
+	Subject     string `json:"subject"`
+	Group       string `json:"group,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+	Backoff     string `json:"backoff,omitempty"`
+	DeadLetter  string `json:"dead_letter,omitempty"`
+	Reply       string `json:"reply,omitempty"`
+}
+
+// Brokers of queue triggers
+const (
+	BrokerNATS  = "nats"
+	BrokerRedis = "redis"
+)
+
+// QueueTrigger is the state of a function's queue trigger
+type QueueTrigger struct {
+	Function     string `json:"function"`
+	Broker       string `json:"broker"`
+	Source       string `json:"source"`
+	Group        string `json:"group"`
+	Concurrency  int    `json:"concurrency"`
+	Connected    bool   `json:"connected"`
+	Processed    int64  `json:"processed"`
+	Failed       int64  `json:"failed"`
+	DeadLettered int64  `json:"dead_lettered"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// Missed run policies of schedules
+const (
+	MissedSkip    = "skip"
+	MissedRunOnce = "run_once"
+)
+
+// ScheduleStatus is the state of a scheduled function
+type ScheduleStatus struct {
+	Function string    `json:"function"`
+	Cron     string    `json:"cron"`
+	Timezone string    `json:"timezone"`
+	Jitter   string    `json:"jitter,omitempty"`
+	Missed   string    `json:"missed"`
+	Next     time.Time `json:"next"`
+	LastRun  time.Time `json:"last_run,omitempty"`
+	Running  bool      `json:"running"`
+}
+
+// ScheduleRun is the record of a scheduled run. Status is ok,
+// failed, missed or skipped_overlap
+type ScheduleRun struct {
+	Function   string    `json:"function"`
+	Scheduled  time.Time `json:"scheduled"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Missed     int       `json:"missed,omitempty"`
+}
+
+// Kinds of registered functions
+const (
+	KindCall = "call"
+	KindHTTP = "http"
+)
+
+// Functions returns the registered functions
+func (c *Client) Functions(ctx context.Context) ([]*Function, error) {
+	var res struct {
+		Functions []*Function `json:"functions"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/functions", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Functions, nil
+}
+
+// GetFunction returns the function registered under the given name
+func (c *Client) GetFunction(ctx context.Context, name string) (*Function, error) {
+	var res struct {
+		Function *Function `json:"function"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/function/"+url.PathEscape(name), nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Function, nil
+}
+
+// RegisterFunction registers the given function under its name,
+// replacing any function registered under it
+func (c *Client) RegisterFunction(ctx context.Context, fn *Function) (*Function, error) {
+	var res struct {
+		Function *Function `json:"function"`
+	}
+	if err := c.do(ctx, http.MethodPut, apiPrefix+"/admin/function/"+url.PathEscape(fn.Name), fn, &res); err != nil {
+		return nil, err
+	}
+	return res.Function, nil
+}
+
+// RemoveFunction removes the function registered under the given name
+func (c *Client) RemoveFunction(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, apiPrefix+"/admin/function/"+url.PathEscape(name), nil, nil)
+}
+
+// Schedules returns the state of the scheduled functions
+func (c *Client) Schedules(ctx context.Context) ([]*ScheduleStatus, error) {
+	var res struct {
+		Schedules []*ScheduleStatus `json:"schedules"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/schedules", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Schedules, nil
+}
+
+// ScheduleRuns returns the scheduled runs of the given function, most
+// recent first
+func (c *Client) ScheduleRuns(ctx context.Context, name string) ([]*ScheduleRun, error) {
+	var res struct {
+		Runs []*ScheduleRun `json:"runs"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/schedule/"+url.PathEscape(name)+"/runs", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Runs, nil
+}
+
+// QueueTriggers returns the state of the functions' queue triggers
+func (c *Client) QueueTriggers(ctx context.Context) ([]*QueueTrigger, error) {
+	var res struct {
+		Triggers []*QueueTrigger `json:"triggers"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/admin/queues", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Triggers, nil
+}
@@ -2,51 +2,190 @@ package skyisland
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// Data holds the response from the API
-type Data struct {
-	Timestamp int64  `json:"timestamp"`
-	Data      string `json:"data"`
-}
+const apiPrefix = "/api/v1"
+
+// defaults used when the client isn't configured otherwise
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 250 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
 
 // Client contains the HTTP client and the endpoint
 // it needs to communicate with
 type Client struct {
-	hc       *http.Client
-	endpoint string
+	hc          *http.Client
+	endpoint    string
+	tokenHeader string
+	token       string
+	retries     int
+	backoff     time.Duration
 }
 
-// NewClient creates a new client usable with the Sky Island API
-func NewClient(url string, port int, timeout time.Duration) *Client {
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client requests are made with
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithTimeout sets the timeout of every request. The HTTP client is
+// copied first so one given with WithHTTPClient isn't changed
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		hc := *c.hc
+		hc.Timeout = timeout
+		c.hc = &hc
+	}
+}
+
+// WithAdminToken sets the header and token sent with admin requests
+func WithAdminToken(header, token string) Option {
+	return func(c *Client) {
+		c.tokenHeader = header
+		c.token = token
+	}
+}
+
+// WithRetries sets how many times a request answered with a 429 or
+// 503 is retried and the backoff before the first retry, doubled for
+// each retry after. A Retry-After header takes precedence
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New creates a new client usable with the Sky Island API at the
+// given endpoint, e.g. http://localhost:3280
+func New(endpoint string, opts ...Option) *Client {
 	c := &Client{
-		hc: &http.Client{
-			Timeout: timeout,
-		},
-		endpoint: fmt.Sprintf("%s:%d/api/v1/function", url, port),
+		hc:       &http.Client{},
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		retries:  DefaultRetries,
+		backoff:  DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 	return c
 }
 
-// Function makes the call to the API
-func (c *Client) Function(url, call string) (*Data, error) {
-	d := fmt.Sprintf(`{"url": "%s", "call": "%s"}`, url, call)
-	req, err := http.NewRequest(http.MethodPost, c.endpoint, bytes.NewBuffer([]byte(d)))
+// NewClient creates a new client usable with the Sky Island API
+func NewClient(url string, port int, timeout time.Duration) *Client {
+	return New(fmt.Sprintf("%s:%d", url, port), WithTimeout(timeout))
+}
+
+// do sends a request with the given JSON body, if any, to the given
+// path and decodes the JSON response into out, if given. Requests
+// answered with a 429 or 503 are retried
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, path, b)
+		if err != nil {
+			return err
+		}
+		if retryable(res.StatusCode) && attempt < c.retries {
+			wait := c.wait(attempt, res.Header.Get("Retry-After"))
+			drain(res.Body)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		defer res.Body.Close()
+		return decode(res, out)
+	}
+}
+
+// send makes a single request
+func (c *Client) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.endpoint+path, r)
 	if err != nil {
 		return nil, err
 	}
-	res, err := c.hc.Do(req)
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.tokenHeader != "" {
+		req.Header.Set(c.tokenHeader, c.token)
+	}
+	return c.hc.Do(req)
+}
+
+// retryable reports whether a request answered with the given status
+// should be retried
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// wait returns how long to wait before the retry following the given
+// attempt, as told by the given Retry-After header if set
+func (c *Client) wait(attempt int, retryAfter string) time.Duration {
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(retryAfter); err == nil {
+		return time.Until(t)
+	}
+	d := c.backoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d
+}
+
+// decode decodes the given response into out, or into an *Error if
+// the request failed
+func decode(res *http.Response, out interface{}) error {
+	b, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer res.Body.Close()
-	var data Data
-	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
-		return nil, err
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		e := &Error{StatusCode: res.StatusCode}
+		if json.Unmarshal(b, e) != nil || e.Message == "" {
+			e.Message = http.StatusText(res.StatusCode)
+		}
+		return e
+	}
+	if out == nil || len(b) == 0 {
+		return nil
 	}
-	return &data, nil
+	return json.Unmarshal(b, out)
+}
+
+// drain reads and closes the given body so the connection is reused
+func drain(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, body)
+	body.Close()
 }
@@ -0,0 +1,206 @@
+package skyisland
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRun verifies run requests are encoded as JSON, including calls
+// with quotes, and the output is decoded
+func TestRun(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/function" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req RunRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Call != `Greet("hello \"world\"")` || !req.IP4 || !req.CacheBust || req.Version != "v1.2.0" {
+			t.Errorf("unexpected request %+v", req)
+		}
+		json.NewEncoder(w).Encode(Data{Timestamp: 1, Data: "hello"})
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+	data, err := c.Run(context.Background(), &RunRequest{
+		URL:       "github.com/briandowns/smile",
+		Call:      `Greet("hello \"world\"")`,
+		IP4:       true,
+		CacheBust: true,
+		Version:   "v1.2.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Data != "hello" {
+		t.Errorf("expected hello got %s", data.Data)
+	}
+}
+
+// TestRun_Timeout verifies error statuses are returned as typed
+// errors along with the function's output
+func TestRun_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(map[string]string{"error": "function execution timed out", "data": "partial"})
+	}))
+	defer ts.Close()
+
+	_, err := New(ts.URL).Run(context.Background(), &RunRequest{URL: "github.com/briandowns/smile", Call: "Loop()"})
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout error got %v", err)
+	}
+	if e := err.(*Error); e.Message != "function execution timed out" || e.Data != "partial" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+// TestWithTimeout verifies the timeout is set on a copy of the given
+// HTTP client
+func TestWithTimeout(t *testing.T) {
+	hc := &http.Client{}
+	c := New("http://localhost:3280", WithHTTPClient(hc), WithTimeout(time.Second))
+	if c.hc.Timeout != time.Second {
+		t.Errorf("expected timeout of 1s got %v", c.hc.Timeout)
+	}
+	if hc.Timeout != 0 {
+		t.Errorf("expected given client unchanged got timeout %v", hc.Timeout)
+	}
+}
+
+// TestRetry verifies 503 and 429 responses are retried until the
+// retries run out
+func TestRetry(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"functions": []*Function{{Name: "geohash"}}})
+		}
+	}))
+	defer ts.Close()
+
+	fns, err := New(ts.URL, WithRetries(2, time.Millisecond)).Functions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(fns) != 1 || fns[0].Name != "geohash" {
+		t.Errorf("expected 3 calls and geohash got %d %v", calls, fns)
+	}
+
+	calls = 0
+	_, err = New(ts.URL, WithRetries(1, time.Millisecond)).Functions(context.Background())
+	if !IsUnavailable(err) || calls != 2 {
+		t.Errorf("expected unavailable after 2 calls got %v after %d", err, calls)
+	}
+}
+
+// TestAdminToken verifies admin requests carry the configured token
+func TestAdminToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Forbidden"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jails": []*Jail{{JID: 3, Name: "build"}}})
+	}))
+	defer ts.Close()
+
+	if _, err := New(ts.URL).Jails(context.Background()); !IsForbidden(err) {
+		t.Errorf("expected forbidden got %v", err)
+	}
+	jails, err := New(ts.URL, WithAdminToken("X-Auth-Token", "secret")).Jails(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jails) != 1 || jails[0].JID != 3 {
+		t.Errorf("unexpected jails %v", jails)
+	}
+}
+
+// TestStart verifies a job runs in the background and is cancelled
+// with its context
+func TestStart(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		json.NewEncoder(w).Encode(Data{Data: "done"})
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	c := New(ts.URL)
+	job := c.Start(context.Background(), &RunRequest{URL: "github.com/briandowns/smile", Call: "Slow()"})
+	select {
+	case <-job.Done():
+		t.Fatal("expected job to still be running")
+	default:
+	}
+	job.Cancel()
+	if _, err := job.Wait(); err == nil {
+		t.Error("expected cancelled job to fail")
+	}
+}
+
+// TestIPPool verifies allocated addresses are returned with the id
+// of their jail
+func TestIPPool(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]byte{"10.0.0.2": nil, "10.0.0.3": []byte("1234")})
+	}))
+	defer ts.Close()
+
+	pool, err := New(ts.URL).IPPool(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool["10.0.0.2"] != "" || pool["10.0.0.3"] != "1234" {
+		t.Errorf("unexpected pool %v", pool)
+	}
+}
+
+// TestStartWorkflow verifies the input is sent along with the
+// workflow's steps
+func TestStartWorkflow(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/workflows" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req struct {
+			Steps []*WorkflowStep `json:"steps"`
+			Input json.RawMessage `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if len(req.Steps) != 1 || req.Steps[0].Retry.MaxAttempts != 3 || string(req.Input) != `{"page":1}` {
+			t.Errorf("unexpected request %+v", req)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]*WorkflowRun{"workflow": {ID: "1234", Status: WorkflowRunning}})
+	}))
+	defer ts.Close()
+
+	run, err := New(ts.URL).StartWorkflow(context.Background(), &Workflow{
+		Steps: []*WorkflowStep{{Name: "fetch", Function: "fetch", Retry: &WorkflowRetry{MaxAttempts: 3}}},
+	}, json.RawMessage(`{"page":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.ID != "1234" || run.Status != WorkflowRunning {
+		t.Errorf("unexpected run %+v", run)
+	}
+}
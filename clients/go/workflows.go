@@ -0,0 +1,119 @@
+package skyisland
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Workflow is a sequence of steps run by the server, each given the
+// output of the step before it unless its input is mapped with
+// JSONPath from {"input": ..., "steps": {name: output}}
+type Workflow struct {
+	Name   string          `json:"name,omitempty"`
+	Steps  []*WorkflowStep `json:"steps"`
+	Output string          `json:"output,omitempty"`
+}
+
+// WorkflowStep runs a registered function, the branches of Parallel
+// at once or a nested sequence of Steps
+type WorkflowStep struct {
+	Name        string          `json:"name"`
+	Function    string          `json:"function,omitempty"`
+	Input       json.RawMessage `json:"input,omitempty"`
+	When        string          `json:"when,omitempty"`
+	ForEach     string          `json:"for_each,omitempty"`
+	Concurrency int             `json:"concurrency,omitempty"`
+	Parallel    []*WorkflowStep `json:"parallel,omitempty"`
+	Steps       []*WorkflowStep `json:"steps,omitempty"`
+	Retry       *WorkflowRetry  `json:"retry,omitempty"`
+}
+
+// WorkflowRetry runs a failed workflow step again
+type WorkflowRetry struct {
+	MaxAttempts int    `json:"max_attempts"`
+	Backoff     string `json:"backoff,omitempty"`
+}
+
+// Statuses of workflow runs and their steps
+const (
+	WorkflowRunning   = "running"
+	WorkflowSucceeded = "succeeded"
+	WorkflowFailed    = "failed"
+	WorkflowCanceled  = "canceled"
+	WorkflowSkipped   = "skipped"
+)
+
+// WorkflowRun is a run of a workflow
+type WorkflowRun struct {
+	ID         string                      `json:"id"`
+	Workflow   *Workflow                   `json:"workflow"`
+	Input      json.RawMessage             `json:"input,omitempty"`
+	Status     string                      `json:"status"`
+	Steps      map[string]*WorkflowStepRun `json:"steps"`
+	Output     json.RawMessage             `json:"output,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+	CreatedAt  time.Time                   `json:"created_at"`
+	FinishedAt time.Time                   `json:"finished_at,omitempty"`
+}
+
+// WorkflowStepRun is the state of a step of a workflow run
+type WorkflowStepRun struct {
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  time.Time       `json:"started_at,omitempty"`
+	FinishedAt time.Time       `json:"finished_at,omitempty"`
+}
+
+// StartWorkflow starts running the given workflow with the given
+// input. The run is returned as it started
+func (c *Client) StartWorkflow(ctx context.Context, w *Workflow, input json.RawMessage) (*WorkflowRun, error) {
+	req := struct {
+		*Workflow
+		Input json.RawMessage `json:"input,omitempty"`
+	}{w, input}
+	var res struct {
+		Workflow *WorkflowRun `json:"workflow"`
+	}
+	if err := c.do(ctx, http.MethodPost, apiPrefix+"/workflows", &req, &res); err != nil {
+		return nil, err
+	}
+	return res.Workflow, nil
+}
+
+// Workflows returns the workflow runs, most recent first
+func (c *Client) Workflows(ctx context.Context) ([]*WorkflowRun, error) {
+	var res struct {
+		Workflows []*WorkflowRun `json:"workflows"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/workflows", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Workflows, nil
+}
+
+// GetWorkflow returns the workflow run with the given ID
+func (c *Client) GetWorkflow(ctx context.Context, id string) (*WorkflowRun, error) {
+	var res struct {
+		Workflow *WorkflowRun `json:"workflow"`
+	}
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/workflow/"+url.PathEscape(id), nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Workflow, nil
+}
+
+// CancelWorkflow cancels the workflow run with the given ID
+func (c *Client) CancelWorkflow(ctx context.Context, id string) (*WorkflowRun, error) {
+	var res struct {
+		Workflow *WorkflowRun `json:"workflow"`
+	}
+	if err := c.do(ctx, http.MethodDelete, apiPrefix+"/workflow/"+url.PathEscape(id), nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Workflow, nil
+}
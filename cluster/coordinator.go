@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/tracing"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+// Headers carried between the coordinator and its workers
+const (
+	// TokenHeader carries the cluster token
+	TokenHeader = "X-Cluster-Token"
+	// CallerHeader carries the address of the caller of a routed
+	// invocation
+	CallerHeader = "X-Sky-Island-Caller"
+	// NodeHeader carries the id of the node an invocation ran on
+	NodeHeader = "X-Sky-Island-Node"
+)
+
+const apiPrefix = "/api/v1"
+
+// forwardMargin is added to the build and exec timeouts of a routed
+// invocation for the worker to create and remove the jail
+const forwardMargin = 30 * time.Second
+
+// Coordinator routes invocations to the worker nodes in its registry
+type Coordinator struct {
+	conf     *config.Config
+	logger   gklog.Logger
+	metrics  *metrics.Metrics
+	ren      *render.Render
+	registry *Registry
+	client   *http.Client
+}
+
+// NewCoordinator creates a new value of type Coordinator pointer
+func NewCoordinator(conf *config.Config, l gklog.Logger, m *metrics.Metrics) *Coordinator {
+	return &Coordinator{
+		conf:     conf,
+		logger:   l,
+		metrics:  m,
+		ren:      render.New(),
+		registry: NewRegistry(conf.Cluster.NodeTimeout.D()),
+		client:   &http.Client{},
+	}
+}
+
+// Registry returns the coordinator's node registry
+func (c *Coordinator) Registry() *Registry {
+	return c.registry
+}
+
+// Router returns the coordinator's endpoints
+func (c *Coordinator) Router() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/healthz", c.livenessHandler()).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", c.readinessHandler()).Methods(http.MethodGet)
+	ar := router.PathPrefix(apiPrefix).Subrouter()
+	ar.Path("/function").HandlerFunc(c.routeHandler()).Methods(http.MethodPost)
+	ar.Path("/cluster/heartbeat").HandlerFunc(c.heartbeatHandler()).Methods(http.MethodPost)
+	ar.Path("/admin/cluster/nodes").HandlerFunc(c.auth(c.nodesHandler())).Methods(http.MethodGet)
+	return router
+}
+
+// auth checks the configured admin header and token are provided
+func (c *Coordinator) auth(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header, token := c.conf.AdminAuth()
+		if r.Header.Get(header) != token {
+			c.logger.Log("error", "unauthorized request received")
+			c.ren.JSON(w, http.StatusForbidden, map[string]string{"error": http.StatusText(http.StatusForbidden)})
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// livenessHandler reports the coordinator is up
+func (c *Coordinator) livenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.ren.JSON(w, http.StatusOK, map[string]string{"status": "pass"})
+	}
+}
+
+// readinessHandler reports whether any worker node can be routed to
+func (c *Coordinator) readinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var alive int
+		for _, n := range c.registry.Nodes() {
+			if n.Alive {
+				alive++
+			}
+		}
+		if alive == 0 {
+			c.ren.JSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "fail", "alive_nodes": 0})
+			return
+		}
+		c.ren.JSON(w, http.StatusOK, map[string]interface{}{"status": "pass", "alive_nodes": alive})
+	}
+}
+
+// heartbeatHandler handles the heartbeats of the worker nodes
+func (c *Coordinator) heartbeatHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(TokenHeader) != c.conf.Cluster.Token {
+			c.ren.JSON(w, http.StatusForbidden, map[string]string{"error": http.StatusText(http.StatusForbidden)})
+			return
+		}
+		var s NodeStatus
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			c.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+		if s.ID == "" || s.URL == "" {
+			c.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "id and url required"})
+			return
+		}
+		c.registry.Heartbeat(&s)
+		c.metrics.Inc("heartbeats")
+		c.ren.JSON(w, http.StatusOK, map[string]string{"id": s.ID})
+	}
+}
+
+// nodesHandler handles requests to list the worker nodes
+func (c *Coordinator) nodesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.ren.JSON(w, http.StatusOK, map[string]interface{}{"nodes": c.registry.Nodes()})
+	}
+}
+
+// routeHandler routes an invocation to the best worker node, trying
+// the next best if the node can't be reached or is unavailable. Any
+// other failure may have run the function so it isn't retried
+func (c *Coordinator) routeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			c.logger.Log("error", err.Error())
+			c.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+		var req struct {
+			URL  string `json:"url"`
+			Call string `json:"call"`
+		}
+		if err := json.Unmarshal(b, &req); err != nil {
+			c.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+		candidates := c.registry.Candidates(req.URL, req.Call)
+		if len(candidates) == 0 {
+			c.ren.JSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no worker nodes available"})
+			return
+		}
+		if max := 1 + c.conf.Cluster.Retries; len(candidates) > max {
+			candidates = candidates[:max]
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), c.forwardTimeout())
+		defer cancel()
+		for i, id := range candidates {
+			if i > 0 {
+				c.metrics.Inc("retries")
+			}
+			res, err := c.forward(ctx, r, id, b)
+			if err != nil {
+				c.logger.Log("error", "node "+id+": "+err.Error())
+				if retryable(err) {
+					continue
+				}
+				if ctx.Err() == context.DeadlineExceeded {
+					c.ren.JSON(w, http.StatusGatewayTimeout, map[string]string{"error": "worker node timed out"})
+					return
+				}
+				c.ren.JSON(w, http.StatusBadGateway, map[string]string{"error": "worker node failed"})
+				return
+			}
+			c.metrics.Inc("routed", "node", id)
+			defer res.Body.Close()
+			for k, v := range res.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(NodeHeader, id)
+			w.WriteHeader(res.StatusCode)
+			io.Copy(w, res.Body)
+			return
+		}
+		c.ren.JSON(w, http.StatusBadGateway, map[string]string{"error": "no worker node could run the function"})
+	}
+}
+
+// errUnavailable is returned when a node can't take invocations
+var errUnavailable = errors.New("node unavailable")
+
+// forwardTimeout returns how long a routed invocation may take, the
+// longest a worker may spend building and running the function
+func (c *Coordinator) forwardTimeout() time.Duration {
+	jails := c.conf.JailSettings()
+	return jails.BuildTimeout.D() + jails.MaxExecTimeout.D() + forwardMargin
+}
+
+// retryable reports whether the given forward error means the node
+// never took the invocation: it was unavailable or couldn't be dialed
+func retryable(err error) bool {
+	if err == errUnavailable {
+		return true
+	}
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// forward sends the given invocation to the given node. Nodes that
+// can't be reached or answer 503 are marked failed and an error is
+// returned so the invocation is tried elsewhere
+func (c *Coordinator) forward(ctx context.Context, r *http.Request, id string, body []byte) (*http.Response, error) {
+	url, ok := c.registry.acquire(id)
+	if !ok {
+		return nil, errUnavailable
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+apiPrefix+"/function", bytes.NewReader(body))
+	if err != nil {
+		c.registry.release(id, true)
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TokenHeader, c.conf.Cluster.Token)
+	req.Header.Set(CallerHeader, callerOf(r))
+	tracing.InjectHeader(r.Context(), req.Header)
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.registry.release(id, true)
+		return nil, err
+	}
+	if res.StatusCode == http.StatusServiceUnavailable {
+		res.Body.Close()
+		c.registry.release(id, true)
+		return nil, errUnavailable
+	}
+	c.registry.release(id, false)
+	return res, nil
+}
+
+// callerOf returns the address of the caller of the given request
+func callerOf(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,169 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// testConf returns the config of a node with the given role
+func testConf(role, coordinator, url string) *config.Config {
+	c := &config.Config{
+		AdminTokenHeader: "X-Token",
+		AdminAPIToken:    "admin",
+		Cluster: &config.Cluster{
+			Role:         role,
+			NodeID:       strings.TrimPrefix(url, "http://"),
+			Coordinator:  coordinator,
+			AdvertiseURL: url,
+			Token:        "cluster",
+		},
+	}
+	c.SetDefaults()
+	return c
+}
+
+// TestCoordinator verifies workers heartbeat to the coordinator and
+// invocations go to a node with the function cached, moving on to the
+// next node when one is unreachable or unavailable
+func TestCoordinator(t *testing.T) {
+	coord := NewCoordinator(testConf(config.RoleCoordinator, "", ""), gklog.NewNopLogger(), metrics.Discard())
+	cs := httptest.NewServer(coord.Router())
+	defer cs.Close()
+
+	var ran []string
+	worker := func(status int) *httptest.Server {
+		var ts *httptest.Server
+		ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ran = append(ran, ts.URL)
+			if r.Header.Get(TokenHeader) != "cluster" || r.Header.Get(CallerHeader) != "127.0.0.1" {
+				t.Errorf("unexpected headers %v", r.Header)
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"data": "hello"})
+		}))
+		return ts
+	}
+	down := worker(http.StatusOK)
+	down.Close()
+	busy := worker(http.StatusServiceUnavailable)
+	defer busy.Close()
+	ok := worker(http.StatusOK)
+	defer ok.Close()
+
+	cached := []string{"github.com/briandowns/smile.Greet().1.9.2"}
+	for _, w := range []struct {
+		url    string
+		cached []string
+		jails  int
+	}{{down.URL, cached, 0}, {busy.URL, cached, 1}, {ok.URL, nil, 2}} {
+		w := w
+		hb := NewHeartbeater(testConf(config.RoleWorker, cs.URL, w.url), gklog.NewNopLogger(), func() (*NodeStatus, error) {
+			return &NodeStatus{RunningJails: w.jails, Cached: w.cached}, nil
+		})
+		if err := hb.Send(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(coord.Registry().Nodes()); n != 3 {
+		t.Fatalf("expected 3 nodes got %d", n)
+	}
+
+	res, err := http.Post(cs.URL+"/api/v1/function", "application/json", strings.NewReader(`{"url": "github.com/briandowns/smile", "call": "Greet()"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get(NodeHeader) != strings.TrimPrefix(ok.URL, "http://") {
+		t.Errorf("expected 200 from %s got %d from %s", ok.URL, res.StatusCode, res.Header.Get(NodeHeader))
+	}
+	if len(ran) != 2 || ran[0] != busy.URL || ran[1] != ok.URL {
+		t.Errorf("expected busy then ok node got %v", ran)
+	}
+	for _, n := range coord.Registry().Nodes() {
+		if n.Alive != (n.URL == ok.URL) {
+			t.Errorf("expected only the ok node alive got %+v", n)
+		}
+	}
+}
+
+// TestHeartbeat_Forbidden verifies heartbeats without the cluster
+// token are refused
+func TestHeartbeat_Forbidden(t *testing.T) {
+	coord := NewCoordinator(testConf(config.RoleCoordinator, "", ""), gklog.NewNopLogger(), metrics.Discard())
+	cs := httptest.NewServer(coord.Router())
+	defer cs.Close()
+	conf := testConf(config.RoleWorker, cs.URL, "http://worker")
+	conf.Cluster.Token = "wrong"
+	conf.Cluster.HeartbeatInterval = config.Duration(time.Second)
+	hb := NewHeartbeater(conf, gklog.NewNopLogger(), func() (*NodeStatus, error) { return &NodeStatus{}, nil })
+	if err := hb.Send(context.Background()); err == nil {
+		t.Error("expected forbidden heartbeat to fail")
+	}
+	if n := len(coord.Registry().Nodes()); n != 0 {
+		t.Errorf("expected no nodes got %d", n)
+	}
+}
+
+// TestCoordinator_NoRetry verifies an invocation a worker accepted
+// but failed to answer isn't tried on another node
+func TestCoordinator_NoRetry(t *testing.T) {
+	coord := NewCoordinator(testConf(config.RoleCoordinator, "", ""), gklog.NewNopLogger(), metrics.Discard())
+	cs := httptest.NewServer(coord.Router())
+	defer cs.Close()
+
+	var ran int
+	worker := func(jails int) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ran++
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+		}))
+		hb := NewHeartbeater(testConf(config.RoleWorker, cs.URL, ts.URL), gklog.NewNopLogger(), func() (*NodeStatus, error) {
+			return &NodeStatus{RunningJails: jails}, nil
+		})
+		if err := hb.Send(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	a := worker(0)
+	defer a.Close()
+	b := worker(1)
+	defer b.Close()
+
+	res, err := http.Post(cs.URL+"/api/v1/function", "application/json", strings.NewReader(`{"url": "github.com/briandowns/smile", "call": "Greet()"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("wrong status code: got %v want %v", res.StatusCode, http.StatusBadGateway)
+	}
+	if ran != 1 {
+		t.Errorf("expected the invocation to reach one node got %d", ran)
+	}
+}
+
+// TestForwardTimeout verifies routed invocations may take as long as
+// a worker may spend building and running the function
+func TestForwardTimeout(t *testing.T) {
+	conf := testConf(config.RoleCoordinator, "", "")
+	conf.Jails.BuildTimeout = config.Duration(time.Minute)
+	conf.Jails.MaxExecTimeout = config.Duration(2 * time.Minute)
+	coord := NewCoordinator(conf, gklog.NewNopLogger(), metrics.Discard())
+	if d := coord.forwardTimeout(); d != 3*time.Minute+forwardMargin {
+		t.Errorf("unexpected timeout %s", d)
+	}
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// StatusFunc returns the current capacity of the node
+type StatusFunc func() (*NodeStatus, error)
+
+// Heartbeater sends the node's capacity to the coordinator
+type Heartbeater struct {
+	conf   *config.Config
+	logger gklog.Logger
+	status StatusFunc
+	client *http.Client
+	id     string
+}
+
+// NodeID returns the id of the node, the configured node id or the
+// host name
+func NodeID(conf *config.Config) string {
+	if conf.Cluster != nil && conf.Cluster.NodeID != "" {
+		return conf.Cluster.NodeID
+	}
+	id, _ := os.Hostname()
+	return id
+}
+
+// NewHeartbeater creates a new value of type Heartbeater pointer
+func NewHeartbeater(conf *config.Config, l gklog.Logger, status StatusFunc) *Heartbeater {
+	return &Heartbeater{
+		conf:   conf,
+		logger: l,
+		status: status,
+		client: &http.Client{Timeout: conf.Cluster.HeartbeatInterval.D()},
+		id:     NodeID(conf),
+	}
+}
+
+// Run sends a heartbeat every heartbeat interval until the given
+// context is done
+func (h *Heartbeater) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.conf.Cluster.HeartbeatInterval.D())
+	defer ticker.Stop()
+	for {
+		if err := h.Send(ctx); err != nil {
+			h.logger.Log("error", "heartbeat: "+err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send sends one heartbeat
+func (h *Heartbeater) Send(ctx context.Context) error {
+	s, err := h.status()
+	if err != nil {
+		return err
+	}
+	s.ID = h.id
+	s.URL = h.conf.Cluster.AdvertiseURL
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(h.conf.Cluster.Coordinator, "/")+apiPrefix+"/cluster/heartbeat", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TokenHeader, h.conf.Cluster.Token)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("coordinator answered %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package cluster
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeStatus is the capacity a worker node heartbeats
+type NodeStatus struct {
+	ID           string   `json:"id"`
+	URL          string   `json:"url"`
+	FreeIPs      int      `json:"free_ips"`
+	RunningJails int      `json:"running_jails"`
+	Load         float64  `json:"load"`
+	Cached       []string `json:"cached"`
+}
+
+// Node is a worker node known to the coordinator
+type Node struct {
+	NodeStatus
+	LastSeen time.Time `json:"last_seen"`
+	Alive    bool      `json:"alive"`
+	InFlight int       `json:"in_flight"`
+
+	// failed is when routing to the node last failed. The node isn't
+	// routed to again until it heartbeats after that
+	failed time.Time
+}
+
+// Registry holds the worker nodes and their last heartbeat
+type Registry struct {
+	mu      sync.Mutex
+	nodes   map[string]*Node
+	timeout time.Duration
+	now     func() time.Time
+}
+
+// NewRegistry creates a registry in which nodes not heard from for
+// the given timeout aren't routed to
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		nodes:   make(map[string]*Node),
+		timeout: timeout,
+		now:     time.Now,
+	}
+}
+
+// Heartbeat records the given status of a node
+func (r *Registry) Heartbeat(s *NodeStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.nodes[s.ID]
+	if !ok {
+		n = &Node{}
+		r.nodes[s.ID] = n
+	}
+	n.NodeStatus = *s
+	n.LastSeen = r.now()
+}
+
+// alive reports whether the node can be routed to
+func (r *Registry) alive(n *Node) bool {
+	return r.now().Sub(n.LastSeen) <= r.timeout && !n.failed.After(n.LastSeen)
+}
+
+// Nodes returns every known node sorted by id
+func (r *Registry) Nodes() []*Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes := make([]*Node, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		c := *n
+		c.Alive = r.alive(n)
+		nodes = append(nodes, &c)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Candidates returns the ids of the nodes an invocation of the given
+// function can be routed to, best first. Nodes with a cached binary
+// of the function come first, then the least busy
+func (r *Registry) Candidates(url, call string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prefix := url + "." + call + "."
+	type candidate struct {
+		id   string
+		warm bool
+		busy int
+		load float64
+	}
+	var cs []candidate
+	for id, n := range r.nodes {
+		if !r.alive(n) {
+			continue
+		}
+		c := candidate{id: id, busy: n.RunningJails + n.InFlight, load: n.Load}
+		for _, k := range n.Cached {
+			if strings.HasPrefix(k, prefix) {
+				c.warm = true
+				break
+			}
+		}
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		a, b := cs[i], cs[j]
+		switch {
+		case a.warm != b.warm:
+			return a.warm
+		case a.busy != b.busy:
+			return a.busy < b.busy
+		case a.load != b.load:
+			return a.load < b.load
+		}
+		return a.id < b.id
+	})
+	ids := make([]string, len(cs))
+	for i, c := range cs {
+		ids[i] = c.id
+	}
+	return ids
+}
+
+// acquire returns the URL of the given node and counts an invocation
+// routed to it until released
+func (r *Registry) acquire(id string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.nodes[id]
+	if !ok {
+		return "", false
+	}
+	n.InFlight++
+	return n.URL, true
+}
+
+// release ends an invocation routed to the given node, marking the
+// node failed if it couldn't be carried out
+func (r *Registry) release(id string, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.nodes[id]
+	if !ok {
+		return
+	}
+	n.InFlight--
+	if failed {
+		n.failed = r.now()
+	}
+}
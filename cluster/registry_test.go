@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCandidates verifies nodes with a cached binary of the function
+// come first, then the least busy, and nodes not heard from recently
+// are skipped
+func TestCandidates(t *testing.T) {
+	now := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRegistry(15 * time.Second)
+	r.now = func() time.Time { return now }
+	statuses := []*NodeStatus{
+		{ID: "a", URL: "http://a", RunningJails: 1},
+		{ID: "b", URL: "http://b", RunningJails: 4, Cached: []string{"github.com/briandowns/smile.Greet().1.9.2"}},
+		{ID: "c", URL: "http://c"},
+	}
+	for _, s := range statuses {
+		r.Heartbeat(s)
+	}
+	now = now.Add(10 * time.Second)
+	r.Heartbeat(&NodeStatus{ID: "d", URL: "http://d", Load: 2})
+	now = now.Add(10 * time.Second)
+
+	if ids := r.Candidates("github.com/briandowns/smile", "Greet()"); !reflect.DeepEqual(ids, []string{"d"}) {
+		t.Errorf("expected only d alive got %v", ids)
+	}
+	for _, s := range statuses {
+		r.Heartbeat(s)
+	}
+	if ids := r.Candidates("github.com/briandowns/smile", "Greet()"); !reflect.DeepEqual(ids, []string{"b", "c", "d", "a"}) {
+		t.Errorf("expected b c d a got %v", ids)
+	}
+	if ids := r.Candidates("github.com/briandowns/smile", "Wave()"); !reflect.DeepEqual(ids, []string{"c", "d", "a", "b"}) {
+		t.Errorf("expected c d a b got %v", ids)
+	}
+}
+
+// TestRelease_Failed verifies a node that failed isn't routed to
+// until it heartbeats again
+func TestRelease_Failed(t *testing.T) {
+	now := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRegistry(time.Minute)
+	r.now = func() time.Time { return now }
+	r.Heartbeat(&NodeStatus{ID: "a", URL: "http://a"})
+	if _, ok := r.acquire("a"); !ok {
+		t.Fatal("expected node a")
+	}
+	now = now.Add(time.Second)
+	r.release("a", true)
+	if ids := r.Candidates("", ""); len(ids) != 0 {
+		t.Errorf("expected no candidates got %v", ids)
+	}
+	now = now.Add(time.Second)
+	r.Heartbeat(&NodeStatus{ID: "a", URL: "http://a"})
+	if ids := r.Candidates("", ""); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("expected a got %v", ids)
+	}
+}
@@ -2,7 +2,13 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // Filesystem
@@ -29,37 +35,496 @@ type IP4 struct {
 // Jails contains necessary components to setup
 // the necessary jails
 type Jails struct {
-	BaseJailDir            string `json:"base_jail_dir"`
-	CacheDefaultExpiration string `json:"cache_default_expiration"`
-	CachePurgeAfter        string `json:"cache_purge_after"`
-	ChildrenMax            int    `json:"children_max"`
-	MonitoringAddr         string `json:"monitoring_addr"`
-	BuildTimeout           string `json:"build_timeout"`
-	ExecTimeout            string `json:"exec_timeout"`
+	BaseJailDir            string   `json:"base_jail_dir"`
+	CacheDefaultExpiration Duration `json:"cache_default_expiration"`
+	CachePurgeAfter        Duration `json:"cache_purge_after"`
+	ChildrenMax            int      `json:"children_max"`
+	MonitoringAddr         string   `json:"monitoring_addr"`
+	BuildTimeout           Duration `json:"build_timeout"`
+	ExecTimeout            Duration `json:"exec_timeout"`
+	MaxExecTimeout         Duration `json:"max_exec_timeout"`
+	EnvAllowList           []string `json:"env_allow_list"`
+	PackageAllowList       []string `json:"package_allow_list"`
+}
+
+// Artifacts configures where system initialization gets the base
+// system packages and Go tarballs from and how they're verified
+type Artifacts struct {
+	Dir             string `json:"dir"`
+	MirrorURL       string `json:"mirror_url"`
+	CacheDir        string `json:"cache_dir"`
+	Manifest        string `json:"manifest"`
+	AllowUnverified bool   `json:"allow_unverified"`
+	TLSSkipVerify   bool   `json:"tls_skip_verify"`
+}
+
+// Patching configures periodic patching of the active release's
+// base jail with freebsd-update
+type Patching struct {
+	Interval Duration `json:"interval"`
+	Keep     int      `json:"keep"`
+}
+
+// Audit configures the invocation history and audit log kept in
+// the state directory
+type Audit struct {
+	Retention Duration `json:"retention"`
+}
+
+// Metrics configures where metrics are sent. Backends are any of
+// statsd and prometheus, statsd alone when none are given
+type Metrics struct {
+	Backends []string `json:"backends"`
+}
+
+// Tracing configures exporting OpenTelemetry spans over OTLP/HTTP.
+// SampleRatio is the share of new traces sampled, all when not set
+type Tracing struct {
+	Endpoint    string  `json:"endpoint"`
+	Insecure    bool    `json:"insecure"`
+	ServiceName string  `json:"service_name"`
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+// Health configures the thresholds of the readiness checks
+type Health struct {
+	MinFreeIPs     int `json:"min_free_ips"`
+	MinFreeSpaceMB int `json:"min_free_space_mb"`
+}
+
+// Types of remote artifact store
+const (
+	StoreDir  = "dir"
+	StoreS3   = "s3"
+	StoreNode = "node"
+)
+
+// ArtifactStore configures where built function binaries are kept as
+// content addressed artifacts, artifacts in the state directory by
+// default. Remote, when set, is consulted before building and gets
+// every binary built
+type ArtifactStore struct {
+	Dir    string       `json:"dir"`
+	Remote *RemoteStore `json:"remote"`
+}
+
+// RemoteStore is a store artifacts are shared through. Type dir is a
+// directory, such as a shared mount, s3 an S3 compatible bucket and
+// node another node of the cluster, reached at URL
+type RemoteStore struct {
+	Type            string `json:"type"`
+	Dir             string `json:"dir"`
+	URL             string `json:"url"`
+	Bucket          string `json:"bucket"`
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// Queues configures the message brokers functions can be triggered
+// from
+type Queues struct {
+	NATS  *NATS  `json:"nats"`
+	Redis *Redis `json:"redis"`
+}
+
+// NATS is a NATS server reached at URL, such as nats://localhost:4222,
+// authenticating with Token or User and Password when set
+type NATS struct {
+	URL      string `json:"url"`
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Redis is a Redis server whose streams are consumed, reached at Addr
+// such as localhost:6379
+type Redis struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// Roles of a node in a cluster
+const (
+	RoleCoordinator = "coordinator"
+	RoleWorker      = "worker"
+)
+
+// Cluster configures running as a coordinator, routing invocations
+// to worker nodes, or as a worker heartbeating its capacity to the
+// coordinator. Token authenticates the heartbeats
+type Cluster struct {
+	Role              string   `json:"role"`
+	NodeID            string   `json:"node_id"`
+	Coordinator       string   `json:"coordinator"`
+	AdvertiseURL      string   `json:"advertise_url"`
+	Token             string   `json:"token"`
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+	NodeTimeout       Duration `json:"node_timeout"`
+	Retries           int      `json:"retries"`
+}
+
+// DefaultStateDir is where state that must outlive the process, such
+// as the progress of system initialization, is kept when no state
+// directory is configured
+const DefaultStateDir = "/var/db/sky-island"
+
+// Defaults applied to fields that aren't set
+const (
+	DefaultHTTPPort          = 3280
+	DefaultAdminTokenHeader  = "X-Auth-Token"
+	DefaultBuildTimeout      = Duration(60 * time.Second)
+	DefaultExecTimeout       = Duration(30 * time.Second)
+	DefaultHeartbeatInterval = Duration(5 * time.Second)
+	DefaultClusterRetries    = 2
+	DefaultS3Region          = "us-east-1"
+)
+
+// Duration is a time.Duration set in config files as a string such
+// as "30s" or "24h"
+type Duration time.Duration
+
+// D returns the duration as a time.Duration
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+// String implements the fmt.Stringer interface
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("duration must be a string such as 30s")
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// parseDuration parses the given duration, which can't be negative.
+// An empty duration is zero
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q can't be negative", s)
+	}
+	return d, nil
 }
 
 // Config contains the parameters necessary to run sky-island
 type Config struct {
-	Release          string
-	HTTPPort         int         `json:"http_port"`
-	AdminAPIToken    string      `json:"admin_api_token"`
-	AdminTokenHeader string      `json:"admin_token_header"`
-	GoVersion        string      `json:"go_version"`
-	Filesystem       *Filesystem `json:"filesystem"`
-	Network          *Network    `json:"network"`
-	Jails            *Jails      `json:"jails"`
-}
-
-// Load prses the given file and creates a new value
-// of type Config pointer
+	Release          string         `json:"release"`
+	HTTPPort         int            `json:"http_port"`
+	AdminAPIToken    string         `json:"admin_api_token"`
+	AdminTokenHeader string         `json:"admin_token_header"`
+	GoVersion        string         `json:"go_version"`
+	GoVersions       []string       `json:"go_versions"`
+	GoTarballDir     string         `json:"go_tarball_dir"`
+	StateDir         string         `json:"state_dir"`
+	Filesystem       *Filesystem    `json:"filesystem"`
+	Network          *Network       `json:"network"`
+	Jails            *Jails         `json:"jails"`
+	Artifacts        *Artifacts     `json:"artifacts"`
+	Patching         *Patching      `json:"patching"`
+	Audit            *Audit         `json:"audit"`
+	Metrics          *Metrics       `json:"metrics"`
+	Tracing          *Tracing       `json:"tracing"`
+	Health           *Health        `json:"health"`
+	Cluster          *Cluster       `json:"cluster"`
+	ArtifactStore    *ArtifactStore `json:"artifact_store"`
+	Queues           *Queues        `json:"queues"`
+	SecretsKey       string         `json:"secrets_key"`
+	SecretsKeyFile   string         `json:"secrets_key_file"`
+}
+
+// Load parses the given JSON, YAML or TOML file, chosen by its
+// extension, applies SKYISLAND_* environment variable overrides and
+// defaults and validates the result. Unknown fields, values of the
+// wrong type and invalid settings are all returned at once in a
+// *ValidationError
 func Load(confFile string) (*Config, error) {
 	f, err := ioutil.ReadFile(confFile)
 	if err != nil {
 		return nil, err
 	}
+	raw, err := decodeRaw(f, filepath.Ext(confFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", confFile, err)
+	}
+	t := reflectConfig()
+	problems := applyEnv(raw, t, envPrefix)
+	problems = append(problems, checkFields(raw, t, "")...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
 	var c Config
-	if err := json.Unmarshal(f, &c); err != nil {
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
+
+// SetDefaults sets the fields that aren't set to their defaults and
+// creates the sections every service depends on
+func (c *Config) SetDefaults() {
+	if c.HTTPPort == 0 {
+		c.HTTPPort = DefaultHTTPPort
+	}
+	if c.AdminTokenHeader == "" {
+		c.AdminTokenHeader = DefaultAdminTokenHeader
+	}
+	if c.StateDir == "" {
+		c.StateDir = DefaultStateDir
+	}
+	if c.Filesystem == nil {
+		c.Filesystem = &Filesystem{}
+	}
+	if c.Network == nil {
+		c.Network = &Network{}
+	}
+	if c.Jails == nil {
+		c.Jails = &Jails{}
+	}
+	if c.Jails.BuildTimeout == 0 {
+		c.Jails.BuildTimeout = DefaultBuildTimeout
+	}
+	if c.Jails.ExecTimeout == 0 {
+		c.Jails.ExecTimeout = DefaultExecTimeout
+	}
+	if c.Jails.MaxExecTimeout == 0 {
+		c.Jails.MaxExecTimeout = c.Jails.ExecTimeout
+	}
+	if c.ArtifactStore != nil && c.ArtifactStore.Remote != nil && c.ArtifactStore.Remote.Type == StoreS3 && c.ArtifactStore.Remote.Region == "" {
+		c.ArtifactStore.Remote.Region = DefaultS3Region
+	}
+	if c.Cluster != nil {
+		if c.Cluster.HeartbeatInterval == 0 {
+			c.Cluster.HeartbeatInterval = DefaultHeartbeatInterval
+		}
+		if c.Cluster.NodeTimeout == 0 {
+			c.Cluster.NodeTimeout = 3 * c.Cluster.HeartbeatInterval
+		}
+		if c.Cluster.Retries == 0 {
+			c.Cluster.Retries = DefaultClusterRetries
+		}
+	}
+}
+
+// Coordinator reports whether the config is of a cluster coordinator,
+// which runs no jails itself
+func (c *Config) Coordinator() bool {
+	return c.Cluster != nil && c.Cluster.Role == RoleCoordinator
+}
+
+// ValidationError lists every problem found in a config
+type ValidationError struct {
+	Problems []string
+}
+
+// Error implements the error interface
+func (v *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(v.Problems, "; ")
+}
+
+// Validate checks the sections and fields every command depends on
+// are set and valid, returning a *ValidationError listing all that
+// aren't
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if c.HTTPPort <= 0 || c.HTTPPort > 65535 {
+		add("http_port must be between 1 and 65535")
+	}
+	// an empty token would match requests without the admin header
+	if c.AdminAPIToken == "" {
+		add("admin_api_token required")
+	}
+	c.validateCluster(add)
+	c.validateArtifactStore(add)
+	c.validateQueues(add)
+	// a coordinator only routes invocations to its workers
+	if !c.Coordinator() {
+		if c.Release == "" {
+			add("release required")
+		}
+		if c.GoVersion == "" {
+			add("go_version required")
+		}
+		if c.Filesystem == nil || c.Filesystem.ZFSDataset == "" {
+			add("filesystem.zfs_dataset required")
+		}
+		if c.Jails == nil || c.Jails.BaseJailDir == "" {
+			add("jails.base_jail_dir required")
+		}
+		if c.Network == nil || c.Network.IP4 == nil {
+			add("network.ip4 required")
+		}
+	}
+	if c.Jails != nil {
+		if c.Jails.ChildrenMax < 0 {
+			add("jails.children_max can't be negative")
+		}
+		if c.Jails.MaxExecTimeout != 0 && c.Jails.MaxExecTimeout < c.Jails.ExecTimeout {
+			add("jails.max_exec_timeout must be at least jails.exec_timeout")
+		}
+	}
+	if c.Network != nil && c.Network.IP4 != nil {
+		ip4 := c.Network.IP4
+		if ip4.StartAddr == "" {
+			add("network.ip4.start_addr required")
+		} else if ip := net.ParseIP(ip4.StartAddr); ip == nil || ip.To4() == nil {
+			add("network.ip4.start_addr %q isn't an IPv4 address", ip4.StartAddr)
+		}
+		if ip4.Range <= 0 || ip4.Range > 255 {
+			add("network.ip4.range must be between 1 and 255")
+		}
+		for name, addr := range map[string]string{"mask": ip4.Mask, "gateway": ip4.Gateway} {
+			if addr != "" && net.ParseIP(addr) == nil {
+				add("network.ip4.%s %q isn't an IP address", name, addr)
+			}
+		}
+		for _, addr := range ip4.DNS {
+			if net.ParseIP(addr) == nil {
+				add("network.ip4.dns %q isn't an IP address", addr)
+			}
+		}
+	}
+	if c.Patching != nil && c.Patching.Keep < 0 {
+		add("patching.keep can't be negative")
+	}
+	if c.Metrics != nil {
+		for _, b := range c.Metrics.Backends {
+			if b != "statsd" && b != "prometheus" {
+				add("metrics.backends %q must be statsd or prometheus", b)
+			}
+		}
+	}
+	if c.Tracing != nil && (c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1) {
+		add("tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.Health != nil && (c.Health.MinFreeIPs < 0 || c.Health.MinFreeSpaceMB < 0) {
+		add("health thresholds can't be negative")
+	}
+	if c.SecretsKey != "" && c.SecretsKeyFile != "" {
+		add("only one of secrets_key and secrets_key_file may be set")
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateCluster checks the cluster section
+func (c *Config) validateCluster(add func(string, ...interface{})) {
+	if c.Cluster == nil {
+		return
+	}
+	switch c.Cluster.Role {
+	case RoleCoordinator:
+	case RoleWorker:
+		if c.Cluster.Coordinator == "" {
+			add("cluster.coordinator required for workers")
+		}
+		if c.Cluster.AdvertiseURL == "" {
+			add("cluster.advertise_url required for workers")
+		}
+	default:
+		add("cluster.role %q must be %s or %s", c.Cluster.Role, RoleCoordinator, RoleWorker)
+		return
+	}
+	if c.Cluster.Token == "" {
+		add("cluster.token required")
+	}
+	for name, u := range map[string]string{"coordinator": c.Cluster.Coordinator, "advertise_url": c.Cluster.AdvertiseURL} {
+		if u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			add("cluster.%s %q must be an http or https URL", name, u)
+		}
+	}
+	if c.Cluster.Retries < 0 {
+		add("cluster.retries can't be negative")
+	}
+}
+
+// validateArtifactStore checks the artifact store section
+func (c *Config) validateArtifactStore(add func(string, ...interface{})) {
+	if c.ArtifactStore == nil || c.ArtifactStore.Remote == nil {
+		return
+	}
+	r := c.ArtifactStore.Remote
+	switch r.Type {
+	case StoreDir:
+		if r.Dir == "" {
+			add("artifact_store.remote.dir required for dir stores")
+		}
+		return
+	case StoreS3:
+		if r.Bucket == "" {
+			add("artifact_store.remote.bucket required for s3 stores")
+		}
+		if r.AccessKeyID == "" || r.SecretAccessKey == "" {
+			add("artifact_store.remote.access_key_id and secret_access_key required for s3 stores")
+		}
+	case StoreNode:
+		if c.Cluster == nil || c.Cluster.Token == "" {
+			add("artifact_store.remote of type node requires cluster.token")
+		}
+	default:
+		add("artifact_store.remote.type %q must be %s, %s or %s", r.Type, StoreDir, StoreS3, StoreNode)
+		return
+	}
+	if !strings.HasPrefix(r.URL, "http://") && !strings.HasPrefix(r.URL, "https://") {
+		add("artifact_store.remote.url %q must be an http or https URL", r.URL)
+	}
+}
+
+// validateQueues checks the queues section
+func (c *Config) validateQueues(add func(string, ...interface{})) {
+	if c.Queues == nil {
+		return
+	}
+	if n := c.Queues.NATS; n != nil {
+		if !strings.HasPrefix(n.URL, "nats://") {
+			add("queues.nats.url %q must be a nats URL", n.URL)
+		}
+		if n.Token != "" && n.User != "" {
+			add("only one of queues.nats.token and queues.nats.user may be set")
+		}
+	}
+	if r := c.Queues.Redis; r != nil {
+		if r.Addr == "" {
+			add("queues.redis.addr required")
+		}
+		if r.DB < 0 {
+			add("queues.redis.db can't be negative")
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package config
 
-import "testing"
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 // TestLoad validates that the config.Load function reads
 // the data in the given file
@@ -37,3 +43,190 @@ func TestLoad_BadJSON_Failure(t *testing.T) {
 		t.Error("expected nil")
 	}
 }
+
+// TestLoad_Formats validates that YAML and TOML configs are read the
+// same as JSON ones, durations are parsed and defaults are set
+func TestLoad_Formats(t *testing.T) {
+	for _, file := range []string{"testdata/config.yaml", "testdata/config.toml"} {
+		conf, err := Load(file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if conf.HTTPPort != 3280 || conf.Network.IP4.Range != 220 || len(conf.Network.IP4.DNS) != 2 {
+			t.Errorf("%s: unexpected config %+v", file, conf)
+		}
+		if conf.Jails.BuildTimeout.D() != 10*time.Second || conf.Jails.ExecTimeout.D() != 5*time.Second {
+			t.Errorf("%s: unexpected timeouts %v %v", file, conf.Jails.BuildTimeout, conf.Jails.ExecTimeout)
+		}
+		if conf.Jails.MaxExecTimeout != conf.Jails.ExecTimeout || conf.AdminTokenHeader != DefaultAdminTokenHeader || conf.StateDir != DefaultStateDir {
+			t.Errorf("%s: expected defaults got %+v", file, conf)
+		}
+	}
+}
+
+// TestLoad_Invalid validates that every problem in a config is
+// returned at once
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load("testdata/invalid_config.json")
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected validation error got %v", err)
+	}
+	expected := []string{
+		"unknown field base_sys_pkg_dir, use artifacts.dir",
+		"unknown field filesystem.compresion",
+		"http_port must be an integer",
+		`jails.exec_timeout: invalid duration "5 seconds"`,
+	}
+	for _, e := range expected {
+		var found bool
+		for _, p := range verr.Problems {
+			found = found || p == e
+		}
+		if !found {
+			t.Errorf("expected %q in %v", e, verr.Problems)
+		}
+	}
+}
+
+// TestLoad_Env validates that environment variables override the
+// config file
+func TestLoad_Env(t *testing.T) {
+	os.Setenv("SKYISLAND_HTTP_PORT", "8080")
+	os.Setenv("SKYISLAND_JAILS_EXEC_TIMEOUT", "2m")
+	os.Setenv("SKYISLAND_NETWORK_IP4_DNS", "1.1.1.1, 8.8.8.8")
+	os.Setenv("SKYISLAND_METRICS_BACKENDS", "prometheus")
+	defer func() {
+		for _, k := range []string{"SKYISLAND_HTTP_PORT", "SKYISLAND_JAILS_EXEC_TIMEOUT", "SKYISLAND_NETWORK_IP4_DNS", "SKYISLAND_METRICS_BACKENDS"} {
+			os.Unsetenv(k)
+		}
+	}()
+	conf, err := Load("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.HTTPPort != 8080 || conf.Jails.ExecTimeout.D() != 2*time.Minute {
+		t.Errorf("expected overrides got %d %v", conf.HTTPPort, conf.Jails.ExecTimeout)
+	}
+	if !reflect.DeepEqual(conf.Network.IP4.DNS, []string{"1.1.1.1", "8.8.8.8"}) || !reflect.DeepEqual(conf.Metrics.Backends, []string{"prometheus"}) {
+		t.Errorf("expected overrides got %v %v", conf.Network.IP4.DNS, conf.Metrics)
+	}
+
+	os.Setenv("SKYISLAND_HTTP_PORT", "http")
+	if _, err := Load("testdata/config.yaml"); err == nil || !strings.Contains(err.Error(), "SKYISLAND_HTTP_PORT") {
+		t.Errorf("expected invalid override error got %v", err)
+	}
+}
+
+// TestValidate validates that missing sections are reported rather
+// than causing a panic
+func TestValidate(t *testing.T) {
+	c := &Config{}
+	c.SetDefaults()
+	err := c.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected validation error got %v", err)
+	}
+	if len(verr.Problems) != 6 || verr.Problems[0] != "admin_api_token required" {
+		t.Errorf("expected 6 problems got %v", verr.Problems)
+	}
+}
+
+// TestValidate_Cluster validates a coordinator needs no host settings
+// and a worker needs to know its coordinator
+func TestValidate_Cluster(t *testing.T) {
+	c := &Config{AdminAPIToken: "admin", Cluster: &Cluster{Role: RoleCoordinator, Token: "secret"}}
+	c.SetDefaults()
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected valid coordinator got %v", err)
+	}
+	if c.Cluster.NodeTimeout != 3*DefaultHeartbeatInterval {
+		t.Errorf("expected default node timeout got %s", c.Cluster.NodeTimeout)
+	}
+	c.Cluster = &Cluster{Role: RoleWorker, Token: "secret"}
+	verr, ok := c.Validate().(*ValidationError)
+	if !ok {
+		t.Fatal("expected validation error")
+	}
+	if len(verr.Problems) != 7 {
+		t.Errorf("expected 7 problems got %v", verr.Problems)
+	}
+}
+
+// TestValidate_ArtifactStore validates remote stores are checked for
+// the settings of their type
+func TestValidate_ArtifactStore(t *testing.T) {
+	for _, tc := range []struct {
+		remote   RemoteStore
+		problems int
+	}{
+		{RemoteStore{Type: StoreDir, Dir: "/mnt/artifacts"}, 0},
+		{RemoteStore{Type: StoreS3, URL: "http://minio:9000", Bucket: "b", AccessKeyID: "a", SecretAccessKey: "s"}, 0},
+		{RemoteStore{Type: StoreS3, URL: "minio:9000"}, 3},
+		{RemoteStore{Type: StoreNode, URL: "http://node"}, 1},
+		{RemoteStore{Type: "ftp"}, 1},
+	} {
+		r := tc.remote
+		c := &Config{AdminAPIToken: "admin", Cluster: &Cluster{Role: RoleCoordinator, Token: "secret"}, ArtifactStore: &ArtifactStore{Remote: &r}}
+		if r.Type == StoreNode {
+			c.Cluster = nil
+			c.Release, c.GoVersion = "11.1-RELEASE", "1.9.2"
+			c.Filesystem = &Filesystem{ZFSDataset: "zroot"}
+			c.Jails = &Jails{BaseJailDir: "/zroot/jails"}
+			c.Network = &Network{IP4: &IP4{StartAddr: "10.0.0.2", Range: 10}}
+		}
+		c.SetDefaults()
+		var problems []string
+		if verr, ok := c.Validate().(*ValidationError); ok {
+			problems = verr.Problems
+		}
+		if len(problems) != tc.problems {
+			t.Errorf("%s: expected %d problems got %v", r.Type, tc.problems, problems)
+		}
+	}
+}
+
+// TestValidate_Queues verifies broker settings are checked
+func TestValidate_Queues(t *testing.T) {
+	c := &Config{
+		AdminAPIToken: "admin",
+		Cluster:       &Cluster{Role: RoleCoordinator, Token: "secret"},
+		Queues: &Queues{
+			NATS:  &NATS{URL: "localhost:4222", Token: "t", User: "u"},
+			Redis: &Redis{DB: -1},
+		},
+	}
+	c.SetDefaults()
+	verr, ok := c.Validate().(*ValidationError)
+	if !ok || len(verr.Problems) != 4 {
+		t.Fatalf("expected 4 problems got %v", verr)
+	}
+	c.Queues = &Queues{NATS: &NATS{URL: "nats://localhost:4222"}, Redis: &Redis{Addr: "localhost:6379"}}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDecodeRaw_TOML validates TOML is decoded like the other
+// formats and errors carry their line
+func TestDecodeRaw_TOML(t *testing.T) {
+	raw, err := decodeRaw([]byte("a.b = 1\n[c]\nd = { e = \"x\\ty\", f = [true, false] }\ng = 1.5\n[[h]]\ni = \"j\"\n"), ".toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"a": map[string]interface{}{"b": int64(1)},
+		"c": map[string]interface{}{
+			"d": map[string]interface{}{"e": "x\ty", "f": []interface{}{true, false}},
+			"g": 1.5,
+		},
+		"h": []interface{}{map[string]interface{}{"i": "j"}},
+	}
+	if !reflect.DeepEqual(raw, expected) {
+		t.Errorf("expected %v got %v", expected, raw)
+	}
+	if _, err := decodeRaw([]byte("a = 1\na = 2\n"), ".toml"); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected duplicate key error on line 2 got %v", err)
+	}
+}
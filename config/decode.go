@@ -0,0 +1,307 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// envPrefix prefixes the environment variables overriding config
+// fields, e.g. SKYISLAND_HTTP_PORT and SKYISLAND_JAILS_EXEC_TIMEOUT
+const envPrefix = "SKYISLAND"
+
+// renamedFields are fields no longer read, with the field that
+// replaced them
+var renamedFields = map[string]string{
+	"base_sys_pkg_dir": "artifacts.dir",
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// reflectConfig returns the type fields are checked against
+func reflectConfig() reflect.Type {
+	return reflect.TypeOf(Config{})
+}
+
+// decodeRaw decodes the given config file contents into a generic
+// map according to the file's extension
+func decodeRaw(b []byte, ext string) (map[string]interface{}, error) {
+	switch strings.ToLower(ext) {
+	case ".json", "":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var raw map[string]interface{}
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	case ".yaml", ".yml":
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		raw, ok := stringKeys(v).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a mapping at the top level")
+		}
+		return raw, nil
+	case ".toml":
+		var raw map[string]interface{}
+		if _, err := toml.Decode(string(b), &raw); err != nil {
+			return nil, err
+		}
+		return tomlLists(raw).(map[string]interface{}), nil
+	default:
+		return nil, fmt.Errorf("unsupported config format %q, must be .json, .yaml, .yml or .toml", ext)
+	}
+}
+
+// tomlLists converts the arrays of tables toml decodes into lists
+// like those of the other formats
+func tomlLists(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = tomlLists(e)
+		}
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = tomlLists(e)
+		}
+		return list
+	case []interface{}:
+		for i := range v {
+			v[i] = tomlLists(v[i])
+		}
+	}
+	return v
+}
+
+// stringKeys converts the maps yaml decodes into maps keyed by
+// strings
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = stringKeys(e)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = stringKeys(v[i])
+		}
+	}
+	return v
+}
+
+// fieldsByName returns the fields of the given struct type by their
+// json name
+func fieldsByName(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// checkFields checks every field of the given section is known and
+// holds a value of the right type, returning all that aren't
+func checkFields(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := fieldsByName(t)
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var problems []string
+	for _, k := range keys {
+		path := prefix + k
+		f, ok := fields[k]
+		if !ok {
+			if to, ok := renamedFields[path]; ok {
+				problems = append(problems, fmt.Sprintf("unknown field %s, use %s", path, to))
+				continue
+			}
+			problems = append(problems, "unknown field "+path)
+			continue
+		}
+		problems = append(problems, checkValue(raw[k], f.Type, path)...)
+	}
+	return problems
+}
+
+// checkValue checks the given value can be decoded into the given type
+func checkValue(v interface{}, t reflect.Type, path string) []string {
+	if v == nil {
+		return nil
+	}
+	if t == durationType {
+		s, ok := v.(string)
+		if !ok {
+			return []string{path + " must be a duration such as 30s"}
+		}
+		if _, err := parseDuration(s); err != nil {
+			return []string{path + ": " + err.Error()}
+		}
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return checkValue(v, t.Elem(), path)
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{path + " must be a section"}
+		}
+		return checkFields(m, t, path+".")
+	case reflect.Slice:
+		list, ok := v.([]interface{})
+		if !ok {
+			return []string{path + " must be a list"}
+		}
+		var problems []string
+		for i, e := range list {
+			problems = append(problems, checkValue(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			return []string{path + " must be a string"}
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			return []string{path + " must be true or false"}
+		}
+	case reflect.Int, reflect.Int64:
+		if f, ok := number(v); !ok || f != math.Trunc(f) {
+			return []string{path + " must be an integer"}
+		}
+	case reflect.Float64:
+		if _, ok := number(v); !ok {
+			return []string{path + " must be a number"}
+		}
+	}
+	return nil
+}
+
+// number returns the given decoded number as a float64, reporting
+// whether it's a number
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// applyEnv sets the fields of the given section for which an
+// environment variable named after the field's path is set, e.g.
+// SKYISLAND_NETWORK_IP4_START_ADDR. Lists are comma separated
+func applyEnv(raw map[string]interface{}, t reflect.Type, prefix string) []string {
+	var problems []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		env := prefix + "_" + strings.ToUpper(name)
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			sub, ok := raw[name].(map[string]interface{})
+			if !ok && raw[name] != nil {
+				continue
+			}
+			if sub == nil {
+				sub = make(map[string]interface{})
+			}
+			problems = append(problems, applyEnv(sub, ft, env)...)
+			if len(sub) > 0 {
+				raw[name] = sub
+			}
+			continue
+		}
+		s, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		v, err := envValue(s, ft)
+		if err != nil {
+			problems = append(problems, env+": "+err.Error())
+			continue
+		}
+		raw[name] = v
+	}
+	return problems
+}
+
+// envValue converts the given environment variable value into the
+// decoded form of the given type
+func envValue(s string, t reflect.Type) (interface{}, error) {
+	if t == durationType {
+		return s, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", s)
+		}
+		return b, nil
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		return n, nil
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", s)
+		}
+		return f, nil
+	case reflect.Slice:
+		list := []interface{}{}
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		return list, nil
+	}
+	return s, nil
+}
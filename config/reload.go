@@ -0,0 +1,184 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reloadMu guards the reloadable fields. They're read through the
+// accessors below so a reload is never seen half applied
+var reloadMu sync.RWMutex
+
+// reloadableFields are the fields a reload may change. Changing any
+// other field requires a restart
+var reloadableFields = map[string]bool{
+	"admin_api_token":          true,
+	"admin_token_header":       true,
+	"jails.build_timeout":      true,
+	"jails.exec_timeout":       true,
+	"jails.max_exec_timeout":   true,
+	"jails.children_max":       true,
+	"jails.env_allow_list":     true,
+	"jails.package_allow_list": true,
+	"network.ip4.dns":          true,
+	"health.min_free_ips":      true,
+	"health.min_free_space_mb": true,
+}
+
+// RestartRequiredError lists the changed fields that can only be
+// applied by restarting
+type RestartRequiredError struct {
+	Fields []string
+}
+
+// Error implements the error interface
+func (r *RestartRequiredError) Error() string {
+	return "restart required to change " + strings.Join(r.Fields, ", ")
+}
+
+// ReloadFrom loads and validates the given file and applies it. See
+// Reload
+func (c *Config) ReloadFrom(confFile string) ([]string, error) {
+	next, err := Load(confFile)
+	if err != nil {
+		return nil, err
+	}
+	return c.Reload(next)
+}
+
+// Reload applies the reloadable fields of the given validated config
+// and returns the fields that changed. If any field that requires a
+// restart changed nothing is applied and a *RestartRequiredError is
+// returned
+func (c *Config) Reload(next *Config) ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	changed := Changes(c, next)
+	var restart []string
+	for _, f := range changed {
+		if !reloadableFields[f] {
+			restart = append(restart, f)
+		}
+	}
+	if len(restart) > 0 {
+		return nil, &RestartRequiredError{Fields: restart}
+	}
+	c.AdminAPIToken = next.AdminAPIToken
+	c.AdminTokenHeader = next.AdminTokenHeader
+	if next.Jails != nil {
+		if c.Jails == nil {
+			c.Jails = &Jails{}
+		}
+		c.Jails.BuildTimeout = next.Jails.BuildTimeout
+		c.Jails.ExecTimeout = next.Jails.ExecTimeout
+		c.Jails.MaxExecTimeout = next.Jails.MaxExecTimeout
+		c.Jails.ChildrenMax = next.Jails.ChildrenMax
+		c.Jails.EnvAllowList = next.Jails.EnvAllowList
+		c.Jails.PackageAllowList = next.Jails.PackageAllowList
+	}
+	if next.Network != nil && next.Network.IP4 != nil && c.Network != nil && c.Network.IP4 != nil {
+		c.Network.IP4.DNS = next.Network.IP4.DNS
+	}
+	if next.Health != nil {
+		h := *next.Health
+		c.Health = &h
+	} else {
+		c.Health = nil
+	}
+	return changed, nil
+}
+
+// Changes returns the paths of the fields that differ between the
+// given configs. A missing section is the same as an empty one
+func Changes(a, b *Config) []string {
+	var changed []string
+	diffFields(reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem(), "", &changed)
+	sort.Strings(changed)
+	return changed
+}
+
+// diffFields appends the paths of the fields that differ between the
+// given structs
+func diffFields(a, b reflect.Value, prefix string, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Ptr && fa.Type().Elem().Kind() == reflect.Struct {
+			diffFields(deref(fa), deref(fb), prefix+name+".", changed)
+			continue
+		}
+		if !equalField(fa, fb) {
+			*changed = append(*changed, prefix+name)
+		}
+	}
+}
+
+// equalField reports whether the given field values are equal. Slices
+// are compared by their elements so a missing list and an empty one,
+// as JSON decodes [] and an absent field, are the same
+func equalField(a, b reflect.Value) bool {
+	if a.Kind() != reflect.Slice {
+		return reflect.DeepEqual(a.Interface(), b.Interface())
+	}
+	if a.Len() != b.Len() {
+		return false
+	}
+	for i := 0; i < a.Len(); i++ {
+		if !reflect.DeepEqual(a.Index(i).Interface(), b.Index(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// deref returns the struct the given pointer points to or, if it's
+// nil, the struct's zero value
+func deref(v reflect.Value) reflect.Value {
+	if v.IsNil() {
+		return reflect.Zero(v.Type().Elem())
+	}
+	return v.Elem()
+}
+
+// AdminAuth returns the header and token admin requests must carry
+func (c *Config) AdminAuth() (string, string) {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.AdminTokenHeader, c.AdminAPIToken
+}
+
+// JailSettings returns a copy of the jails section, empty if unset
+func (c *Config) JailSettings() Jails {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	if c.Jails == nil {
+		return Jails{}
+	}
+	return *c.Jails
+}
+
+// HealthSettings returns a copy of the health section, empty if unset
+func (c *Config) HealthSettings() Health {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	if c.Health == nil {
+		return Health{}
+	}
+	return *c.Health
+}
+
+// DNS returns the DNS servers jails are configured with
+func (c *Config) DNS() []string {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	if c.Network == nil || c.Network.IP4 == nil {
+		return nil
+	}
+	return c.Network.IP4.DNS
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes the YAML test config, with the given
+// replacements made, to a temp file
+func writeConfig(t *testing.T, dir string, replacements ...string) string {
+	b, err := ioutil.ReadFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.yaml")
+	s := strings.NewReplacer(replacements...).Replace(string(b))
+	if err := ioutil.WriteFile(file, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// TestReload verifies reloadable fields are applied and changes
+// requiring a restart are rejected without applying anything
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf, err := Load(writeConfig(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := conf.ReloadFrom(writeConfig(t, dir, "exec_timeout: 5s", "exec_timeout: 20s", "4.2.2.2", "8.8.8.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"jails.exec_timeout", "jails.max_exec_timeout", "network.ip4.dns"}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("expected %v got %v", expected, changed)
+	}
+	if conf.JailSettings().ExecTimeout.D() != 20*time.Second || conf.DNS()[1] != "8.8.8.8" {
+		t.Errorf("expected reloaded fields got %v %v", conf.Jails.ExecTimeout, conf.DNS())
+	}
+
+	_, err = conf.ReloadFrom(writeConfig(t, dir, "exec_timeout: 5s", "exec_timeout: 1m", "zfs_dataset: zroot", "zfs_dataset: tank", "range: 220", "range: 100"))
+	rerr, ok := err.(*RestartRequiredError)
+	if !ok {
+		t.Fatalf("expected restart required error got %v", err)
+	}
+	if !reflect.DeepEqual(rerr.Fields, []string{"filesystem.zfs_dataset", "network.ip4.range"}) {
+		t.Errorf("unexpected fields %v", rerr.Fields)
+	}
+	if conf.JailSettings().ExecTimeout.D() != 20*time.Second {
+		t.Errorf("expected nothing applied got exec timeout %v", conf.Jails.ExecTimeout)
+	}
+}
+
+// TestChanges_EmptySlices verifies a missing list and an empty one
+// aren't a change
+func TestChanges_EmptySlices(t *testing.T) {
+	a := &Config{GoVersions: []string{}, Jails: &Jails{EnvAllowList: nil}}
+	b := &Config{Jails: &Jails{EnvAllowList: []string{}}}
+	if changed := Changes(a, b); len(changed) != 0 {
+		t.Errorf("expected no changes got %v", changed)
+	}
+	b.GoVersions = []string{"1.21.5"}
+	if changed := Changes(a, b); !reflect.DeepEqual(changed, []string{"go_versions"}) {
+		t.Errorf("expected go_versions changed got %v", changed)
+	}
+}
@@ -33,7 +33,6 @@ echo '{
     "admin_api_token": "asdfasdfasdfasdf",
     "admin_token_header": "X-Sky-Island-Token",
     "go_version": "1.9.2",
-    "base_sys_pkg_dir": "/tmp/11.1-RELEASE",
     "filesystem": {
         "zfs_dataset": "zroot",
         "compression": false
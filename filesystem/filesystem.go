@@ -2,20 +2,22 @@ package filesystem
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
 	"github.com/briandowns/sky-island/utils"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 // FSServicer defines the behavior of the filesystem service
 type FSServicer interface {
 	CreateBaseJailDataset() error
-	CloneBaseToJail(string) error
+	CloneBaseToJail(string, string) error
 	CreateDataset() error
 	CreateSnapshot() error
 	RemoveDataset(string) error
+	DatasetExists(string) (bool, error)
 }
 
 // fsService
@@ -23,12 +25,12 @@ type fsService struct {
 	logger  gklog.Logger
 	conf    *config.Config
 	wrapper utils.Wrapper
-	metrics *statsd.Client
+	metrics *metrics.Metrics
 }
 
 // NewFilesystemService creates a new value of type FileSystemService which provides the dependencies
 // to the service methods
-func NewFilesystemService(conf *config.Config, l gklog.Logger, metrics *statsd.Client, w utils.Wrapper) FSServicer {
+func NewFilesystemService(conf *config.Config, l gklog.Logger, metrics *metrics.Metrics, w utils.Wrapper) FSServicer {
 	return &fsService{
 		logger:  l,
 		conf:    conf,
@@ -45,13 +47,13 @@ func (f *fsService) CreateBaseJailDataset() error {
 	return err
 }
 
-// CloneBaseToJail does a ZFS clone from the base jail to the new jail
-func (f *fsService) CloneBaseToJail(jname string) error {
+// CloneBaseToJail does a ZFS clone from the given base jail
+// snapshot to the new jail
+func (f *fsService) CloneBaseToJail(jname, snapshot string) error {
 	t := f.metrics.NewTiming()
 	defer t.Send("dataset_create")
-	base := f.conf.Filesystem.ZFSDataset + "/jails/releases/" + f.conf.Release + "@p1"
 	dataset := f.conf.Filesystem.ZFSDataset + "/jails/" + jname
-	_, err := f.wrapper.Output("zfs", "clone", base, dataset)
+	_, err := f.wrapper.Output("zfs", "clone", snapshot, dataset)
 	return err
 }
 
@@ -78,3 +80,20 @@ func (f *fsService) RemoveDataset(id string) error {
 	_, err := f.wrapper.Output("zfs", "destroy", "-rf", f.conf.Filesystem.ZFSDataset+"/jails/"+id)
 	return err
 }
+
+// exists checks whether the given ZFS dataset or snapshot exists
+func (f *fsService) exists(name string) (bool, error) {
+	out, err := f.wrapper.CombinedOutput("zfs", "list", "-H", "-o", "name", "-t", "all", name)
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return false, nil
+		}
+		return false, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// DatasetExists checks whether the Dataset associated with the given id exists
+func (f *fsService) DatasetExists(id string) (bool, error) {
+	return f.exists(f.conf.Filesystem.ZFSDataset + "/jails/" + id)
+}
@@ -4,9 +4,9 @@ import (
 	"testing"
 
 	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
 	"github.com/briandowns/sky-island/utils"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 var testConf = &config.Config{
@@ -21,7 +21,7 @@ var testConf = &config.Config{
 
 // TestNewFilesystemService
 func TestNewFilesystemService(t *testing.T) {
-	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if fsSvc == nil {
 		t.Error("expected not nil filesystem service")
 	}
@@ -29,7 +29,7 @@ func TestNewFilesystemService(t *testing.T) {
 
 // TestCreateBaseJailDataset
 func TestCreateBaseJailDataset(t *testing.T) {
-	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if fsSvc == nil {
 		t.Error("expected not nil filesystem service")
 	}
@@ -39,18 +39,18 @@ func TestCreateBaseJailDataset(t *testing.T) {
 }
 
 func TestCloneBaseToJail(t *testing.T) {
-	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if fsSvc == nil {
 		t.Error("expected not nil filesystem service")
 	}
-	if err := fsSvc.CloneBaseToJail("test-jail-name"); err != nil {
+	if err := fsSvc.CloneBaseToJail("test-jail-name", "test/dataset/jails/releases/11.1-RELEASE@p1"); err != nil {
 		t.Error(err)
 	}
 }
 
 // TestCreateDataset
 func TestCreateDataset(t *testing.T) {
-	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if fsSvc == nil {
 		t.Error("expected not nil filesystem service")
 	}
@@ -61,7 +61,7 @@ func TestCreateDataset(t *testing.T) {
 
 // TestCreateSnapshot
 func TestCreateSnapshot(t *testing.T) {
-	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if fsSvc == nil {
 		t.Error("expected not nil filesystem service")
 	}
@@ -72,7 +72,7 @@ func TestCreateSnapshot(t *testing.T) {
 
 // TestRemoveDataset
 func TestRemoveDataset(t *testing.T) {
-	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	fsSvc := NewFilesystemService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if fsSvc == nil {
 		t.Error("expected not nil filesystem service")
 	}
@@ -0,0 +1,326 @@
+package functions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/queue"
+	"github.com/briandowns/sky-island/scheduler"
+	"github.com/briandowns/sky-island/utils"
+	"github.com/briandowns/sky-island/webhooks"
+)
+
+// Kinds of functions that can be registered
+const (
+	// KindCall functions are Go expressions whose printed
+	// result is returned to the caller
+	KindCall = "call"
+
+	// KindHTTP functions are exported http.HandlerFunc values
+	// that are served the incoming request
+	KindHTTP = "http"
+)
+
+var (
+	nameRegexp  = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	identRegexp = regexp.MustCompile(`^[A-Z][a-zA-Z0-9_]*$`)
+)
+
+// Function describes a registered function. For functions of
+// kind http, Call holds the name of the exported handler
+type Function struct {
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	Kind      string            `json:"kind"`
+	Call      string            `json:"call"`
+	IP4       bool              `json:"ip4"`
+	Env       map[string]string `json:"env,omitempty"`
+	Secrets   []string          `json:"secrets,omitempty"`
+	GoVersion string            `json:"go_version,omitempty"`
+	Release   string            `json:"release,omitempty"`
+	Packages  []string          `json:"packages,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+	Schedule  *Schedule         `json:"schedule,omitempty"`
+	Webhook   *Webhook          `json:"webhook,omitempty"`
+	Queue     *Queue            `json:"queue,omitempty"`
+}
+
+// Schedule runs a function on a cron expression in the given time
+// zone, UTC by default. Each run starts up to Jitter late and is
+// given Input. Missed is the missed run policy, skip or run_once
+type Schedule struct {
+	Cron     string          `json:"cron"`
+	Timezone string          `json:"timezone,omitempty"`
+	Jitter   string          `json:"jitter,omitempty"`
+	Missed   string          `json:"missed,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
+}
+
+// Job returns the scheduler job of the schedule of the given function
+func (s *Schedule) Job(name string) (*scheduler.Job, error) {
+	c, err := scheduler.ParseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %q", s.Timezone)
+		}
+	}
+	var jitter time.Duration
+	if s.Jitter != "" {
+		if jitter, err = time.ParseDuration(s.Jitter); err != nil || jitter < 0 {
+			return nil, fmt.Errorf("invalid schedule jitter %q", s.Jitter)
+		}
+	}
+	switch s.Missed {
+	case "", scheduler.MissedSkip, scheduler.MissedRunOnce:
+	default:
+		return nil, fmt.Errorf("schedule missed must be %s or %s, got %q", scheduler.MissedSkip, scheduler.MissedRunOnce, s.Missed)
+	}
+	if len(s.Input) > 0 && !json.Valid(s.Input) {
+		return nil, errors.New("invalid schedule input")
+	}
+	return &scheduler.Job{
+		Name:     name,
+		Cron:     c,
+		Location: loc,
+		Jitter:   jitter,
+		Missed:   s.Missed,
+		Input:    s.Input,
+	}, nil
+}
+
+// Webhook accepts deliveries to /hooks/{name} signed with the named
+// secret, which must be granted to the function. The header holds the
+// signature made with the algorithm, X-Hub-Signature-256 and sha256
+// by default. Deliveries carrying the timestamp header are refused
+// outside of the tolerance and those with the nonce header replayed
+// within it
+type Webhook struct {
+	Secret          string `json:"secret"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	Header          string `json:"header,omitempty"`
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+	NonceHeader     string `json:"nonce_header,omitempty"`
+	Tolerance       string `json:"tolerance,omitempty"`
+}
+
+// Verifier returns the verifier of the webhook's deliveries
+func (w *Webhook) Verifier() (*webhooks.Verifier, error) {
+	var tolerance time.Duration
+	if w.Tolerance != "" {
+		var err error
+		if tolerance, err = time.ParseDuration(w.Tolerance); err != nil || tolerance <= 0 {
+			return nil, fmt.Errorf("invalid webhook tolerance %q", w.Tolerance)
+		}
+	}
+	return webhooks.NewVerifier(w.Algorithm, w.Header, w.TimestampHeader, w.NonceHeader, tolerance)
+}
+
+// Queue runs a function with each message of a NATS subject or Redis
+// stream, consumed as the group, sky-island.<name> by default, by
+// up to Concurrency runs at once. Failed runs are retried up to
+// MaxAttempts times in all with a doubling Backoff before the message
+// is published to DeadLetter. Outputs are published to Reply
+type Queue struct {
+	Broker      string `json:"broker"`
+	Subject     string `json:"subject"`
+	Group       string `json:"group,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+	Backoff     string `json:"backoff,omitempty"`
+	DeadLetter  string `json:"dead_letter,omitempty"`
+	Reply       string `json:"reply,omitempty"`
+}
+
+// Trigger returns the queue trigger of the given function
+func (q *Queue) Trigger(name string) (*queue.Trigger, error) {
+	if q.Broker != queue.BrokerNATS && q.Broker != queue.BrokerRedis {
+		return nil, fmt.Errorf("queue broker must be %s or %s, got %q", queue.BrokerNATS, queue.BrokerRedis, q.Broker)
+	}
+	if q.Subject == "" || strings.ContainsAny(q.Subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid queue subject %q", q.Subject)
+	}
+	if q.Concurrency < 0 || q.MaxAttempts < 0 {
+		return nil, errors.New("queue concurrency and max_attempts can't be negative")
+	}
+	var backoff time.Duration
+	if q.Backoff != "" {
+		var err error
+		if backoff, err = time.ParseDuration(q.Backoff); err != nil || backoff <= 0 {
+			return nil, fmt.Errorf("invalid queue backoff %q", q.Backoff)
+		}
+	}
+	group := q.Group
+	if group == "" {
+		group = "sky-island." + name
+	}
+	return &queue.Trigger{
+		Function:    name,
+		Broker:      q.Broker,
+		Source:      q.Subject,
+		Group:       group,
+		Concurrency: q.Concurrency,
+		MaxAttempts: q.MaxAttempts,
+		Backoff:     backoff,
+		DeadLetter:  q.DeadLetter,
+		Reply:       q.Reply,
+	}, nil
+}
+
+// Validate checks that the function has all necessary fields
+// set and defaults the kind to call if not given
+func (f *Function) Validate() error {
+	if !nameRegexp.MatchString(f.Name) {
+		return fmt.Errorf("invalid function name %q", f.Name)
+	}
+	if f.URL == "" {
+		return errors.New("function url required")
+	}
+	if f.Call == "" {
+		return errors.New("function call required")
+	}
+	switch f.Kind {
+	case "":
+		f.Kind = KindCall
+	case KindCall:
+	case KindHTTP:
+		if !identRegexp.MatchString(f.Call) {
+			return fmt.Errorf("http function call must be an exported handler name, got %q", f.Call)
+		}
+	default:
+		return fmt.Errorf("unknown function kind %q", f.Kind)
+	}
+	if f.Timeout != "" {
+		if d, err := time.ParseDuration(f.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid function timeout %q", f.Timeout)
+		}
+	}
+	if f.Schedule != nil {
+		if f.Kind == KindHTTP {
+			return errors.New("http functions can't be scheduled")
+		}
+		if _, err := f.Schedule.Job(f.Name); err != nil {
+			return err
+		}
+	}
+	if f.Webhook != nil {
+		if f.Kind == KindHTTP {
+			return errors.New("http functions can't have webhooks")
+		}
+		if f.Webhook.Secret == "" {
+			return errors.New("webhook secret required")
+		}
+		if _, err := f.Webhook.Verifier(); err != nil {
+			return err
+		}
+	}
+	if f.Queue != nil {
+		if f.Kind == KindHTTP {
+			return errors.New("http functions can't have queue triggers")
+		}
+		if _, err := f.Queue.Trigger(f.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registry holds the registered functions and, if given a
+// path, persists them to disk on every change
+type Registry struct {
+	mu   sync.RWMutex
+	path string
+	fns  map[string]*Function
+}
+
+// NewRegistry creates a new value of type Registry pointer and
+// loads any previously persisted functions from the given path.
+// An empty path keeps the registry in memory only
+func NewRegistry(path string) (*Registry, error) {
+	r := &Registry{
+		path: path,
+		fns:  make(map[string]*Function),
+	}
+	if path == "" || !utils.Exists(path) {
+		return r, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &r.fns); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get returns the function with the given name
+func (r *Registry) Get(name string) (*Function, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.fns[name]
+	return fn, ok
+}
+
+// List returns all registered functions sorted by name
+func (r *Registry) List() []*Function {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fns := make([]*Function, 0, len(r.fns))
+	for _, fn := range r.fns {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		return fns[i].Name < fns[j].Name
+	})
+	return fns
+}
+
+// Set validates and adds or replaces the given function
+func (r *Registry) Set(fn *Function) error {
+	if err := fn.Validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fns[fn.Name] = fn
+	return r.save()
+}
+
+// Remove removes the function with the given name
+func (r *Registry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.fns[name]; !ok {
+		return fmt.Errorf("function %s not found", name)
+	}
+	delete(r.fns, name)
+	return r.save()
+}
+
+// save writes the registry to disk. The caller must hold the lock
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(r.fns, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
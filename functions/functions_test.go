@@ -0,0 +1,101 @@
+package functions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestValidate_DefaultKind verifies a function without a kind
+// is treated as a call function
+func TestValidate_DefaultKind(t *testing.T) {
+	fn := &Function{Name: "geohash", URL: "github.com/mmcloughlin/geohash", Call: "Encode(100.1, 80.9)"}
+	if err := fn.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if fn.Kind != KindCall {
+		t.Errorf("expected kind %s got %s", KindCall, fn.Kind)
+	}
+}
+
+// TestValidate_Failure verifies invalid functions are rejected
+func TestValidate_Failure(t *testing.T) {
+	tests := []*Function{
+		{Name: "bad name", URL: "github.com/a/b", Call: "F()"},
+		{Name: "no-url", Call: "F()"},
+		{Name: "no-call", URL: "github.com/a/b"},
+		{Name: "bad-kind", URL: "github.com/a/b", Call: "F()", Kind: "grpc"},
+		{Name: "bad-handler", URL: "github.com/a/b", Call: "Handler()", Kind: KindHTTP},
+		{Name: "bad-cron", URL: "github.com/a/b", Call: "F()", Schedule: &Schedule{Cron: "* * *"}},
+		{Name: "bad-tz", URL: "github.com/a/b", Call: "F()", Schedule: &Schedule{Cron: "@daily", Timezone: "Mars/Olympus"}},
+		{Name: "bad-missed", URL: "github.com/a/b", Call: "F()", Schedule: &Schedule{Cron: "@daily", Missed: "all"}},
+		{Name: "no-secret", URL: "github.com/a/b", Call: "F()", Webhook: &Webhook{}},
+		{Name: "bad-algorithm", URL: "github.com/a/b", Call: "F()", Webhook: &Webhook{Secret: "hook", Algorithm: "md5"}},
+		{Name: "bad-tolerance", URL: "github.com/a/b", Call: "F()", Webhook: &Webhook{Secret: "hook", Tolerance: "-1m"}},
+		{Name: "http-webhook", URL: "github.com/a/b", Call: "Handler", Kind: KindHTTP, Webhook: &Webhook{Secret: "hook"}},
+		{Name: "bad-broker", URL: "github.com/a/b", Call: "F()", Queue: &Queue{Broker: "kafka", Subject: "jobs"}},
+		{Name: "no-subject", URL: "github.com/a/b", Call: "F()", Queue: &Queue{Broker: "nats"}},
+		{Name: "bad-backoff", URL: "github.com/a/b", Call: "F()", Queue: &Queue{Broker: "redis", Subject: "jobs", Backoff: "soon"}},
+		{Name: "http-schedule", URL: "github.com/a/b", Call: "Handler", Kind: KindHTTP, Schedule: &Schedule{Cron: "@daily"}},
+	}
+	for _, fn := range tests {
+		if err := fn.Validate(); err == nil {
+			t.Errorf("expected error for %s but received none", fn.Name)
+		}
+	}
+}
+
+// TestSchedule_Job verifies a schedule is turned into a job in its
+// time zone
+func TestSchedule_Job(t *testing.T) {
+	s := &Schedule{Cron: "30 2 * * *", Timezone: "Europe/Berlin", Jitter: "10s", Input: []byte(`{"a":1}`)}
+	j, err := s.Job("report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Name != "report" || j.Location.String() != "Europe/Berlin" || j.Jitter != 10*time.Second {
+		t.Errorf("unexpected job %+v", j)
+	}
+	if string(j.Input) != `{"a":1}` {
+		t.Errorf("expected input %s got %s", s.Input, j.Input)
+	}
+}
+
+// TestRegistry_Persist verifies that registered functions are
+// loaded by a new registry using the same path
+func TestRegistry_Persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "functions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "functions.json")
+
+	r, err := NewRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set(&Function{Name: "hello", URL: "github.com/a/hello", Call: "Handler", Kind: KindHTTP}); err != nil {
+		t.Fatal(err)
+	}
+
+	r2, err := NewRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, ok := r2.Get("hello")
+	if !ok {
+		t.Fatal("expected function to be loaded from disk")
+	}
+	if fn.Kind != KindHTTP {
+		t.Errorf("expected kind %s got %s", KindHTTP, fn.Kind)
+	}
+	if err := r2.Remove("hello"); err != nil {
+		t.Error(err)
+	}
+	if len(r2.List()) != 0 {
+		t.Error("expected empty registry")
+	}
+}
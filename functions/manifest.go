@@ -0,0 +1,278 @@
+package functions
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ManifestFile is the name of the optional manifest at a
+// function repo's root
+const ManifestFile = "skyisland.yaml"
+
+// Names of the manifest fields that can be marked overridable
+const (
+	FieldEntry     = "entry"
+	FieldGoVersion = "go_version"
+	FieldNetwork   = "network"
+	FieldEnv       = "env"
+	FieldTimeout   = "timeout"
+)
+
+var (
+	goVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?((rc|beta)[0-9]+)?$`)
+	buildTagRegexp  = regexp.MustCompile(`^[a-zA-Z0-9_.]+$`)
+	memoryRegexp    = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+	envKeyRegexp    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// overridableFields are the fields requests can be allowed to override
+var overridableFields = []string{FieldEntry, FieldEnv, FieldGoVersion, FieldNetwork, FieldTimeout}
+
+// Manifest is a function's skyisland.yaml. It configures how the
+// function is built and run and which of those settings callers
+// may override
+type Manifest struct {
+	Entry       string            `yaml:"entry"`
+	GoVersion   string            `yaml:"go_version"`
+	Build       Build             `yaml:"build"`
+	Limits      Limits            `yaml:"limits"`
+	Network     bool              `yaml:"network"`
+	Env         map[string]string `yaml:"env"`
+	Timeout     string            `yaml:"timeout"`
+	Callers     []string          `yaml:"callers"`
+	Overridable []string          `yaml:"overridable"`
+}
+
+// Build holds the go build flags of a function
+type Build struct {
+	Tags    []string `yaml:"tags"`
+	LDFlags string   `yaml:"ldflags"`
+}
+
+// Limits holds the resource limits of a function's jail. Memory is
+// a size such as 256M, CPU a percentage of a single CPU
+type Limits struct {
+	Memory  string `yaml:"memory"`
+	CPU     int    `yaml:"cpu"`
+	MaxProc int    `yaml:"maxproc"`
+}
+
+// ManifestError lists every problem found in a manifest
+type ManifestError struct {
+	Problems []string
+}
+
+// Error implements the error interface on the ManifestError
+func (m *ManifestError) Error() string {
+	return ManifestFile + ": " + strings.Join(m.Problems, "; ")
+}
+
+// ParseManifest parses and validates the given manifest. Unknown
+// fields are rejected
+func ParseManifest(b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		if strings.Contains(msg, "not found in type") {
+			msg += ", known fields are entry, go_version, build, limits, network, env, timeout, callers and overridable"
+		}
+		return nil, &ManifestError{Problems: []string{msg}}
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// LoadManifest loads the manifest from the given repo directory. A
+// nil manifest is returned if the repo doesn't have one
+func LoadManifest(dir string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ParseManifest(b)
+}
+
+// Validate checks every field of the manifest, returning a
+// ManifestError listing all of the problems found
+func (m *Manifest) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if m.GoVersion != "" && !goVersionRegexp.MatchString(m.GoVersion) {
+		add("go_version: %q is not a Go release, e.g. 1.22.0", m.GoVersion)
+	}
+	for _, t := range m.Build.Tags {
+		if !buildTagRegexp.MatchString(t) {
+			add("build.tags: %q is not a valid build tag", t)
+		}
+	}
+	if strings.ContainsAny(m.Build.LDFlags, "\n\r") {
+		add("build.ldflags: must be a single line")
+	}
+	if m.Limits.Memory != "" && !memoryRegexp.MatchString(m.Limits.Memory) {
+		add("limits.memory: %q is not a size, e.g. 256M", m.Limits.Memory)
+	}
+	if m.Limits.CPU < 0 {
+		add("limits.cpu: must be a positive percentage")
+	}
+	if m.Limits.MaxProc < 0 {
+		add("limits.maxproc: must be positive")
+	}
+	for k := range m.Env {
+		if !envKeyRegexp.MatchString(k) {
+			add("env: %q is not a valid variable name", k)
+		}
+	}
+	if m.Timeout != "" {
+		if d, err := time.ParseDuration(m.Timeout); err != nil || d <= 0 {
+			add("timeout: %q is not a positive duration, e.g. 30s", m.Timeout)
+		}
+	}
+	for _, c := range m.Callers {
+		if net.ParseIP(c) == nil {
+			if _, _, err := net.ParseCIDR(c); err != nil {
+				add("callers: %q is not an IP address or CIDR network", c)
+			}
+		}
+	}
+	for _, f := range m.Overridable {
+		if !contains(overridableFields, f) {
+			add("overridable: %q can't be overridden, must be one of %s", f, strings.Join(overridableFields, ", "))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &ManifestError{Problems: problems}
+	}
+	return nil
+}
+
+// contains checks whether the given slice holds the given value
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCaller checks whether the caller with the given address may
+// call the function. Every caller is allowed if none are listed
+func (m *Manifest) AllowsCaller(addr string) bool {
+	if m == nil || len(m.Callers) == 0 {
+		return true
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, c := range m.Callers {
+		if allowed := net.ParseIP(c); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+		if _, n, err := net.ParseCIDR(c); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings are the effective settings a function is built and run
+// with, from its manifest and the request
+type Settings struct {
+	Call      string
+	GoVersion string
+	Tags      []string
+	LDFlags   string
+	Limits    Limits
+	IP4       bool
+	Env       map[string]string
+	Timeout   time.Duration
+}
+
+// Apply returns the settings from the manifest with the given request
+// settings applied. A request may only change a field the manifest
+// sets if the manifest marks it overridable. A nil manifest returns
+// the request settings
+func (m *Manifest) Apply(req *Settings) (*Settings, error) {
+	if m == nil {
+		return req, nil
+	}
+	s := &Settings{
+		Call:      m.Entry,
+		GoVersion: m.GoVersion,
+		Tags:      m.Build.Tags,
+		LDFlags:   m.Build.LDFlags,
+		Limits:    m.Limits,
+		IP4:       m.Network,
+		Env:       make(map[string]string, len(m.Env)+len(req.Env)),
+	}
+	if m.Timeout != "" {
+		s.Timeout, _ = time.ParseDuration(m.Timeout)
+	}
+	for k, v := range m.Env {
+		s.Env[k] = v
+	}
+
+	var denied []string
+	override := func(field string, set, differs bool) bool {
+		if !set {
+			return false
+		}
+		if differs && !contains(m.Overridable, field) {
+			denied = append(denied, field)
+			return false
+		}
+		return true
+	}
+	if override(FieldEntry, req.Call != "", m.Entry != "" && req.Call != m.Entry) {
+		s.Call = req.Call
+	}
+	if override(FieldGoVersion, req.GoVersion != "", m.GoVersion != "" && req.GoVersion != m.GoVersion) {
+		s.GoVersion = req.GoVersion
+	}
+	if override(FieldNetwork, req.IP4, !m.Network) {
+		s.IP4 = true
+	}
+	if override(FieldTimeout, req.Timeout > 0, s.Timeout > 0 && req.Timeout != s.Timeout) {
+		s.Timeout = req.Timeout
+	}
+	envDenied := false
+	for k, v := range req.Env {
+		mv, ok := m.Env[k]
+		if ok && mv != v && !contains(m.Overridable, FieldEnv) {
+			envDenied = true
+			continue
+		}
+		s.Env[k] = v
+	}
+	if envDenied {
+		denied = append(denied, FieldEnv)
+	}
+	if len(denied) > 0 {
+		return nil, fmt.Errorf("%s doesn't allow overriding %s", ManifestFile, strings.Join(denied, ", "))
+	}
+	if s.Call == "" {
+		return nil, fmt.Errorf("no call given and %s has no entry", ManifestFile)
+	}
+	return s, nil
+}
@@ -0,0 +1,137 @@
+package functions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testManifest = `
+entry: Encode(100.1, 80.9)
+go_version: 1.22.0
+build:
+  tags: [netgo, osusergo]
+  ldflags: -s -w
+limits:
+  memory: 256M
+  cpu: 50
+network: true
+env:
+  LOG_LEVEL: info
+timeout: 30s
+callers: [10.0.0.0/8, 192.168.1.5]
+overridable: [env, timeout]
+`
+
+// TestLoadManifest verifies a manifest is loaded from the repo root
+// and a repo without one returns no manifest
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.Errorf("expected no manifest got %+v", m)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ManifestFile), []byte(testManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = LoadManifest(dir); err != nil {
+		t.Fatal(err)
+	}
+	if m.Entry != "Encode(100.1, 80.9)" || m.Limits.Memory != "256M" || !reflect.DeepEqual(m.Build.Tags, []string{"netgo", "osusergo"}) {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+}
+
+// TestParseManifest_Failure verifies every problem in a manifest is
+// reported and unknown fields are rejected
+func TestParseManifest_Failure(t *testing.T) {
+	_, err := ParseManifest([]byte(`
+go_version: latest
+build:
+  tags: ["bad tag"]
+limits:
+  memory: lots
+timeout: soon
+callers: [somewhere]
+overridable: [callers]
+`))
+	merr, ok := err.(*ManifestError)
+	if !ok {
+		t.Fatalf("expected manifest error got %v", err)
+	}
+	if len(merr.Problems) != 6 {
+		t.Errorf("expected 6 problems got %v", merr.Problems)
+	}
+
+	_, err = ParseManifest([]byte("entrypoint: F()\n"))
+	if err == nil || !strings.Contains(err.Error(), "known fields") {
+		t.Errorf("expected unknown field error got %v", err)
+	}
+}
+
+// TestAllowsCaller verifies callers are matched by IP and network
+func TestAllowsCaller(t *testing.T) {
+	m, err := ParseManifest([]byte(testManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"10.1.2.3:5123":    true,
+		"192.168.1.5:8080": true,
+		"192.168.1.6:8080": false,
+		"bogus":            false,
+	}
+	for addr, expected := range tests {
+		if allowed := m.AllowsCaller(addr); allowed != expected {
+			t.Errorf("%s: expected %v got %v", addr, expected, allowed)
+		}
+	}
+	var none *Manifest
+	if !none.AllowsCaller("1.2.3.4:1") {
+		t.Error("expected any caller allowed without a manifest")
+	}
+}
+
+// TestApply verifies request settings only override the manifest
+// fields marked overridable
+func TestApply(t *testing.T) {
+	m, err := ParseManifest([]byte(testManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Apply(&Settings{Env: map[string]string{"LOG_LEVEL": "debug"}, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Call != m.Entry || s.GoVersion != "1.22.0" || !s.IP4 || s.Timeout != time.Minute || s.Env["LOG_LEVEL"] != "debug" {
+		t.Errorf("unexpected settings %+v", s)
+	}
+
+	if _, err := m.Apply(&Settings{Call: m.Entry, GoVersion: "1.22.0"}); err != nil {
+		t.Errorf("expected settings matching the manifest allowed got %v", err)
+	}
+	_, err = m.Apply(&Settings{Call: "Decode(\"abc\")", GoVersion: "1.21.5"})
+	if err == nil || !strings.Contains(err.Error(), "entry, go_version") {
+		t.Errorf("expected override error got %v", err)
+	}
+
+	var none *Manifest
+	req := &Settings{Call: "F()"}
+	if s, _ := none.Apply(req); s != req {
+		t.Error("expected request settings without a manifest")
+	}
+}
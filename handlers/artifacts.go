@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/briandowns/sky-island/artifacts"
+	"github.com/briandowns/sky-island/cluster"
+	"github.com/gorilla/mux"
+)
+
+// transferRequest contains the fields seen in a request to push or
+// pull an artifact. Without a node the configured remote store is used
+type transferRequest struct {
+	Key  string `json:"key"`
+	Node string `json:"node"`
+}
+
+// artifactsConfigured writes an error response and returns false
+// if no artifact store has been configured
+func (h *handler) artifactsConfigured(w http.ResponseWriter) bool {
+	if h.artifacts == nil {
+		h.ren.JSON(w, http.StatusNotImplemented, map[string]string{"error": artifacts.ErrNotConfigured.Error()})
+		return false
+	}
+	return true
+}
+
+// artifactError writes the response for the given store error
+func (h *handler) artifactError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *artifacts.DigestError:
+		h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err == artifacts.ErrNotFound {
+		h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	h.logger.Log("error", err.Error())
+	h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+}
+
+// artifactsHandler handles requests to list the stored binaries
+func (h *handler) artifactsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.artifactsConfigured(w) {
+			return
+		}
+		ms, err := h.artifacts.List(r.Context())
+		if err != nil {
+			h.artifactError(w, err)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"artifacts": ms})
+	}
+}
+
+// transfer handles requests to copy an artifact between this node and
+// another node or the remote store
+func (h *handler) transfer(push bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.artifactsConfigured(w) {
+			return
+		}
+		var req transferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "key required"})
+			return
+		}
+		other := h.artifacts.Remote()
+		if req.Node != "" {
+			if h.conf.Cluster == nil || h.conf.Cluster.Token == "" {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "transfers between nodes require cluster.token"})
+				return
+			}
+			other = artifacts.NewNode(req.Node, cluster.TokenHeader, h.conf.Cluster.Token)
+		}
+		if other == nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "node required without a remote store"})
+			return
+		}
+		var m *artifacts.Metadata
+		var err error
+		if push {
+			m, err = h.artifacts.Push(r.Context(), req.Key, other)
+		} else {
+			m, err = h.artifacts.Pull(r.Context(), req.Key, other)
+		}
+		if err != nil {
+			h.artifactError(w, err)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, m)
+	}
+}
+
+// pushArtifactHandler handles requests to push an artifact
+func (h *handler) pushArtifactHandler() http.HandlerFunc {
+	return h.transfer(true)
+}
+
+// pullArtifactHandler handles requests to pull an artifact
+func (h *handler) pullArtifactHandler() http.HandlerFunc {
+	return h.transfer(false)
+}
+
+// lookupArtifactHandler handles requests from other nodes for the
+// metadata of the binary stored under a cache key
+func (h *handler) lookupArtifactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.artifactsConfigured(w) {
+			return
+		}
+		m, err := h.artifacts.Lookup(r.Context(), r.URL.Query().Get("key"))
+		if err != nil {
+			h.artifactError(w, err)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, m)
+	}
+}
+
+// getArtifactHandler handles requests from other nodes for a binary
+func (h *handler) getArtifactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.artifactsConfigured(w) {
+			return
+		}
+		f, err := h.artifacts.Get(r.Context(), mux.Vars(r)["digest"])
+		if err != nil {
+			h.artifactError(w, err)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.Copy(w, f)
+	}
+}
+
+// putArtifactHandler handles binaries pushed by other nodes. The
+// binary must match the digest of the metadata it's sent with
+func (h *handler) putArtifactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.artifactsConfigured(w) {
+			return
+		}
+		var m artifacts.Metadata
+		if err := json.Unmarshal([]byte(r.Header.Get(artifacts.MetadataHeader)), &m); err != nil || m.Key == "" {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": artifacts.MetadataHeader + " header with a key required"})
+			return
+		}
+		if err := h.artifacts.Put(r.Context(), &m, r.Body); err != nil {
+			h.artifactError(w, err)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, m)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/briandowns/sky-island/artifacts"
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+// testArtifactNode returns a node handler with an artifact store in
+// the given directory and a server for its cluster artifact endpoints
+func testArtifactNode(t *testing.T, dir string) (*handler, *httptest.Server) {
+	store, err := artifacts.NewStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{
+		conf: &config.Config{
+			AdminTokenHeader: "X-Token",
+			AdminAPIToken:    "admin",
+			Cluster:          &config.Cluster{Token: "cluster"},
+		},
+		logger:    gklog.NewNopLogger(),
+		ren:       render.New(),
+		artifacts: store,
+	}
+	router := mux.NewRouter()
+	router.Path(artifacts.NodePath).HandlerFunc(h.clusterAuth(h.lookupArtifactHandler())).Queries("key", "{key}").Methods(http.MethodGet)
+	router.Path(artifacts.NodePath).HandlerFunc(h.clusterAuth(h.putArtifactHandler())).Methods(http.MethodPut)
+	router.Path(artifacts.NodePath + "/{digest}").HandlerFunc(h.clusterAuth(h.getArtifactHandler())).Methods(http.MethodGet)
+	return h, httptest.NewServer(router)
+}
+
+// TestArtifactTransfer verifies binaries are pushed and pulled between
+// nodes
+func TestArtifactTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, as := testArtifactNode(t, filepath.Join(dir, "a"))
+	defer as.Close()
+	b, bs := testArtifactNode(t, filepath.Join(dir, "b"))
+	defer bs.Close()
+	c, cs := testArtifactNode(t, filepath.Join(dir, "c"))
+	defer cs.Close()
+
+	key := "github.com/briandowns/smile.Greet().1.9.2"
+	if err := a.artifacts.Put(context.Background(), &artifacts.Metadata{Key: key}, strings.NewReader("binary")); err != nil {
+		t.Fatal(err)
+	}
+	transfer := func(fn http.HandlerFunc, node string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(transferRequest{Key: key, Node: node})
+		w := httptest.NewRecorder()
+		fn(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+		return w
+	}
+
+	if w := transfer(a.pushArtifactHandler(), bs.URL); w.Code != http.StatusOK {
+		t.Fatalf("expected push to succeed got %d: %s", w.Code, w.Body)
+	}
+	if _, err := b.artifacts.Lookup(context.Background(), key); err != nil {
+		t.Errorf("expected the binary pushed got %v", err)
+	}
+	if w := transfer(c.pullArtifactHandler(), bs.URL); w.Code != http.StatusOK {
+		t.Fatalf("expected pull to succeed got %d: %s", w.Code, w.Body)
+	}
+	path, err := c.artifacts.Binary(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != "binary" {
+		t.Errorf("unexpected binary %q", got)
+	}
+	if w := transfer(c.pullArtifactHandler(), as.URL+"/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing node store got %d", w.Code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, bs.URL+artifacts.NodePath+"?key=x", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 without the cluster token got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/briandowns/sky-island/audit"
+)
+
+// maxErrorBody is how much of an error response is kept to find
+// the error message recorded in the audit log
+const maxErrorBody = 4096
+
+// statusWriter records the status code and size of a response and
+// the body of an error response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+	body   bytes.Buffer
+}
+
+// WriteHeader implements the http.ResponseWriter interface
+func (s *statusWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the http.ResponseWriter interface
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	if s.status >= http.StatusBadRequest && s.body.Len() < maxErrorBody {
+		s.body.Write(b)
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += n
+	return n, err
+}
+
+// code returns the status code written, 200 if none was
+func (s *statusWriter) code() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// errorMessage returns the error of an error response
+func (s *statusWriter) errorMessage() string {
+	if s.code() < http.StatusBadRequest {
+		return ""
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(s.body.Bytes(), &payload); err == nil {
+		if msg, ok := payload["error"].(string); ok {
+			return msg
+		}
+	}
+	return http.StatusText(s.code())
+}
+
+// statusOf returns the audit status of the given response code
+func statusOf(code int) string {
+	switch {
+	case code == http.StatusGatewayTimeout:
+		return audit.StatusTimeout
+	case code >= http.StatusBadRequest:
+		return audit.StatusFailed
+	}
+	return audit.StatusOK
+}
+
+// callerOf returns the address the given request came from
+func callerOf(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// allocatedIP returns the IP address allocated to the jail with
+// the given id, if any
+func (h *handler) allocatedIP(id string) string {
+	for ip, owner := range h.networksvc.Pool() {
+		if string(owner) == id {
+			return ip
+		}
+	}
+	return ""
+}
+
+// recordInvocation completes the given invocation from the response,
+// records it and updates the invocation metrics
+func (h *handler) recordInvocation(inv *audit.Invocation, sw *statusWriter) {
+	inv.FinishedAt = time.Now().UTC()
+	inv.Status = statusOf(sw.code())
+	inv.Error = sw.errorMessage()
+	h.observeInvocation(inv)
+	if h.audit == nil {
+		return
+	}
+	inv.IP = h.allocatedIP(inv.Jail)
+	if err := h.audit.RecordInvocation(inv); err != nil {
+		h.logger.Log("error", "recording invocation: "+err.Error())
+	}
+}
+
+// audited records every call of the given admin handler as the
+// given action, including those refused for a bad token
+func (h *handler) audited(action string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		fn(sw, r)
+		if h.audit == nil {
+			return
+		}
+		a := &audit.Action{
+			Time:   time.Now().UTC(),
+			Caller: callerOf(r),
+			Action: action,
+			Target: r.URL.Path,
+			Status: statusOf(sw.code()),
+			Code:   sw.code(),
+		}
+		if err := h.audit.RecordAction(a); err != nil {
+			h.logger.Log("error", "recording audit action: "+err.Error())
+		}
+	}
+}
+
+// parseSince parses the since query parameter, either an RFC 3339
+// time or a duration before now
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return time.Time{}, errors.New("since must be an RFC 3339 time or a duration")
+	}
+	return time.Now().Add(-d), nil
+}
+
+// writeNDJSON writes the given values as newline delimited JSON
+func writeNDJSON(w http.ResponseWriter, n int, fn func(int) interface{}) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		if err := enc.Encode(fn(i)); err != nil {
+			return
+		}
+	}
+}
+
+// invocationsHandler returns the recorded invocations matching the
+// function, status, since and limit query parameters, as NDJSON if
+// the format parameter is ndjson
+func (h *handler) invocationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.metrics.Inc("handlers.requests", "handler", "invocations")
+		if h.audit == nil {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "audit log not configured"})
+			return
+		}
+		q := r.URL.Query()
+		since, err := parseSince(q.Get("since"))
+		if err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		var limit int
+		if l := q.Get("limit"); l != "" {
+			if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit " + l})
+				return
+			}
+		}
+		invs := h.audit.Invocations(&audit.Query{
+			Function: q.Get("function"),
+			Status:   q.Get("status"),
+			Since:    since,
+			Limit:    limit,
+		})
+		if q.Get("format") == "ndjson" {
+			writeNDJSON(w, len(invs), func(i int) interface{} { return invs[i] })
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"invocations": invs})
+	}
+}
+
+// auditHandler returns the recorded admin actions since the since
+// query parameter, as NDJSON if the format parameter is ndjson
+func (h *handler) auditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.metrics.Inc("handlers.requests", "handler", "audit")
+		if h.audit == nil {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "audit log not configured"})
+			return
+		}
+		since, err := parseSince(r.URL.Query().Get("since"))
+		if err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		actions := h.audit.Actions(since)
+		if r.URL.Query().Get("format") == "ndjson" {
+			writeNDJSON(w, len(actions), func(i int) interface{} { return actions[i] })
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"actions": actions})
+	}
+}
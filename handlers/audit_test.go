@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/unrolled/render"
+)
+
+// TestAudited verifies admin calls are recorded with the caller and
+// status, including those refused for a bad token
+func TestAudited(t *testing.T) {
+	store, err := audit.NewStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{
+		conf:   &config.Config{AdminTokenHeader: "X-Token", AdminAPIToken: "secret"},
+		logger: gklog.NewNopLogger(),
+		ren:    render.New(),
+		audit:  store,
+	}
+	fn := h.audited("jail.kill", h.auth(func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]int{"deleted": 1})
+	}))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/jail/1", nil)
+	req.RemoteAddr = "10.0.0.5:4242"
+	fn(httptest.NewRecorder(), req)
+	req.Header.Set("X-Token", "secret")
+	fn(httptest.NewRecorder(), req)
+
+	actions := store.Actions(time.Time{})
+	if len(actions) != 2 {
+		t.Fatalf("expected 2 actions got %d", len(actions))
+	}
+	if a := actions[0]; a.Status != audit.StatusOK || a.Caller != "10.0.0.5" || a.Target != "/api/v1/admin/jail/1" {
+		t.Errorf("unexpected action %+v", a)
+	}
+	if a := actions[1]; a.Status != audit.StatusFailed || a.Code != http.StatusForbidden {
+		t.Errorf("expected refused action got %+v", a)
+	}
+}
+
+// TestStatusWriter_ErrorMessage verifies the error of an error
+// response is found
+func TestStatusWriter_ErrorMessage(t *testing.T) {
+	h := &handler{ren: render.New()}
+	sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+	h.ren.JSON(sw, http.StatusBadRequest, map[string]string{"error": "invalid timeout soon"})
+	if msg := sw.errorMessage(); msg != "invalid timeout soon" {
+		t.Errorf("expected error message got %q", msg)
+	}
+	if status := statusOf(sw.code()); status != audit.StatusFailed {
+		t.Errorf("expected failed got %s", status)
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+)
+
+// cacheHandler handles requests to list the cached binaries by
+// cache key
+func (h *handler) cacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"binaries": h.binCache.Entries()})
+	}
+}
+
+// purgeCacheHandler handles requests to empty the binary cache,
+// removing the cached binaries
+func (h *handler) purgeCacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paths := h.binCache.Purge()
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				h.logger.Log("error", err.Error())
+			}
+		}
+		h.metrics.Set("cache.binaries", 0)
+		h.ren.JSON(w, http.StatusOK, map[string]int{"purged": len(paths)})
+	}
+}
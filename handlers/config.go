@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/briandowns/sky-island/config"
+)
+
+// reloadConfigHandler handles requests to reload the config file,
+// applying the fields that can change without a restart
+func (h *handler) reloadConfigHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changed, err := h.conf.ReloadFrom(h.configFile)
+		switch err := err.(type) {
+		case nil:
+			h.logger.Log("msg", "config reloaded", "changed", strings.Join(changed, ","))
+			if changed == nil {
+				changed = []string{}
+			}
+			h.ren.JSON(w, http.StatusOK, map[string][]string{"changed": changed})
+		case *config.ValidationError:
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error(), "problems": err.Problems})
+		case *config.RestartRequiredError:
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "fields": err.Fields})
+		default:
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+		}
+	}
+}
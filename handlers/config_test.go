@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/unrolled/render"
+)
+
+// TestReloadConfigHandler verifies a reload applies new tokens and
+// reports changes requiring a restart
+func TestReloadConfigHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := ioutil.ReadFile("../config/testdata/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.json")
+	write := func(old, new string) {
+		if err := ioutil.WriteFile(file, []byte(strings.Replace(string(b), old, new, 1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("", "")
+	conf, err := config.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{conf: conf, configFile: file, logger: gklog.NewNopLogger(), ren: render.New()}
+
+	write(`"admin_api_token": "asdfasdfasdfasdf"`, `"admin_api_token": "rotated"`)
+	w := httptest.NewRecorder()
+	h.reloadConfigHandler()(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/config/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", w.Code, w.Body)
+	}
+	if _, token := conf.AdminAuth(); token != "rotated" {
+		t.Errorf("expected rotated token got %s", token)
+	}
+
+	write(`"release": "11.1-RELEASE"`, `"release": "11.2-RELEASE"`)
+	w = httptest.NewRecorder()
+	h.reloadConfigHandler()(w, httptest.NewRequest(http.MethodPost, "/api/v1/admin/config/reload", nil))
+	var res struct {
+		Fields []string `json:"fields"`
+	}
+	json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusConflict || len(res.Fields) != 1 || res.Fields[0] != "release" {
+		t.Errorf("expected conflict on release got %d %v", w.Code, res.Fields)
+	}
+	if _, token := conf.AdminAuth(); token != "rotated" {
+		t.Errorf("expected token unchanged got %s", token)
+	}
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,20 +12,35 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/briandowns/sky-island/artifacts"
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/cluster"
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/secrets"
+	"github.com/briandowns/sky-island/tracing"
 	"github.com/briandowns/sky-island/utils"
 	"github.com/pborman/uuid"
 )
 
-const (
-	jailGoPath        = "/root/go"
-	jailGoInstallpath = "/usr/local/go/bin/go"
-	jailGoBin         = "command=" + jailGoInstallpath
-)
+const jailGoPath = "/root/go"
+
+// jailPath is the PATH given to functions executing in a jail
+const jailPath = "PATH=/sbin:/bin:/usr/sbin:/usr/bin:/usr/local/sbin:/usr/local/bin"
+
+// secretRefPrefix marks an env value as a reference to a
+// stored secret rather than a literal value
+const secretRefPrefix = "secret:"
+
+// errSecretsUnregistered is returned when a function that isn't
+// registered references a secret, as secrets are granted by name
+var errSecretsUnregistered = errors.New("secrets are only available to registered functions")
 
 const (
 	buildJailSrcDirPath = "/build/root/go/src/"
@@ -33,11 +51,49 @@ const (
 // functionRunRequest contains the data sent to build
 // and execute a function
 type functionRunRequest struct {
-	URL       string `json:"url"`
-	Call      string `json:"call"`
-	IP4       bool   `json:"ip4,omityempty"`
-	CacheBust bool   `json:"cache_bust,omityempty"`
-	Version   string `json:"version,omityempty"`
+	URL       string            `json:"url"`
+	Call      string            `json:"call"`
+	IP4       bool              `json:"ip4,omityempty"`
+	CacheBust bool              `json:"cache_bust,omityempty"`
+	Version   string            `json:"version,omityempty"`
+	Input     json.RawMessage   `json:"input,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Secrets   []string          `json:"secrets,omitempty"`
+	GoVersion string            `json:"go_version,omitempty"`
+	Release   string            `json:"release,omitempty"`
+	Packages  []string          `json:"packages,omitempty"`
+	Timeout   string            `json:"timeout,omitempty"`
+}
+
+// stdin returns the input payload to be piped to the function.
+// A JSON string is passed as its unquoted contents while any
+// other JSON value is passed as is
+func (f *functionRunRequest) stdin() ([]byte, error) {
+	if len(f.Input) == 0 {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(f.Input, &s); err == nil {
+		return []byte(s), nil
+	}
+	if !json.Valid(f.Input) {
+		return nil, errors.New("invalid input payload")
+	}
+	return f.Input, nil
+}
+
+// referencesSecrets reports whether the request asks for secrets,
+// as files or as env values
+func (f *functionRunRequest) referencesSecrets() bool {
+	if len(f.Secrets) > 0 {
+		return true
+	}
+	for _, v := range f.Env {
+		if strings.HasPrefix(v, secretRefPrefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // functionRunResponse is returned upon successful
@@ -47,15 +103,56 @@ type functionRunResponse struct {
 	Data      string `json:"data"`
 }
 
-// build builds the binary from the request data
-func (h *handler) build(id, url, call string) ([]byte, error) {
+// buildSpec describes how a function's binary is built. Every
+// field contributes to the binary cache key
+type buildSpec struct {
+	URL       string
+	Call      string
+	Kind      string
+	GoVersion string
+	Tags      []string
+	LDFlags   string
+
+	// Commit is the commit of the cloned repo, so a binary built
+	// before the repo moved on isn't reused
+	Commit string
+}
+
+// cacheKey returns the binary cache key for the spec
+func (b *buildSpec) cacheKey() string {
+	key := b.URL + "." + b.Call + "." + b.GoVersion
+	if b.Kind == functions.KindHTTP {
+		key += "." + b.Kind
+	}
+	if len(b.Tags) > 0 || b.LDFlags != "" {
+		key += "." + strings.Join(b.Tags, ",") + "." + b.LDFlags
+	}
+	if b.Commit != "" {
+		key += "." + b.Commit
+	}
+	return key
+}
+
+// build builds the binary from the given spec using the main
+// template for the spec's kind of function. The build jail is
+// removed if the context is done first
+func (h *handler) build(ctx context.Context, id string, spec *buildSpec) ([]byte, error) {
+	goBin, err := h.tsvc.GoBin(spec.GoVersion)
+	if err != nil {
+		return nil, err
+	}
+	url := spec.URL
 	importElems := strings.Split(url, "/")
 	td := &tmplData{
 		PKGName:    importElems[len(importElems)-1],
 		ImportPath: url,
-		Call:       call,
+		Call:       spec.Call,
 	}
-	t, err := template.New(url).Parse(mainTmpl)
+	tmpl := mainTmpl
+	if spec.Kind == functions.KindHTTP {
+		tmpl = httpMainTmpl
+	}
+	t, err := template.New(url).Parse(tmpl)
 	if err != nil {
 		return nil, err
 	}
@@ -78,50 +175,240 @@ func (h *handler) build(id, url, call string) ([]byte, error) {
 		return nil, err
 	}
 	buildCommand := []string{
-		jailGoBin,
+		"command=" + goBin,
 		"build",
 		"-o",
 		"/tmp/" + id,
 		"-v",
-		url + "/cmd",
 	}
+	if len(spec.Tags) > 0 {
+		buildCommand = append(buildCommand, "-tags", strings.Join(spec.Tags, ","))
+	}
+	if spec.LDFlags != "" {
+		buildCommand = append(buildCommand, "-ldflags", spec.LDFlags)
+	}
+	buildCommand = append(buildCommand, url+"/cmd")
 	fullBuildArgs := []string{
 		"-c",
 		"-n",
 		id,
 		"ip4=disable",
-		"exec.timeout=" + h.conf.Jails.BuildTimeout,
+		"exec.timeout=" + strconv.Itoa(int(h.buildTimeout().Seconds())) + "s",
 		"path=" + h.conf.Jails.BaseJailDir + "/build",
 		"host.hostname=build",
 		"mount.devfs",
 	}
 	fullBuildArgs = append(fullBuildArgs, buildCommand...)
 	buildCmd := exec.Command("jail", fullBuildArgs...)
+	var out bytes.Buffer
+	buildCmd.Stdout = &out
+	buildCmd.Stderr = &out
+	err = h.runJailed(ctx, "build", id, buildCmd, &out)
+	return out.Bytes(), err
+}
+
+// checkEnv verifies that every key of the given env is present
+// in the configured allow list
+func (h *handler) checkEnv(env map[string]string) error {
+	allowList := h.conf.JailSettings().EnvAllowList
+	allowed := make(map[string]bool, len(allowList))
+	for _, k := range allowList {
+		allowed[k] = true
+	}
+	for k := range env {
+		if !allowed[k] {
+			return fmt.Errorf("env var %s not allowed", k)
+		}
+	}
+	return nil
+}
+
+// checkPackages verifies that every given package is present in the
+// configured allow list
+func (h *handler) checkPackages(pkgs []string) error {
+	allowList := h.conf.JailSettings().PackageAllowList
+	allowed := make(map[string]bool, len(allowList))
+	for _, p := range allowList {
+		allowed[p] = true
+	}
+	for _, p := range pkgs {
+		if !allowed[p] {
+			return fmt.Errorf("package %s not allowed", p)
+		}
+	}
+	return nil
+}
+
+// secret returns the value of the named secret if it has been
+// granted to the given registered function
+func (h *handler) secret(name, function string) ([]byte, error) {
+	if function == "" {
+		return nil, errSecretsUnregistered
+	}
+	if h.secrets == nil {
+		return nil, secrets.ErrNotConfigured
+	}
+	return h.secrets.Value(name, function)
+}
+
+// buildEnv resolves any secret references of the given environment
+// granted to the given registered function, empty for ad-hoc calls,
+// and returns the result in the form expected by exec.Cmd. The env of
+// ad-hoc calls, manifest env included, is checked against the allow
+// list before, the env of registered functions when they're registered
+func (h *handler) buildEnv(function string, reqEnv map[string]string) ([]string, error) {
+	env := []string{jailPath}
+	for k, v := range reqEnv {
+		if strings.HasPrefix(v, secretRefPrefix) {
+			secret, err := h.secret(strings.TrimPrefix(v, secretRefPrefix), function)
+			if err != nil {
+				return nil, err
+			}
+			v = string(secret)
+		}
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env[1:])
+	return env, nil
+}
+
+// secretFiles returns the values of the given secrets, keyed by
+// name, to be mounted into the jail for the given registered
+// function, empty for ad-hoc calls
+func (h *handler) secretFiles(function string, names []string) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		secret, err := h.secret(name, function)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = secret
+	}
+	return files, nil
+}
+
+// repoPath returns the path the given repo is cloned to
+func (h *handler) repoPath(url string) string {
+	return h.conf.Jails.BaseJailDir + buildJailSrcDirPath + url
+}
+
+// ensureRepo clones the given repo if it hasn't been cloned yet,
+// removing it first when cache busting
+func (h *handler) ensureRepo(ctx context.Context, url string, cacheBust bool) error {
+	if cacheBust {
+		h.logger.Log("msg", "cache busting"+url)
+		if err := h.rsvc.RemoveRepo(url); err != nil {
+			return err
+		}
+	}
+	if !utils.Exists(h.repoPath(url)) {
+		h.logger.Log("msg", "cloning "+url)
+		if err := h.rsvc.CloneRepo(ctx, h.conf.Jails.BaseJailDir+buildJailSrcDirPath, url); err != nil {
+			if err == context.DeadlineExceeded {
+				return &timeoutError{stage: "clone"}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// manifestSettings loads the manifest of the given cloned repo and
+// applies the given request settings to it. The manifest is nil if
+// the repo doesn't have one
+func (h *handler) manifestSettings(url string, req *functions.Settings) (*functions.Manifest, *functions.Settings, error) {
+	m, err := functions.LoadManifest(h.repoPath(url))
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := m.Apply(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, s, nil
+}
+
+// binary returns the path to the compiled binary for the given spec
+// from the cloned repo and whether it was cached. A cached binary is
+// used if present, otherwise a new binary is built in the build jail
+// and cached
+func (h *handler) binary(ctx context.Context, id string, spec *buildSpec, cacheBust bool) (string, bool, error) {
+	if spec.GoVersion == "" {
+		spec.GoVersion = h.conf.GoVersion
+	}
+	cacheKey := spec.cacheKey()
+	if cacheBust {
+		h.binCache.Set(cacheKey, "")
+	} else {
+		binPath, err := h.binCache.Lookup(ctx, cacheKey)
+		if err != nil {
+			h.logger.Log("error", "artifact store: "+err.Error())
+		}
+		if binPath != "" {
+			h.logger.Log("msg", "using cached binary: "+binPath)
+			h.metrics.Inc("cache.hits")
+			return binPath, true, nil
+		}
+	}
+	h.metrics.Inc("cache.misses")
 
-	return buildCmd.CombinedOutput()
+	buildRes, err := h.build(ctx, id, spec)
+	if err != nil {
+		if _, ok := err.(*timeoutError); ok {
+			return "", false, err
+		}
+		return "", false, fmt.Errorf("%s %s", err.Error(), string(buildRes))
+	}
+	buildPath := h.conf.Jails.BaseJailDir + "/build/tmp/" + id
+	m := &artifacts.Metadata{
+		Key:       cacheKey,
+		URL:       spec.URL,
+		Call:      spec.Call,
+		GoVersion: spec.GoVersion,
+		Commit:    spec.Commit,
+		Node:      cluster.NodeID(h.conf),
+	}
+	binPath, err := h.binCache.Add(ctx, m, buildPath)
+	if err != nil {
+		h.logger.Log("error", "artifact store: "+err.Error())
+	}
+	switch binPath {
+	case "":
+		binPath = buildPath
+		h.binCache.Set(cacheKey, binPath)
+	case buildPath:
+	default:
+		os.Remove(buildPath)
+	}
+	h.metrics.Set("cache.binaries", float64(h.binCache.Len()))
+	return binPath, false, nil
 }
 
-// execute creates a jail, executes the built binary and returns the output
-func (h *handler) execute(id, binPath string, ip4 bool) ([]byte, error) {
+// jailArgs copies the given binary into the jail with the given
+// id and returns the arguments to create the jail with, allocating
+// an IP address if requested
+func (h *handler) jailArgs(ctx context.Context, id, binPath string, ip4 bool, timeout time.Duration) ([]string, error) {
 	dst := filepath.Join(h.conf.Jails.BaseJailDir, id, "tmp", id)
 	if err := copyBinary(dst, binPath); err != nil {
 		return nil, err
 	}
 
-	cm := strconv.Itoa(h.conf.Jails.ChildrenMax)
+	cm := strconv.Itoa(h.conf.JailSettings().ChildrenMax)
 	funcExecArgs := []string{
 		"-c",
 		"-n",
 		id,
 		"children.max=" + cm,
-		"exec.timeout=" + h.conf.Jails.ExecTimeout,
+		"exec.timeout=" + strconv.Itoa(int(timeout.Seconds())) + "s",
 		"path=" + h.conf.Jails.BaseJailDir + "/" + id,
 		"host.hostname=" + id,
 		"mount.devfs",
 	}
 
 	if ip4 {
+		_, span := h.tracer.Start(ctx, "ip_allocate")
 		ip, err := h.networksvc.Allocate([]byte(id))
+		tracing.End(span, err)
 		if err != nil {
 			return nil, err
 		}
@@ -130,15 +417,50 @@ func (h *handler) execute(id, binPath string, ip4 bool) ([]byte, error) {
 	} else {
 		funcExecArgs = append(funcExecArgs, "ip4=disable")
 	}
+	return funcExecArgs, nil
+}
+
+// execute creates a jail, executes the built binary and returns the output.
+// The given env is the full environment of the function and input, if any,
+// is piped to its stdin. The jail is removed if the context is done first
+func (h *handler) execute(ctx context.Context, id, binPath string, s *functions.Settings, env []string, input []byte) ([]byte, error) {
+	funcExecArgs, err := h.jailArgs(ctx, id, binPath, s.IP4, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
 	funcExecArgs = append(funcExecArgs, "command=/tmp/"+id)
 
-	return exec.Command("jail", funcExecArgs...).Output()
+	cmd := exec.Command("jail", funcExecArgs...)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err = h.runJailed(ctx, "execution", id, cmd, &out)
+	return out.Bytes(), err
 }
 
 // functionRunHandler handles requests to run functions
 func (h *handler) functionRunHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		defer h.metrics.Histogram("handlers.function.run", 1)
+		h.metrics.Inc("handlers.requests", "handler", "function.run")
+		id := uuid.NewUUID().String()
+		inv := &audit.Invocation{
+			ID:        id,
+			Caller:    callerOf(r),
+			Jail:      id,
+			StartedAt: time.Now().UTC(),
+		}
+		// secrets are only granted to registered functions, which
+		// are run in process with a trigger naming them
+		var function string
+		if t := triggerOf(r); t != nil {
+			function = t.function
+			inv.Function = function
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		defer h.recordInvocation(inv, sw)
+
 		b, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			h.logger.Log("error", err.Error())
@@ -151,66 +473,89 @@ func (h *handler) functionRunHandler() http.HandlerFunc {
 			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
 			return
 		}
-		id := uuid.NewUUID().String()
-		if err := h.jsvc.CreateJail(id, true); err != nil {
-			h.logger.Log("error", err.Error())
-			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
-			return
-		}
-		defer h.jsvc.RemoveJail(id)
-
-		if req.CacheBust {
-			h.logger.Log("msg", "cache busting"+req.URL)
-			if err := h.rsvc.RemoveRepo(req.URL); err != nil {
-				h.logger.Log("error", err.Error())
-				h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+		inv.URL = req.URL
+		if function == "" {
+			if req.referencesSecrets() {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": errSecretsUnregistered.Error()})
 				return
 			}
-			h.binCache.Set(req.URL, "")
-		}
-
-		var binPath string
-		binPath = h.binCache.Get(req.URL + "." + req.Call)
-		if binPath != "" {
-			execRes, err := h.execute(id, binPath, req.IP4)
-			if err != nil {
-				h.logger.Log("error", err.Error())
-				h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			// the env and packages of registered functions were
+			// checked when they were registered
+			if err := h.checkEnv(req.Env); err != nil {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 				return
 			}
-			h.logger.Log("msg", "using cached binary: "+binPath)
-			h.ren.JSON(w, http.StatusOK, functionRunResponse{Timestamp: time.Now().UTC().Unix(), Data: string(execRes)})
-			return
-		}
-
-		clonePath := h.conf.Jails.BaseJailDir + buildJailSrcDirPath
-		if !utils.Exists(clonePath + req.URL) {
-			h.logger.Log("msg", "cloning "+req.URL)
-			if err := h.rsvc.CloneRepo(clonePath, req.URL); err != nil {
-				h.logger.Log("error", err.Error())
-				h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			if err := h.checkPackages(req.Packages); err != nil {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 				return
 			}
 		}
-
-		buildRes, err := h.build(id, req.URL, req.Call)
+		input, err := req.stdin()
 		if err != nil {
-			h.logger.Log("error", err.Error()+" "+string(buildRes))
-			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
-
-		execRes, err := h.execute(id, h.conf.Jails.BaseJailDir+"/build/tmp/"+id, req.IP4)
+		timeout, err := parseTimeout(req.Timeout)
 		if err != nil {
-			h.logger.Log("error", err.Error())
-			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := jail.ValidPackages(req.Packages); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
-		h.binCache.Set(req.URL+"."+req.Call, h.conf.Jails.BaseJailDir+"/build/tmp/"+id)
-		h.ren.JSON(w, http.StatusOK, functionRunResponse{Timestamp: time.Now().UTC().Unix(), Data: string(execRes)})
+		fr := &pipelineRun{
+			function: function,
+			url:      req.URL,
+			kind:     functions.KindCall,
+			requested: &functions.Settings{
+				Call:      req.Call,
+				GoVersion: req.GoVersion,
+				IP4:       req.IP4,
+				Env:       req.Env,
+				Timeout:   timeout,
+			},
+			secrets:       req.Secrets,
+			release:       req.Release,
+			packages:      req.Packages,
+			cacheBust:     req.CacheBust,
+			checkCaller:   function == "",
+			invalidStatus: http.StatusBadRequest,
+		}
+		h.runPipeline(w, r, inv, fr, func(ctx context.Context, binPath string, s *functions.Settings, env []string) error {
+			ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+			defer cancel()
+			execRes, err := h.execute(ctx, id, binPath, s, env, input)
+			inv.OutputSize = len(execRes)
+			inv.ExitCode = exitCode(err)
+			if err != nil {
+				return err
+			}
+			h.ren.JSON(w, http.StatusOK, functionRunResponse{Timestamp: time.Now().UTC().Unix(), Data: string(execRes)})
+			return nil
+		})
 	}
 }
 
+// exitCode returns the exit code of a function that returned the
+// given error, -1 if it didn't exit
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if ee, ok := err.(*exec.ExitError); ok {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// jailLimits returns the jail resource limits for the given
+// manifest limits
+func jailLimits(l functions.Limits) *jail.Resources {
+	return &jail.Resources{Memory: l.Memory, CPU: l.CPU, MaxProc: l.MaxProc}
+}
+
 // tmplData contains the data passed to the tempalte
 // engine to render the code for compilation
 type tmplData struct {
@@ -236,6 +581,30 @@ func main() {
 }
 `
 
+// httpMainTmpl is the template used for http function execution. The
+// handler is served on the unix socket given as the first argument
+const httpMainTmpl = `// generated by sky-island
+// DO NOT EDIT
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+
+	"{{.ImportPath}}"
+)
+
+func main() {
+	l, err := net.Listen("unix", os.Args[1])
+	if err != nil {
+		os.Exit(1)
+	}
+	http.Serve(l, http.HandlerFunc({{.PKGName}}.{{.Call}}))
+}
+`
+
 // copyBinary copies the given src to the given destination
 func copyBinary(dst, src string) error {
 	bb, err := os.Open(src)
@@ -1 +1,294 @@
 package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/mocks"
+	"github.com/briandowns/sky-island/secrets"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestEnvHandler creates a handler with env and package allow
+// lists
+func newTestEnvHandler(t *testing.T) *handler {
+	h := newTestHandler(t, nil)
+	h.conf = &config.Config{
+		Jails: &config.Jails{
+			EnvAllowList:     []string{"FOO", "DB_PASS"},
+			PackageAllowList: []string{"curl"},
+		},
+	}
+	return h
+}
+
+// TestBuildEnv verifies that allowed env vars are passed through
+// and secret references are resolved
+func TestBuildEnv(t *testing.T) {
+	env, err := newTestEnvHandler(t).buildEnv("billing", map[string]string{
+		"FOO":     "bar",
+		"DB_PASS": "secret:db",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{jailPath, "DB_PASS=s3cr3t", "FOO=bar"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %v got %v", expected, env)
+	}
+}
+
+// TestCheckEnv_NotAllowed verifies that env vars missing from
+// the allow list are rejected
+func TestCheckEnv_NotAllowed(t *testing.T) {
+	h := newTestEnvHandler(t)
+	if err := h.checkEnv(map[string]string{"FOO": "bar"}); err != nil {
+		t.Error(err)
+	}
+	if err := h.checkEnv(map[string]string{"HOME": "/"}); err == nil {
+		t.Error("expected error but received none")
+	}
+}
+
+// TestFunctionRunHandler_EnvNotAllowed verifies that ad-hoc calls
+// setting env vars missing from the allow list are rejected before
+// their repo is cloned
+func TestFunctionRunHandler_EnvNotAllowed(t *testing.T) {
+	h := newTestEnvHandler(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/function", strings.NewReader(`{"url": "github.com/a/b", "call": "Run()", "env": {"HOME": "/"}}`))
+	rr := httptest.NewRecorder()
+	h.functionRunHandler()(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "env var HOME not allowed") {
+		t.Errorf("expected %d got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+}
+
+// TestBuildEnv_UnknownSecret verifies that a reference to a
+// secret that doesn't exist is rejected
+func TestBuildEnv_UnknownSecret(t *testing.T) {
+	if _, err := newTestEnvHandler(t).buildEnv("billing", map[string]string{"FOO": "secret:nope"}); err == nil {
+		t.Error("expected error but received none")
+	}
+}
+
+// TestBuildEnv_NotGranted verifies that a function can't reference
+// a secret it hasn't been granted
+func TestBuildEnv_NotGranted(t *testing.T) {
+	if _, err := newTestEnvHandler(t).buildEnv("github.com/a/b", map[string]string{"DB_PASS": "secret:db"}); err == nil {
+		t.Error("expected error but received none")
+	}
+}
+
+// TestBuildEnv_Unregistered verifies that ad-hoc calls can't
+// reference secrets, even ones granted to their repo URL
+func TestBuildEnv_Unregistered(t *testing.T) {
+	if _, err := newTestEnvHandler(t).buildEnv("", map[string]string{"DB_PASS": "secret:db"}); err != errSecretsUnregistered {
+		t.Errorf("expected %v got %v", errSecretsUnregistered, err)
+	}
+	if _, err := newTestEnvHandler(t).secretFiles("", []string{"db"}); err != errSecretsUnregistered {
+		t.Errorf("expected %v got %v", errSecretsUnregistered, err)
+	}
+}
+
+// TestFunctionRunHandler_AdHocSecrets verifies that ad-hoc calls
+// asking for secrets are rejected before their repo is cloned
+func TestFunctionRunHandler_AdHocSecrets(t *testing.T) {
+	h := newTestEnvHandler(t)
+	for _, body := range []string{
+		`{"url": "github.com/a/b", "call": "Run()", "secrets": ["db"]}`,
+		`{"url": "github.com/a/b", "call": "Run()", "env": {"DB_PASS": "secret:db"}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/function", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		h.functionRunHandler()(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), errSecretsUnregistered.Error()) {
+			t.Errorf("%s: expected %d got %d: %s", body, http.StatusBadRequest, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// TestSecretFiles verifies granted secrets are returned by name
+// and that secrets fail without a configured store
+func TestSecretFiles(t *testing.T) {
+	files, err := newTestEnvHandler(t).secretFiles("billing", []string{"db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(files["db"]) != "s3cr3t" {
+		t.Errorf("expected secret value got %s", string(files["db"]))
+	}
+	h := &handler{conf: testConf}
+	if _, err := h.secretFiles("billing", []string{"db"}); err != secrets.ErrNotConfigured {
+		t.Errorf("expected %v got %v", secrets.ErrNotConfigured, err)
+	}
+}
+
+// TestStdin verifies string and JSON input payloads are
+// converted to the bytes piped to the function
+func TestStdin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: `"hello"`, expected: "hello"},
+		{input: `{"a": 1}`, expected: `{"a": 1}`},
+		{input: ``, expected: ""},
+	}
+	for _, test := range tests {
+		req := functionRunRequest{Input: json.RawMessage(test.input)}
+		b, err := req.stdin()
+		if err != nil {
+			t.Error(err)
+		}
+		if string(b) != test.expected {
+			t.Errorf("expected %q got %q", test.expected, string(b))
+		}
+	}
+}
+
+// TestBuildSpecCacheKey verifies the Go version, kind and commit are
+// part of the binary cache key
+func TestBuildSpecCacheKey(t *testing.T) {
+	a := &buildSpec{URL: "github.com/a/b", Call: "F()", GoVersion: "1.21.5"}
+	b := &buildSpec{URL: "github.com/a/b", Call: "F()", GoVersion: "1.22.0"}
+	if a.cacheKey() == b.cacheKey() {
+		t.Error("expected different cache keys for different go versions")
+	}
+	c := &buildSpec{URL: "github.com/a/b", Call: "F()", GoVersion: "1.21.5", Kind: functions.KindHTTP}
+	if a.cacheKey() == c.cacheKey() {
+		t.Error("expected different cache keys for different kinds")
+	}
+	d := &buildSpec{URL: "github.com/a/b", Call: "F()", GoVersion: "1.21.5", Commit: "0b1f6a3c"}
+	if a.cacheKey() == d.cacheKey() {
+		t.Error("expected different cache keys for different commits")
+	}
+}
+
+// TestInvoke_Secrets verifies runs of registered functions resolve the
+// secrets granted to the function's name
+func TestInvoke_Secrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := &functions.Function{
+		Name:    "billing",
+		URL:     "github.com/example/billing",
+		Call:    "Run()",
+		Env:     map[string]string{"DB_PASS": "secret:db"},
+		Secrets: []string{"db"},
+	}
+	if err := os.MkdirAll(dir+buildJailSrcDirPath+fn.URL, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rsvc := &mocks.RepoServicer{}
+	rsvc.On("RepoCommit", fn.URL).Return("abc123", nil)
+	jsvc := &mocks.JailServicer{}
+	jsvc.On("CreateFunctionJail", mock.Anything, mock.Anything).Return(nil)
+	jsvc.On("MountSecrets", mock.Anything, map[string][]byte{"db": []byte("s3cr3t")}).Return(errors.New("no tmpfs"))
+	jsvc.On("RemoveJail", mock.Anything).Return(nil)
+	h := newTestHandler(t, rsvc, fn)
+	h.jsvc = jsvc
+	h.conf = &config.Config{
+		Jails: &config.Jails{
+			BaseJailDir:  dir,
+			EnvAllowList: []string{"DB_PASS"},
+		},
+	}
+
+	// mounting the resolved secret fails, ending the run
+	_, err = h.invoke(context.Background(), triggerScheduler, fn, nil, false)
+	if ierr, ok := err.(*invokeError); !ok || ierr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a %d invoke error got %v", http.StatusInternalServerError, err)
+	}
+	jsvc.AssertCalled(t, "MountSecrets", mock.Anything, map[string][]byte{"db": []byte("s3cr3t")})
+	jsvc.AssertCalled(t, "RemoveJail", mock.Anything)
+}
+
+// TestFunctionRunHandler_PackageNotAllowed verifies that ad-hoc calls
+// asking for packages missing from the allow list are rejected before
+// their repo is cloned
+func TestFunctionRunHandler_PackageNotAllowed(t *testing.T) {
+	h := newTestEnvHandler(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/function", strings.NewReader(`{"url": "github.com/a/b", "call": "Run()", "packages": ["curl", "nmap"]}`))
+	rr := httptest.NewRecorder()
+	h.functionRunHandler()(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "package nmap not allowed") {
+		t.Errorf("expected %d got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+}
+
+// TestRegisterFunction_PackageNotAllowed verifies functions asking for
+// packages missing from the allow list can't be registered
+func TestRegisterFunction_PackageNotAllowed(t *testing.T) {
+	h := newTestEnvHandler(t)
+	body := `{"url":"github.com/example/resize","call":"Run()","packages":["curl","nmap"]}`
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/function/resize", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": "resize"})
+	rr := httptest.NewRecorder()
+	h.registerFunctionHandler()(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "package nmap not allowed") {
+		t.Errorf("expected %d got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if _, ok := h.registry.Get("resize"); ok {
+		t.Error("expected function not to be registered")
+	}
+}
+
+// TestFunctionRunHandler_ManifestEnv verifies env set by the manifest
+// of an ad-hoc call's repo is checked against the allow list
+func TestFunctionRunHandler_ManifestEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := "github.com/example/echo"
+	repo := dir + buildJailSrcDirPath + url
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(repo, functions.ManifestFile)
+	rsvc := &mocks.RepoServicer{}
+	rsvc.On("RepoCommit", url).Return("abc123", nil)
+	jsvc := &mocks.JailServicer{}
+	jsvc.On("CreateFunctionJail", mock.Anything, mock.Anything).Return(errors.New("no jail"))
+	h := newTestEnvHandler(t)
+	h.rsvc, h.jsvc = rsvc, jsvc
+	h.conf.Jails.BaseJailDir = dir
+
+	run := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/function", strings.NewReader(`{"url": "`+url+`", "call": "Run()"}`))
+		rr := httptest.NewRecorder()
+		h.functionRunHandler()(rr, req)
+		return rr
+	}
+	if err := ioutil.WriteFile(manifest, []byte("env:\n  LD_PRELOAD: ./evil.so\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rr := run(); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "LD_PRELOAD not allowed") {
+		t.Errorf("expected %d got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	jsvc.AssertNotCalled(t, "CreateFunctionJail", mock.Anything, mock.Anything)
+
+	if err := ioutil.WriteFile(manifest, []byte("env:\n  FOO: bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rr := run(); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d got %d: %s", http.StatusInternalServerError, rr.Code, rr.Body.String())
+	}
+	jsvc.AssertCalled(t, "CreateFunctionJail", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/gorilla/mux"
+)
+
+// functionsHandler handles requests to list the registered functions
+func (h *handler) functionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"functions": h.registry.List()})
+	}
+}
+
+// functionDetailsHandler handles requests for a registered function
+func (h *handler) functionDetailsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		fn, ok := h.registry.Get(name)
+		if !ok {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "function " + name + " not found"})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"function": fn})
+	}
+}
+
+// registerFunctionHandler handles requests to add or replace a function
+func (h *handler) registerFunctionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		var fn functions.Function
+		if err := json.Unmarshal(b, &fn); err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+		fn.Name = mux.Vars(r)["name"]
+		if err := h.checkEnv(fn.Env); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := h.checkPackages(fn.Packages); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := jail.ValidPackages(fn.Packages); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := fn.Validate(); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if fn.Queue != nil && (h.queues == nil || !h.queues.Configured(fn.Queue.Broker)) {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "queue broker " + fn.Queue.Broker + " not configured"})
+			return
+		}
+		if err := h.registry.Set(&fn); err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.schedule(&fn)
+		h.consume(&fn)
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"function": fn})
+	}
+}
+
+// removeFunctionHandler handles requests to remove a registered function
+func (h *handler) removeFunctionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if err := h.registry.Remove(name); err != nil {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		if h.scheduler != nil {
+			h.scheduler.Remove(name)
+		}
+		if h.queues != nil {
+			h.queues.Remove(name)
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]string{"deleted": name})
+	}
+}
@@ -1,17 +1,32 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"path/filepath"
+	"time"
 
+	"github.com/briandowns/sky-island/artifacts"
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/cluster"
 	"github.com/briandowns/sky-island/config"
 	"github.com/briandowns/sky-island/filesystem"
+	"github.com/briandowns/sky-island/functions"
 	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/queue"
+	"github.com/briandowns/sky-island/scheduler"
+	"github.com/briandowns/sky-island/secrets"
+	"github.com/briandowns/sky-island/tracing"
 	"github.com/briandowns/sky-island/utils"
+	"github.com/briandowns/sky-island/webhooks"
+	"github.com/briandowns/sky-island/workflow"
 	gklog "github.com/go-kit/kit/log"
 	"github.com/gorilla/mux"
 	"github.com/thoas/stats"
 	"github.com/unrolled/render"
-	statsd "gopkg.in/alexcesaro/statsd.v2"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 const apiPrefix = "/api/v1"
@@ -28,57 +43,203 @@ type Params struct {
 	Conf    *config.Config
 	Logger  gklog.Logger
 	StatsMW *stats.Stats
-	Metrics *statsd.Client
+	Metrics *metrics.Metrics
+
+	// ConfigFile is the file the config is reloaded from
+	ConfigFile string
+	// Wrapper runs the host commands reporting the node's capacity,
+	// utils.Wrap when not set
+	Wrapper utils.Wrapper
+
+	// MetricsHandler serves the Prometheus metrics when enabled
+	MetricsHandler http.Handler
+	Tracer         trace.Tracer
 }
 
 // handler contains the state of the api system
 type handler struct {
 	ren        *render.Render
 	conf       *config.Config
+	configFile string
+	wrapper    utils.Wrapper
 	logger     gklog.Logger
 	statsMW    *stats.Stats
-	metrics    *statsd.Client
+	metrics    *metrics.Metrics
+	tracer     trace.Tracer
 	rsvc       jail.RepoServicer
 	networksvc jail.NetworkServicer
 	jsvc       jail.JailServicer
 	fssvc      filesystem.FSServicer
+	tsvc       jail.ToolchainServicer
+	relsvc     jail.ReleaseServicer
+	patchsvc   jail.PatchServicer
+	healthsvc  jail.HealthServicer
 	binCache   *jail.BinaryCache
+	artifacts  *artifacts.Store
+	scheduler  *scheduler.Scheduler
+	nonces     *webhooks.Nonces
+	queues     *queue.Manager
+	workflows  *workflow.Engine
+	registry   *functions.Registry
+	secrets    *secrets.Store
+	audit      *audit.Store
 }
 
 // AddHandlers builds all endpoints to be passed into the
 func AddHandlers(p *Params) (*mux.Router, error) {
 	p.Logger.Log("msg", "initializing route handlers")
-	networksvc, err := jail.NewNetworkService(p.Conf, p.Logger, p.Metrics.Clone(statsd.Prefix("network")))
+	networksvc, err := jail.NewNetworkService(p.Conf, p.Logger, p.Metrics.Prefix("network"))
+	if err != nil {
+		return nil, err
+	}
+	var registryFile, secretsFile, auditDir, schedulesFile, workflowsDir string
+	if p.Conf.StateDir != "" {
+		registryFile = filepath.Join(p.Conf.StateDir, "functions.json")
+		secretsFile = filepath.Join(p.Conf.StateDir, "secrets.json")
+		auditDir = filepath.Join(p.Conf.StateDir, "audit")
+		schedulesFile = filepath.Join(p.Conf.StateDir, "schedules.json")
+		workflowsDir = filepath.Join(p.Conf.StateDir, "workflows")
+	}
+	registry, err := functions.NewRegistry(registryFile)
 	if err != nil {
 		return nil, err
 	}
+	secretsKey, err := secrets.LoadKey(p.Conf)
+	if err != nil {
+		return nil, err
+	}
+	var secretStore *secrets.Store
+	if secretsKey != nil {
+		if secretStore, err = secrets.NewStore(secretsFile, secretsKey); err != nil {
+			return nil, err
+		}
+	}
+	artifactStore, err := artifacts.FromConfig(p.Conf)
+	if err != nil {
+		return nil, err
+	}
+	var retention time.Duration
+	if p.Conf.Audit != nil {
+		retention = p.Conf.Audit.Retention.D()
+	}
+	auditStore, err := audit.NewStore(auditDir, retention)
+	if err != nil {
+		return nil, err
+	}
+	if p.Wrapper == nil {
+		p.Wrapper = utils.Wrap{}
+	}
+	if p.Tracer == nil {
+		p.Tracer = noop.NewTracerProvider().Tracer(tracing.Name)
+	}
 	h := &handler{
 		ren:        render.New(),
 		conf:       p.Conf,
+		configFile: p.ConfigFile,
+		wrapper:    p.Wrapper,
 		logger:     p.Logger,
 		statsMW:    p.StatsMW,
 		metrics:    p.Metrics,
-		rsvc:       jail.NewRepoService(p.Conf, p.Logger, p.Metrics.Clone(statsd.Prefix("repo"))),
+		tracer:     p.Tracer,
+		rsvc:       jail.NewRepoService(p.Conf, p.Logger, p.Metrics.Prefix("repo")),
 		networksvc: networksvc,
-		jsvc:       jail.NewJailService(p.Conf, p.Logger, p.Metrics.Clone(statsd.Prefix("jail")), utils.Wrap{}),
-		fssvc:      filesystem.NewFilesystemService(p.Conf, p.Logger, p.Metrics.Clone(statsd.Prefix("filesystem")), utils.Wrap{}),
+		jsvc:       jail.NewJailService(p.Conf, p.Logger, p.Metrics.Prefix("jail"), utils.Wrap{}),
+		fssvc:      filesystem.NewFilesystemService(p.Conf, p.Logger, p.Metrics.Prefix("filesystem"), utils.Wrap{}),
+		tsvc:       jail.NewToolchainService(p.Conf, p.Logger, p.Metrics.Prefix("toolchain")),
+		relsvc:     jail.NewReleaseService(p.Conf, p.Logger, p.Metrics.Prefix("release"), utils.Wrap{}),
+		patchsvc:   jail.NewPatchService(p.Conf, p.Logger, p.Metrics.Prefix("patch"), utils.Wrap{}),
 		binCache:   jail.NewBinaryCache(),
+		artifacts:  artifactStore,
+		registry:   registry,
+		secrets:    secretStore,
+		audit:      auditStore,
+		nonces:     webhooks.NewNonces(),
+	}
+	if artifactStore != nil {
+		h.binCache.UseStore(artifactStore)
+	}
+	h.healthsvc = jail.NewHealthService(p.Conf, p.Logger, p.Metrics.Prefix("health"), utils.Wrap{}, networksvc, h.tsvc)
+	if p.Conf.Patching != nil && p.Conf.Patching.Interval > 0 {
+		go h.patchsvc.Schedule(p.Conf.Patching.Interval.D(), nil)
+	}
+	if h.scheduler, err = scheduler.NewScheduler(schedulesFile, p.Logger, nil, h.runScheduled); err != nil {
+		return nil, err
+	}
+	h.queues = queue.NewManager(p.Logger, p.Metrics.Prefix("queue"), queue.FromConfig(p.Conf, cluster.NodeID(p.Conf)), h.runQueued)
+	for _, fn := range registry.List() {
+		h.schedule(fn)
+		h.consume(fn)
+	}
+	go h.scheduler.Run(context.Background())
+	if h.workflows, err = workflow.NewEngine(workflowsDir, p.Logger, h.runWorkflowStep); err != nil {
+		return nil, err
+	}
+	h.workflows.Resume()
+	if p.Conf.Cluster != nil && p.Conf.Cluster.Role == config.RoleWorker {
+		go cluster.NewHeartbeater(p.Conf, p.Logger, h.nodeStatus).Run(context.Background())
 	}
 	router := mux.NewRouter()
 	router.HandleFunc("/healthcheck", h.healthcheckHandler()).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", h.livenessHandler()).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", h.readinessHandler()).Methods(http.MethodGet)
+	if p.MetricsHandler != nil {
+		router.Handle("/metrics", p.MetricsHandler).Methods(http.MethodGet)
+	}
 
 	fr := router.PathPrefix(apiPrefix).Subrouter()
-	fr.Path("/function").HandlerFunc(h.functionRunHandler()).Methods(http.MethodPost)
+	fr.Path("/function").HandlerFunc(tracing.Middleware(h.tracer, "function.run", h.routed(h.functionRunHandler()))).Methods(http.MethodPost)
+
+	router.Path("/hooks/{name}").HandlerFunc(tracing.Middleware(h.tracer, "function.hook", h.hookHandler())).Methods(http.MethodPost)
+
+	router.Path("/fn/{name}").HandlerFunc(tracing.Middleware(h.tracer, "function.http", h.httpFunctionHandler()))
+	router.PathPrefix("/fn/{name}/").HandlerFunc(tracing.Middleware(h.tracer, "function.http", h.httpFunctionHandler()))
 
 	ar := router.PathPrefix(apiPrefix).Subrouter()
 	ar.Path("/admin/api-stats").HandlerFunc(h.auth(h.statsHandler())).Methods(http.MethodGet)
 	ar.Path("/admin/jails").HandlerFunc(h.auth(h.jailsRunningHandler())).Methods(http.MethodGet)
 	ar.Path("/admin/jail/{id}").HandlerFunc(h.auth(h.jailDetailsHandler())).Methods(http.MethodGet)
-	ar.Path("/admin/jail/{id}").HandlerFunc(h.auth(h.killJailHandler())).Methods(http.MethodDelete)
-	ar.Path("/admin/jails").HandlerFunc(h.auth(h.killAllJailsHandler())).Methods(http.MethodDelete)
+	ar.Path("/admin/jail/{id}").HandlerFunc(h.audited("jail.kill", h.auth(h.killJailHandler()))).Methods(http.MethodDelete)
+	ar.Path("/admin/jails").HandlerFunc(h.audited("jails.kill", h.auth(h.killAllJailsHandler()))).Methods(http.MethodDelete)
 	ar.Path("/admin/network/ips").HandlerFunc(h.auth(h.networkHandler())).Methods(http.MethodGet)
 	ar.Path("/admin/network/ips").HandlerFunc(h.auth(h.networkHandler())).Queries("state", "{state}").Methods(http.MethodGet)
-	ar.Path("/admin/network/ip").HandlerFunc(h.auth(h.updateIPStateHandler())).Methods(http.MethodPut)
+	ar.Path("/admin/network/ip").HandlerFunc(h.audited("network.ip.update", h.auth(h.updateIPStateHandler()))).Methods(http.MethodPut)
+	ar.Path("/admin/cache").HandlerFunc(h.auth(h.cacheHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/cache").HandlerFunc(h.audited("cache.purge", h.auth(h.purgeCacheHandler()))).Methods(http.MethodDelete)
+	ar.Path("/admin/artifacts").HandlerFunc(h.auth(h.artifactsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/artifacts/push").HandlerFunc(h.audited("artifacts.push", h.auth(h.pushArtifactHandler()))).Methods(http.MethodPost)
+	ar.Path("/admin/artifacts/pull").HandlerFunc(h.audited("artifacts.pull", h.auth(h.pullArtifactHandler()))).Methods(http.MethodPost)
+	ar.Path("/cluster/artifacts").HandlerFunc(h.clusterAuth(h.lookupArtifactHandler())).Queries("key", "{key}").Methods(http.MethodGet)
+	ar.Path("/cluster/artifacts").HandlerFunc(h.clusterAuth(h.putArtifactHandler())).Methods(http.MethodPut)
+	ar.Path("/cluster/artifacts/{digest}").HandlerFunc(h.clusterAuth(h.getArtifactHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/schedules").HandlerFunc(h.auth(h.schedulesHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/schedule/{name}/runs").HandlerFunc(h.auth(h.scheduleRunsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/queues").HandlerFunc(h.auth(h.queuesHandler())).Methods(http.MethodGet)
+	ar.Path("/workflows").HandlerFunc(h.auth(h.workflowsHandler())).Methods(http.MethodGet)
+	ar.Path("/workflows").HandlerFunc(h.audited("workflow.start", h.auth(h.startWorkflowHandler()))).Methods(http.MethodPost)
+	ar.Path("/workflow/{id}").HandlerFunc(h.auth(h.workflowHandler())).Methods(http.MethodGet)
+	ar.Path("/workflow/{id}").HandlerFunc(h.audited("workflow.cancel", h.auth(h.cancelWorkflowHandler()))).Methods(http.MethodDelete)
+	ar.Path("/admin/functions").HandlerFunc(h.auth(h.functionsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/function/{name}").HandlerFunc(h.auth(h.functionDetailsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/function/{name}").HandlerFunc(h.audited("function.register", h.auth(h.registerFunctionHandler()))).Methods(http.MethodPut)
+	ar.Path("/admin/function/{name}").HandlerFunc(h.audited("function.remove", h.auth(h.removeFunctionHandler()))).Methods(http.MethodDelete)
+	ar.Path("/admin/toolchains").HandlerFunc(h.auth(h.toolchainsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/toolchain/{version}").HandlerFunc(h.audited("toolchain.install", h.auth(h.installToolchainHandler()))).Methods(http.MethodPut)
+	ar.Path("/admin/toolchain/{version}").HandlerFunc(h.audited("toolchain.remove", h.auth(h.removeToolchainHandler()))).Methods(http.MethodDelete)
+	ar.Path("/admin/secrets").HandlerFunc(h.auth(h.secretsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/secret/{name}").HandlerFunc(h.audited("secret.set", h.auth(h.setSecretHandler()))).Methods(http.MethodPut)
+	ar.Path("/admin/secret/{name}").HandlerFunc(h.audited("secret.delete", h.auth(h.deleteSecretHandler()))).Methods(http.MethodDelete)
+	ar.Path("/admin/releases").HandlerFunc(h.auth(h.releasesHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/releases/prune").HandlerFunc(h.audited("releases.prune", h.auth(h.pruneReleasesHandler()))).Methods(http.MethodPost)
+	ar.Path("/admin/release/{release}").HandlerFunc(h.auth(h.upgradeStateHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/release/{release}").HandlerFunc(h.audited("release.upgrade", h.auth(h.upgradeReleaseHandler()))).Methods(http.MethodPut)
+	ar.Path("/admin/release/{release}/activate").HandlerFunc(h.audited("release.activate", h.auth(h.activateReleaseHandler()))).Methods(http.MethodPut)
+	ar.Path("/admin/patches").HandlerFunc(h.auth(h.patchesHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/patches").HandlerFunc(h.audited("patch", h.auth(h.patchHandler()))).Methods(http.MethodPost)
+	ar.Path("/admin/init").HandlerFunc(h.auth(h.initStateHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/invocations").HandlerFunc(h.auth(h.invocationsHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/audit").HandlerFunc(h.auth(h.auditHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/node").HandlerFunc(h.auth(h.nodeHandler())).Methods(http.MethodGet)
+	ar.Path("/admin/config/reload").HandlerFunc(h.audited("config.reload", h.auth(h.reloadConfigHandler()))).Methods(http.MethodPost)
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
 	return router, nil
 }
@@ -90,12 +251,33 @@ func (h *handler) healthcheckHandler() http.HandlerFunc {
 	}
 }
 
+// livenessHandler reports whether the service is up
+func (h *handler) livenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, h.healthsvc.Live())
+	}
+}
+
+// readinessHandler reports whether the service can run functions,
+// with the result of each component check. A failed critical check
+// is answered with a 503
+func (h *handler) readinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.healthsvc.Ready()
+		if !report.Ready() {
+			h.ren.JSON(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, report)
+	}
+}
+
 // auth checks to see if the configured header and token are provided
 // in the request
 func (h *handler) auth(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var hdr string
-		if hdr = r.Header.Get(h.conf.AdminTokenHeader); hdr != h.conf.AdminAPIToken {
+		header, token := h.conf.AdminAuth()
+		if r.Header.Get(header) != token {
 			h.logger.Log("error", "unauthorized request received")
 			h.ren.JSON(w, http.StatusForbidden, map[string]string{"error": http.StatusText(http.StatusForbidden)})
 			return
@@ -1,15 +1,26 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/metrics"
 	"github.com/briandowns/sky-island/mocks"
+	"github.com/briandowns/sky-island/queue"
+	"github.com/briandowns/sky-island/scheduler"
+	"github.com/briandowns/sky-island/secrets"
+	"github.com/briandowns/sky-island/webhooks"
+	"github.com/briandowns/sky-island/workflow"
 	gklog "github.com/go-kit/kit/log"
 	"github.com/thoas/stats"
 	"github.com/unrolled/render"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // mocks for testing
@@ -42,6 +53,56 @@ var testHandler = &handler{
 	fssvc:      mockFSSvc,
 }
 
+// newTestHandler creates a handler with the given functions registered
+// and the given repo servicer, nil for one expecting no calls. The db
+// secret is granted to the billing function and the hook secret to
+// the deploy function. The scheduler, queue manager, whose brokers
+// aren't configured, and workflow engine run functions through the
+// pipeline but the scheduler isn't started
+func newTestHandler(t *testing.T, rsvc *mocks.RepoServicer, fns ...*functions.Function) *handler {
+	registry, err := functions.NewRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range fns {
+		if err := registry.Set(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := secrets.NewStore("", bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, fn := range map[string]string{"db": "billing", "hook": "deploy"} {
+		if _, err := store.Set(name, []byte("s3cr3t"), []string{fn}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rsvc == nil {
+		rsvc = &mocks.RepoServicer{}
+	}
+	h := &handler{
+		conf:     testConf,
+		logger:   gklog.NewNopLogger(),
+		ren:      render.New(),
+		metrics:  metrics.Discard(),
+		tracer:   noop.NewTracerProvider().Tracer("test"),
+		rsvc:     rsvc,
+		registry: registry,
+		secrets:  store,
+		nonces:   webhooks.NewNonces(),
+	}
+	if h.scheduler, err = scheduler.NewScheduler("", h.logger, nil, h.runScheduled); err != nil {
+		t.Fatal(err)
+	}
+	h.queues = queue.NewManager(h.logger, h.metrics, nil, h.runQueued)
+	if h.workflows, err = workflow.NewEngine("", h.logger, h.runWorkflowStep); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.workflows.Close)
+	return h
+}
+
 // TestHealthCheckHandler
 func TestHealthCheckHandler(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, "/healthcheck", nil)
@@ -55,3 +116,26 @@ func TestHealthCheckHandler(t *testing.T) {
 		t.Errorf("wrong status code: got %v want %v", status, http.StatusOK)
 	}
 }
+
+// TestReadinessHandler verifies a failed critical check is answered
+// with a 503 and the check breakdown
+func TestReadinessHandler(t *testing.T) {
+	healthsvc := &mocks.HealthServicer{}
+	healthsvc.On("Ready").Return(&jail.HealthReport{
+		Status: jail.HealthFail,
+		Checks: []*jail.HealthCheck{{Name: "ip_pool", Status: jail.HealthFail, Message: "0 free, want at least 1", Critical: true}},
+	})
+	h := &handler{ren: render.New(), healthsvc: healthsvc}
+	rr := httptest.NewRecorder()
+	h.readinessHandler()(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	var report jail.HealthReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Checks) != 1 || report.Checks[0].Name != "ip_pool" {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/briandowns/sky-island/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// triggerWebhook is the caller recorded for webhook invocations
+const triggerWebhook = "webhook"
+
+// maxHookBody is the largest webhook delivery accepted
+const maxHookBody = 10 << 20
+
+// hookHandler handles webhook deliveries to registered functions. The
+// verified payload is given to the function as its input. A push to
+// the function's own repo clones the repo again and rebuilds the
+// function's binary first
+func (h *handler) hookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.metrics.Inc("handlers.requests", "handler", "function.hook")
+		name := mux.Vars(r)["name"]
+		fn, ok := h.registry.Get(name)
+		if !ok || fn.Webhook == nil {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "webhook " + name + " not found"})
+			return
+		}
+		v, err := fn.Webhook.Verifier()
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		secret, err := h.secret(fn.Webhook.Secret, fn.Name)
+		if err != nil {
+			h.logger.Log("error", "webhook "+name+": "+err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBody))
+		if err != nil {
+			h.ren.JSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		if err := v.Verify(secret, r.Header, body, now); err != nil {
+			h.metrics.Inc("webhooks.rejected", "function", fn.Name)
+			h.ren.JSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		if nonce, expires := v.Nonce(r.Header); nonce != "" {
+			if err := h.nonces.Add(fn.Name+"/"+nonce, now, expires); err != nil {
+				h.metrics.Inc("webhooks.rejected", "function", fn.Name)
+				h.ren.JSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		repo, pushed := webhooks.PushedRepo(r.Header, body)
+		refresh := pushed && strings.EqualFold(repo, fn.URL)
+		if refresh {
+			h.logger.Log("msg", "push to "+repo+", rebuilding "+fn.Name)
+		}
+		out, err := h.invoke(r.Context(), triggerWebhook, fn, rawInput(body), refresh)
+		if err != nil {
+			if ie, ok := err.(*invokeError); ok {
+				h.ren.JSON(w, ie.Code, map[string]string{"error": ie.Message})
+				return
+			}
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, functionRunResponse{Timestamp: time.Now().UTC().Unix(), Data: string(out)})
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/mocks"
+	"github.com/briandowns/sky-island/webhooks"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+// testDeploy returns the deploy function accepting deliveries with a
+// timestamp and nonce, signed with the hook secret
+func testDeploy() *functions.Function {
+	return &functions.Function{
+		Name: "deploy",
+		URL:  "github.com/example/deploy",
+		Call: "Run()",
+		Webhook: &functions.Webhook{
+			Secret:          "hook",
+			TimestampHeader: "X-Timestamp",
+			NonceHeader:     "X-Delivery",
+		},
+	}
+}
+
+// deliver sends the given payload to the deploy hook, signed with the
+// given secret
+func deliver(h *handler, secret, event, delivery string, payload []byte) *httptest.ResponseRecorder {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	req := httptest.NewRequest(http.MethodPost, "/hooks/deploy", bytes.NewReader(payload))
+	req.Header.Set(webhooks.DefaultHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Delivery", delivery)
+	req = mux.SetURLVars(req, map[string]string{"name": "deploy"})
+	rr := httptest.NewRecorder()
+	h.hookHandler()(rr, req)
+	return rr
+}
+
+// TestHookHandler_Rejected verifies deliveries to functions without a
+// webhook, with a bad signature or replayed are refused
+func TestHookHandler_Rejected(t *testing.T) {
+	rsvc := &mocks.RepoServicer{}
+	rsvc.On("CloneRepo", mock.Anything, mock.Anything, "github.com/example/deploy").Return(errors.New("offline"))
+	h := newTestHandler(t, rsvc, testDeploy())
+
+	req := httptest.NewRequest(http.MethodPost, "/hooks/missing", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "missing"})
+	rr := httptest.NewRecorder()
+	h.hookHandler()(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := deliver(h, "guess", "ping", "1", []byte(`{}`)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	// the clone fails so the verified delivery reaches the pipeline
+	if rr := deliver(h, "s3cr3t", "ping", "2", []byte(`{}`)); rr.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+	if rr := deliver(h, "s3cr3t", "ping", "2", []byte(`{}`)); rr.Code != http.StatusConflict {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+// TestHookHandler_Push verifies a push to the function's repo clones
+// it again
+func TestHookHandler_Push(t *testing.T) {
+	rsvc := &mocks.RepoServicer{}
+	rsvc.On("RemoveRepo", "github.com/example/deploy").Return(errors.New("busy"))
+	h := newTestHandler(t, rsvc, testDeploy())
+	rr := deliver(h, "s3cr3t", "push", "3", []byte(`{"repository": {"html_url": "https://github.com/example/deploy"}}`))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+	rsvc.AssertCalled(t, "RemoveRepo", "github.com/example/deploy")
+}
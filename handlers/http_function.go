@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/tracing"
+	"github.com/briandowns/sky-island/utils"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+)
+
+// waitForSocket polls for the given unix socket to be created until
+// the context is done or the function exits
+func waitForSocket(ctx context.Context, path string, exited <-chan struct{}) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !utils.Exists(path) {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return &timeoutError{stage: "execution"}
+			}
+			return ctx.Err()
+		case <-exited:
+			return errors.New("function exited before listening on " + path)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// serve starts the given http function binary in the jail with the given
+// id, proxies the request to it over a unix socket and writes the
+// function's response to w. A function not responding within the
+// settings' timeout, counted from its start, is answered with a 504
+// and its jail removed
+func (h *handler) serve(w http.ResponseWriter, r *http.Request, id, binPath, path string, fn *functions.Function, s *functions.Settings, env []string) error {
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	funcExecArgs, err := h.jailArgs(ctx, id, binPath, s.IP4, s.Timeout)
+	if err != nil {
+		return err
+	}
+	jailSock := "/tmp/" + id + ".sock"
+	hostSock := filepath.Join(h.conf.Jails.BaseJailDir, id, jailSock)
+	funcExecArgs = append(funcExecArgs, "command=/tmp/"+id, jailSock)
+
+	cmd := exec.Command("jail", funcExecArgs...)
+	cmd.Env = env
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer func() {
+		if res, err := exec.Command("jail", "-r", id).CombinedOutput(); err != nil {
+			h.logger.Log("error", string(res))
+		}
+		<-exited
+	}()
+
+	if err := waitForSocket(ctx, hostSock, exited); err != nil {
+		return err
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", hostSock)
+		},
+	}
+	defer transport.CloseIdleConnections()
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = fn.Name
+			req.URL.Path = path
+			tracing.InjectHeader(req.Context(), req.Header)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if ctx.Err() == context.DeadlineExceeded {
+				h.timedOut(w, &timeoutError{stage: "execution"})
+				return
+			}
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusBadGateway, map[string]string{"error": http.StatusText(http.StatusBadGateway)})
+		},
+	}
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+	return nil
+}
+
+// httpFunctionHandler handles requests to registered http functions. The
+// request is passed to the function with the /fn/{name} prefix removed
+func (h *handler) httpFunctionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.metrics.Inc("handlers.requests", "handler", "function.http")
+		name := mux.Vars(r)["name"]
+		fn, ok := h.registry.Get(name)
+		if !ok || fn.Kind != functions.KindHTTP {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "http function " + name + " not found"})
+			return
+		}
+		id := uuid.NewUUID().String()
+		inv := &audit.Invocation{
+			ID:        id,
+			Caller:    callerOf(r),
+			Function:  fn.Name,
+			URL:       fn.URL,
+			Jail:      id,
+			StartedAt: time.Now().UTC(),
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		defer h.recordInvocation(inv, sw)
+
+		timeout, err := parseTimeout(fn.Timeout)
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/fn/"+name)
+		if path == "" {
+			path = "/"
+		}
+		fr := &pipelineRun{
+			function: fn.Name,
+			url:      fn.URL,
+			kind:     fn.Kind,
+			requested: &functions.Settings{
+				Call:      fn.Call,
+				GoVersion: fn.GoVersion,
+				IP4:       fn.IP4,
+				Env:       fn.Env,
+				Timeout:   timeout,
+			},
+			secrets:       fn.Secrets,
+			release:       fn.Release,
+			packages:      fn.Packages,
+			checkCaller:   true,
+			invalidStatus: http.StatusInternalServerError,
+		}
+		h.runPipeline(w, r, inv, fr, func(ctx context.Context, binPath string, s *functions.Settings, env []string) error {
+			return h.serve(w, r.WithContext(ctx), id, binPath, path, fn, s, env)
+		})
+		inv.OutputSize = sw.size
+	}
+}
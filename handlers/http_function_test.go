@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/mocks"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestHTTPFunctionHandler_NotFound verifies that requests for
+// functions that aren't registered, or aren't http functions,
+// receive a 404
+func TestHTTPFunctionHandler_NotFound(t *testing.T) {
+	h := newTestHandler(t, nil, &functions.Function{Name: "geohash", URL: "github.com/mmcloughlin/geohash", Call: "Encode(100.1, 80.9)"})
+	for _, name := range []string{"missing", "geohash"} {
+		req, err := http.NewRequest(http.MethodGet, "/fn/"+name+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.httpFunctionHandler()).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("wrong status code for %s: got %v want %v", name, status, http.StatusNotFound)
+		}
+	}
+}
+
+// TestWaitForSocket_Timeout verifies a timeout is returned when the
+// function never starts listening
+func TestWaitForSocket_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := waitForSocket(ctx, "/tmp/sky-island-missing.sock", nil)
+	if _, ok := err.(*timeoutError); !ok {
+		t.Errorf("expected timeout got %v", err)
+	}
+}
+
+// TestWaitForSocket_Exited verifies waiting stops when the function
+// exits without listening
+func TestWaitForSocket_Exited(t *testing.T) {
+	exited := make(chan struct{})
+	close(exited)
+	err := waitForSocket(context.Background(), "/tmp/sky-island-missing.sock", exited)
+	if err == nil || err.Error() != "function exited before listening on /tmp/sky-island-missing.sock" {
+		t.Errorf("expected exit error got %v", err)
+	}
+}
+
+// TestHTTPFunctionHandler_Pipeline verifies http functions run through
+// the invoke pipeline, resolving the secrets granted to them
+func TestHTTPFunctionHandler_Pipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := &functions.Function{Name: "billing", URL: "github.com/example/billing", Kind: functions.KindHTTP, Call: "Serve", Secrets: []string{"db"}}
+	if err := os.MkdirAll(dir+buildJailSrcDirPath+fn.URL, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rsvc := &mocks.RepoServicer{}
+	rsvc.On("RepoCommit", fn.URL).Return("abc123", nil)
+	jsvc := &mocks.JailServicer{}
+	jsvc.On("CreateFunctionJail", mock.Anything, mock.Anything).Return(nil)
+	jsvc.On("MountSecrets", mock.Anything, map[string][]byte{"db": []byte("s3cr3t")}).Return(errors.New("no tmpfs"))
+	jsvc.On("RemoveJail", mock.Anything).Return(nil)
+	h := newTestHandler(t, rsvc, fn)
+	h.jsvc = jsvc
+	h.conf = &config.Config{Jails: &config.Jails{BaseJailDir: dir}}
+
+	req := httptest.NewRequest(http.MethodGet, "/fn/billing/", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "billing"})
+	rr := httptest.NewRecorder()
+	h.httpFunctionHandler()(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+	jsvc.AssertCalled(t, "MountSecrets", mock.Anything, map[string][]byte{"db": []byte("s3cr3t")})
+	jsvc.AssertCalled(t, "RemoveJail", mock.Anything)
+}
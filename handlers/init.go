@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/briandowns/sky-island/jail"
+)
+
+// initStateHandler handles requests for the recorded state and
+// progress of system initialization
+func (h *handler) initStateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := jail.ReadInitState(h.conf)
+		if err != nil {
+			if os.IsNotExist(err) {
+				h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "system not initialized"})
+				return
+			}
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"init": state})
+	}
+}
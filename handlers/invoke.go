@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/briandowns/sky-island/functions"
+)
+
+// trigger is what made an invocation that came from sky-island itself,
+// such as a schedule, rather than an HTTP caller
+type trigger struct {
+	name     string
+	function string
+}
+
+// triggerKey is the context key of the trigger of an invocation
+type triggerKey struct{}
+
+// triggerOf returns the trigger of the given request, nil for
+// requests from HTTP callers
+func triggerOf(r *http.Request) *trigger {
+	t, _ := r.Context().Value(triggerKey{}).(*trigger)
+	return t
+}
+
+// invokeError is returned when an invocation made in process fails
+type invokeError struct {
+	Code    int
+	Message string
+}
+
+// Error implements the error interface
+func (i *invokeError) Error() string {
+	return fmt.Sprintf("%d: %s", i.Code, i.Message)
+}
+
+// rawInput returns the given payload as function input. Payloads that
+// aren't JSON, such as form encoded ones, are given as they are
+func rawInput(b []byte) json.RawMessage {
+	if len(b) > 0 && !json.Valid(b) {
+		s, _ := json.Marshal(string(b))
+		return s
+	}
+	return b
+}
+
+// invoke runs the given registered function with the given input
+// through the same pipeline as the function run endpoint, returning
+// its output. The invocation is recorded with the trigger as its
+// caller and isn't subject to the callers allowed by the manifest.
+// Cache busting clones the repo again and rebuilds the binary
+func (h *handler) invoke(ctx context.Context, by string, fn *functions.Function, input json.RawMessage, cacheBust bool) ([]byte, error) {
+	b, err := json.Marshal(&functionRunRequest{
+		URL:       fn.URL,
+		Call:      fn.Call,
+		IP4:       fn.IP4,
+		Input:     input,
+		Env:       fn.Env,
+		Secrets:   fn.Secrets,
+		GoVersion: fn.GoVersion,
+		Release:   fn.Release,
+		Packages:  fn.Packages,
+		Timeout:   fn.Timeout,
+		CacheBust: cacheBust,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, apiPrefix+"/function", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(context.WithValue(ctx, triggerKey{}, &trigger{name: by, function: fn.Name}))
+	r.RemoteAddr = by
+	rec := httptest.NewRecorder()
+	sw := &statusWriter{ResponseWriter: rec}
+	h.functionRunHandler()(sw, r)
+	if sw.code() != http.StatusOK {
+		return nil, &invokeError{Code: sw.code(), Message: sw.errorMessage()}
+	}
+	var res functionRunResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		return nil, err
+	}
+	return []byte(res.Data), nil
+}
@@ -19,7 +19,7 @@ func (h *handler) jailsRunningHandler() http.HandlerFunc {
 			return
 		}
 		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"jails": jls})
-		h.metrics.Histogram("handlers.jail.running", 1)
+		h.metrics.Inc("handlers.requests", "handler", "jail.running")
 	}
 }
 
@@ -44,7 +44,7 @@ func (h *handler) jailDetailsHandler() http.HandlerFunc {
 			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
 		}
 		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"details": jail})
-		h.metrics.Histogram("handlers.jail.details", 1)
+		h.metrics.Inc("handlers.requests", "handler", "jail.details")
 	}
 }
 
@@ -61,7 +61,7 @@ func (h *handler) killJailHandler() http.HandlerFunc {
 			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
 		}
 		h.ren.JSON(w, http.StatusOK, map[string]int{"deleted": jid})
-		h.metrics.Histogram("handlers.jail.kill", 1)
+		h.metrics.Inc("handlers.requests", "handler", "jail.kill")
 	}
 }
 
@@ -80,6 +80,6 @@ func (h *handler) killAllJailsHandler() http.HandlerFunc {
 			}
 		}
 		w.WriteHeader(http.StatusOK)
-		h.metrics.Histogram("handlers.jail.killall", 1)
+		h.metrics.Inc("handlers.requests", "handler", "jail.killall")
 	}
 }
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Invocation phases, timed in the invocation.phase histogram and
+// traced in spans of the same name
+const (
+	phaseClone      = "clone"
+	phaseBuild      = "build"
+	phaseJailCreate = "jail_create"
+	phaseExec       = "exec"
+	phaseTeardown   = "teardown"
+)
+
+// adhocFunction labels invocations of functions that aren't
+// registered so repo URLs never become label values
+const adhocFunction = "adhoc"
+
+// functionLabel returns the function label of the given invocation
+func functionLabel(inv *audit.Invocation) string {
+	if inv.Function == "" {
+		return adhocFunction
+	}
+	return inv.Function
+}
+
+// phase is one phase of an invocation being timed and traced
+type phase struct {
+	name    string
+	inv     *audit.Invocation
+	t       metrics.Timing
+	span    trace.Span
+	untimed bool
+}
+
+// startPhase starts the given phase of the given invocation in a
+// span that's a child of the given context
+func (h *handler) startPhase(ctx context.Context, name string, inv *audit.Invocation) (context.Context, *phase) {
+	ctx, span := h.tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("invocation.id", inv.ID),
+		attribute.String("function", functionLabel(inv)),
+	))
+	return ctx, &phase{name: name, inv: inv, t: h.metrics.NewTiming(), span: span}
+}
+
+// cached marks the phase as served from the cache. Its time isn't
+// recorded since nothing was done
+func (p *phase) cached() {
+	p.span.SetAttributes(attribute.Bool("cache.hit", true))
+	p.untimed = true
+}
+
+// end ends the phase, recording the given error if any
+func (p *phase) end(err error) {
+	tracing.End(p.span, err)
+	if !p.untimed {
+		p.t.Send("invocation.phase", "phase", p.name, "function", functionLabel(p.inv))
+	}
+}
+
+// teardown removes the jail of the given invocation
+func (h *handler) teardown(ctx context.Context, inv *audit.Invocation) {
+	_, p := h.startPhase(ctx, phaseTeardown, inv)
+	p.end(h.jsvc.RemoveJail(inv.Jail))
+}
+
+// observeInvocation counts the given finished invocation and records
+// its duration
+func (h *handler) observeInvocation(inv *audit.Invocation) {
+	fn := functionLabel(inv)
+	h.metrics.Inc("invocations", "function", fn, "status", inv.Status)
+	h.metrics.ObserveDuration("invocation.duration", inv.FinishedAt.Sub(inv.StartedAt), "function", fn)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/mocks"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestPhases verifies invocation phases are traced as children of
+// the request span, recording errors and cache hits
+func TestPhases(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)).Tracer("test")
+	jsvc := &mocks.JailServicer{}
+	jsvc.On("RemoveJail", "1234").Return(errors.New("dataset busy"))
+	h := &handler{metrics: metrics.Discard(), tracer: tracer, jsvc: jsvc}
+	inv := &audit.Invocation{ID: "1234", Jail: "1234"}
+
+	ctx, root := tracer.Start(context.Background(), "function.run")
+	_, p := h.startPhase(ctx, phaseBuild, inv)
+	p.cached()
+	p.end(nil)
+	h.teardown(ctx, inv)
+	root.End()
+
+	spans := exp.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans got %d", len(spans))
+	}
+	build, teardown := spans[0], spans[1]
+	for _, s := range []tracetest.SpanStub{build, teardown} {
+		if s.Parent.SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of the request span", s.Name)
+		}
+	}
+	if build.Name != phaseBuild || len(build.Attributes) != 3 {
+		t.Errorf("unexpected build span %s %v", build.Name, build.Attributes)
+	}
+	if teardown.Name != phaseTeardown || teardown.Status.Code != codes.Error {
+		t.Errorf("expected failed teardown span got %s %v", teardown.Name, teardown.Status)
+	}
+}
+
+// TestFunctionLabel verifies unregistered functions share one label
+func TestFunctionLabel(t *testing.T) {
+	if l := functionLabel(&audit.Invocation{URL: "github.com/a/b"}); l != adhocFunction {
+		t.Errorf("expected %s got %s", adhocFunction, l)
+	}
+	if l := functionLabel(&audit.Invocation{Function: "geohash"}); l != "geohash" {
+		t.Errorf("expected geohash got %s", l)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/briandowns/sky-island/cluster"
+	"github.com/briandowns/sky-island/jail"
+)
+
+// nodeStatus returns the capacity of this node as heartbeated to the
+// cluster coordinator
+func (h *handler) nodeStatus() (*cluster.NodeStatus, error) {
+	s := &cluster.NodeStatus{}
+	for _, owner := range h.networksvc.Pool() {
+		if owner == nil {
+			s.FreeIPs++
+		}
+	}
+	jls, err := jail.JLSRun(h.wrapper)
+	if err != nil {
+		return nil, err
+	}
+	s.RunningJails = len(jls)
+	if s.Load, err = jail.LoadAverage(h.wrapper); err != nil {
+		return nil, err
+	}
+	for k := range h.binCache.Entries() {
+		s.Cached = append(s.Cached, k)
+	}
+	return s, nil
+}
+
+// nodeHandler handles requests for the capacity of this node
+func (h *handler) nodeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := h.nodeStatus()
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, s)
+	}
+}
+
+// routed restores the caller of invocations routed by the cluster
+// coordinator so callers allowed by manifests and the audit log see
+// the original caller rather than the coordinator
+func (h *handler) routed(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller := r.Header.Get(cluster.CallerHeader)
+		if caller != "" && h.conf.Cluster != nil && h.conf.Cluster.Token != "" && r.Header.Get(cluster.TokenHeader) == h.conf.Cluster.Token {
+			r.RemoteAddr = net.JoinHostPort(caller, "0")
+		}
+		fn(w, r)
+	}
+}
+
+// clusterAuth checks the cluster token is provided, for the requests
+// nodes of a cluster make of each other
+func (h *handler) clusterAuth(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.conf.Cluster == nil || h.conf.Cluster.Token == "" || r.Header.Get(cluster.TokenHeader) != h.conf.Cluster.Token {
+			h.logger.Log("error", "unauthorized cluster request received")
+			h.ren.JSON(w, http.StatusForbidden, map[string]string{"error": http.StatusText(http.StatusForbidden)})
+			return
+		}
+		fn(w, r)
+	}
+}
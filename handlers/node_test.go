@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/briandowns/sky-island/cluster"
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/mocks"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/unrolled/render"
+)
+
+// testWorker returns a worker node handler whose host is faked by the
+// given jls output and load average
+func testWorker(coordinator, id, jls, load string, pool map[string][]byte) *handler {
+	w := &mocks.Wrapper{}
+	w.On("CombinedOutput", "jls", []string{"-s"}).Return([]byte(jls), nil)
+	w.On("CombinedOutput", "sysctl", []string{"-n", "vm.loadavg"}).Return([]byte(load), nil)
+	n := &mocks.NetworkServicer{}
+	n.On("Pool").Return(pool)
+	conf := &config.Config{
+		Cluster: &config.Cluster{
+			Role:         config.RoleWorker,
+			NodeID:       id,
+			Coordinator:  coordinator,
+			AdvertiseURL: "http://" + id,
+			Token:        "cluster",
+		},
+	}
+	conf.SetDefaults()
+	return &handler{
+		conf:       conf,
+		logger:     gklog.NewNopLogger(),
+		ren:        render.New(),
+		wrapper:    w,
+		networksvc: n,
+		binCache:   jail.NewBinaryCache(),
+	}
+}
+
+// TestNodeStatus_Heartbeat verifies the capacity of in-process
+// workers reaches the coordinator and steers routing
+func TestNodeStatus_Heartbeat(t *testing.T) {
+	conf := &config.Config{
+		AdminTokenHeader: "X-Token",
+		AdminAPIToken:    "admin",
+		Cluster:          &config.Cluster{Role: config.RoleCoordinator, Token: "cluster"},
+	}
+	conf.SetDefaults()
+	coord := cluster.NewCoordinator(conf, gklog.NewNopLogger(), metrics.Discard())
+	ts := httptest.NewServer(coord.Router())
+	defer ts.Close()
+
+	idle := testWorker(ts.URL, "idle", "", "{ 0.10 0.20 0.30 }\n", map[string][]byte{"10.0.0.2": nil, "10.0.0.3": nil})
+	warm := testWorker(ts.URL, "warm", "jid=1 name=fn1\njid=2 name=fn2\n", "{ 1.50 1.00 0.50 }\n", map[string][]byte{"10.0.0.2": []byte("1"), "10.0.0.3": nil})
+	warm.binCache.Set("github.com/briandowns/smile.Greet().1.9.2", "/tmp/smile")
+	for _, h := range []*handler{idle, warm} {
+		if err := cluster.NewHeartbeater(h.conf, h.logger, h.nodeStatus).Send(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/cluster/nodes", nil)
+	req.Header.Set("X-Token", "admin")
+	w := httptest.NewRecorder()
+	coord.Router().ServeHTTP(w, req)
+	var res struct {
+		Nodes []*cluster.Node `json:"nodes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes got %d", len(res.Nodes))
+	}
+	if n := res.Nodes[0]; n.ID != "idle" || n.FreeIPs != 2 || n.RunningJails != 0 || n.Load != 0.1 || !n.Alive {
+		t.Errorf("unexpected idle node %+v", n)
+	}
+	if n := res.Nodes[1]; n.ID != "warm" || n.FreeIPs != 1 || n.RunningJails != 2 || n.Load != 1.5 || len(n.Cached) != 1 {
+		t.Errorf("unexpected warm node %+v", n)
+	}
+	if ids := coord.Registry().Candidates("github.com/briandowns/smile", "Greet()"); len(ids) != 2 || ids[0] != "warm" {
+		t.Errorf("expected warm node first got %v", ids)
+	}
+	if ids := coord.Registry().Candidates("github.com/briandowns/other", "Run()"); len(ids) != 2 || ids[0] != "idle" {
+		t.Errorf("expected idle node first got %v", ids)
+	}
+}
+
+// TestRouted verifies the caller is only restored for requests
+// carrying the cluster token
+func TestRouted(t *testing.T) {
+	h := testWorker("http://coordinator", "worker", "", "", nil)
+	var caller string
+	fn := h.routed(func(w http.ResponseWriter, r *http.Request) { caller = r.RemoteAddr })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/function", nil)
+	req.Header.Set(cluster.CallerHeader, "10.1.1.1")
+	fn(httptest.NewRecorder(), req)
+	if caller != req.RemoteAddr {
+		t.Errorf("expected caller untouched without token got %s", caller)
+	}
+	req.Header.Set(cluster.TokenHeader, "cluster")
+	fn(httptest.NewRecorder(), req)
+	if caller != "10.1.1.1:0" {
+		t.Errorf("expected routed caller got %s", caller)
+	}
+}
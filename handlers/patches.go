@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// patchesHandler handles requests for the patch history
+func (h *handler) patchesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runs, err := h.patchsvc.History()
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{
+			"patching": h.patchsvc.Patching(),
+			"patches":  runs,
+		})
+	}
+}
+
+// patchHandler handles requests to patch the active release now.
+// The patch runs in the background
+func (h *handler) patchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.patchsvc.Patching() {
+			h.ren.JSON(w, http.StatusConflict, map[string]string{"error": "patch already in progress"})
+			return
+		}
+		go func() {
+			if _, err := h.patchsvc.Patch(); err != nil {
+				h.logger.Log("error", err.Error())
+			}
+		}()
+		h.ren.JSON(w, http.StatusAccepted, map[string]bool{"patching": true})
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/briandowns/sky-island/audit"
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/jail"
+	"github.com/briandowns/sky-island/tracing"
+)
+
+// pipelineRun describes a function to be run by the invoke pipeline
+type pipelineRun struct {
+	// function is the name of the registered function, empty for
+	// ad-hoc calls. Secrets are only granted to registered functions
+	function  string
+	url       string
+	kind      string
+	requested *functions.Settings
+	secrets   []string
+	release   string
+	packages  []string
+	cacheBust bool
+
+	// checkCaller applies the callers allowed by the manifest
+	checkCaller bool
+
+	// invalidStatus answers a manifest, env or secret the function
+	// can't be run with, a 400 when the caller supplied them
+	invalidStatus int
+}
+
+// execFunc runs the built binary at binPath in the jail of the given
+// invocation with the given settings and env, writing its response
+type execFunc func(ctx context.Context, binPath string, s *functions.Settings, env []string) error
+
+// runPipeline runs the given function in a new jail: it clones the
+// repo, applies the manifest to the requested settings, resolves env
+// and secrets, creates the jail, mounts the secrets and builds the
+// binary before handing it to exec. Each phase is timed and traced
+// on the invocation and every failure is written to w. The jail is
+// removed when exec returns
+func (h *handler) runPipeline(w http.ResponseWriter, r *http.Request, inv *audit.Invocation, fr *pipelineRun, exec execFunc) {
+	buildCtx, cancel := context.WithTimeout(r.Context(), h.buildTimeout())
+	defer cancel()
+	cloneCtx, p := h.startPhase(buildCtx, phaseClone, inv)
+	err := h.ensureRepo(cloneCtx, fr.url, fr.cacheBust)
+	p.end(err)
+	if err != nil {
+		if h.timedOut(w, err) {
+			return
+		}
+		h.logger.Log("error", err.Error())
+		h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+		return
+	}
+	inv.Commit, _ = h.rsvc.RepoCommit(fr.url)
+	m, settings, err := h.manifestSettings(fr.url, fr.requested)
+	if err != nil {
+		h.invalid(w, fr, err)
+		return
+	}
+	if fr.checkCaller && !m.AllowsCaller(r.RemoteAddr) {
+		h.ren.JSON(w, http.StatusForbidden, map[string]string{"error": "caller not allowed by " + functions.ManifestFile})
+		return
+	}
+	// ad-hoc callers pick the repo and with it the manifest, so the
+	// env it merges in is checked as the caller's own
+	if fr.function == "" {
+		if err := h.checkEnv(settings.Env); err != nil {
+			h.invalid(w, fr, err)
+			return
+		}
+	}
+	env, err := h.buildEnv(fr.function, settings.Env)
+	if err != nil {
+		h.invalid(w, fr, err)
+		return
+	}
+	files, err := h.secretFiles(fr.function, fr.secrets)
+	if err != nil {
+		h.invalid(w, fr, err)
+		return
+	}
+
+	jailSpec := &jail.JailSpec{
+		Release:  fr.release,
+		Packages: fr.packages,
+		Limits:   jailLimits(settings.Limits),
+	}
+	_, p = h.startPhase(r.Context(), phaseJailCreate, inv)
+	err = h.jsvc.CreateFunctionJail(inv.Jail, jailSpec)
+	p.end(err)
+	if err != nil {
+		h.logger.Log("error", err.Error())
+		h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+		return
+	}
+	defer h.teardown(r.Context(), inv)
+
+	if len(files) > 0 {
+		if err := h.jsvc.MountSecrets(inv.Jail, files); err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		defer h.jsvc.UnmountSecrets(inv.Jail)
+	}
+
+	spec := &buildSpec{
+		URL:       fr.url,
+		Call:      settings.Call,
+		Kind:      fr.kind,
+		GoVersion: settings.GoVersion,
+		Tags:      settings.Tags,
+		LDFlags:   settings.LDFlags,
+		Commit:    inv.Commit,
+	}
+	ctx, p := h.startPhase(buildCtx, phaseBuild, inv)
+	binPath, cached, err := h.binary(ctx, inv.Jail, spec, fr.cacheBust)
+	inv.CacheHit = cached
+	if cached {
+		p.cached()
+	}
+	p.end(err)
+	if err != nil {
+		if h.timedOut(w, err) {
+			return
+		}
+		h.logger.Log("error", err.Error())
+		h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+		return
+	}
+
+	settings.Timeout = h.execTimeout(settings.Timeout)
+	execCtx, p := h.startPhase(r.Context(), phaseExec, inv)
+	err = exec(execCtx, binPath, settings, append(env, tracing.Env(execCtx)...))
+	p.end(err)
+	if err != nil {
+		if h.timedOut(w, err) {
+			return
+		}
+		h.logger.Log("error", err.Error())
+		h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+	}
+}
+
+// invalid writes the given error with the run's invalid status, or a
+// 500 hiding it when the function is misconfigured
+func (h *handler) invalid(w http.ResponseWriter, fr *pipelineRun, err error) {
+	h.logger.Log("error", err.Error())
+	if fr.invalidStatus == http.StatusInternalServerError {
+		h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+		return
+	}
+	h.ren.JSON(w, fr.invalidStatus, map[string]string{"error": err.Error()})
+}
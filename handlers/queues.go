@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/briandowns/sky-island/functions"
+)
+
+// triggerQueue is the caller recorded for queue invocations
+const triggerQueue = "queue"
+
+// runQueued runs the named registered function with a message
+func (h *handler) runQueued(ctx context.Context, name string, input []byte) ([]byte, error) {
+	fn, ok := h.registry.Get(name)
+	if !ok {
+		return nil, errors.New("function " + name + " not found")
+	}
+	return h.invoke(ctx, triggerQueue, fn, rawInput(input), false)
+}
+
+// consume starts, replaces or stops the queue trigger of the given
+// function to match its settings
+func (h *handler) consume(fn *functions.Function) {
+	if h.queues == nil {
+		return
+	}
+	if fn.Queue == nil {
+		h.queues.Remove(fn.Name)
+		return
+	}
+	t, err := fn.Queue.Trigger(fn.Name)
+	if err == nil {
+		err = h.queues.Set(t)
+	}
+	if err != nil {
+		h.logger.Log("error", "queue trigger of "+fn.Name+": "+err.Error())
+	}
+}
+
+// queuesHandler handles requests to list the queue triggers
+func (h *handler) queuesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"triggers": h.queues.Triggers()})
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestRegisterFunction_QueueNotConfigured verifies functions can't be
+// triggered by a broker that isn't configured
+func TestRegisterFunction_QueueNotConfigured(t *testing.T) {
+	h := newTestHandler(t, nil)
+	body := `{"url":"github.com/example/resize","call":"Run()","queue":{"broker":"nats","subject":"images"}}`
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/function/resize", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"name": "resize"})
+	rr := httptest.NewRecorder()
+	h.registerFunctionHandler()(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "queue broker nats not configured") {
+		t.Errorf("expected %d got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+	if _, ok := h.registry.Get("resize"); ok {
+		t.Error("expected function not to be registered")
+	}
+}
+
+// TestRunQueued_NotFound verifies a message for a function that has
+// been removed fails
+func TestRunQueued_NotFound(t *testing.T) {
+	h := newTestHandler(t, nil)
+	if _, err := h.runQueued(context.Background(), "gone", []byte("x")); err == nil {
+		t.Error("expected error but received none")
+	}
+}
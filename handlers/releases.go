@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// releasesHandler handles requests to list the release base jails
+// and their snapshots
+func (h *handler) releasesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		releases, err := h.relsvc.Releases()
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{
+			"active":    h.relsvc.Active(),
+			"upgrading": h.relsvc.Upgrading(),
+			"releases":  releases,
+		})
+	}
+}
+
+// upgradeReleaseHandler handles requests to build the base jail of
+// a new release and activate it. The upgrade runs in the background
+func (h *handler) upgradeReleaseHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release := mux.Vars(r)["release"]
+		if upgrading := h.relsvc.Upgrading(); upgrading != "" {
+			h.ren.JSON(w, http.StatusConflict, map[string]string{"error": "upgrade to " + upgrading + " already in progress"})
+			return
+		}
+		go func() {
+			if err := h.relsvc.Upgrade(release); err != nil {
+				h.logger.Log("error", err.Error(), "release", release)
+			}
+		}()
+		h.ren.JSON(w, http.StatusAccepted, map[string]string{"upgrading": release})
+	}
+}
+
+// upgradeStateHandler handles requests for the state and progress
+// of an upgrade to a release
+func (h *handler) upgradeStateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release := mux.Vars(r)["release"]
+		state, err := h.relsvc.UpgradeState(release)
+		if err != nil {
+			if os.IsNotExist(err) {
+				h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": "no upgrade to " + release})
+				return
+			}
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"upgrade": state})
+	}
+}
+
+// activateReleaseHandler handles requests to switch new jails to
+// an already built release
+func (h *handler) activateReleaseHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release := mux.Vars(r)["release"]
+		if err := h.relsvc.Activate(release); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]string{"active": release})
+	}
+}
+
+// pruneReleasesHandler handles requests to destroy the release
+// snapshots no longer used by any jail or registered function
+func (h *handler) pruneReleasesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var pinned []string
+		for _, fn := range h.registry.List() {
+			if fn.Release != "" {
+				pinned = append(pinned, fn.Release)
+			}
+		}
+		destroyed, err := h.relsvc.Prune(pinned)
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"destroyed": destroyed})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/scheduler"
+	"github.com/gorilla/mux"
+)
+
+// triggerScheduler is the caller recorded for scheduled invocations
+const triggerScheduler = "scheduler"
+
+// runScheduled runs the registered function of the given job
+func (h *handler) runScheduled(ctx context.Context, j *scheduler.Job) error {
+	fn, ok := h.registry.Get(j.Name)
+	if !ok {
+		return errors.New("function " + j.Name + " not found")
+	}
+	_, err := h.invoke(ctx, triggerScheduler, fn, j.Input, false)
+	return err
+}
+
+// schedule adds, replaces or removes the job of the given function
+// to match its schedule
+func (h *handler) schedule(fn *functions.Function) {
+	if h.scheduler == nil {
+		return
+	}
+	if fn.Schedule == nil {
+		h.scheduler.Remove(fn.Name)
+		return
+	}
+	j, err := fn.Schedule.Job(fn.Name)
+	if err != nil {
+		h.logger.Log("error", "scheduling "+fn.Name+": "+err.Error())
+		return
+	}
+	h.scheduler.Set(j)
+}
+
+// schedulesHandler handles requests to list the scheduled functions
+func (h *handler) schedulesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"schedules": h.scheduler.Jobs()})
+	}
+}
+
+// scheduleRunsHandler handles requests for the run history of a
+// scheduled function, most recent first
+func (h *handler) scheduleRunsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"runs": h.scheduler.History(mux.Vars(r)["name"])})
+	}
+}
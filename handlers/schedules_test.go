@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/scheduler"
+	"github.com/gorilla/mux"
+)
+
+// TestSchedule verifies jobs follow the schedules of the registered
+// functions
+func TestSchedule(t *testing.T) {
+	h := newTestHandler(t, nil)
+	fn := &functions.Function{
+		Name:     "report",
+		URL:      "github.com/a/b",
+		Call:     "Report()",
+		Schedule: &functions.Schedule{Cron: "0 6 * * mon", Timezone: "UTC"},
+	}
+	h.schedule(fn)
+
+	router := mux.NewRouter()
+	router.Path("/schedules").HandlerFunc(h.schedulesHandler())
+	router.Path("/schedule/{name}/runs").HandlerFunc(h.scheduleRunsHandler())
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/schedules", nil))
+	var res struct {
+		Schedules []*scheduler.Status `json:"schedules"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Schedules) != 1 || res.Schedules[0].Function != "report" || res.Schedules[0].Cron != "0 6 * * mon" {
+		t.Errorf("unexpected schedules %+v", res.Schedules)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/schedule/report/runs", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"runs":[]}` {
+		t.Errorf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+
+	fn.Schedule = nil
+	h.schedule(fn)
+	if jobs := h.scheduler.Jobs(); len(jobs) != 0 {
+		t.Errorf("expected no jobs got %d", len(jobs))
+	}
+}
+
+// TestRunScheduled_NotFound verifies a job whose function has been
+// removed fails
+func TestRunScheduled_NotFound(t *testing.T) {
+	h := newTestHandler(t, nil)
+	if err := h.runScheduled(context.Background(), &scheduler.Job{Name: "gone"}); err == nil {
+		t.Error("expected error but received none")
+	}
+}
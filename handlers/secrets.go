@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/briandowns/sky-island/secrets"
+	"github.com/gorilla/mux"
+)
+
+// secretRequest contains the fields seen in a
+// request to create or rotate a secret
+type secretRequest struct {
+	Value  string   `json:"value"`
+	Grants []string `json:"grants"`
+}
+
+// secretsConfigured writes an error response and returns false
+// if no secrets store has been configured
+func (h *handler) secretsConfigured(w http.ResponseWriter) bool {
+	if h.secrets == nil {
+		h.ren.JSON(w, http.StatusNotImplemented, map[string]string{"error": secrets.ErrNotConfigured.Error()})
+		return false
+	}
+	return true
+}
+
+// secretsHandler handles requests to list secrets. Only
+// metadata is returned, never the values
+func (h *handler) secretsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.secretsConfigured(w) {
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"secrets": h.secrets.List()})
+	}
+}
+
+// setSecretHandler handles requests to create or rotate a secret
+func (h *handler) setSecretHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.secretsConfigured(w) {
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		var req secretRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+		md, err := h.secrets.Set(mux.Vars(r)["name"], []byte(req.Value), req.Grants)
+		if err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"secret": md})
+	}
+}
+
+// deleteSecretHandler handles requests to delete a secret
+func (h *handler) deleteSecretHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.secretsConfigured(w) {
+			return
+		}
+		name := mux.Vars(r)["name"]
+		if err := h.secrets.Delete(name); err != nil {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]string{"deleted": name})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+)
+
+// Timeouts used when the config's are unset
+const (
+	defaultBuildTimeout = 60 * time.Second
+	defaultExecTimeout  = 30 * time.Second
+)
+
+// timeoutError is returned when cloning, building or executing a
+// function runs past its deadline. Output holds what the jail wrote
+// before it was removed
+type timeoutError struct {
+	stage  string
+	output []byte
+}
+
+// Error implements the error interface on the timeoutError
+func (t *timeoutError) Error() string {
+	return t.stage + " timed out"
+}
+
+// parseTimeout parses the given timeout. An empty timeout is zero
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid timeout " + s)
+	}
+	return d, nil
+}
+
+// configTimeout returns the given configured timeout, or the given
+// default if it's unset
+func configTimeout(d config.Duration, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d.D()
+}
+
+// buildTimeout returns how long cloning and building a function
+// may take
+func (h *handler) buildTimeout() time.Duration {
+	return configTimeout(h.conf.JailSettings().BuildTimeout, defaultBuildTimeout)
+}
+
+// execTimeout returns how long a function may run. The given
+// timeout is used if set, otherwise the configured exec timeout,
+// and is capped at the configured max exec timeout which defaults
+// to the exec timeout
+func (h *handler) execTimeout(requested time.Duration) time.Duration {
+	jails := h.conf.JailSettings()
+	def := configTimeout(jails.ExecTimeout, defaultExecTimeout)
+	max := configTimeout(jails.MaxExecTimeout, def)
+	d := requested
+	if d == 0 {
+		d = def
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// runJailed runs the given jail command for the jail with the given
+// id, writing its output to out. If the context is done before the
+// command exits the jail is removed, killing every process in it,
+// and a timeoutError for the given stage holding the output so far
+// is returned on deadline
+func (h *handler) runJailed(ctx context.Context, stage, id string, cmd *exec.Cmd, out *bytes.Buffer) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if res, err := exec.Command("jail", "-r", id).CombinedOutput(); err != nil {
+			h.logger.Log("error", string(res))
+		}
+		cmd.Process.Kill()
+		<-done
+		if ctx.Err() == context.DeadlineExceeded {
+			return &timeoutError{stage: stage, output: out.Bytes()}
+		}
+		return ctx.Err()
+	}
+}
+
+// timedOut writes a 504 response holding the partial output if the
+// given error is a timeout, reporting whether it did
+func (h *handler) timedOut(w http.ResponseWriter, err error) bool {
+	terr, ok := err.(*timeoutError)
+	if !ok {
+		return false
+	}
+	h.logger.Log("error", terr.Error())
+	h.ren.JSON(w, http.StatusGatewayTimeout, map[string]string{
+		"error": terr.Error(),
+		"data":  string(terr.output),
+	})
+	return true
+}
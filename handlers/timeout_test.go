@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// TestExecTimeout verifies requested timeouts default to the exec
+// timeout and are capped at the max exec timeout
+func TestExecTimeout(t *testing.T) {
+	h := &handler{conf: &config.Config{Jails: &config.Jails{ExecTimeout: config.Duration(5 * time.Second)}}}
+	tests := map[time.Duration]time.Duration{
+		0:                5 * time.Second,
+		time.Second:      time.Second,
+		10 * time.Second: 5 * time.Second,
+	}
+	for requested, expected := range tests {
+		if d := h.execTimeout(requested); d != expected {
+			t.Errorf("%v: expected %v got %v", requested, expected, d)
+		}
+	}
+	h.conf.Jails.MaxExecTimeout = config.Duration(time.Minute)
+	if d := h.execTimeout(10 * time.Second); d != 10*time.Second {
+		t.Errorf("expected 10s got %v", d)
+	}
+	h.conf.Jails.ExecTimeout = 0
+	if d := h.execTimeout(0); d != defaultExecTimeout {
+		t.Errorf("expected default exec timeout got %v", d)
+	}
+}
+
+// TestRunJailed_Timeout verifies a command running past its deadline
+// is killed and returns a timeout error holding its partial output
+func TestRunJailed_Timeout(t *testing.T) {
+	h := &handler{logger: gklog.NewNopLogger()}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	cmd := exec.Command("sh", "-c", "echo partial; exec sleep 10")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	start := time.Now()
+	err := h.runJailed(ctx, "execution", "test", cmd, &out)
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected command to be killed on deadline")
+	}
+	terr, ok := err.(*timeoutError)
+	if !ok {
+		t.Fatalf("expected timeout error got %v", err)
+	}
+	if string(terr.output) != "partial\n" {
+		t.Errorf("expected partial output got %q", terr.output)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// toolchainsHandler handles requests to list the installed Go toolchains
+func (h *handler) toolchainsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		versions, err := h.tsvc.List()
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{
+			"default":    h.conf.GoVersion,
+			"toolchains": versions,
+		})
+	}
+}
+
+// installToolchainHandler handles requests to install a Go toolchain
+// from the configured tarball directory
+func (h *handler) installToolchainHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := mux.Vars(r)["version"]
+		if err := h.tsvc.Install(version); err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]string{"installed": version})
+		h.metrics.Inc("handlers.requests", "handler", "toolchain.install")
+	}
+}
+
+// removeToolchainHandler handles requests to remove a Go toolchain
+func (h *handler) removeToolchainHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := mux.Vars(r)["version"]
+		if err := h.tsvc.Remove(version); err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]string{"removed": version})
+		h.metrics.Inc("handlers.requests", "handler", "toolchain.remove")
+	}
+}
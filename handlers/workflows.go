@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/workflow"
+	"github.com/gorilla/mux"
+)
+
+// triggerWorkflow is the caller recorded for workflow invocations
+const triggerWorkflow = "workflow"
+
+// workflowRequest is a workflow submitted to be run with its input
+type workflowRequest struct {
+	workflow.Workflow
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// runWorkflowStep runs the named registered function for a step of a
+// workflow
+func (h *handler) runWorkflowStep(ctx context.Context, name string, input json.RawMessage) ([]byte, error) {
+	fn, ok := h.registry.Get(name)
+	if !ok {
+		return nil, errors.New("function " + name + " not found")
+	}
+	if fn.Kind == functions.KindHTTP {
+		return nil, errors.New("http function " + name + " can't be run by a workflow")
+	}
+	return h.invoke(ctx, triggerWorkflow, fn, input, false)
+}
+
+// startWorkflowHandler handles requests to run a workflow
+func (h *handler) startWorkflowHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusInternalServerError, httpISEPayload)
+			return
+		}
+		var req workflowRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			h.logger.Log("error", err.Error())
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": http.StatusText(http.StatusBadRequest)})
+			return
+		}
+		if err := req.Workflow.Validate(); err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		for _, name := range req.Workflow.Functions() {
+			fn, ok := h.registry.Get(name)
+			if !ok {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "function " + name + " not found"})
+				return
+			}
+			if fn.Kind == functions.KindHTTP {
+				h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": "http function " + name + " can't be run by a workflow"})
+				return
+			}
+		}
+		run, err := h.workflows.Start(&req.Workflow, req.Input)
+		if err != nil {
+			h.ren.JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusAccepted, map[string]interface{}{"workflow": run})
+	}
+}
+
+// workflowsHandler handles requests to list the workflow runs
+func (h *handler) workflowsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"workflows": h.workflows.List()})
+	}
+}
+
+// workflowHandler handles requests for the state of a workflow run
+func (h *handler) workflowHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		run, ok := h.workflows.Get(mux.Vars(r)["id"])
+		if !ok {
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": workflow.ErrNotFound.Error()})
+			return
+		}
+		h.ren.JSON(w, http.StatusOK, map[string]interface{}{"workflow": run})
+	}
+}
+
+// cancelWorkflowHandler handles requests to cancel a workflow run
+func (h *handler) cancelWorkflowHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		run, err := h.workflows.Cancel(mux.Vars(r)["id"])
+		switch err {
+		case nil:
+			h.ren.JSON(w, http.StatusOK, map[string]interface{}{"workflow": run})
+		case workflow.ErrNotFound:
+			h.ren.JSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		default:
+			h.ren.JSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+	}
+}
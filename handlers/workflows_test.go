@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/functions"
+	"github.com/briandowns/sky-island/mocks"
+	"github.com/briandowns/sky-island/workflow"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+// testWorkflowFunctions returns the fetch function and the http
+// function page
+func testWorkflowFunctions() []*functions.Function {
+	return []*functions.Function{
+		{Name: "fetch", URL: "github.com/example/fetch", Call: "Run()"},
+		{Name: "page", URL: "github.com/example/page", Kind: functions.KindHTTP, Call: "Serve"},
+	}
+}
+
+// startWorkflow submits the given workflow
+func startWorkflow(h *handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/workflows", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.startWorkflowHandler()(rr, req)
+	return rr
+}
+
+// TestStartWorkflowHandler_Rejected verifies workflows running
+// functions that aren't registered or are http functions are refused
+func TestStartWorkflowHandler_Rejected(t *testing.T) {
+	h := newTestHandler(t, nil, testWorkflowFunctions()...)
+	for body, want := range map[string]string{
+		`{"steps": []}`: "workflow steps required",
+		`{"steps": [{"name": "a", "function": "missing"}]}`:           "function missing not found",
+		`{"steps": [{"name": "a", "function": "page"}]}`:              "http function page can't be run by a workflow",
+		`{"steps": [{"name": "a", "function": "fetch"}], "input": "x`: "Bad Request",
+	} {
+		rr := startWorkflow(h, body)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("%s: expected %d %q got %d: %s", body, http.StatusBadRequest, want, rr.Code, rr.Body.String())
+		}
+	}
+	if runs := h.workflows.List(); len(runs) != 0 {
+		t.Errorf("expected no runs got %d", len(runs))
+	}
+}
+
+// TestStartWorkflowHandler verifies workflow steps run through the
+// function pipeline and their failures fail the run
+func TestStartWorkflowHandler(t *testing.T) {
+	rsvc := &mocks.RepoServicer{}
+	rsvc.On("CloneRepo", mock.Anything, mock.Anything, "github.com/example/fetch").Return(errors.New("offline"))
+	h := newTestHandler(t, rsvc, testWorkflowFunctions()...)
+	rr := startWorkflow(h, `{"name": "sync", "steps": [{"name": "fetch", "function": "fetch"}], "input": {"page": 1}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var res struct {
+		Workflow *workflow.Run `json:"workflow"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	id := res.Workflow.ID
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/workflow/"+id, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		rr = httptest.NewRecorder()
+		h.workflowHandler()(rr, req)
+		if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Workflow.Status != workflow.StatusRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the run to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if res.Workflow.Status != workflow.StatusFailed || !strings.HasPrefix(res.Workflow.Error, "step fetch: 500") {
+		t.Errorf("unexpected run %+v", res.Workflow)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/workflow/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	rr = httptest.NewRecorder()
+	h.cancelWorkflowHandler()(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": "missing"})
+	rr = httptest.NewRecorder()
+	h.workflowHandler()(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,200 @@
+package jail
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/utils"
+)
+
+// defaultArtifactCacheDir is where downloaded artifacts are kept
+// when no cache directory is configured
+const defaultArtifactCacheDir = "/tmp"
+
+// bsdManifestRegexp matches lines written by sha256(1) without -r,
+// e.g. "SHA256 (base.txz) = <digest>"
+var bsdManifestRegexp = regexp.MustCompile(`^SHA256 \((.+)\) = ([0-9a-fA-F]{64})$`)
+
+// errNoManifest is returned when artifacts can't be verified because
+// no manifest is configured and unverified artifacts aren't allowed
+var errNoManifest = errors.New("artifact manifest not configured, set artifacts.manifest or artifacts.allow_unverified")
+
+// manifest maps artifact names, relative to the artifact root, to
+// their expected SHA-256 digests
+type manifest map[string]string
+
+// loadManifest parses the given SHA-256 manifest. Lines in the format
+// written by sha256sum(1) or sha256 -r, "<digest> <name>", and by
+// sha256(1), "SHA256 (<name>) = <digest>", are accepted
+func loadManifest(path string) (manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := make(manifest)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match := bsdManifestRegexp.FindStringSubmatch(line); match != nil {
+			m[match[1]] = strings.ToLower(match[2])
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid manifest line %d in %s", n, path)
+		}
+		m[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return m, s.Err()
+}
+
+// sha256File returns the hex encoded SHA-256 digest of the given file
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// artifactConf returns the artifacts configuration, or the
+// defaults if none is set
+func (j *jailService) artifactConf() *config.Artifacts {
+	if j.conf.Artifacts == nil {
+		return &config.Artifacts{}
+	}
+	return j.conf.Artifacts
+}
+
+// artifactPath returns the local path of the named artifact. Artifacts
+// are read from the configured artifact directory if set, otherwise
+// from the download cache directory
+func (j *jailService) artifactPath(name string) string {
+	ac := j.artifactConf()
+	if ac.Dir != "" {
+		return filepath.Join(ac.Dir, name)
+	}
+	dir := ac.CacheDir
+	if dir == "" {
+		dir = defaultArtifactCacheDir
+	}
+	return filepath.Join(dir, name)
+}
+
+// verifyArtifact checks the file at the given path against the
+// manifest entry for the named artifact. Verification fails closed,
+// an artifact missing from the manifest is an error
+func (j *jailService) verifyArtifact(name, path string) error {
+	if j.manifest == nil {
+		if j.artifactConf().AllowUnverified {
+			j.logger.Log("msg", "skipping verification of unverified artifact "+name)
+			return nil
+		}
+		return errNoManifest
+	}
+	expected, ok := j.manifest[name]
+	if !ok {
+		return fmt.Errorf("artifact %s not found in manifest", name)
+	}
+	digest, err := sha256File(path)
+	if err != nil {
+		return err
+	}
+	if digest != expected {
+		return fmt.Errorf("artifact %s checksum mismatch: expected %s got %s", name, expected, digest)
+	}
+	return nil
+}
+
+// loadArtifactManifest loads the configured manifest, if any, for
+// use by verifyArtifact
+func (j *jailService) loadArtifactManifest() error {
+	path := j.artifactConf().Manifest
+	if path == "" {
+		return nil
+	}
+	m, err := loadManifest(path)
+	if err != nil {
+		return err
+	}
+	j.manifest = m
+	return nil
+}
+
+// artifact returns the verified local path of the named artifact. When
+// an artifact directory is configured nothing is downloaded. Otherwise
+// a previously downloaded copy is reused if it verifies, or the artifact
+// is downloaded from the mirror, if configured, or the given default URL
+func (j *jailService) artifact(name, defaultURL string) (string, error) {
+	path := j.artifactPath(name)
+	ac := j.artifactConf()
+	if ac.Dir != "" {
+		if !utils.Exists(path) {
+			return "", errors.New("artifact not found: " + path)
+		}
+		return path, j.verifyArtifact(name, path)
+	}
+	if utils.Exists(path) {
+		if err := j.verifyArtifact(name, path); err == nil {
+			return path, nil
+		}
+		j.logger.Log("msg", "discarding unverified artifact "+path)
+	}
+
+	url := defaultURL
+	if ac.MirrorURL != "" {
+		url = strings.TrimSuffix(ac.MirrorURL, "/") + "/" + name
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp := path + ".download"
+	if err := j.download(url, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := j.verifyArtifact(name, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// download saves the content at the given URL to the given path
+func (j *jailService) download(url, path string) error {
+	j.logger.Log("msg", "downloading "+url)
+	res, err := j.hc.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", url, res.Status)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, &progressReader{r: res.Body, tracker: j.tracker})
+	return err
+}
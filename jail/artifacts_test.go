@@ -0,0 +1,186 @@
+package jail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+)
+
+var testArtifacts = map[string][]byte{
+	"11.1-RELEASE/base.txz":         []byte("base"),
+	"11.1-RELEASE/lib32.txz":        []byte("lib32"),
+	"11.1-RELEASE/ports.txz":        []byte("ports"),
+	"go1.9.2.freebsd-amd64.tar.gz":  []byte("go"),
+	"go1.10.1.freebsd-amd64.tar.gz": []byte("tampered"),
+}
+
+// digest returns the hex encoded SHA-256 digest of b
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// newArtifactServer serves the test artifacts
+func newArtifactServer(tls bool) *httptest.Server {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := testArtifacts[r.URL.Path[1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	})
+	if tls {
+		ts := httptest.NewUnstartedServer(h)
+		ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+		ts.StartTLS()
+		return ts
+	}
+	return httptest.NewServer(h)
+}
+
+// newArtifactJailService creates a jail service getting artifacts
+// from the given mirror, caching them in a temp dir, and verifying
+// them against a manifest. go1.10.1 is listed with a digest that
+// doesn't match what's served
+func newArtifactJailService(t *testing.T, mirror string) (*jailService, string) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := "# sky-island artifacts\n" +
+		digest(testArtifacts["11.1-RELEASE/base.txz"]) + "  11.1-RELEASE/base.txz\n" +
+		digest(testArtifacts["11.1-RELEASE/lib32.txz"]) + "  11.1-RELEASE/lib32.txz\n" +
+		"SHA256 (11.1-RELEASE/ports.txz) = " + digest(testArtifacts["11.1-RELEASE/ports.txz"]) + "\n" +
+		digest(testArtifacts["go1.9.2.freebsd-amd64.tar.gz"]) + "  go1.9.2.freebsd-amd64.tar.gz\n" +
+		digest([]byte("go")) + "  go1.10.1.freebsd-amd64.tar.gz\n"
+	manifestFile := filepath.Join(dir, "MANIFEST")
+	if err := ioutil.WriteFile(manifestFile, []byte(m), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{
+		Release: "11.1-RELEASE",
+		Jails:   &config.Jails{BaseJailDir: dir},
+		Artifacts: &config.Artifacts{
+			MirrorURL: mirror,
+			CacheDir:  filepath.Join(dir, "cache"),
+			Manifest:  manifestFile,
+		},
+	}
+	j := NewJailService(conf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{}).(*jailService)
+	if err := j.loadArtifactManifest(); err != nil {
+		t.Fatal(err)
+	}
+	return j, dir
+}
+
+// TestDownloadBaseSystem_Mirror verifies base packages are downloaded
+// from the mirror and verified against the manifest
+func TestDownloadBaseSystem_Mirror(t *testing.T) {
+	ts := newArtifactServer(false)
+	defer ts.Close()
+	j, dir := newArtifactJailService(t, ts.URL)
+	defer os.RemoveAll(dir)
+	if err := j.downloadBaseSystem(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range basePackages {
+		if !utils.Exists(j.artifactPath(j.baseArtifact(p))) {
+			t.Errorf("expected %s to be downloaded", p)
+		}
+	}
+	if _, err := j.downloadGo("1.9.2"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDownloadGo_ChecksumMismatch verifies a download that doesn't
+// match the manifest fails and isn't kept
+func TestDownloadGo_ChecksumMismatch(t *testing.T) {
+	ts := newArtifactServer(false)
+	defer ts.Close()
+	j, dir := newArtifactJailService(t, ts.URL)
+	defer os.RemoveAll(dir)
+	if _, err := j.downloadGo("1.10.1"); err == nil {
+		t.Fatal("expected checksum mismatch error")
+	}
+	if utils.Exists(j.artifactPath("go1.10.1.freebsd-amd64.tar.gz")) {
+		t.Error("expected mismatched artifact to be removed")
+	}
+}
+
+// TestArtifact_NotInManifest verifies artifacts missing from the
+// manifest fail closed
+func TestArtifact_NotInManifest(t *testing.T) {
+	ts := newArtifactServer(false)
+	defer ts.Close()
+	j, dir := newArtifactJailService(t, ts.URL)
+	defer os.RemoveAll(dir)
+	if _, err := j.downloadGo("1.11"); err == nil {
+		t.Error("expected error for artifact missing from manifest")
+	}
+}
+
+// TestArtifact_NoManifest verifies artifacts aren't used without a
+// manifest unless unverified artifacts are explicitly allowed
+func TestArtifact_NoManifest(t *testing.T) {
+	ts := newArtifactServer(false)
+	defer ts.Close()
+	j, dir := newArtifactJailService(t, ts.URL)
+	defer os.RemoveAll(dir)
+	j.manifest = nil
+	if _, err := j.downloadGo("1.9.2"); err != errNoManifest {
+		t.Errorf("expected %v got %v", errNoManifest, err)
+	}
+	j.conf.Artifacts.AllowUnverified = true
+	if _, err := j.downloadGo("1.9.2"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestArtifact_LocalDir verifies artifacts are read from the
+// artifact directory without downloading
+func TestArtifact_LocalDir(t *testing.T) {
+	j, dir := newArtifactJailService(t, "http://127.0.0.1:1")
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local")
+	j.conf.Artifacts.Dir = local
+	if err := os.MkdirAll(filepath.Join(local, "11.1-RELEASE"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range basePackages {
+		name := j.baseArtifact(p)
+		if err := ioutil.WriteFile(filepath.Join(local, name), testArtifacts[name], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.downloadBaseSystem(); err != nil {
+		t.Error(err)
+	}
+	if _, err := j.downloadGo("1.9.2"); err == nil {
+		t.Error("expected error for artifact missing from the artifact directory")
+	}
+}
+
+// TestArtifact_TLSVerify verifies TLS certificates are verified
+// by default
+func TestArtifact_TLSVerify(t *testing.T) {
+	ts := newArtifactServer(true)
+	defer ts.Close()
+	j, dir := newArtifactJailService(t, ts.URL)
+	defer os.RemoveAll(dir)
+	if _, err := j.downloadGo("1.9.2"); err == nil {
+		t.Error("expected TLS verification error")
+	}
+}
@@ -1,52 +1,34 @@
 package jail
 
 import (
+	"archive/tar"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 
-	"github.com/briandowns/sky-island/utils"
 	"github.com/mholt/archiver"
 	"golang.org/x/sync/errgroup"
 )
 
-// downloadBaseSystem downloads the base FreeBSD system. It
-// will only download the packages if they haven't already
-// been downloaded
+// baseArtifact returns the artifact name of the given base
+// system package for the configured release
+func (j *jailService) baseArtifact(pkg string) string {
+	return j.conf.Release + "/" + pkg
+}
+
+// downloadBaseSystem downloads the base FreeBSD system. It will
+// only download the packages if verified copies haven't already
+// been downloaded or aren't available in the artifact directory
 func (j *jailService) downloadBaseSystem() error {
 	t := j.metrics.NewTiming()
 	defer t.Send("base.download_packages_time")
-	if !utils.Exists("/tmp/" + j.conf.Release) {
-		if err := os.Mkdir("/tmp/"+j.conf.Release, os.ModePerm); err != nil {
-			return err
-		}
-	}
 	var g errgroup.Group
 	for _, p := range basePackages {
-		file := "/tmp/" + j.conf.Release + "/" + p
-		if utils.Exists(file) {
-			continue
-		}
 		pkg := p
-		pkgFile := file
 		g.Go(func() error {
-			out, err := os.Create(pkgFile)
-			if err != nil {
-				return err
-			}
-			defer out.Close()
-			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(sysDownloadURL, j.conf.Release, pkg), nil)
-			if err != nil {
-				return err
-			}
-			res, err := j.hc.Do(req)
-			if err != nil {
-				return err
-			}
-			defer res.Body.Close()
-			_, err = io.Copy(out, res.Body)
+			_, err := j.artifact(j.baseArtifact(pkg), fmt.Sprintf(sysDownloadURL, j.conf.Release, pkg))
 			return err
 		})
 	}
@@ -58,34 +40,95 @@ func (j *jailService) downloadBaseSystem() error {
 func (j *jailService) extractBasePkgs() error {
 	t := j.metrics.NewTiming()
 	defer t.Send("base.extract_packages_time")
-	fullPath := j.conf.Jails.BaseJailDir + "/releases/" + j.conf.Release
-	txz := archiver.TarXz{
-		Tar: &archiver.Tar{
-			MkdirAll:          true,
-			OverwriteExisting: true,
-		},
-	}
 	for _, p := range basePackages {
-		if err := txz.Unarchive("/tmp/"+j.conf.Release+"/"+p, fullPath); err != nil {
+		if err := j.extractTxz(j.artifactPath(j.baseArtifact(p)), j.releasePath()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// extractTxz extracts the given txz archive into dst, overwriting
+// existing files so an interrupted extraction can be rerun. Each
+// extracted file counts towards the init progress
+func (j *jailService) extractTxz(archive, dst string) error {
+	dst = filepath.Clean(dst)
+	txz := archiver.TarXz{Tar: &archiver.Tar{}}
+	return txz.Walk(archive, func(f archiver.File) error {
+		hdr, ok := f.Header.(*tar.Header)
+		if !ok {
+			return fmt.Errorf("unexpected header type %T in %s", f.Header, archive)
+		}
+		path := filepath.Join(dst, hdr.Name)
+		if path != dst && !strings.HasPrefix(path, dst+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal path %s in %s", hdr.Name, archive)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, f.Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(path, f, f.Mode()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(path)
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			os.Remove(path)
+			if err := os.Link(filepath.Join(dst, hdr.Linkname), path); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			return nil
+		default:
+			return fmt.Errorf("unsupported entry type %c for %s in %s", hdr.Typeflag, hdr.Name, archive)
+		}
+		j.tracker.addFiles(1)
+		return nil
+	})
+}
+
+// writeFile replaces the file at the given path with the contents
+// of r, keeping the setuid, setgid and sticky bits of the given mode
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	os.Remove(path)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
+
+// updateArgs returns the arguments given to env(1) to run
+// freebsd-update against the base jail
+func (j *jailService) updateArgs() []string {
+	return []string{"UNAME_r=" + j.conf.Release, "freebsd-update", "-b", j.releasePath(), "--not-running-from-cron", "fetch", "install"}
+}
+
 // updateBaseJail uses freebsd-update to make sure that
 // the base jail is up to date
 func (j *jailService) updateBaseJail() error {
 	t := j.metrics.NewTiming()
 	defer t.Send("base.update_time")
-	path := j.conf.Jails.BaseJailDir + "/releases/" + j.conf.Release
-	cmd := exec.Command("env", "UNAME_r="+j.conf.Release, "freebsd-update", "-b", "--not-running-from-cron", path, "fetch", "install")
-	env := os.Environ()
-	env = append(env, "UNAME_r="+j.conf.Release)
-	if err := cmd.Start(); err != nil {
-		return err
+	out, err := j.wrapper.CombinedOutput("env", j.updateArgs()...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
 	}
-	return cmd.Wait()
+	return nil
 }
 
 // setupResolveConf takes the DNS servers from configuration and
@@ -93,16 +136,8 @@ func (j *jailService) updateBaseJail() error {
 // settings aren't present in configuration, it will copy from
 // the host system to thet release jail
 func (j *jailService) setupResolvConf() error {
-	if j.conf.Network.IP4.DNS != nil {
-		out, err := os.Create(j.conf.Jails.BaseJailDir + "/releases/" + j.conf.Release + "/etc/resolv.conf")
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-		for _, i := range j.conf.Network.IP4.DNS {
-			out.Write([]byte("nameserver " + i + "\n"))
-		}
-		return nil
+	if dns := j.conf.DNS(); dns != nil {
+		return writeResolvConf(j.conf.Jails.BaseJailDir+"/releases/"+j.conf.Release+"/etc/resolv.conf", dns)
 	}
 	in, err := os.Open("/etc/resolv.conf")
 	if err != nil {
@@ -118,6 +153,21 @@ func (j *jailService) setupResolvConf() error {
 	return err
 }
 
+// writeResolvConf writes a resolv.conf using the given DNS servers
+func writeResolvConf(path string, dns []string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, i := range dns {
+		if _, err := out.Write([]byte("nameserver " + i + "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // setupLocaltime copies the localtime file from the host
 // to the release jail
 func (j *jailService) setupLocaltime() error {
@@ -141,31 +191,25 @@ func (j *jailService) setBaseJailConf() error {
 	return j.setupResolvConf()
 }
 
-// downloadGo downloads the configured version of Go only if it hasn't
-// been downloaded previously
-func (j *jailService) downloadGo() error {
+// goVersions returns the default Go version followed by any
+// additional configured versions
+func (j *jailService) goVersions() []string {
+	versions := []string{j.conf.GoVersion}
+	for _, v := range j.conf.GoVersions {
+		if v != j.conf.GoVersion {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// downloadGo downloads the given version of Go only if a verified
+// copy hasn't been downloaded previously or isn't available in the
+// artifact directory, returning the path to the tarball
+func (j *jailService) downloadGo(version string) (string, error) {
 	t := j.metrics.NewTiming()
 	defer t.Send("go.download_time")
-	goTarBall := fmt.Sprintf("/tmp/go%s.freebsd-amd64.tar.gz", j.conf.GoVersion)
-	if utils.Exists(goTarBall) {
-		return nil
-	}
-	out, err := os.Create(goTarBall)
-	if err != nil {
-		return err
-	}
-	endpoint := fmt.Sprintf(goDownloadURL, j.conf.GoVersion)
-	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
-	if err != nil {
-		return err
-	}
-	res, err := j.hc.Do(req)
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	_, err = io.Copy(out, res.Body)
-	return err
+	return j.artifact(fmt.Sprintf(goTarballName, version), fmt.Sprintf(goDownloadURL, version))
 }
 
 // setupGoEnv creates the Go workspace
@@ -181,22 +225,29 @@ func (j *jailService) setupGoEnv() error {
 	return nil
 }
 
-// installGo installs the configured version of Go
+// installGo installs each configured version of Go side by side in
+// /usr/local/go<version> and links /usr/local/go to the default
 func (j *jailService) installGo() error {
 	t := j.metrics.NewTiming()
 	defer t.Send("go.install_time")
-	if err := j.downloadGo(); err != nil {
-		return err
+	root := fmt.Sprintf("%s/releases/%s", j.conf.Jails.BaseJailDir, j.conf.Release)
+	for _, v := range j.goVersions() {
+		if err := validGoVersion(v); err != nil {
+			return err
+		}
+		tarball, err := j.downloadGo(v)
+		if err != nil {
+			return err
+		}
+		if err := extractGo(tarball, root, v); err != nil {
+			return err
+		}
 	}
-	src := fmt.Sprintf("/tmp/go%s.freebsd-amd64.tar.gz", j.conf.GoVersion)
-	dst := fmt.Sprintf("%s/releases/%s/usr/local", j.conf.Jails.BaseJailDir, j.conf.Release)
-	tgz := archiver.TarGz{
-		Tar: &archiver.Tar{
-			MkdirAll:          true,
-			OverwriteExisting: true,
-		},
+	link := root + goToolchainDir
+	if err := os.RemoveAll(link); err != nil {
+		return err
 	}
-	if err := tgz.Unarchive(src, dst); err != nil {
+	if err := os.Symlink("go"+j.conf.GoVersion, link); err != nil {
 		return err
 	}
 	return j.setupGoEnv()
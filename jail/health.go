@@ -0,0 +1,289 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// Statuses of a health check and report
+const (
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthFail = "fail"
+)
+
+// defaults used when the health thresholds aren't configured
+const (
+	defaultMinFreeIPs     = 1
+	defaultMinFreeSpaceMB = 1024
+	defaultStatsdPort     = "8125"
+	statsdDialTimeout     = 500 * time.Millisecond
+)
+
+// zfsCheckTTL is how long the result of a check running zfs is reused
+// so frequent probes don't run zfs every time
+const zfsCheckTTL = 30 * time.Second
+
+// HealthCheck is the result of checking one component
+type HealthCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+// HealthReport is the result of checking every component. It fails
+// if any critical check fails and warns if any other check fails
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []*HealthCheck `json:"checks"`
+}
+
+// Ready reports whether the report doesn't fail
+func (h *HealthReport) Ready() bool {
+	return h.Status != HealthFail
+}
+
+// HealthServicer defines the behavior of the health service
+type HealthServicer interface {
+	Live() *HealthReport
+	Ready() *HealthReport
+}
+
+// healthService holds the state of the service
+type healthService struct {
+	logger     gklog.Logger
+	conf       *config.Config
+	metrics    *metrics.Metrics
+	wrapper    utils.Wrapper
+	networksvc NetworkServicer
+	tsvc       ToolchainServicer
+	dial       func(network, addr string, timeout time.Duration) (net.Conn, error)
+	now        func() time.Time
+
+	mu     sync.Mutex
+	cached map[string]*cachedCheck
+}
+
+// cachedCheck is the result of a check reused until it expires
+type cachedCheck struct {
+	msg     string
+	err     error
+	expires time.Time
+}
+
+// NewHealthService creates a new value of type healthService pointer
+// checking the components function invocations depend on
+func NewHealthService(conf *config.Config, l gklog.Logger, m *metrics.Metrics, w utils.Wrapper, n NetworkServicer, t ToolchainServicer) HealthServicer {
+	return &healthService{
+		logger:     l,
+		conf:       conf,
+		metrics:    m,
+		wrapper:    w,
+		networksvc: n,
+		tsvc:       t,
+		dial:       net.DialTimeout,
+		now:        time.Now,
+		cached:     make(map[string]*cachedCheck),
+	}
+}
+
+// Live reports the service is up. Nothing is checked since anything
+// failing is fixed by fixing the host, not by restarting the service
+func (h *healthService) Live() *HealthReport {
+	return &HealthReport{Status: HealthOK, Checks: []*HealthCheck{}}
+}
+
+// Ready checks every component function invocations depend on
+func (h *healthService) Ready() *HealthReport {
+	checks := []*HealthCheck{
+		h.check("dataset", true, h.cache("dataset", h.checkDataset)),
+		h.check("base_snapshot", true, h.cache("base_snapshot", h.checkSnapshot)),
+		h.check("build_jail", true, h.cache("build_jail", h.checkBuildJail)),
+		h.check("go_toolchain", true, h.checkToolchain),
+		h.check("ip_pool", true, h.checkIPPool),
+		h.check("disk_space", true, h.cache("disk_space", h.checkDiskSpace)),
+	}
+	if h.statsdEnabled() {
+		checks = append(checks, h.check("statsd", false, h.checkStatsd))
+	}
+	report := &HealthReport{Status: HealthOK, Checks: checks}
+	for _, c := range checks {
+		switch {
+		case c.Status == HealthFail && c.Critical:
+			report.Status = HealthFail
+		case c.Status == HealthFail && report.Status == HealthOK:
+			report.Status = HealthWarn
+		}
+	}
+	for _, c := range checks {
+		v := 0.0
+		if c.Status == HealthOK {
+			v = 1
+		}
+		h.metrics.Set("check", v, "check", c.Name)
+	}
+	return report
+}
+
+// check runs the given check, describing its result
+func (h *healthService) check(name string, critical bool, fn func() (string, error)) *HealthCheck {
+	c := &HealthCheck{Name: name, Status: HealthOK, Critical: critical}
+	msg, err := fn()
+	if err != nil {
+		h.logger.Log("error", "health check "+name+": "+err.Error())
+		c.Status = HealthFail
+		c.Message = err.Error()
+		return c
+	}
+	c.Message = msg
+	return c
+}
+
+// cache returns the given check reusing its result for zfsCheckTTL
+func (h *healthService) cache(name string, fn func() (string, error)) func() (string, error) {
+	return func() (string, error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		now := h.now()
+		if c, ok := h.cached[name]; ok && now.Before(c.expires) {
+			return c.msg, c.err
+		}
+		msg, err := fn()
+		h.cached[name] = &cachedCheck{msg: msg, err: err, expires: now.Add(zfsCheckTTL)}
+		return msg, err
+	}
+}
+
+// datasetExists checks the given ZFS dataset exists
+func (h *healthService) datasetExists(name string) (string, error) {
+	names, err := zfsList(h.wrapper, "name", name)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", errors.New(name + " does not exist")
+	}
+	return name, nil
+}
+
+// checkDataset checks the configured dataset exists
+func (h *healthService) checkDataset() (string, error) {
+	return h.datasetExists(h.conf.Filesystem.ZFSDataset)
+}
+
+// checkSnapshot checks the active release has a snapshot to clone
+// function jails from
+func (h *healthService) checkSnapshot() (string, error) {
+	release := readActiveRelease(h.conf)
+	snapshots, err := zfsList(h.wrapper, "name", "-t", "snapshot", "-d", "1", releasesDataset(h.conf)+"/"+release)
+	if err != nil {
+		return "", err
+	}
+	latest := latestSnapshot(snapshots)
+	if latest == "" {
+		return "", fmt.Errorf("no snapshot found for release %s", release)
+	}
+	return latest, nil
+}
+
+// checkBuildJail checks the build jail dataset exists
+func (h *healthService) checkBuildJail() (string, error) {
+	return h.datasetExists(h.conf.Filesystem.ZFSDataset + "/jails/build")
+}
+
+// checkToolchain checks the default Go toolchain runs
+func (h *healthService) checkToolchain() (string, error) {
+	bin, err := h.tsvc.GoBin("")
+	if err != nil {
+		return "", err
+	}
+	out, err := h.wrapper.CombinedOutput(h.conf.Jails.BaseJailDir+"/build"+bin, "version")
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkIPPool checks enough IP addresses are free
+func (h *healthService) checkIPPool() (string, error) {
+	var free int
+	for _, owner := range h.networksvc.Pool() {
+		if owner == nil {
+			free++
+		}
+	}
+	min := defaultMinFreeIPs
+	if n := h.conf.HealthSettings().MinFreeIPs; n > 0 {
+		min = n
+	}
+	msg := strconv.Itoa(free) + " free"
+	if free < min {
+		return "", fmt.Errorf("%s, want at least %d", msg, min)
+	}
+	return msg, nil
+}
+
+// checkDiskSpace checks enough space is available on the dataset
+func (h *healthService) checkDiskSpace() (string, error) {
+	values, err := zfsList(h.wrapper, "available", "-p", h.conf.Filesystem.ZFSDataset)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", errors.New(h.conf.Filesystem.ZFSDataset + " does not exist")
+	}
+	avail, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	min := int64(defaultMinFreeSpaceMB)
+	if mb := h.conf.HealthSettings().MinFreeSpaceMB; mb > 0 {
+		min = int64(mb)
+	}
+	availMB := avail >> 20
+	msg := strconv.FormatInt(availMB, 10) + "MB available"
+	if availMB < min {
+		return "", fmt.Errorf("%s, want at least %dMB", msg, min)
+	}
+	return msg, nil
+}
+
+// statsdEnabled reports whether metrics are sent to statsd
+func (h *healthService) statsdEnabled() bool {
+	if h.conf.Metrics == nil || len(h.conf.Metrics.Backends) == 0 {
+		return true
+	}
+	for _, b := range h.conf.Metrics.Backends {
+		if b == metrics.BackendStatsd {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStatsd checks the statsd address resolves. Metrics are sent
+// over UDP, which can't tell statsd is listening without waiting on
+// a reply that never comes, so nothing is sent
+func (h *healthService) checkStatsd() (string, error) {
+	addr := h.conf.Jails.MonitoringAddr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultStatsdPort)
+	}
+	conn, err := h.dial("udp", addr, statsdDialTimeout)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return addr, nil
+}
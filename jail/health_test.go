@@ -0,0 +1,160 @@
+package jail
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// newHealthTestService creates a health service with the given fake
+// wrapper, a pool with the given free and allocated addresses and the
+// default Go toolchain installed in a temp dir
+func newHealthTestService(t *testing.T, w *fakeWrapper, free, allocated int) *healthService {
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{
+		Release:    "11.1-RELEASE",
+		GoVersion:  "1.9.2",
+		StateDir:   dir,
+		Filesystem: &config.Filesystem{ZFSDataset: "zroot"},
+		Jails:      &config.Jails{BaseJailDir: dir, MonitoringAddr: "127.0.0.1"},
+		Metrics:    &config.Metrics{Backends: []string{metrics.BackendPrometheus}},
+	}
+	bin := dir + "/build" + goToolchainDir + "1.9.2/bin"
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bin+"/go", nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	n := &networkService{metrics: metrics.Discard(), mu: &sync.Mutex{}, ip4Pool: make(map[string][]byte)}
+	for i := 0; i < free+allocated; i++ {
+		var owner []byte
+		if i >= free {
+			owner = []byte("jail")
+		}
+		n.ip4Pool[net.IPv4(10, 0, 0, byte(i)).String()] = owner
+	}
+	tsvc := NewToolchainService(conf, gklog.NewNopLogger(), metrics.Discard())
+	return NewHealthService(conf, gklog.NewNopLogger(), metrics.Discard(), w, n, tsvc).(*healthService)
+}
+
+// healthyOutput is the output of every command run by the checks on
+// a healthy host with its state in the given dir
+func healthyOutput(dir string) map[string]string {
+	return map[string]string{
+		"zfs list -H -o name zroot": "zroot",
+		"zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/11.1-RELEASE": "zroot/jails/releases/11.1-RELEASE@p1\n",
+		"zfs list -H -o name zroot/jails/build":                                  "zroot/jails/build",
+		"zfs list -H -o available -p zroot":                                      "10737418240",
+		dir + "/build" + goToolchainDir + "1.9.2/bin/go version":                 "go version go1.9.2 freebsd/amd64",
+	}
+}
+
+// TestReady verifies a healthy host is ready with every check passing
+func TestReady(t *testing.T) {
+	w := &fakeWrapper{}
+	h := newHealthTestService(t, w, 5, 1)
+	defer os.RemoveAll(h.conf.StateDir)
+	w.out = healthyOutput(h.conf.StateDir)
+	report := h.Ready()
+	if report.Status != HealthOK || !report.Ready() {
+		t.Fatalf("expected ok got %s", report.Status)
+	}
+	if len(report.Checks) != 6 {
+		t.Errorf("expected 6 checks got %d", len(report.Checks))
+	}
+	for _, c := range report.Checks {
+		if c.Status != HealthOK {
+			t.Errorf("expected %s ok got %s %s", c.Name, c.Status, c.Message)
+		}
+	}
+}
+
+// TestReady_Failing verifies missing components and exhausted
+// resources fail readiness with a reason
+func TestReady_Failing(t *testing.T) {
+	w := &fakeWrapper{}
+	h := newHealthTestService(t, w, 0, 3)
+	defer os.RemoveAll(h.conf.StateDir)
+	w.out = healthyOutput(h.conf.StateDir)
+	delete(w.out, "zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/11.1-RELEASE")
+	w.out["zfs list -H -o available -p zroot"] = "1048576"
+	report := h.Ready()
+	if report.Ready() {
+		t.Fatal("expected not ready")
+	}
+	failed := make(map[string]string)
+	for _, c := range report.Checks {
+		if c.Status == HealthFail {
+			failed[c.Name] = c.Message
+		}
+	}
+	for _, name := range []string{"base_snapshot", "ip_pool", "disk_space"} {
+		if failed[name] == "" {
+			t.Errorf("expected %s to fail with a reason", name)
+		}
+	}
+	if len(failed) != 3 {
+		t.Errorf("expected 3 failed checks got %v", failed)
+	}
+}
+
+// TestReady_StatsdWarns verifies an unreachable statsd warns without
+// failing readiness
+func TestReady_StatsdWarns(t *testing.T) {
+	w := &fakeWrapper{}
+	h := newHealthTestService(t, w, 1, 0)
+	defer os.RemoveAll(h.conf.StateDir)
+	w.out = healthyOutput(h.conf.StateDir)
+	h.conf.Metrics = nil
+	h.dial = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		if addr != "127.0.0.1:8125" {
+			t.Errorf("unexpected statsd address %s", addr)
+		}
+		return nil, errors.New("no route to host")
+	}
+	report := h.Ready()
+	if report.Status != HealthWarn || !report.Ready() {
+		t.Errorf("expected warn and ready got %s", report.Status)
+	}
+}
+
+// TestReady_Cached verifies the zfs checks aren't run again until
+// their result expires
+func TestReady_Cached(t *testing.T) {
+	w := &fakeWrapper{}
+	h := newHealthTestService(t, w, 1, 0)
+	defer os.RemoveAll(h.conf.StateDir)
+	w.out = healthyOutput(h.conf.StateDir)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	if report := h.Ready(); report.Status != HealthOK {
+		t.Fatalf("expected ok got %s", report.Status)
+	}
+	w.ran = nil
+	delete(w.out, "zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/11.1-RELEASE")
+	if report := h.Ready(); report.Status != HealthOK {
+		t.Errorf("expected cached ok got %s", report.Status)
+	}
+	for _, cmd := range w.ran {
+		if strings.HasPrefix(cmd, "zfs") {
+			t.Errorf("unexpected command %s", cmd)
+		}
+	}
+	now = now.Add(zfsCheckTTL)
+	if report := h.Ready(); report.Ready() {
+		t.Error("expected not ready once the cached result expires")
+	}
+}
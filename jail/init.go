@@ -0,0 +1,492 @@
+package jail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+)
+
+// Init step names in the order they're run
+const (
+	StepDataset   = "dataset"
+	StepDownload  = "download"
+	StepExtract   = "extract"
+	StepUpdate    = "update"
+	StepConfigure = "configure"
+	StepGo        = "go"
+	StepSnapshot  = "snapshot"
+	StepBuildJail = "build_jail"
+)
+
+// Init step statuses
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// initStateFile is the name of the file, in the state
+// directory, initialization state is recorded in
+const initStateFile = "init.json"
+
+// progressInterval is how often progress is logged and
+// recorded while a step is running
+const progressInterval = 5 * time.Second
+
+// InitOptions controls how system initialization is run
+type InitOptions struct {
+	// Resume skips the steps recorded as done by a previous run
+	Resume bool
+
+	// FromStep skips the steps before the named step
+	FromStep string
+
+	// DryRun writes the commands that would be run to Out
+	// instead of running them
+	DryRun bool
+	Out    io.Writer
+}
+
+// StepState records the state and progress of an init step
+type StepState struct {
+	Name            string    `json:"name"`
+	Status          string    `json:"status"`
+	BytesDownloaded int64     `json:"bytes_downloaded"`
+	FilesExtracted  int64     `json:"files_extracted"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// InitState is the recorded state of system initialization
+type InitState struct {
+	Release   string       `json:"release"`
+	Steps     []*StepState `json:"steps"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// step returns the state of the named step
+func (s *InitState) step(name string) *StepState {
+	for _, st := range s.Steps {
+		if st.Name == name {
+			return st
+		}
+	}
+	return nil
+}
+
+// InitStatePath returns the path of the initialization state file
+func InitStatePath(conf *config.Config) string {
+	dir := conf.StateDir
+	if dir == "" {
+		dir = config.DefaultStateDir
+	}
+	return filepath.Join(dir, initStateFile)
+}
+
+// ReadInitState reads the recorded initialization state
+func ReadInitState(conf *config.Config) (*InitState, error) {
+	return readInitState(InitStatePath(conf))
+}
+
+// readInitState reads the init state recorded in the given file
+func readInitState(path string) (*InitState, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s InitState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// initTracker records the state and progress of initialization
+// and persists it to the state file
+type initTracker struct {
+	mu    sync.Mutex
+	path  string
+	state *InitState
+	cur   *StepState
+}
+
+// addBytes adds to the bytes downloaded by the current step
+func (t *initTracker) addBytes(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur != nil {
+		t.cur.BytesDownloaded += n
+	}
+}
+
+// addFiles adds to the files extracted by the current step
+func (t *initTracker) addFiles(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur != nil {
+		t.cur.FilesExtracted += n
+	}
+}
+
+// progress returns a log friendly summary of the current step
+func (t *initTracker) progress() []interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil {
+		return nil
+	}
+	return []interface{}{
+		"step", t.cur.Name,
+		"bytes_downloaded", t.cur.BytesDownloaded,
+		"files_extracted", t.cur.FilesExtracted,
+	}
+}
+
+// setStatus sets the status of the given step, making it the
+// current step if it's running, and saves the state
+func (t *initTracker) setStatus(st *StepState, status string, err error) error {
+	t.mu.Lock()
+	now := time.Now().UTC()
+	st.Status = status
+	switch status {
+	case StatusRunning:
+		t.cur = st
+		st.StartedAt = now
+		st.FinishedAt = time.Time{}
+		st.BytesDownloaded = 0
+		st.FilesExtracted = 0
+		st.Error = ""
+	case StatusDone, StatusFailed:
+		t.cur = nil
+		st.FinishedAt = now
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	t.mu.Unlock()
+	return t.save()
+}
+
+// save writes the state file. The lock is held throughout since the
+// progress ticker and the steps share the temporary file
+func (t *initTracker) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.UpdatedAt = time.Now().UTC()
+	b, err := json.MarshalIndent(t.state, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// progressReader counts the bytes read towards the init progress
+type progressReader struct {
+	r       io.Reader
+	tracker *initTracker
+}
+
+// Read implements the io.Reader interface on the progressReader
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.tracker.addBytes(int64(n))
+	return n, err
+}
+
+// initStep is a single idempotent step of system initialization
+type initStep struct {
+	name string
+	msg  string
+	plan func() []string
+	run  func() error
+}
+
+// releaseDataset returns the ZFS dataset of the release base jail
+func (j *jailService) releaseDataset() string {
+	return j.conf.Filesystem.ZFSDataset + "/jails/releases/" + j.conf.Release
+}
+
+// releasePath returns the path of the release base jail
+func (j *jailService) releasePath() string {
+	return j.conf.Jails.BaseJailDir + "/releases/" + j.conf.Release
+}
+
+// initSteps returns the steps of system initialization in order
+func (j *jailService) initSteps() []*initStep {
+	return []*initStep{
+		{
+			name: StepDataset,
+			msg:  "creating ZFS dataset",
+			plan: func() []string {
+				return []string{"zfs create -p " + j.releaseDataset()}
+			},
+			run: j.fsService.CreateDataset,
+		},
+		{
+			name: StepDownload,
+			msg:  "downloading base system",
+			plan: func() []string {
+				var cmds []string
+				for _, p := range basePackages {
+					cmds = append(cmds, j.planArtifact(j.baseArtifact(p), fmt.Sprintf(sysDownloadURL, j.conf.Release, p))...)
+				}
+				return cmds
+			},
+			run: j.downloadBaseSystem,
+		},
+		{
+			name: StepExtract,
+			msg:  "extracting packages into base jail",
+			plan: func() []string {
+				var cmds []string
+				for _, p := range basePackages {
+					cmds = append(cmds, "tar -xf "+j.artifactPath(j.baseArtifact(p))+" -C "+j.releasePath())
+				}
+				return cmds
+			},
+			run: j.extractBasePkgs,
+		},
+		{
+			name: StepUpdate,
+			msg:  "updating base jail",
+			plan: func() []string {
+				return []string{"env " + strings.Join(j.updateArgs(), " ")}
+			},
+			run: j.updateBaseJail,
+		},
+		{
+			name: StepConfigure,
+			msg:  "setting base jail config",
+			plan: func() []string {
+				return []string{"write " + j.releasePath() + "/etc/resolv.conf"}
+			},
+			run: j.setBaseJailConf,
+		},
+		{
+			name: StepGo,
+			msg:  "installing Go",
+			plan: func() []string {
+				var cmds []string
+				for _, v := range j.goVersions() {
+					name := fmt.Sprintf(goTarballName, v)
+					cmds = append(cmds, j.planArtifact(name, fmt.Sprintf(goDownloadURL, v))...)
+					cmds = append(cmds, "tar -xzf "+j.artifactPath(name)+" -C "+j.releasePath()+goToolchainDir+v)
+				}
+				return append(cmds, "ln -sfh go"+j.conf.GoVersion+" "+j.releasePath()+goToolchainDir)
+			},
+			run: j.installGo,
+		},
+		{
+			name: StepSnapshot,
+			msg:  "creating base jail snapshot",
+			plan: func() []string {
+				return []string{"zfs snapshot " + j.releaseDataset() + "@p1"}
+			},
+			run: j.createSnapshot,
+		},
+		{
+			name: StepBuildJail,
+			msg:  "creating build jail",
+			plan: func() []string {
+				return []string{"zfs clone " + j.releaseDataset() + "@p1 " + j.conf.Filesystem.ZFSDataset + "/jails/build"}
+			},
+			run: j.createBuildJail,
+		},
+	}
+}
+
+// planArtifact describes how the named artifact would be obtained
+func (j *jailService) planArtifact(name, defaultURL string) []string {
+	path := j.artifactPath(name)
+	if j.artifactConf().Dir != "" {
+		return []string{"sha256 -c <manifest> " + path}
+	}
+	url := defaultURL
+	if mirror := j.artifactConf().MirrorURL; mirror != "" {
+		url = strings.TrimSuffix(mirror, "/") + "/" + name
+	}
+	return []string{"fetch -o " + path + " " + url, "sha256 -c <manifest> " + path}
+}
+
+// createSnapshot creates the base jail snapshot if the release
+// doesn't already have one
+func (j *jailService) createSnapshot() error {
+	if snapshot, err := j.releaseSnapshot(j.conf.Release); err == nil {
+		j.logger.Log("msg", "base jail snapshot "+snapshot+" already exists")
+		return nil
+	}
+	return j.fsService.CreateSnapshot()
+}
+
+// createBuildJail creates the build jail if it doesn't
+// already exist
+func (j *jailService) createBuildJail() error {
+	exists, err := j.fsService.DatasetExists("build")
+	if err != nil {
+		return err
+	}
+	if exists {
+		j.logger.Log("msg", "build jail already exists")
+		return nil
+	}
+	return j.CreateJail("build", false)
+}
+
+// InitializeSystem is run to make sure the systsem that will be running
+// sky-island has all of the necessary features in place and configured.
+// Every step is idempotent and its state and progress are recorded in
+// the state file so a failed run can be resumed
+func (j *jailService) InitializeSystem(opts InitOptions) error {
+	t := j.metrics.NewTiming()
+	defer t.Send("initialize_system")
+	if !opts.DryRun {
+		j.logger.Log("msg", "loading artifact manifest")
+		if err := j.loadArtifactManifest(); err != nil {
+			return err
+		}
+	}
+	return j.runSteps(j.initSteps(), opts)
+}
+
+// runSteps runs the given init steps according to the given options,
+// recording the state and progress of each in the state file
+func (j *jailService) runSteps(steps []*initStep, opts InitOptions) error {
+	start := 0
+	if opts.FromStep != "" {
+		start = -1
+		for i, s := range steps {
+			if s.name == opts.FromStep {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return fmt.Errorf("unknown init step %q", opts.FromStep)
+		}
+	}
+
+	if opts.DryRun {
+		out := opts.Out
+		if out == nil {
+			out = os.Stdout
+		}
+		for _, s := range steps[start:] {
+			fmt.Fprintf(out, "# %s: %s\n", s.name, s.msg)
+			for _, cmd := range s.plan() {
+				fmt.Fprintln(out, cmd)
+			}
+		}
+		return nil
+	}
+
+	path := j.stateFile
+	if path == "" {
+		path = InitStatePath(j.conf)
+	}
+	state := &InitState{Release: j.conf.Release}
+	if prev, err := readInitState(path); err == nil && prev.Release == j.conf.Release {
+		state = prev
+	}
+	for _, s := range steps {
+		if state.step(s.name) == nil {
+			state.Steps = append(state.Steps, &StepState{Name: s.name, Status: StatusPending})
+		}
+	}
+	tracker := &initTracker{
+		path:  path,
+		state: state,
+	}
+	j.tracker = tracker
+	defer func() { j.tracker = nil }()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if p := tracker.progress(); p != nil {
+					j.logger.Log(append([]interface{}{"msg", "init progress"}, p...)...)
+					if err := tracker.save(); err != nil {
+						j.logger.Log("error", "saving init state: "+err.Error())
+					}
+				}
+			}
+		}
+	}()
+
+	for i, s := range steps {
+		st := state.step(s.name)
+		if i < start {
+			if st.Status != StatusDone {
+				if err := tracker.setStatus(st, StatusSkipped, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if opts.Resume && st.Status == StatusDone {
+			j.logger.Log("msg", "skipping completed step", "step", s.name)
+			continue
+		}
+		j.logger.Log("msg", s.msg, "step", s.name)
+		if err := tracker.setStatus(st, StatusRunning, nil); err != nil {
+			return err
+		}
+		if err := s.run(); err != nil {
+			if serr := tracker.setStatus(st, StatusFailed, err); serr != nil {
+				j.logger.Log("error", "saving init state: "+serr.Error())
+			}
+			return fmt.Errorf("init step %s failed: %v", s.name, err)
+		}
+		if err := tracker.setStatus(st, StatusDone, nil); err != nil {
+			return err
+		}
+		j.logger.Log("msg", "completed step", "step", s.name,
+			"bytes_downloaded", st.BytesDownloaded, "files_extracted", st.FilesExtracted)
+	}
+	return nil
+}
+
+// initStepNames returns the names of the init steps in order
+func initStepNames() []string {
+	return []string{StepDataset, StepDownload, StepExtract, StepUpdate, StepConfigure, StepGo, StepSnapshot, StepBuildJail}
+}
+
+// ValidInitStep checks the given name is an init step
+func ValidInitStep(name string) error {
+	for _, n := range initStepNames() {
+		if n == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown init step %q, must be one of %s", name, strings.Join(initStepNames(), ", "))
+}
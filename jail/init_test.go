@@ -0,0 +1,229 @@
+package jail
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/mholt/archiver"
+)
+
+// newInitJailService creates a jail service recording its init
+// state in a temp dir
+func newInitJailService(t *testing.T) (*jailService, string) {
+	dir, err := ioutil.TempDir("", "init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{
+		Release:  "11.1-RELEASE",
+		StateDir: dir,
+		Jails:    &config.Jails{BaseJailDir: dir},
+	}
+	return NewJailService(conf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{}).(*jailService), dir
+}
+
+// testSteps returns init steps that record when they're run. The
+// step named in fail returns an error
+func testSteps(ran *[]string, fail string) []*initStep {
+	var steps []*initStep
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		steps = append(steps, &initStep{
+			name: name,
+			msg:  "running " + name,
+			plan: func() []string { return []string{"run " + name} },
+			run: func() error {
+				*ran = append(*ran, name)
+				if name == fail {
+					return errors.New(name + " failed")
+				}
+				return nil
+			},
+		})
+	}
+	return steps
+}
+
+// stepStatuses returns the recorded status of each step
+func stepStatuses(t *testing.T, conf *config.Config) []string {
+	state, err := ReadInitState(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, st := range state.Steps {
+		statuses = append(statuses, st.Status)
+	}
+	return statuses
+}
+
+// TestRunStepsResume verifies a failed run records its state and
+// resuming skips the steps already done
+func TestRunStepsResume(t *testing.T) {
+	j, dir := newInitJailService(t)
+	defer os.RemoveAll(dir)
+
+	var ran []string
+	if err := j.runSteps(testSteps(&ran, "b"), InitOptions{}); err == nil {
+		t.Fatal("expected error from failed step")
+	}
+	if !reflect.DeepEqual(ran, []string{"a", "b"}) {
+		t.Errorf("expected a and b to run, got %v", ran)
+	}
+	expected := []string{StatusDone, StatusFailed, StatusPending}
+	if statuses := stepStatuses(t, j.conf); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected statuses %v got %v", expected, statuses)
+	}
+
+	ran = nil
+	if err := j.runSteps(testSteps(&ran, ""), InitOptions{Resume: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ran, []string{"b", "c"}) {
+		t.Errorf("expected b and c to run, got %v", ran)
+	}
+	expected = []string{StatusDone, StatusDone, StatusDone}
+	if statuses := stepStatuses(t, j.conf); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected statuses %v got %v", expected, statuses)
+	}
+}
+
+// TestRunStepsFromStep verifies steps before the given step are skipped
+func TestRunStepsFromStep(t *testing.T) {
+	j, dir := newInitJailService(t)
+	defer os.RemoveAll(dir)
+
+	var ran []string
+	if err := j.runSteps(testSteps(&ran, ""), InitOptions{FromStep: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ran, []string{"b", "c"}) {
+		t.Errorf("expected b and c to run, got %v", ran)
+	}
+	expected := []string{StatusSkipped, StatusDone, StatusDone}
+	if statuses := stepStatuses(t, j.conf); !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected statuses %v got %v", expected, statuses)
+	}
+	if err := j.runSteps(testSteps(&ran, ""), InitOptions{FromStep: "z"}); err == nil {
+		t.Error("expected error for unknown step")
+	}
+}
+
+// TestRunStepsDryRun verifies a dry run prints the plan of each
+// step without running it or recording any state
+func TestRunStepsDryRun(t *testing.T) {
+	j, dir := newInitJailService(t)
+	defer os.RemoveAll(dir)
+
+	var ran []string
+	var out bytes.Buffer
+	if err := j.runSteps(testSteps(&ran, ""), InitOptions{DryRun: true, FromStep: "b", Out: &out}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("expected no steps to run, got %v", ran)
+	}
+	if !strings.Contains(out.String(), "run b\n") || strings.Contains(out.String(), "run a\n") {
+		t.Errorf("unexpected dry run output %q", out.String())
+	}
+	if utils.Exists(InitStatePath(j.conf)) {
+		t.Error("expected no state file from dry run")
+	}
+}
+
+// TestInitSteps verifies the init steps are in the documented order
+func TestInitSteps(t *testing.T) {
+	j, dir := newInitJailService(t)
+	defer os.RemoveAll(dir)
+
+	var names []string
+	for _, s := range j.initSteps() {
+		names = append(names, s.name)
+	}
+	if !reflect.DeepEqual(names, initStepNames()) {
+		t.Errorf("expected steps %v got %v", initStepNames(), names)
+	}
+	if err := ValidInitStep(StepGo); err != nil {
+		t.Error(err)
+	}
+	if err := ValidInitStep("nope"); err == nil {
+		t.Error("expected error for unknown step")
+	}
+}
+
+// TestExtractTxz verifies archives are extracted over existing files
+// and each file counts towards the init progress
+func TestExtractTxz(t *testing.T) {
+	j, dir := newInitJailService(t)
+	defer os.RemoveAll(dir)
+
+	var tb bytes.Buffer
+	tw := tar.NewWriter(&tb)
+	entries := []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/motd", Typeflag: tar.TypeReg, Mode: 0644, Size: 5},
+		{Name: "etc/motd.link", Typeflag: tar.TypeSymlink, Linkname: "motd"},
+		{Name: "bin/su", Typeflag: tar.TypeReg, Mode: 04555, Size: 5},
+	}
+	for _, hdr := range entries {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("hello"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "base.txz")
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archiver.NewXz().Compress(&tb, out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	st := &StepState{Name: StepExtract}
+	j.tracker = &initTracker{
+		path:  InitStatePath(j.conf),
+		state: &InitState{Steps: []*StepState{st}},
+		cur:   st,
+	}
+	dst := filepath.Join(dir, "root")
+	for i := 0; i < 2; i++ {
+		if err := j.extractTxz(archive, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dst, "etc", "motd.link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("expected hello got %q", b)
+	}
+	fi, err := os.Stat(filepath.Join(dst, "bin", "su"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("expected setuid bit to be kept, got %v", fi.Mode())
+	}
+	if st.FilesExtracted != 8 {
+		t.Errorf("expected 8 files extracted got %d", st.FilesExtracted)
+	}
+}
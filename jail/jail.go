@@ -2,18 +2,23 @@ package jail
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/briandowns/sky-island/config"
 	"github.com/briandowns/sky-island/filesystem"
+	"github.com/briandowns/sky-island/metrics"
 	"github.com/briandowns/sky-island/utils"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 const (
@@ -23,15 +28,25 @@ const (
 
 const rcConf = "/etc/rc.conf"
 
+// SecretsDir is where secret files are mounted inside a jail
+const SecretsDir = "/run/secrets"
+
+// jailIDRegexp matches the ids function jails are named with
+var jailIDRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
 var basePackages = []string{"base.txz", "lib32.txz", "ports.txz"}
 
 // JailServicer defines the behavior of the Jail service
 type JailServicer interface {
-	InitializeSystem() error
+	InitializeSystem(InitOptions) error
 	CreateJail(string, bool) error
+	CreateFunctionJail(string, *JailSpec) error
 	RemoveJail(string) error
 	KillJail(int) error
 	JailDetails(int) (*JLS, error)
+	MountSecrets(string, map[string][]byte) error
+	UnmountSecrets(string) error
+	Orphans(time.Duration) ([]string, error)
 }
 
 // jailService holds the state of the service
@@ -39,25 +54,54 @@ type jailService struct {
 	logger    gklog.Logger
 	conf      *config.Config
 	hc        *http.Client
-	metrics   *statsd.Client
+	metrics   *metrics.Metrics
 	fsService filesystem.FSServicer
 	wrapper   utils.Wrapper
+	manifest  manifest
+	tracker   *initTracker
+	stateFile string
+	layers    *layerLocks
+}
+
+// JailSpec describes what a function jail is created from
+type JailSpec struct {
+	// Release is cloned from, the active release if not set
+	Release string
+
+	// Packages are installed in a cached layer the jail is
+	// cloned from instead of the release
+	Packages []string
+
+	// Limits are applied to the jail if set
+	Limits *Resources
+}
+
+// Resources are the rctl(8) resource limits of a jail. Memory is a
+// size such as 256M and CPU a percentage of a single CPU. Unset
+// fields aren't limited
+type Resources struct {
+	Memory  string
+	CPU     int
+	MaxProc int
 }
 
 // NewJailService creates a new value of type jailService pointer
-func NewJailService(conf *config.Config, l gklog.Logger, m *statsd.Client, w utils.Wrapper) JailServicer {
+func NewJailService(conf *config.Config, l gklog.Logger, m *metrics.Metrics, w utils.Wrapper) JailServicer {
 	return &jailService{
 		logger: l,
 		conf:   conf,
 		hc: &http.Client{
 			Timeout: time.Second * 300,
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: conf.Artifacts != nil && conf.Artifacts.TLSSkipVerify,
+				},
 			},
 		},
 		metrics:   m,
 		fsService: filesystem.NewFilesystemService(conf, l, m, w),
 		wrapper:   w,
+		layers:    newLayerLocks(),
 	}
 }
 
@@ -73,49 +117,32 @@ func (j *jailService) configureJailHostname(name string) error {
 	return nil
 }
 
-// InitializeSystem is run to make sure the systsem that will be running
-// sky-island has all of the necessary features in place and configured
-func (j *jailService) InitializeSystem() error {
-	t := j.metrics.NewTiming()
-	defer t.Send("initialize_system")
-	j.logger.Log("msg", "creating ZFS dataset")
-	if err := j.fsService.CreateDataset(); err != nil {
-		return err
-	}
-	j.logger.Log("msg", "downloading base system")
-	if err := j.downloadBaseSystem(); err != nil {
-		return err
-	}
-	j.logger.Log("msg", "extracting packages into base jail")
-	if err := j.extractBasePkgs(); err != nil {
-		return err
-	}
-	j.logger.Log("msg", "updating base jail")
-	if err := j.updateBaseJail(); err != nil {
-		return err
-	}
-	j.logger.Log("msg", "setting base jail config")
-	if err := j.setBaseJailConf(); err != nil {
-		return err
-	}
-	j.logger.Log("msg", "installing Go")
-	if err := j.installGo(); err != nil {
-		return err
-	}
-	j.logger.Log("msg", "creating base jail snapshot")
-	if err := j.fsService.CreateSnapshot(); err != nil {
-		return err
+// CreateJail creates a jail with a name of the given name from
+// the active release and sets resource limits
+func (j *jailService) CreateJail(name string, sl bool) error {
+	spec := &JailSpec{}
+	if sl {
+		spec.Limits = &Resources{}
 	}
-	j.logger.Log("msg", "creating build jail")
-	return j.CreateJail("build", false)
+	return j.CreateFunctionJail(name, spec)
 }
 
-// CreateJail creates a jail with a name of the given name and
-// sets resource limits
-func (j *jailService) CreateJail(name string, sl bool) error {
+// CreateFunctionJail creates a jail with a name of the given name from
+// the latest snapshot of the spec's release, or of the package layer
+// built on it if the spec has packages, and sets resource limits
+func (j *jailService) CreateFunctionJail(name string, spec *JailSpec) error {
 	t := j.metrics.NewTiming()
 	defer t.Send("create_jail_time")
-	if err := j.fsService.CloneBaseToJail(name); err != nil {
+	snapshot, err := j.releaseSnapshot(spec.Release)
+	if err != nil {
+		return err
+	}
+	if len(spec.Packages) > 0 {
+		if snapshot, err = j.layerSnapshot(snapshot, spec.Packages); err != nil {
+			return err
+		}
+	}
+	if err := j.fsService.CloneBaseToJail(name, snapshot); err != nil {
 		return err
 	}
 	f, err := os.Create(j.conf.Jails.BaseJailDir + "/" + name + rcConf)
@@ -123,18 +150,41 @@ func (j *jailService) CreateJail(name string, sl bool) error {
 		return err
 	}
 	defer f.Close()
-	if sl {
-		if err := j.applyResourceLimits(); err != nil {
+	if spec.Limits != nil {
+		if err := j.applyResourceLimits(name, spec.Limits); err != nil {
 			return err
 		}
 	}
 	f.Write([]byte(fmt.Sprintf(`hostname="%s"`, name)))
-	j.metrics.Histogram("created", 1)
+	// the DNS servers may have been reloaded since the release was
+	// snapshotted
+	if dns := j.conf.DNS(); dns != nil {
+		if err := writeResolvConf(j.conf.Jails.BaseJailDir+"/"+name+"/etc/resolv.conf", dns); err != nil {
+			return err
+		}
+	}
+	j.metrics.Inc("created")
 	return nil
 }
 
-// applyResourceLimits
-func (j *jailService) applyResourceLimits() error {
+// applyResourceLimits adds rctl rules denying the jail with the
+// given name more than the given resources
+func (j *jailService) applyResourceLimits(name string, res *Resources) error {
+	var rules []string
+	if res.Memory != "" {
+		rules = append(rules, "memoryuse:deny="+res.Memory)
+	}
+	if res.CPU > 0 {
+		rules = append(rules, "pcpu:deny="+strconv.Itoa(res.CPU))
+	}
+	if res.MaxProc > 0 {
+		rules = append(rules, "maxproc:deny="+strconv.Itoa(res.MaxProc))
+	}
+	for _, rule := range rules {
+		if out, err := j.wrapper.CombinedOutput("rctl", "-a", "jail:"+name+":"+rule); err != nil {
+			return fmt.Errorf("adding rctl rule %s: %v %s", rule, err, strings.TrimSpace(string(out)))
+		}
+	}
 	return nil
 }
 
@@ -142,11 +192,13 @@ func (j *jailService) applyResourceLimits() error {
 func (j *jailService) RemoveJail(name string) error {
 	t := j.metrics.NewTiming()
 	defer t.Send("remove_jail_time")
+	// rules are kept after a jail is removed, there may be none
+	j.wrapper.CombinedOutput("rctl", "-r", "jail:"+name)
 	if err := j.fsService.RemoveDataset(name); err != nil {
 		j.logger.Log("error", err.Error())
 		return err
 	}
-	j.metrics.Histogram("removed", 1)
+	j.metrics.Inc("removed")
 	return nil
 }
 
@@ -158,7 +210,7 @@ func (j *jailService) KillJail(id int) error {
 	if err != nil {
 		return err
 	}
-	j.metrics.Histogram("killed", 1)
+	j.metrics.Inc("killed")
 	return nil
 }
 
@@ -176,3 +228,76 @@ func (j *jailService) JailDetails(id int) (*JLS, error) {
 	}
 	return nil, fmt.Errorf("jail %d not found", id)
 }
+
+// MountSecrets mounts a tmpfs at SecretsDir in the jail with the given
+// name and writes each of the given secrets to a file named after it.
+// The tmpfs is unmounted again if a secret can't be written
+func (j *jailService) MountSecrets(name string, files map[string][]byte) error {
+	dir := j.conf.Jails.BaseJailDir + "/" + name + SecretsDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	res, err := j.wrapper.CombinedOutput("mount", "-t", "tmpfs", "-o", "mode=0700", "tmpfs", dir)
+	if err != nil {
+		return errors.New(string(res))
+	}
+	for n, v := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), v, 0400); err != nil {
+			if uerr := j.UnmountSecrets(name); uerr != nil {
+				j.logger.Log("error", "unmounting secrets: "+uerr.Error())
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmountSecrets unmounts the secrets tmpfs from the jail with the
+// given name, discarding the secret files
+func (j *jailService) UnmountSecrets(name string) error {
+	res, err := j.wrapper.CombinedOutput("umount", "-f", j.conf.Jails.BaseJailDir+"/"+name+SecretsDir)
+	if err != nil {
+		return errors.New(string(res))
+	}
+	return nil
+}
+
+// Orphans returns the ids of the function jails whose datasets were
+// left behind, e.g. by a crash, and that aren't running. Datasets
+// created less than minAge ago are skipped since the jail of an
+// invocation still being set up isn't running yet
+func (j *jailService) Orphans(minAge time.Duration) ([]string, error) {
+	datasets, err := zfsList(j.wrapper, "name,creation", "-p", "-d", "1", j.conf.Filesystem.ZFSDataset+"/jails")
+	if err != nil {
+		return nil, err
+	}
+	jls, err := JLSRun(j.wrapper)
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]bool, len(jls))
+	for _, jl := range jls {
+		running[jl.Name] = true
+	}
+	cutoff := time.Now().Add(-minAge)
+	var orphans []string
+	for _, ds := range datasets {
+		fields := strings.Fields(ds)
+		if len(fields) != 2 {
+			continue
+		}
+		id := filepath.Base(fields[0])
+		if !jailIDRegexp.MatchString(id) || running[id] {
+			continue
+		}
+		created, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("creation of %s: %v", fields[0], err)
+		}
+		if time.Unix(created, 0).After(cutoff) {
+			continue
+		}
+		orphans = append(orphans, id)
+	}
+	return orphans, nil
+}
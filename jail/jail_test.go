@@ -1,12 +1,18 @@
 package jail
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
 	"github.com/briandowns/sky-island/utils"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 var testConf = &config.Config{
@@ -19,14 +25,14 @@ var testConf = &config.Config{
 	},
 	Jails: &config.Jails{
 		BaseJailDir:            "",
-		CacheDefaultExpiration: "24h",
-		CachePurgeAfter:        "48h",
+		CacheDefaultExpiration: config.Duration(24 * time.Hour),
+		CachePurgeAfter:        config.Duration(48 * time.Hour),
 	},
 }
 
 // TestNewJailService
 func TestNewJailService(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -34,7 +40,7 @@ func TestNewJailService(t *testing.T) {
 
 // TestDownloadBaseSystem
 func TestDownloadBaseSystem(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -43,7 +49,7 @@ func TestDownloadBaseSystem(t *testing.T) {
 
 // TestExtractBasePkgs
 func TestExtractBasePkgs(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -51,7 +57,7 @@ func TestExtractBasePkgs(t *testing.T) {
 
 // TestUpdateBaseJail
 func TestUpdateBaseJail(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -59,7 +65,7 @@ func TestUpdateBaseJail(t *testing.T) {
 
 // TestSetBaseJailConf
 func TestSetBaseJailConf(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -67,7 +73,7 @@ func TestSetBaseJailConf(t *testing.T) {
 
 // TestConfigureJailHostname
 func TestConfigureJailHostname(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -75,7 +81,7 @@ func TestConfigureJailHostname(t *testing.T) {
 
 // TestDownloadGo
 func TestDownloadGo(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -83,7 +89,7 @@ func TestDownloadGo(t *testing.T) {
 
 // TestInitializeSystem
 func TestInitializeSystem(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -91,7 +97,7 @@ func TestInitializeSystem(t *testing.T) {
 
 // TestCreateJail
 func TestCreateJail(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
@@ -99,12 +105,83 @@ func TestCreateJail(t *testing.T) {
 
 // TestRemoveJail
 func TestRemoveJail(t *testing.T) {
-	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), &statsd.Client{}, utils.NoOpWrapper{})
+	jailSvc := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
 	if jailSvc == nil {
 		t.Error("expected not nil jail service")
 	}
 }
 
+// TestApplyResourceLimits verifies an rctl rule is added for each
+// limit set
+func TestApplyResourceLimits(t *testing.T) {
+	w := &fakeWrapper{out: map[string]string{"rctl -a *": ""}}
+	j := NewJailService(testConf, gklog.NewNopLogger(), metrics.Discard(), w).(*jailService)
+	if err := j.applyResourceLimits("test", &Resources{Memory: "256M", MaxProc: 32}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"rctl -a jail:test:memoryuse:deny=256M",
+		"rctl -a jail:test:maxproc:deny=32",
+	}
+	if !reflect.DeepEqual(w.ran, expected) {
+		t.Errorf("expected %v got %v", expected, w.ran)
+	}
+	if err := j.applyResourceLimits("test", &Resources{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.ran) != 2 {
+		t.Errorf("expected no rules for empty limits got %v", w.ran[2:])
+	}
+}
+
+// TestMountSecrets verifies secret files are written read only
+// into the jail's secrets directory
+func TestMountSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &config.Config{Jails: &config.Jails{BaseJailDir: dir}}
+	jailSvc := NewJailService(conf, gklog.NewNopLogger(), metrics.Discard(), utils.NoOpWrapper{})
+	if err := jailSvc.MountSecrets("test", map[string][]byte{"db_pass": []byte("hunter2")}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "test", SecretsDir, "db_pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0400 {
+		t.Errorf("expected mode 0400 got %v", fi.Mode().Perm())
+	}
+	if err := jailSvc.UnmountSecrets("test"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMountSecrets_WriteFailure verifies the secrets tmpfs is
+// unmounted when a secret can't be written
+func TestMountSecrets_WriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &config.Config{Jails: &config.Jails{BaseJailDir: dir}}
+	w := &fakeWrapper{out: map[string]string{"mount *": "", "umount *": ""}}
+	jailSvc := NewJailService(conf, gklog.NewNopLogger(), metrics.Discard(), w)
+	if err := jailSvc.MountSecrets("test", map[string][]byte{"missing/db_pass": []byte("hunter2")}); err == nil {
+		t.Fatal("expected error but received none")
+	}
+	expected := []string{
+		"mount -t tmpfs -o mode=0700 tmpfs " + filepath.Join(dir, "test", SecretsDir),
+		"umount -f " + filepath.Join(dir, "test", SecretsDir),
+	}
+	if !reflect.DeepEqual(w.ran, expected) {
+		t.Errorf("expected %v got %v", expected, w.ran)
+	}
+}
+
 var testJLSData = `devfs_ruleset=0 enforce_statfs=2 host=new ip4=disable ip6=disable jid=67 name=f7bb0cf4-caf2-11e7-ac45-0800279d94cc osreldate=1101001 osrelease=11.1-RELEASE path=/zroot/jails/f7bb0cf4-caf2-11e7-ac45-0800279d94cc persist securelevel=-1 sysvmsg=disable sysvsem=disable sysvshm=disable allow.nochflags allow.nomount allow.mount.nodevfs allow.mount.nofdescfs allow.mount.nolinprocfs allow.mount.nolinsysfs allow.mount.nonullfs allow.mount.noprocfs allow.mount.notmpfs allow.mount.nozfs allow.noquotas allow.noraw_sockets allow.set_hostname allow.nosocket_af allow.nosysvipc children.max=0 host.domainname="" host.hostid=0 host.hostname=f7bb0cf4-caf2-11e7-ac45-0800279d94cc host.hostuuid=00000000-0000-0000-0000-000000000000
 devfs_ruleset=0 enforce_statfs=2 host=new ip4=disable ip6=disable jid=67 name=f7bb0cf4-caf2-11e7-ac45-0800279d94cc osreldate=1101001 osrelease=11.1-RELEASE path=/zroot/jails/f7bb0cf4-caf2-11e7-ac45-0800279d94cc persist securelevel=-1 sysvmsg=disable sysvsem=disable sysvshm=disable allow.nochflags allow.nomount allow.mount.nodevfs allow.mount.nofdescfs allow.mount.nolinprocfs allow.mount.nolinsysfs allow.mount.nonullfs allow.mount.noprocfs allow.mount.notmpfs allow.mount.nozfs allow.noquotas allow.noraw_sockets allow.set_hostname allow.nosocket_af allow.nosysvipc children.max=0 host.domainname="" host.hostid=0 host.hostname=f7bb0cf4-caf2-11e7-ac45-0800279d94cc host.hostuuid=00000000-0000-0000-0000-000000000000`
 
@@ -196,3 +273,25 @@ func TestJLSRun_Success(t *testing.T) {
   "working_dir": "/data"
 }
 */
+
+// TestOrphans verifies only uuid named datasets without a running jail
+// and older than the minimum age are returned
+func TestOrphans(t *testing.T) {
+	running := "3d8f5c1e-0b5a-4d8e-9b1f-6a3c2e7d9f10"
+	orphan := "7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	recent := "0f9e8d7c-6b5a-4948-8372-615f4e3d2c1b"
+	old := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	w := &fakeWrapper{out: map[string]string{
+		"zfs list -H -o name,creation -p -d 1 zroot/jails": "zroot/jails\t" + old + "\nzroot/jails/build\t" + old + "\nzroot/jails/releases\t" + old + "\nzroot/jails/" + running + "\t" + old + "\nzroot/jails/" + orphan + "\t" + old + "\nzroot/jails/" + recent + "\t" + now + "\n",
+		"jls -s": "jid=4 name=" + running + " path=/zroot/jails/" + running + "\n",
+	}}
+	conf := &config.Config{Filesystem: &config.Filesystem{ZFSDataset: "zroot"}}
+	orphans, err := NewJailService(conf, gklog.NewNopLogger(), metrics.Discard(), w).Orphans(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(orphans, []string{orphan}) {
+		t.Errorf("expected [%s] got %v", orphan, orphans)
+	}
+}
@@ -75,3 +75,17 @@ func unmarshalJLS(data []byte) (*JLS, error) {
 	}
 	return &j, nil
 }
+
+// LoadAverage returns the host's one minute load average
+func LoadAverage(w utils.Wrapper) (float64, error) {
+	res, err := w.CombinedOutput("sysctl", "-n", "vm.loadavg")
+	if err != nil {
+		return 0, errors.New(string(res))
+	}
+	// the output looks like "{ 0.52 0.41 0.38 }"
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(string(res)), "{}"))
+	if len(fields) == 0 {
+		return 0, errors.New("unexpected vm.loadavg " + string(res))
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,167 @@
+package jail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// layerSnapshotName is the name of the snapshot of a built
+// package layer jails are cloned from
+const layerSnapshotName = "layer"
+
+// ZFS user properties recording what a package layer holds
+const (
+	layerPackagesProp = "sky-island:packages"
+	layerBaseProp     = "sky-island:base"
+)
+
+// packageRegexp matches package names and origins, e.g. "curl",
+// "py311-requests" or "ftp/curl"
+var packageRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*(/[a-zA-Z0-9][a-zA-Z0-9._+-]*)?$`)
+
+// ValidPackages checks the given package names are safe to pass to pkg
+func ValidPackages(pkgs []string) error {
+	for _, p := range pkgs {
+		if !packageRegexp.MatchString(p) {
+			return fmt.Errorf("invalid package %q", p)
+		}
+	}
+	return nil
+}
+
+// packageSet returns the sorted, deduplicated package set
+func packageSet(pkgs []string) []string {
+	seen := make(map[string]bool, len(pkgs))
+	set := make([]string, 0, len(pkgs))
+	for _, p := range pkgs {
+		if !seen[p] {
+			seen[p] = true
+			set = append(set, p)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+// layerHash returns the hash identifying the layer of the given
+// package set built on the given base snapshot
+func layerHash(base string, set []string) string {
+	h := sha256.New()
+	h.Write([]byte(base + "\n" + strings.Join(set, "\n")))
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// layerLocks holds a lock per package layer being looked up or built
+// so only jails needing the same layer wait on each other
+type layerLocks struct {
+	mu    sync.Mutex
+	locks map[string]*layerLock
+}
+
+// layerLock is the lock of a layer with the number of callers holding
+// or waiting for it
+type layerLock struct {
+	sync.Mutex
+	refs int
+}
+
+// newLayerLocks creates a new value of type layerLocks pointer
+func newLayerLocks() *layerLocks {
+	return &layerLocks{locks: make(map[string]*layerLock)}
+}
+
+// lock locks the layer with the given hash and returns the func
+// unlocking it. The lock is dropped once no caller needs it
+func (l *layerLocks) lock(hash string) func() {
+	l.mu.Lock()
+	lk, ok := l.locks[hash]
+	if !ok {
+		lk = &layerLock{}
+		l.locks[hash] = lk
+	}
+	lk.refs++
+	l.mu.Unlock()
+
+	lk.Lock()
+	return func() {
+		lk.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if lk.refs--; lk.refs == 0 {
+			delete(l.locks, hash)
+		}
+	}
+}
+
+// layerSnapshot returns the snapshot of the layer with the given
+// packages installed on the given base snapshot, building the layer
+// if it doesn't exist yet. A layer is a clone of the base with the
+// packages installed by pkg(8), snapshotted once built so every jail
+// needing the same packages on the same base is cloned from it
+func (j *jailService) layerSnapshot(base string, pkgs []string) (string, error) {
+	if err := ValidPackages(pkgs); err != nil {
+		return "", err
+	}
+	set := packageSet(pkgs)
+	hash := layerHash(base, set)
+	dataset := j.layerDataset(hash)
+	snapshot := dataset + "@" + layerSnapshotName
+
+	defer j.layers.lock(hash)()
+	existing, err := zfsList(j.wrapper, "name", "-t", "snapshot", snapshot)
+	if err != nil {
+		return "", err
+	}
+	if len(existing) > 0 {
+		return snapshot, nil
+	}
+
+	t := j.metrics.NewTiming()
+	defer t.Send("layer.build_time")
+	j.logger.Log("msg", "building package layer", "layer", hash, "packages", strings.Join(set, ","))
+	if err := j.buildLayer(base, hash, set); err != nil {
+		if out, derr := j.wrapper.CombinedOutput("zfs", "destroy", "-r", dataset); derr != nil {
+			j.logger.Log("error", "removing failed layer: "+strings.TrimSpace(string(out)))
+		}
+		return "", fmt.Errorf("building package layer %s: %v", hash, err)
+	}
+	return snapshot, nil
+}
+
+// layerDataset returns the dataset of the layer with the given hash
+func (j *jailService) layerDataset(hash string) string {
+	return j.conf.Filesystem.ZFSDataset + "/jails/layers/" + hash
+}
+
+// buildLayer clones the given base, installs the package set into the
+// clone, records the package set and base as user properties of the
+// layer and snapshots it
+func (j *jailService) buildLayer(base, hash string, set []string) error {
+	dataset := j.layerDataset(hash)
+	path := j.conf.Jails.BaseJailDir + "/layers/" + hash
+	cmds := [][]string{
+		{"zfs", "create", "-p", j.conf.Filesystem.ZFSDataset + "/jails/layers"},
+		{"zfs", "destroy", "-r", dataset},
+		{"zfs", "clone", base, dataset},
+		append([]string{"env", "ASSUME_ALWAYS_YES=yes", "pkg", "-r", path, "install", "-y"}, set...),
+		{"zfs", "set", layerPackagesProp + "=" + strings.Join(set, ","), dataset},
+		{"zfs", "set", layerBaseProp + "=" + base, dataset},
+		{"zfs", "snapshot", dataset + "@" + layerSnapshotName},
+	}
+	for i, cmd := range cmds {
+		out, err := j.wrapper.CombinedOutput(cmd[0], cmd[1:]...)
+		if err != nil {
+			// a partial layer left by an earlier failure may not exist
+			if i == 1 && strings.Contains(string(out), "does not exist") {
+				continue
+			}
+			return fmt.Errorf("%s: %v %s", strings.Join(cmd, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
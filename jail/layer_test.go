@@ -0,0 +1,139 @@
+package jail
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBase = "zroot/jails/releases/11.1-RELEASE@p1"
+
+// TestLayerHash verifies the layer hash depends on the package set
+// and base but not the order or duplicates of the packages
+func TestLayerHash(t *testing.T) {
+	a := layerHash(testBase, packageSet([]string{"jq", "curl", "jq"}))
+	b := layerHash(testBase, packageSet([]string{"curl", "jq"}))
+	if a != b {
+		t.Errorf("expected equal hashes got %s and %s", a, b)
+	}
+	if c := layerHash("zroot/jails/releases/11.1-RELEASE@p2", packageSet([]string{"curl", "jq"})); c == a {
+		t.Error("expected a different base to change the hash")
+	}
+	if err := ValidPackages([]string{"curl", "ftp/curl", "py311-requests"}); err != nil {
+		t.Error(err)
+	}
+	for _, p := range []string{"-y", "curl; rm -rf /", "../x", ""} {
+		if err := ValidPackages([]string{p}); err == nil {
+			t.Errorf("expected %q to be invalid", p)
+		}
+	}
+}
+
+// TestLayerSnapshotBuild verifies a missing layer is cloned from the
+// base, has its packages installed and recorded, and is snapshotted
+func TestLayerSnapshotBuild(t *testing.T) {
+	hash := layerHash(testBase, []string{"curl", "jq"})
+	layer := "zroot/jails/layers/" + hash
+	w := &fakeWrapper{out: map[string]string{
+		"zfs create -p zroot/jails/layers":                  "",
+		"zfs clone " + testBase + " " + layer:               "",
+		"env ASSUME_ALWAYS_YES=yes pkg *":                   "",
+		"zfs set sky-island:packages=curl,jq " + layer:      "",
+		"zfs set sky-island:base=" + testBase + " " + layer: "",
+		"zfs snapshot " + layer + "@layer":                  "",
+	}}
+	r, dir := newReleaseTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	snap, err := r.jsvc.layerSnapshot(testBase, []string{"jq", "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap != layer+"@layer" {
+		t.Errorf("expected %s@layer got %s", layer, snap)
+	}
+	expected := []string{
+		"zfs list -H -o name -t snapshot " + layer + "@layer",
+		"zfs create -p zroot/jails/layers",
+		"zfs destroy -r " + layer,
+		"zfs clone " + testBase + " " + layer,
+		"env ASSUME_ALWAYS_YES=yes pkg -r " + dir + "/layers/" + hash + " install -y curl jq",
+		"zfs set sky-island:packages=curl,jq " + layer,
+		"zfs set sky-island:base=" + testBase + " " + layer,
+		"zfs snapshot " + layer + "@layer",
+	}
+	if !reflect.DeepEqual(w.ran, expected) {
+		t.Errorf("expected commands\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(w.ran, "\n"))
+	}
+}
+
+// TestLayerSnapshotCached verifies an existing layer is reused
+func TestLayerSnapshotCached(t *testing.T) {
+	layer := "zroot/jails/layers/" + layerHash(testBase, []string{"curl"})
+	w := &fakeWrapper{out: map[string]string{
+		"zfs list -H -o name -t snapshot " + layer + "@layer": layer + "@layer\n",
+	}}
+	r, dir := newReleaseTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	snap, err := r.jsvc.layerSnapshot(testBase, []string{"curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap != layer+"@layer" || len(w.ran) != 1 {
+		t.Errorf("expected cached layer got %s after %v", snap, w.ran)
+	}
+}
+
+// TestLayerSnapshotFailure verifies a layer whose packages fail to
+// install is destroyed
+func TestLayerSnapshotFailure(t *testing.T) {
+	layer := "zroot/jails/layers/" + layerHash(testBase, []string{"nope"})
+	w := &fakeWrapper{out: map[string]string{
+		"zfs create -p zroot/jails/layers":    "",
+		"zfs clone " + testBase + " " + layer: "",
+	}}
+	r, dir := newReleaseTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	if _, err := r.jsvc.layerSnapshot(testBase, []string{"nope"}); err == nil {
+		t.Fatal("expected error from failed install")
+	}
+	if last := w.ran[len(w.ran)-1]; last != "zfs destroy -r "+layer {
+		t.Errorf("expected failed layer to be destroyed, last command %s", last)
+	}
+}
+
+// TestLayerLocks verifies only callers of the same layer wait on each
+// other and that released locks are dropped
+func TestLayerLocks(t *testing.T) {
+	l := newLayerLocks()
+	unlockA := l.lock("a")
+	l.lock("b")()
+
+	locked, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		defer l.lock("a")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected the second caller of a to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlockA()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("expected the second caller of a to get the lock")
+	}
+	<-done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("expected no locks held got %d", len(l.locks))
+	}
+}
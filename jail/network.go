@@ -6,8 +6,8 @@ import (
 	"sync"
 
 	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 // NetworkServicer defines the behavior of the IP service
@@ -21,13 +21,13 @@ type NetworkServicer interface {
 type networkService struct {
 	logger  gklog.Logger
 	conf    *config.Config
-	metrics *statsd.Client
+	metrics *metrics.Metrics
 	mu      sync.Locker
 	ip4Pool map[string][]byte
 }
 
 // NewNetworkService creates a new value of type networkService pointer
-func NewNetworkService(conf *config.Config, l gklog.Logger, metrics *statsd.Client) (NetworkServicer, error) {
+func NewNetworkService(conf *config.Config, l gklog.Logger, metrics *metrics.Metrics) (NetworkServicer, error) {
 	n := networkService{
 		logger:  l,
 		conf:    conf,
@@ -38,6 +38,7 @@ func NewNetworkService(conf *config.Config, l gklog.Logger, metrics *statsd.Clie
 	if err := n.populatePool(); err != nil {
 		return nil, err
 	}
+	n.setPoolGauges()
 	return &n, nil
 }
 
@@ -69,7 +70,7 @@ func (n *networkService) Allocate(id []byte) (string, error) {
 	for k := range n.ip4Pool {
 		if n.ip4Pool[k] == nil {
 			n.ip4Pool[k] = id
-			n.metrics.Histogram(k, 1)
+			n.setPoolGauges()
 			return k, nil
 		}
 	}
@@ -81,13 +82,31 @@ func (n *networkService) Return(ip string) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.ip4Pool[ip] = nil
+	n.setPoolGauges()
 }
 
 // Pool returns the current state of the IP address pool
 func (n *networkService) Pool() map[string][]byte {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	return n.ip4Pool
+	pool := make(map[string][]byte, len(n.ip4Pool))
+	for k, v := range n.ip4Pool {
+		pool[k] = v
+	}
+	return pool
+}
+
+// setPoolGauges records how many addresses are allocated and
+// available. The caller must hold the lock
+func (n *networkService) setPoolGauges() {
+	var allocated int
+	for _, v := range n.ip4Pool {
+		if v != nil {
+			allocated++
+		}
+	}
+	n.metrics.Set("pool.allocated", float64(allocated))
+	n.metrics.Set("pool.available", float64(len(n.ip4Pool)-allocated))
 }
 
 // UpdateIPState iterates through the pool of IP addresses
@@ -100,6 +119,7 @@ func (n *networkService) UpdateIPState(ip string, state []byte) error {
 	for k := range n.ip4Pool {
 		if k == ip {
 			n.ip4Pool[k] = state
+			n.setPoolGauges()
 			return nil
 		}
 	}
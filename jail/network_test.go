@@ -4,13 +4,13 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/briandowns/sky-island/metrics"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 // TestNewNetworkService
 func TestNewNetworkService(t *testing.T) {
-	networkSvc, err := NewNetworkService(testConf, gklog.NewNopLogger(), &statsd.Client{})
+	networkSvc, err := NewNetworkService(testConf, gklog.NewNopLogger(), metrics.Discard())
 	if err != nil {
 		t.Error("expected err to be nil")
 	}
@@ -24,7 +24,7 @@ func TestPopulatePool(t *testing.T) {
 	networkSvc := &networkService{
 		logger:  gklog.NewNopLogger(),
 		conf:    testConf,
-		metrics: &statsd.Client{},
+		metrics: metrics.Discard(),
 		mu:      &sync.Mutex{},
 		ip4Pool: make(map[string][]byte),
 	}
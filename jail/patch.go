@@ -0,0 +1,426 @@
+package jail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// patchHistoryFile is the name of the file, in the state
+// directory, the patch history is recorded in
+const patchHistoryFile = "patches.json"
+
+// maxPatchHistory is the number of patch runs kept in the history
+const maxPatchHistory = 100
+
+// defaultPatchKeep is the number of versioned snapshots of a
+// release kept after patching when not configured
+const defaultPatchKeep = 3
+
+// maxPatchOutput is how much of the end of the freebsd-update
+// output is kept in the patch history
+const maxPatchOutput = 4096
+
+// canaryDir is where, relative to the patched base jail's root,
+// the canary function is built
+const canaryDir = "/tmp/canary"
+
+// canaryMain is the canary function built to validate a patched
+// base jail before it's promoted
+const canaryMain = `package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func main() {
+	fmt.Println("ok", runtime.Version())
+}
+`
+
+// freebsd-update output when there's nothing to install
+const patchUpToDateMsg = "No updates are available to install"
+
+// Patch run statuses
+const (
+	PatchRunning    = "running"
+	PatchPromoted   = "promoted"
+	PatchUpToDate   = "up_to_date"
+	PatchRolledBack = "rolled_back"
+	PatchFailed     = "failed"
+)
+
+// PatchServicer defines the behavior of the patch service
+type PatchServicer interface {
+	Patch() (*PatchRun, error)
+	Patching() bool
+	History() ([]*PatchRun, error)
+	Schedule(time.Duration, <-chan struct{})
+}
+
+// PatchRun records a run of the patch job
+type PatchRun struct {
+	ID         string    `json:"id"`
+	Release    string    `json:"release"`
+	From       string    `json:"from"`
+	Snapshot   string    `json:"snapshot,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	Pruned     []string  `json:"pruned,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// patchService holds the state of the service
+type patchService struct {
+	logger   gklog.Logger
+	conf     *config.Config
+	metrics  *metrics.Metrics
+	wrapper  utils.Wrapper
+	mu       sync.Mutex
+	patching bool
+}
+
+// NewPatchService creates a new value of type patchService pointer
+// patching the base jail of the active release
+func NewPatchService(conf *config.Config, l gklog.Logger, m *metrics.Metrics, w utils.Wrapper) PatchServicer {
+	return &patchService{
+		logger:  l,
+		conf:    conf,
+		metrics: m,
+		wrapper: w,
+	}
+}
+
+// historyPath returns the path of the patch history file
+func (p *patchService) historyPath() string {
+	return filepath.Join(filepath.Dir(releaseStatePath(p.conf)), patchHistoryFile)
+}
+
+// History returns the recorded patch runs, most recent first
+func (p *patchService) History() ([]*PatchRun, error) {
+	b, err := ioutil.ReadFile(p.historyPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*PatchRun{}, nil
+		}
+		return nil, err
+	}
+	var runs []*PatchRun
+	if err := json.Unmarshal(b, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// record adds the given run to the front of the patch history
+func (p *patchService) record(run *PatchRun) error {
+	runs, err := p.History()
+	if err != nil {
+		return err
+	}
+	runs = append([]*PatchRun{run}, runs...)
+	if len(runs) > maxPatchHistory {
+		runs = runs[:maxPatchHistory]
+	}
+	b, err := json.MarshalIndent(runs, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := p.historyPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Patching returns whether a patch run is in progress
+func (p *patchService) Patching() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.patching
+}
+
+// Schedule runs the patch job every interval until stop is closed
+func (p *patchService) Schedule(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := p.Patch(); err != nil {
+				p.logger.Log("error", err.Error())
+			}
+		}
+	}
+}
+
+// zfs runs the given zfs command
+func (p *patchService) zfs(args ...string) error {
+	out, err := p.wrapper.CombinedOutput("zfs", args...)
+	if err != nil {
+		return fmt.Errorf("zfs %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Patch clones the latest snapshot of the active release, runs
+// freebsd-update on the clone and builds the canary function in it.
+// If that succeeds the clone is promoted to the release dataset and
+// snapshotted with the next version, otherwise it's rolled back.
+// The run is recorded in the patch history
+func (p *patchService) Patch() (*PatchRun, error) {
+	t := p.metrics.NewTiming()
+	defer t.Send("patch.run_time")
+	p.mu.Lock()
+	if p.patching {
+		p.mu.Unlock()
+		return nil, errors.New("patch already in progress")
+	}
+	p.patching = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.patching = false
+		p.mu.Unlock()
+	}()
+
+	run := &PatchRun{
+		ID:        time.Now().UTC().Format("20060102T150405Z"),
+		Release:   readActiveRelease(p.conf),
+		Status:    PatchRunning,
+		StartedAt: time.Now().UTC(),
+	}
+	p.logger.Log("msg", "patching release "+run.Release)
+	err := p.patch(run)
+	run.FinishedAt = time.Now().UTC()
+	if err != nil {
+		run.Error = err.Error()
+		p.metrics.Inc("failed")
+	}
+	p.logger.Log("msg", "patch run finished", "release", run.Release, "status", run.Status)
+	if rerr := p.record(run); rerr != nil {
+		p.logger.Log("error", rerr.Error())
+	}
+	return run, err
+}
+
+// patch runs the steps of the given patch run, undoing the steps
+// already taken if a later one fails
+func (p *patchService) patch(run *PatchRun) error {
+	if err := validRelease(run.Release); err != nil {
+		run.Status = PatchFailed
+		return err
+	}
+	dataset := releasesDataset(p.conf) + "/" + run.Release
+	snapshots, err := zfsList(p.wrapper, "name", "-t", "snapshot", "-d", "1", dataset)
+	if err != nil {
+		run.Status = PatchFailed
+		return err
+	}
+	run.From = latestSnapshot(snapshots)
+	if run.From == "" {
+		run.Status = PatchFailed
+		return fmt.Errorf("no snapshot found for release %s", run.Release)
+	}
+	staging := p.conf.Filesystem.ZFSDataset + "/jails/patch-" + run.Release
+	previous := staging + "-prev"
+	stagingPath := p.conf.Jails.BaseJailDir + "/patch-" + run.Release
+
+	// a leftover previous dataset means a run was interrupted while
+	// the release dataset was being swapped and needs fixing by hand
+	if exists, _ := zfsList(p.wrapper, "name", previous); len(exists) > 0 {
+		run.Status = PatchFailed
+		return fmt.Errorf("dataset %s left by an interrupted patch run", previous)
+	}
+	if exists, _ := zfsList(p.wrapper, "name", staging); len(exists) > 0 {
+		p.logger.Log("msg", "destroying leftover dataset "+staging)
+		if err := p.zfs("destroy", "-r", staging); err != nil {
+			run.Status = PatchFailed
+			return err
+		}
+	}
+
+	var undo []func() error
+	fail := func(err error) error {
+		run.Status = PatchRolledBack
+		for i := len(undo) - 1; i >= 0; i-- {
+			if uerr := undo[i](); uerr != nil {
+				p.logger.Log("error", "patch rollback failed: "+uerr.Error())
+				run.Status = PatchFailed
+			}
+		}
+		return err
+	}
+
+	if err := p.zfs("clone", run.From, staging); err != nil {
+		run.Status = PatchFailed
+		return err
+	}
+	undo = append(undo, func() error { return p.zfs("destroy", "-r", staging) })
+
+	out, err := p.wrapper.CombinedOutput("env", "UNAME_r="+run.Release, "freebsd-update", "-b", stagingPath, "--not-running-from-cron", "fetch", "install")
+	run.Output = string(out)
+	if len(run.Output) > maxPatchOutput {
+		run.Output = run.Output[len(run.Output)-maxPatchOutput:]
+	}
+	if err != nil {
+		return fail(fmt.Errorf("freebsd-update failed: %v", err))
+	}
+	if strings.Contains(string(out), patchUpToDateMsg) {
+		run.Status = PatchUpToDate
+		return p.zfs("destroy", "-r", staging)
+	}
+
+	if err := p.canary(stagingPath); err != nil {
+		return fail(err)
+	}
+
+	// Promoting the clone moves the release's snapshots to it, so
+	// jails already cloned from them are unaffected, then the clone
+	// takes the release dataset's name
+	if err := p.zfs("promote", staging); err != nil {
+		return fail(err)
+	}
+	undo = append(undo, func() error { return p.zfs("promote", dataset) })
+	if err := p.zfs("rename", dataset, previous); err != nil {
+		return fail(err)
+	}
+	undo = append(undo, func() error { return p.zfs("rename", previous, dataset) })
+	if err := p.zfs("rename", staging, dataset); err != nil {
+		return fail(err)
+	}
+	undo = append(undo, func() error { return p.zfs("rename", dataset, staging) })
+	snapshot := nextSnapshot(dataset, snapshots)
+	if err := p.zfs("snapshot", snapshot); err != nil {
+		return fail(err)
+	}
+	run.Snapshot = snapshot
+	run.Status = PatchPromoted
+
+	if err := p.zfs("destroy", "-r", previous); err != nil {
+		p.logger.Log("error", err.Error())
+	}
+	pruned, err := p.rotate(dataset, append(snapshots, snapshot))
+	run.Pruned = pruned
+	if err != nil {
+		p.logger.Log("error", err.Error())
+	}
+	return nil
+}
+
+// canary builds and runs the canary function in a jail on the
+// base jail at the given path
+func (p *patchService) canary(root string) error {
+	if err := os.MkdirAll(root+canaryDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(root + canaryDir)
+	if err := ioutil.WriteFile(root+canaryDir+"/main.go", []byte(canaryMain), 0644); err != nil {
+		return err
+	}
+	args := []string{
+		"-c",
+		"-n", "patchcanary",
+		"path=" + root,
+		"host.hostname=patchcanary",
+		"mount.devfs",
+		"ip4=disable",
+	}
+	build := append(append([]string{}, args...), "command=/usr/bin/env", "HOME="+canaryDir, "GOCACHE="+canaryDir+"/cache", "GO111MODULE=off",
+		goToolchainDir+"/bin/go", "build", "-o", canaryDir+"/canary", canaryDir+"/main.go")
+	js := p.conf.JailSettings()
+	buildTimeout, execTimeout := js.BuildTimeout.D(), js.ExecTimeout.D()
+	if buildTimeout == 0 {
+		buildTimeout = config.DefaultBuildTimeout.D()
+	}
+	if execTimeout == 0 {
+		execTimeout = config.DefaultExecTimeout.D()
+	}
+	if out, err := p.canaryJail(buildTimeout, build); err != nil {
+		return fmt.Errorf("canary build failed: %v %s", err, out)
+	}
+	out, err := p.canaryJail(execTimeout, append(append([]string{}, args...), "command="+canaryDir+"/canary"))
+	if err != nil {
+		return fmt.Errorf("canary run failed: %v %s", err, out)
+	}
+	if !strings.HasPrefix(string(out), "ok") {
+		return fmt.Errorf("canary run failed: unexpected output %q", out)
+	}
+	return nil
+}
+
+// canaryJail creates the canary jail with the given args, removing it
+// if its command doesn't finish within the timeout
+func (p *patchService) canaryJail(timeout time.Duration, args []string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := p.wrapper.CombinedOutput("jail", args...)
+		done <- result{out: out, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.out, res.err
+	case <-ctx.Done():
+		if out, err := p.wrapper.CombinedOutput("jail", "-r", "patchcanary"); err != nil {
+			p.logger.Log("error", "removing canary jail: "+strings.TrimSpace(string(out)))
+		}
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// rotate destroys the versioned snapshots of the given dataset older
+// than the configured number to keep that no jails are cloned from
+func (p *patchService) rotate(dataset string, snapshots []string) ([]string, error) {
+	keep := defaultPatchKeep
+	if p.conf.Patching != nil && p.conf.Patching.Keep > 0 {
+		keep = p.conf.Patching.Keep
+	}
+	latest, _ := snapshotVersion(latestSnapshot(snapshots))
+	values, err := zfsList(p.wrapper, "origin", "-t", "filesystem", "-r", p.conf.Filesystem.ZFSDataset+"/jails")
+	if err != nil {
+		return nil, err
+	}
+	origins := make(map[string]bool, len(values))
+	for _, v := range values {
+		origins[v] = true
+	}
+	var pruned []string
+	for _, s := range snapshots {
+		v, ok := snapshotVersion(s)
+		if !ok || v > latest-keep || origins[s] {
+			continue
+		}
+		if err := p.zfs("destroy", s); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, s)
+	}
+	return pruned, nil
+}
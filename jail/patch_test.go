@@ -0,0 +1,173 @@
+package jail
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// patchCommands returns canned output for a patch run of 11.1-RELEASE
+// from p2 with the given freebsd-update and canary output
+func patchCommands(update, canary string) map[string]string {
+	return map[string]string{
+		"zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/11.1-RELEASE":           "zroot/jails/releases/11.1-RELEASE@p1\nzroot/jails/releases/11.1-RELEASE@p2\n",
+		"zfs clone zroot/jails/releases/11.1-RELEASE@p2 zroot/jails/patch-11.1-RELEASE":    "",
+		"env UNAME_r=11.1-RELEASE freebsd-update *":                                        update,
+		"jail -c -n patchcanary *":                                                         canary,
+		"zfs destroy -r zroot/jails/patch-11.1-RELEASE":                                    "",
+		"zfs promote zroot/jails/patch-11.1-RELEASE":                                       "",
+		"zfs rename zroot/jails/releases/11.1-RELEASE zroot/jails/patch-11.1-RELEASE-prev": "",
+		"zfs rename zroot/jails/patch-11.1-RELEASE zroot/jails/releases/11.1-RELEASE":      "",
+		"zfs snapshot zroot/jails/releases/11.1-RELEASE@p3":                                "",
+		"zfs destroy -r zroot/jails/patch-11.1-RELEASE-prev":                               "",
+		"zfs list -H -o origin -t filesystem -r zroot/jails":                               "-\nzroot/jails/releases/11.1-RELEASE@p2\n",
+		"zfs destroy zroot/jails/releases/11.1-RELEASE@p1":                                 "",
+	}
+}
+
+// newPatchTestService creates a patch service with the given fake
+// wrapper, recording its history in a temp dir
+func newPatchTestService(t *testing.T, w *fakeWrapper) (*patchService, string) {
+	r, dir := newReleaseTestService(t, w)
+	r.conf.Patching = &config.Patching{Keep: 2}
+	return NewPatchService(r.conf, gklog.NewNopLogger(), metrics.Discard(), w).(*patchService), dir
+}
+
+// ran reports whether the given command was run
+func ran(w *fakeWrapper, cmd string) bool {
+	for _, c := range w.ran {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// TestPatchPromoted verifies a patched clone that passes the canary
+// is promoted to the next snapshot and old snapshots are rotated
+func TestPatchPromoted(t *testing.T) {
+	w := &fakeWrapper{out: patchCommands("Installing updates... done.\n", "ok go1.9.2\n")}
+	p, dir := newPatchTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	run, err := p.Patch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != PatchPromoted || run.Snapshot != "zroot/jails/releases/11.1-RELEASE@p3" {
+		t.Errorf("unexpected run %+v", run)
+	}
+	if !reflect.DeepEqual(run.Pruned, []string{"zroot/jails/releases/11.1-RELEASE@p1"}) {
+		t.Errorf("expected p1 pruned got %v", run.Pruned)
+	}
+	var swaps []string
+	for _, c := range w.ran {
+		if strings.HasPrefix(c, "zfs promote") || strings.HasPrefix(c, "zfs rename") || strings.HasPrefix(c, "zfs snapshot") {
+			swaps = append(swaps, c)
+		}
+	}
+	expected := []string{
+		"zfs promote zroot/jails/patch-11.1-RELEASE",
+		"zfs rename zroot/jails/releases/11.1-RELEASE zroot/jails/patch-11.1-RELEASE-prev",
+		"zfs rename zroot/jails/patch-11.1-RELEASE zroot/jails/releases/11.1-RELEASE",
+		"zfs snapshot zroot/jails/releases/11.1-RELEASE@p3",
+	}
+	if !reflect.DeepEqual(swaps, expected) {
+		t.Errorf("expected %v got %v", expected, swaps)
+	}
+	history, err := p.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Status != PatchPromoted {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
+
+// TestPatchRollback verifies a patched clone that fails the canary
+// is destroyed and nothing is promoted
+func TestPatchRollback(t *testing.T) {
+	w := &fakeWrapper{out: patchCommands("Installing updates... done.\n", "panic: runtime error\n")}
+	p, dir := newPatchTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	run, err := p.Patch()
+	if err == nil {
+		t.Fatal("expected canary failure")
+	}
+	if run.Status != PatchRolledBack || run.Snapshot != "" {
+		t.Errorf("unexpected run %+v", run)
+	}
+	if !ran(w, "zfs destroy -r zroot/jails/patch-11.1-RELEASE") {
+		t.Error("expected clone to be destroyed")
+	}
+	if ran(w, "zfs promote zroot/jails/patch-11.1-RELEASE") {
+		t.Error("expected clone not to be promoted")
+	}
+	history, _ := p.History()
+	if len(history) != 1 || history[0].Error == "" {
+		t.Errorf("expected failed run in history got %+v", history)
+	}
+}
+
+// TestPatchUpToDate verifies nothing is promoted when there are no
+// updates to install
+func TestPatchUpToDate(t *testing.T) {
+	w := &fakeWrapper{out: patchCommands("No updates are available to install.\n", "ok\n")}
+	p, dir := newPatchTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	run, err := p.Patch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != PatchUpToDate {
+		t.Errorf("expected up to date got %s", run.Status)
+	}
+	if !ran(w, "zfs destroy -r zroot/jails/patch-11.1-RELEASE") || ran(w, "zfs promote zroot/jails/patch-11.1-RELEASE") {
+		t.Errorf("unexpected commands %v", w.ran)
+	}
+}
+
+// hangingWrapper runs canary jails until they're removed
+type hangingWrapper struct {
+	removed chan struct{}
+}
+
+// CombinedOutput implements the utils.Wrapper interface
+func (h *hangingWrapper) CombinedOutput(name string, args ...string) ([]byte, error) {
+	if strings.Join(args, " ") == "-r patchcanary" {
+		close(h.removed)
+		return nil, nil
+	}
+	<-h.removed
+	return nil, errors.New("signal: killed")
+}
+
+// Output implements the utils.Wrapper interface
+func (h *hangingWrapper) Output(name string, args ...string) ([]byte, error) {
+	return h.CombinedOutput(name, args...)
+}
+
+// TestCanaryJail_Timeout verifies a canary that doesn't finish in time
+// has its jail removed
+func TestCanaryJail_Timeout(t *testing.T) {
+	w := &hangingWrapper{removed: make(chan struct{})}
+	p := NewPatchService(&config.Config{}, gklog.NewNopLogger(), metrics.Discard(), w).(*patchService)
+	_, err := p.canaryJail(20*time.Millisecond, []string{"-c", "-n", "patchcanary", "command=/tmp/canary/canary"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout got %v", err)
+	}
+	select {
+	case <-w.removed:
+	default:
+		t.Error("expected canary jail to be removed")
+	}
+}
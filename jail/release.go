@@ -0,0 +1,390 @@
+package jail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/filesystem"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// releaseStateFile is the name of the file, in the state
+// directory, the active release is recorded in
+const releaseStateFile = "release.json"
+
+var (
+	releaseRegexp  = regexp.MustCompile(`^[0-9]+\.[0-9]+-(RELEASE|BETA[0-9]+|RC[0-9]+)(-p[0-9]+)?$`)
+	snapshotRegexp = regexp.MustCompile(`^p([0-9]+)$`)
+)
+
+// ReleaseServicer defines the behavior of the release service
+type ReleaseServicer interface {
+	Releases() ([]*Release, error)
+	Active() string
+	Activate(string) error
+	Upgrade(string) error
+	Upgrading() string
+	UpgradeState(string) (*InitState, error)
+	Prune([]string) ([]string, error)
+}
+
+// Release describes a base release and its snapshots. New jails
+// are cloned from the latest snapshot of the active release unless
+// pinned to another
+type Release struct {
+	Name      string   `json:"name"`
+	Snapshots []string `json:"snapshots"`
+	Latest    string   `json:"latest"`
+	Active    bool     `json:"active"`
+	InUse     []string `json:"in_use"`
+}
+
+// activeRelease is the recorded active release
+type activeRelease struct {
+	Release   string    `json:"release"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// validRelease checks the given name looks like a FreeBSD release
+func validRelease(release string) error {
+	if !releaseRegexp.MatchString(release) {
+		return fmt.Errorf("invalid release %q", release)
+	}
+	return nil
+}
+
+// releasesDataset returns the dataset holding every release base jail
+func releasesDataset(conf *config.Config) string {
+	return conf.Filesystem.ZFSDataset + "/jails/releases"
+}
+
+// snapshotVersion returns the version of the given snapshot, e.g. 2
+// for "zroot/jails/releases/11.1-RELEASE@p2"
+func snapshotVersion(snapshot string) (int, bool) {
+	i := strings.LastIndex(snapshot, "@")
+	if i < 0 {
+		return 0, false
+	}
+	m := snapshotRegexp.FindStringSubmatch(snapshot[i+1:])
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// latestSnapshot returns the versioned snapshot with the highest
+// version, or an empty string if there are none
+func latestSnapshot(snapshots []string) string {
+	var latest string
+	max := 0
+	for _, s := range snapshots {
+		if v, ok := snapshotVersion(s); ok && v > max {
+			latest, max = s, v
+		}
+	}
+	return latest
+}
+
+// nextSnapshot returns the name of the next versioned snapshot
+// of the given dataset
+func nextSnapshot(dataset string, snapshots []string) string {
+	v, _ := snapshotVersion(latestSnapshot(snapshots))
+	return fmt.Sprintf("%s@p%d", dataset, v+1)
+}
+
+// zfsList runs zfs list for the given property and arguments,
+// returning the value for each dataset. A dataset that doesn't
+// exist returns no values
+func zfsList(w utils.Wrapper, property string, args ...string) ([]string, error) {
+	out, err := w.CombinedOutput("zfs", append([]string{"list", "-H", "-o", property}, args...)...)
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	var values []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); l != "" && l != "-" {
+			values = append(values, l)
+		}
+	}
+	return values, nil
+}
+
+// releaseStatePath returns the path of the active release file
+func releaseStatePath(conf *config.Config) string {
+	dir := conf.StateDir
+	if dir == "" {
+		dir = config.DefaultStateDir
+	}
+	return filepath.Join(dir, releaseStateFile)
+}
+
+// readActiveRelease returns the recorded active release, or the
+// configured release if none has been recorded
+func readActiveRelease(conf *config.Config) string {
+	b, err := ioutil.ReadFile(releaseStatePath(conf))
+	if err != nil {
+		return conf.Release
+	}
+	var a activeRelease
+	if err := json.Unmarshal(b, &a); err != nil || a.Release == "" {
+		return conf.Release
+	}
+	return a.Release
+}
+
+// writeActiveRelease atomically records the given active release
+func writeActiveRelease(conf *config.Config, release string) error {
+	b, err := json.MarshalIndent(activeRelease{Release: release, UpdatedAt: time.Now().UTC()}, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := releaseStatePath(conf)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// releaseSnapshot returns the latest snapshot of the given release,
+// or of the active release if none given
+func (j *jailService) releaseSnapshot(release string) (string, error) {
+	if release == "" {
+		release = readActiveRelease(j.conf)
+	}
+	if err := validRelease(release); err != nil {
+		return "", err
+	}
+	snapshots, err := zfsList(j.wrapper, "name", "-t", "snapshot", "-d", "1", releasesDataset(j.conf)+"/"+release)
+	if err != nil {
+		return "", err
+	}
+	latest := latestSnapshot(snapshots)
+	if latest == "" {
+		return "", fmt.Errorf("no snapshot found for release %s", release)
+	}
+	return latest, nil
+}
+
+// forRelease returns a copy of the jail service that builds the
+// base jail of the given release
+func (j *jailService) forRelease(release string) *jailService {
+	conf := *j.conf
+	conf.Release = release
+	js := *j
+	js.conf = &conf
+	js.fsService = filesystem.NewFilesystemService(&conf, j.logger, j.metrics, j.wrapper)
+	js.tracker = nil
+	return &js
+}
+
+// releaseService holds the state of the service
+type releaseService struct {
+	logger    gklog.Logger
+	conf      *config.Config
+	metrics   *metrics.Metrics
+	wrapper   utils.Wrapper
+	jsvc      *jailService
+	mu        sync.Mutex
+	upgrading string
+}
+
+// NewReleaseService creates a new value of type releaseService pointer
+// managing the release base jails and their snapshots
+func NewReleaseService(conf *config.Config, l gklog.Logger, m *metrics.Metrics, w utils.Wrapper) ReleaseServicer {
+	return &releaseService{
+		logger:  l,
+		conf:    conf,
+		metrics: m,
+		wrapper: w,
+		jsvc:    NewJailService(conf, l, m, w).(*jailService),
+	}
+}
+
+// upgradeStatePath returns the path of the file the state of an
+// upgrade to the given release is recorded in
+func (r *releaseService) upgradeStatePath(release string) string {
+	return filepath.Join(filepath.Dir(releaseStatePath(r.conf)), "upgrade-"+release+".json")
+}
+
+// Active returns the release new jails are cloned from
+func (r *releaseService) Active() string {
+	return readActiveRelease(r.conf)
+}
+
+// Releases returns the releases with snapshots and which of
+// those snapshots have jails cloned from them
+func (r *releaseService) Releases() ([]*Release, error) {
+	snapshots, err := zfsList(r.wrapper, "name", "-t", "snapshot", "-r", releasesDataset(r.conf))
+	if err != nil {
+		return nil, err
+	}
+	origins, err := r.origins()
+	if err != nil {
+		return nil, err
+	}
+	active := r.Active()
+	byName := make(map[string]*Release)
+	for _, s := range snapshots {
+		if _, ok := snapshotVersion(s); !ok {
+			continue
+		}
+		name := strings.TrimPrefix(s[:strings.LastIndex(s, "@")], releasesDataset(r.conf)+"/")
+		rel, ok := byName[name]
+		if !ok {
+			rel = &Release{Name: name, Active: name == active}
+			byName[name] = rel
+		}
+		rel.Snapshots = append(rel.Snapshots, s)
+		if origins[s] {
+			rel.InUse = append(rel.InUse, s)
+		}
+	}
+	releases := make([]*Release, 0, len(byName))
+	for _, rel := range byName {
+		rel.Latest = latestSnapshot(rel.Snapshots)
+		releases = append(releases, rel)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Name < releases[j].Name
+	})
+	return releases, nil
+}
+
+// origins returns the snapshots jails have been cloned from
+func (r *releaseService) origins() (map[string]bool, error) {
+	values, err := zfsList(r.wrapper, "origin", "-t", "filesystem", "-r", r.conf.Filesystem.ZFSDataset+"/jails")
+	if err != nil {
+		return nil, err
+	}
+	origins := make(map[string]bool, len(values))
+	for _, v := range values {
+		origins[v] = true
+	}
+	return origins, nil
+}
+
+// Activate switches new jails to the latest snapshot of the given
+// release. Running jails are unaffected
+func (r *releaseService) Activate(release string) error {
+	if _, err := r.jsvc.releaseSnapshot(release); err != nil {
+		return err
+	}
+	if err := writeActiveRelease(r.conf, release); err != nil {
+		return err
+	}
+	r.logger.Log("msg", "activated release "+release)
+	return nil
+}
+
+// Upgrading returns the release being upgraded to, if any
+func (r *releaseService) Upgrading() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.upgrading
+}
+
+// Upgrade builds and snapshots the base jail of the given release
+// alongside the existing releases then activates it. The state of
+// the upgrade is recorded so a failed upgrade is resumed when rerun
+func (r *releaseService) Upgrade(release string) error {
+	t := r.metrics.NewTiming()
+	defer t.Send("release.upgrade_time")
+	if err := validRelease(release); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.upgrading != "" {
+		r.mu.Unlock()
+		return fmt.Errorf("upgrade to %s already in progress", r.upgrading)
+	}
+	r.upgrading = release
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.upgrading = ""
+		r.mu.Unlock()
+	}()
+
+	js := r.jsvc.forRelease(release)
+	js.stateFile = r.upgradeStatePath(release)
+	if err := js.loadArtifactManifest(); err != nil {
+		return err
+	}
+	var steps []*initStep
+	for _, s := range js.initSteps() {
+		if s.name != StepBuildJail {
+			steps = append(steps, s)
+		}
+	}
+	r.logger.Log("msg", "upgrading to release "+release)
+	if err := js.runSteps(steps, InitOptions{Resume: true}); err != nil {
+		return err
+	}
+	return r.Activate(release)
+}
+
+// UpgradeState returns the recorded state of the upgrade to the
+// given release
+func (r *releaseService) UpgradeState(release string) (*InitState, error) {
+	if err := validRelease(release); err != nil {
+		return nil, err
+	}
+	return readInitState(r.upgradeStatePath(release))
+}
+
+// Prune destroys the release snapshots no jails have been cloned
+// from, keeping the latest snapshot of the active release and of
+// each of the given pinned releases. The destroyed snapshots are
+// returned
+func (r *releaseService) Prune(pinned []string) ([]string, error) {
+	releases, err := r.Releases()
+	if err != nil {
+		return nil, err
+	}
+	keep := map[string]bool{r.Active(): true}
+	for _, p := range pinned {
+		keep[p] = true
+	}
+	var destroyed []string
+	for _, rel := range releases {
+		inUse := make(map[string]bool, len(rel.InUse))
+		for _, s := range rel.InUse {
+			inUse[s] = true
+		}
+		for _, s := range rel.Snapshots {
+			if inUse[s] || (keep[rel.Name] && s == rel.Latest) {
+				continue
+			}
+			if out, err := r.wrapper.CombinedOutput("zfs", "destroy", s); err != nil {
+				return destroyed, errors.New(strings.TrimSpace(string(out)))
+			}
+			r.logger.Log("msg", "destroyed snapshot "+s)
+			destroyed = append(destroyed, s)
+		}
+	}
+	return destroyed, nil
+}
@@ -0,0 +1,170 @@
+package jail
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// fakeWrapper returns canned output for commands, keyed by the
+// command line or a prefix of it ending in "*", and records every
+// command run
+type fakeWrapper struct {
+	out map[string]string
+	ran []string
+}
+
+// CombinedOutput implements the utils.Wrapper interface
+func (f *fakeWrapper) CombinedOutput(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	f.ran = append(f.ran, cmd)
+	out, ok := f.out[cmd]
+	for k, v := range f.out {
+		if !ok && strings.HasSuffix(k, "*") && strings.HasPrefix(cmd, strings.TrimSuffix(k, "*")) {
+			out, ok = v, true
+		}
+	}
+	if !ok {
+		return []byte("cannot open '" + args[len(args)-1] + "': dataset does not exist"), errors.New("exit status 1")
+	}
+	return []byte(out), nil
+}
+
+// Output implements the utils.Wrapper interface
+func (f *fakeWrapper) Output(name string, args ...string) ([]byte, error) {
+	return f.CombinedOutput(name, args...)
+}
+
+// newReleaseTestService creates a release service with the given
+// fake wrapper, recording its state in a temp dir
+func newReleaseTestService(t *testing.T, w *fakeWrapper) (*releaseService, string) {
+	dir, err := ioutil.TempDir("", "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{
+		Release:    "11.1-RELEASE",
+		StateDir:   dir,
+		Filesystem: &config.Filesystem{ZFSDataset: "zroot"},
+		Jails:      &config.Jails{BaseJailDir: dir},
+	}
+	return NewReleaseService(conf, gklog.NewNopLogger(), metrics.Discard(), w).(*releaseService), dir
+}
+
+// TestSnapshotNames verifies snapshot versions are parsed and the
+// next version is one past the latest
+func TestSnapshotNames(t *testing.T) {
+	snapshots := []string{
+		"zroot/jails/releases/11.1-RELEASE@p1",
+		"zroot/jails/releases/11.1-RELEASE@p10",
+		"zroot/jails/releases/11.1-RELEASE@p2",
+		"zroot/jails/releases/11.1-RELEASE@manual",
+	}
+	if latest := latestSnapshot(snapshots); latest != "zroot/jails/releases/11.1-RELEASE@p10" {
+		t.Errorf("expected p10 got %s", latest)
+	}
+	if next := nextSnapshot("zroot/jails/releases/11.1-RELEASE", snapshots); next != "zroot/jails/releases/11.1-RELEASE@p11" {
+		t.Errorf("expected p11 got %s", next)
+	}
+	if next := nextSnapshot("zroot/jails/releases/14.1-RELEASE", nil); next != "zroot/jails/releases/14.1-RELEASE@p1" {
+		t.Errorf("expected p1 got %s", next)
+	}
+	if _, ok := snapshotVersion("zroot/jails/releases/11.1-RELEASE"); ok {
+		t.Error("expected no version without a snapshot name")
+	}
+}
+
+// TestReleaseSnapshot verifies jails are cloned from the latest
+// snapshot of the active release unless pinned to another
+func TestReleaseSnapshot(t *testing.T) {
+	w := &fakeWrapper{out: map[string]string{
+		"zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/11.1-RELEASE": "zroot/jails/releases/11.1-RELEASE@p1\n",
+		"zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/14.1-RELEASE": "zroot/jails/releases/14.1-RELEASE@p1\nzroot/jails/releases/14.1-RELEASE@p2\n",
+	}}
+	r, dir := newReleaseTestService(t, w)
+	defer os.RemoveAll(dir)
+
+	snap, err := r.jsvc.releaseSnapshot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap != "zroot/jails/releases/11.1-RELEASE@p1" {
+		t.Errorf("expected configured release snapshot got %s", snap)
+	}
+
+	if err := r.Activate("14.1-RELEASE"); err != nil {
+		t.Fatal(err)
+	}
+	if active := r.Active(); active != "14.1-RELEASE" {
+		t.Errorf("expected 14.1-RELEASE active got %s", active)
+	}
+	if snap, _ = r.jsvc.releaseSnapshot(""); snap != "zroot/jails/releases/14.1-RELEASE@p2" {
+		t.Errorf("expected active release snapshot got %s", snap)
+	}
+	if snap, _ = r.jsvc.releaseSnapshot("11.1-RELEASE"); snap != "zroot/jails/releases/11.1-RELEASE@p1" {
+		t.Errorf("expected pinned release snapshot got %s", snap)
+	}
+
+	if err := r.Activate("12.0-RELEASE"); err == nil {
+		t.Error("expected error activating release without snapshots")
+	}
+	if active := r.Active(); active != "14.1-RELEASE" {
+		t.Errorf("expected 14.1-RELEASE to stay active got %s", active)
+	}
+	if _, err := r.jsvc.releaseSnapshot("../../etc"); err == nil {
+		t.Error("expected error for invalid release")
+	}
+}
+
+// TestPrune verifies only snapshots without clones that aren't the
+// latest of the active or a pinned release are destroyed
+func TestPrune(t *testing.T) {
+	w := &fakeWrapper{out: map[string]string{
+		"zfs list -H -o name -t snapshot -r zroot/jails/releases": strings.Join([]string{
+			"zroot/jails/releases/10.4-RELEASE@p1",
+			"zroot/jails/releases/11.1-RELEASE@p1",
+			"zroot/jails/releases/11.1-RELEASE@p2",
+			"zroot/jails/releases/12.0-RELEASE@p1",
+			"zroot/jails/releases/14.1-RELEASE@p1",
+			"zroot/jails/releases/14.1-RELEASE@p2",
+		}, "\n"),
+		"zfs list -H -o origin -t filesystem -r zroot/jails":                     "-\n-\nzroot/jails/releases/11.1-RELEASE@p1\n",
+		"zfs list -H -o name -t snapshot -d 1 zroot/jails/releases/14.1-RELEASE": "zroot/jails/releases/14.1-RELEASE@p2\n",
+		"zfs destroy zroot/jails/releases/10.4-RELEASE@p1":                       "",
+		"zfs destroy zroot/jails/releases/11.1-RELEASE@p2":                       "",
+		"zfs destroy zroot/jails/releases/14.1-RELEASE@p1":                       "",
+	}}
+	r, dir := newReleaseTestService(t, w)
+	defer os.RemoveAll(dir)
+	if err := r.Activate("14.1-RELEASE"); err != nil {
+		t.Fatal(err)
+	}
+
+	destroyed, err := r.Prune([]string{"12.0-RELEASE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"zroot/jails/releases/10.4-RELEASE@p1",
+		"zroot/jails/releases/11.1-RELEASE@p2",
+		"zroot/jails/releases/14.1-RELEASE@p1",
+	}
+	if !reflect.DeepEqual(destroyed, expected) {
+		t.Errorf("expected %v destroyed got %v", expected, destroyed)
+	}
+
+	releases, err := r.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 4 || releases[1].Name != "11.1-RELEASE" || len(releases[1].InUse) != 1 || !releases[3].Active {
+		t.Errorf("unexpected releases %+v", releases)
+	}
+}
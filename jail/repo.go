@@ -1,30 +1,33 @@
 package jail
 
 import (
+	"context"
 	"os"
 	"sync"
 
+	"github.com/briandowns/sky-island/artifacts"
 	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 	"gopkg.in/src-d/go-git.v4"
 )
 
 // RepoServicer
 type RepoServicer interface {
-	CloneRepo(jpath, fname string) error
+	CloneRepo(ctx context.Context, jpath, fname string) error
 	RemoveRepo(repo string) error
+	RepoCommit(repo string) (string, error)
 }
 
 // repoService
 type repoService struct {
 	logger  gklog.Logger
 	conf    *config.Config
-	metrics *statsd.Client
+	metrics *metrics.Metrics
 }
 
 // newRepoService
-func NewRepoService(conf *config.Config, l gklog.Logger, metrics *statsd.Client) RepoServicer {
+func NewRepoService(conf *config.Config, l gklog.Logger, metrics *metrics.Metrics) RepoServicer {
 	return &repoService{
 		logger:  l,
 		conf:    conf,
@@ -32,18 +35,23 @@ func NewRepoService(conf *config.Config, l gklog.Logger, metrics *statsd.Client)
 	}
 }
 
-// CloneRepo clones the given repo into the given path
-func (r *repoService) CloneRepo(jpath, fname string) error {
+// CloneRepo clones the given repo into the given path. A clone
+// cancelled by the given context is removed
+func (r *repoService) CloneRepo(ctx context.Context, jpath, fname string) error {
 	t := r.metrics.NewTiming()
 	defer t.Send("clone")
-	_, err := git.PlainClone(jpath+"/"+fname, false, &git.CloneOptions{
+	_, err := git.PlainCloneContext(ctx, jpath+"/"+fname, false, &git.CloneOptions{
 		URL:   "https://" + fname + ".git",
 		Depth: 1,
 	})
 	if err != nil {
+		if ctx.Err() != nil {
+			os.RemoveAll(jpath + "/" + fname)
+			return ctx.Err()
+		}
 		return err
 	}
-	r.metrics.Histogram(fname, 1)
+	r.metrics.Inc("cloned")
 	return nil
 }
 
@@ -54,14 +62,29 @@ func (r *repoService) RemoveRepo(repo string) error {
 	if err := os.RemoveAll(r.conf.Jails.BaseJailDir + "/build/root/go/src/" + repo); err != nil {
 		return err
 	}
-	r.metrics.Histogram(repo, 1)
+	r.metrics.Inc("removed")
 	return nil
 }
 
+// RepoCommit returns the hash of the commit the given repo in the
+// build jail is checked out at
+func (r *repoService) RepoCommit(repo string) (string, error) {
+	gr, err := git.PlainOpen(r.conf.Jails.BaseJailDir + "/build/root/go/src/" + repo)
+	if err != nil {
+		return "", err
+	}
+	head, err := gr.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
 // BinaryCache holds the path to compiled binaries
 type BinaryCache struct {
 	mu    sync.RWMutex
 	cache map[string]string
+	store *artifacts.Store
 }
 
 // NewBinaryCache creates a new value of type
@@ -75,6 +98,57 @@ func NewBinaryCache() *BinaryCache {
 	}
 }
 
+// UseStore makes the cache consult the given artifact store on a miss
+// and keep the binaries added to it there
+func (b *BinaryCache) UseStore(s *artifacts.Store) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.store = s
+}
+
+// Lookup returns the path of the binary cached under the given key.
+// On a miss the artifact store, if any, is consulted and a binary
+// found there is cached. An empty path is returned if there's none
+func (b *BinaryCache) Lookup(ctx context.Context, k string) (string, error) {
+	b.mu.RLock()
+	p, s := b.cache[k], b.store
+	b.mu.RUnlock()
+	if p != "" || s == nil {
+		return p, nil
+	}
+	p, err := s.Binary(ctx, k)
+	if err == artifacts.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	b.Set(k, p)
+	return p, nil
+}
+
+// Add caches the binary built at the given path under the metadata's
+// key. With an artifact store the binary is added to it and the path
+// of the artifact is cached and returned. If the binary was stored
+// but couldn't be pushed to the remote store the path is returned
+// along with the error
+func (b *BinaryCache) Add(ctx context.Context, m *artifacts.Metadata, path string) (string, error) {
+	b.mu.RLock()
+	s := b.store
+	b.mu.RUnlock()
+	if s == nil {
+		b.Set(m.Key, path)
+		return path, nil
+	}
+	err := s.Add(ctx, m, path)
+	if m.Digest == "" {
+		return "", err
+	}
+	p := s.Path(m.Digest)
+	b.Set(m.Key, p)
+	return p, err
+}
+
 // Get takes a key as an argument and gets the associated
 // value if it exists
 func (b *BinaryCache) Get(k string) string {
@@ -93,3 +167,38 @@ func (b *BinaryCache) Set(k, v string) {
 	defer b.mu.Unlock()
 	b.cache[k] = v
 }
+
+// Len returns the number of binaries in the cache
+func (b *BinaryCache) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.cache)
+}
+
+// Entries returns a copy of the cached keys and binary paths
+func (b *BinaryCache) Entries() map[string]string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries := make(map[string]string, len(b.cache))
+	for k, v := range b.cache {
+		if v != "" {
+			entries[k] = v
+		}
+	}
+	return entries
+}
+
+// Purge empties the cache and returns the paths of the binaries
+// that were cached
+func (b *BinaryCache) Purge() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var paths []string
+	for _, v := range b.cache {
+		if v != "" {
+			paths = append(paths, v)
+		}
+	}
+	b.cache = make(map[string]string)
+	return paths
+}
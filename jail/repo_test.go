@@ -1,11 +1,16 @@
 package jail
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/briandowns/sky-island/artifacts"
+	"github.com/briandowns/sky-island/metrics"
 	gklog "github.com/go-kit/kit/log"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
 // removeTempRepos removes the repos cloned during testing
@@ -17,8 +22,8 @@ func removeTempRepos() {
 // from the given URL
 func TestCloneRepo(t *testing.T) {
 	defer removeTempRepos()
-	rs := NewRepoService(testConf, gklog.NewNopLogger(), &statsd.Client{})
-	if err := rs.CloneRepo("/tmp", "github.com/briandowns/smile"); err != nil {
+	rs := NewRepoService(testConf, gklog.NewNopLogger(), metrics.Discard())
+	if err := rs.CloneRepo(context.Background(), "/tmp", "github.com/briandowns/smile"); err != nil {
 		t.Error(err)
 	}
 }
@@ -27,8 +32,47 @@ func TestCloneRepo(t *testing.T) {
 // when trying to clone a repo from a bad URL
 func TestCloneRepo_Failure(t *testing.T) {
 	defer removeTempRepos()
-	rs := NewRepoService(testConf, gklog.NewNopLogger(), &statsd.Client{})
-	if err := rs.CloneRepo("/tmp", "github.com/briandown/smile"); err == nil {
+	rs := NewRepoService(testConf, gklog.NewNopLogger(), metrics.Discard())
+	if err := rs.CloneRepo(context.Background(), "/tmp", "github.com/briandown/smile"); err == nil {
 		t.Error("expected error but received none")
 	}
 }
+
+// TestBinaryCache_Store verifies binaries added to the cache are kept
+// in the artifact store and found there once the cache is emptied
+func TestBinaryCache_Store(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bincache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := artifacts.NewStore(filepath.Join(dir, "artifacts"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := filepath.Join(dir, "built")
+	if err := ioutil.WriteFile(built, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBinaryCache()
+	b.UseStore(store)
+	ctx := context.Background()
+	key := "github.com/briandowns/smile.Greet().1.9.2"
+	if p, err := b.Lookup(ctx, key); err != nil || p != "" {
+		t.Fatalf("expected a miss got %q %v", p, err)
+	}
+	p, err := b.Add(ctx, &artifacts.Metadata{Key: key}, built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(p, filepath.Join(dir, "artifacts")) {
+		t.Errorf("expected the artifact's path got %s", p)
+	}
+	b.Purge()
+	if found, err := b.Lookup(ctx, key); err != nil || found != p {
+		t.Errorf("expected %s from the store got %q %v", p, found, err)
+	}
+	if b.Get(key) != p {
+		t.Error("expected the binary cached again")
+	}
+}
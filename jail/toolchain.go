@@ -0,0 +1,214 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/utils"
+	gklog "github.com/go-kit/kit/log"
+	"github.com/mholt/archiver"
+)
+
+// goTarballName is the name of the FreeBSD Go release tarball
+const goTarballName = "go%s.freebsd-amd64.tar.gz"
+
+// goToolchainDir is the directory, relative to a jail's root, each
+// Go toolchain is installed into
+const goToolchainDir = "/usr/local/go"
+
+var goVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?((rc|beta)[0-9]+)?$`)
+
+// ToolchainServicer defines the behavior of the Go toolchain service
+type ToolchainServicer interface {
+	Install(string) error
+	Remove(string) error
+	List() ([]string, error)
+	GoBin(string) (string, error)
+}
+
+// toolchainService holds the state of the service
+type toolchainService struct {
+	logger  gklog.Logger
+	conf    *config.Config
+	metrics *metrics.Metrics
+	root    string
+	migrate sync.Once
+}
+
+// NewToolchainService creates a new value of type toolchainService
+// pointer managing the Go toolchains installed in the build jail
+func NewToolchainService(conf *config.Config, l gklog.Logger, metrics *metrics.Metrics) ToolchainServicer {
+	return &toolchainService{
+		logger:  l,
+		conf:    conf,
+		metrics: metrics,
+		root:    conf.Jails.BaseJailDir + "/build",
+	}
+}
+
+// validGoVersion checks the given version looks like a Go release
+func validGoVersion(version string) error {
+	if !goVersionRegexp.MatchString(version) {
+		return fmt.Errorf("invalid go version %q", version)
+	}
+	return nil
+}
+
+// extractGo extracts the given Go release tarball into the
+// go<version> toolchain directory under the given root
+func extractGo(tarball, root, version string) error {
+	local := root + "/usr/local"
+	if err := os.MkdirAll(local, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(local, ".go")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	tgz := archiver.TarGz{
+		Tar: &archiver.Tar{
+			MkdirAll:          true,
+			OverwriteExisting: true,
+		},
+	}
+	if err := tgz.Unarchive(tarball, tmp); err != nil {
+		return err
+	}
+	dst := root + goToolchainDir + version
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(tmp, "go"), dst)
+}
+
+// migrateLegacyGo moves a toolchain installed directly in
+// /usr/local/go, as it was before versions were installed side by
+// side, to the directory of its version and links /usr/local/go to
+// it. Nothing is done if /usr/local/go is already a link
+func migrateLegacyGo(root string) (string, error) {
+	dir := root + goToolchainDir
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "VERSION"))
+	if err != nil {
+		return "", err
+	}
+	version := strings.TrimPrefix(strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0]), "go")
+	if err := validGoVersion(version); err != nil {
+		return "", err
+	}
+	dst := dir + version
+	if utils.Exists(dst) {
+		return "", fmt.Errorf("go %s installed in both %s and %s", version, dir, dst)
+	}
+	if err := os.Rename(dir, dst); err != nil {
+		return "", err
+	}
+	return version, os.Symlink("go"+version, dir)
+}
+
+// migrated migrates a toolchain left in /usr/local/go by an older
+// install the first time the service is used
+func (t *toolchainService) migrated() {
+	t.migrate.Do(func() {
+		version, err := migrateLegacyGo(t.root)
+		if err != nil {
+			t.logger.Log("error", "migrating "+goToolchainDir+": "+err.Error())
+			return
+		}
+		if version != "" {
+			t.logger.Log("msg", "moved "+goToolchainDir+" to "+goToolchainDir+version)
+		}
+	})
+}
+
+// Install installs the given Go version into the build jail from
+// the tarball in the configured tarball directory
+func (t *toolchainService) Install(version string) error {
+	timing := t.metrics.NewTiming()
+	defer timing.Send("toolchain.install_time")
+	if err := validGoVersion(version); err != nil {
+		return err
+	}
+	t.migrated()
+	if t.conf.GoTarballDir == "" {
+		return errors.New("go tarball directory not configured")
+	}
+	tarball := filepath.Join(t.conf.GoTarballDir, fmt.Sprintf(goTarballName, version))
+	if !utils.Exists(tarball) {
+		return errors.New("go tarball not found: " + tarball)
+	}
+	t.logger.Log("msg", "installing go "+version)
+	return extractGo(tarball, t.root, version)
+}
+
+// Remove removes the given Go version from the build jail. The
+// default version can't be removed
+func (t *toolchainService) Remove(version string) error {
+	if err := validGoVersion(version); err != nil {
+		return err
+	}
+	if version == t.conf.GoVersion {
+		return errors.New("can't remove the default go version")
+	}
+	t.migrated()
+	dir := t.root + goToolchainDir + version
+	if !utils.Exists(dir) {
+		return fmt.Errorf("go %s not installed", version)
+	}
+	t.logger.Log("msg", "removing go "+version)
+	return os.RemoveAll(dir)
+}
+
+// List returns the Go versions installed in the build jail
+func (t *toolchainService) List() ([]string, error) {
+	t.migrated()
+	matches, err := filepath.Glob(t.root + goToolchainDir + "*")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, m := range matches {
+		v := strings.TrimPrefix(filepath.Base(m), "go")
+		if validGoVersion(v) == nil && utils.Exists(m+"/bin/go") {
+			versions = append(versions, v)
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// GoBin returns the path, inside the build jail, of the go binary
+// for the given version. The default version is used if none given
+func (t *toolchainService) GoBin(version string) (string, error) {
+	if version == "" {
+		version = t.conf.GoVersion
+	}
+	if err := validGoVersion(version); err != nil {
+		return "", err
+	}
+	t.migrated()
+	bin := goToolchainDir + version + "/bin/go"
+	if !utils.Exists(t.root + bin) {
+		return "", fmt.Errorf("go %s not installed", version)
+	}
+	return bin, nil
+}
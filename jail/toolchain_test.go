@@ -0,0 +1,156 @@
+package jail
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// writeGoTarball creates a minimal Go release tarball for
+// the given version in the given directory
+func writeGoTarball(t *testing.T, dir, version string) {
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf(goTarballName, version)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+	body := []byte("#!/bin/sh\n")
+	for _, hdr := range []*tar.Header{
+		{Name: "go/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "go/bin/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "go/bin/go", Mode: 0755, Size: int64(len(body)), Typeflag: tar.TypeReg},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestToolchainService verifies toolchains are installed side by
+// side, listed, resolved and removed
+func TestToolchainService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolchains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tarballs := filepath.Join(dir, "tarballs")
+	if err := os.Mkdir(tarballs, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	writeGoTarball(t, tarballs, "1.21.5")
+	writeGoTarball(t, tarballs, "1.22.0")
+
+	conf := &config.Config{
+		GoVersion:    "1.21.5",
+		GoTarballDir: tarballs,
+		Jails:        &config.Jails{BaseJailDir: dir},
+	}
+	tsvc := NewToolchainService(conf, gklog.NewNopLogger(), metrics.Discard())
+	for _, v := range []string{"1.21.5", "1.22.0"} {
+		if err := tsvc.Install(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tsvc.Install("1.23.0"); err == nil {
+		t.Error("expected error installing version without a tarball")
+	}
+
+	versions, err := tsvc.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1.21.5", "1.22.0"}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v got %v", expected, versions)
+	}
+
+	bin, err := tsvc.GoBin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bin != "/usr/local/go1.21.5/bin/go" {
+		t.Errorf("expected default go bin got %s", bin)
+	}
+
+	if err := tsvc.Remove("1.21.5"); err == nil {
+		t.Error("expected error removing default version")
+	}
+	if err := tsvc.Remove("1.22.0"); err != nil {
+		t.Error(err)
+	}
+	if _, err := tsvc.GoBin("1.22.0"); err == nil {
+		t.Error("expected error for removed version")
+	}
+}
+
+// TestToolchainService_Legacy verifies a toolchain installed in
+// /usr/local/go by an older install is moved beside the others
+func TestToolchainService_Legacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolchains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	legacy := filepath.Join(dir, "build", goToolchainDir)
+	if err := os.MkdirAll(filepath.Join(legacy, "bin"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(legacy, "bin", "go"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(legacy, "VERSION"), []byte("go1.9.2\ntime 2017-10-25T20:24:49Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{GoVersion: "1.9.2", Jails: &config.Jails{BaseJailDir: dir}}
+	tsvc := NewToolchainService(conf, gklog.NewNopLogger(), metrics.Discard())
+	bin, err := tsvc.GoBin("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bin != "/usr/local/go1.9.2/bin/go" {
+		t.Errorf("expected migrated go bin got %s", bin)
+	}
+	if target, err := os.Readlink(legacy); err != nil || target != "go1.9.2" {
+		t.Errorf("expected %s linked to go1.9.2 got %q %v", legacy, target, err)
+	}
+	versions, err := tsvc.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1.9.2"}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v got %v", expected, versions)
+	}
+}
+
+// TestValidGoVersion
+func TestValidGoVersion(t *testing.T) {
+	for _, v := range []string{"1.9.2", "1.22", "1.22rc1"} {
+		if err := validGoVersion(v); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, v := range []string{"", "../1.9", "1.9.2; rm -rf /"} {
+		if err := validGoVersion(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
@@ -1,29 +1,72 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/briandowns/sky-island/cluster"
 	"github.com/briandowns/sky-island/config"
 	"github.com/briandowns/sky-island/handlers"
 	"github.com/briandowns/sky-island/jail"
 	"github.com/briandowns/sky-island/log"
+	"github.com/briandowns/sky-island/metrics"
+	"github.com/briandowns/sky-island/tracing"
 	"github.com/briandowns/sky-island/utils"
 	"github.com/codegangsta/negroni"
+	gklog "github.com/go-kit/kit/log"
 	"github.com/thoas/stats"
-	"gopkg.in/alexcesaro/statsd.v2"
 )
 
-var (
-	configFlag string
-	initFlag   bool
-)
+// defaultConfigFile is the config file used when none is given, the
+// same the rc script uses
+const defaultConfigFile = "/usr/local/etc/sky-island.json"
 
 var signalsChan = make(chan os.Signal, 1)
 
+// command is a sky-island subcommand
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// commands are the subcommands by name
+var commands = map[string]*command{
+	"serve":     {usage: "run the API server", run: serveCmd},
+	"init":      {usage: "initialize the system", run: initCmd},
+	"invoke":    {usage: "run a function", run: invokeCmd},
+	"jails":     {usage: "list, inspect and kill running jails: ls|inspect <jid>|kill <jid>|kill all", run: jailsCmd},
+	"ips":       {usage: "list and release IP pool addresses: ls|release <ip>", run: ipsCmd},
+	"cache":     {usage: "list and purge cached binaries: ls|purge", run: cacheCmd},
+	"artifacts": {usage: "list stored binaries and copy them between nodes: ls|push <key>|pull <key>", run: artifactsCmd},
+	"gc":        {usage: "remove the datasets of function jails left behind", run: gcCmd},
+	"config":    {usage: "check a config file or reload a server's: validate|reload", run: configCmd},
+	"doctor":    {usage: "check everything functions depend on is usable", run: doctorCmd},
+}
+
+// usage prints the subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sky-island <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run sky-island <command> -h for the flags of a command")
+}
+
 func main() {
 	signal.Notify(signalsChan, os.Interrupt)
 	go func() {
@@ -32,64 +75,171 @@ func main() {
 			os.Exit(1)
 		}
 	}()
-	if os.Getgid() != 0 {
-		fmt.Println("must be run with super user permissions")
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
 		os.Exit(1)
 	}
-	flag.StringVar(&configFlag, "c", "", "sky-island configuration file")
-	flag.BoolVar(&initFlag, "i", false, "initialize system")
-	flag.Parse()
-	if configFlag == "" {
-		flag.Usage()
+	// sky-island -c config.json [-i] predates the subcommands
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" {
+		args = legacyArgs(args)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage()
+		os.Exit(1)
+	}
+	if err := cmd.run(args[1:]); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "sky-island "+args[0]+": "+err.Error())
+		}
 		os.Exit(1)
 	}
+}
+
+// legacyArgs converts the flags given without a subcommand into the
+// serve, or with -i the init, subcommand
+func legacyArgs(args []string) []string {
+	var rest []string
+	cmd := "serve"
+	for _, a := range args {
+		if a == "-i" || a == "--i" || a == "-i=true" {
+			cmd = "init"
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return append([]string{cmd}, rest...)
+}
+
+// requireRoot fails unless run with super user permissions
+func requireRoot() error {
+	if os.Getgid() != 0 {
+		return fmt.Errorf("must be run with super user permissions")
+	}
+	return nil
+}
 
-	conf, err := config.Load(configFlag)
+// serveCmd runs the API server
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configFlag := fs.String("c", defaultConfigFile, "sky-island configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	conf, err := config.Load(*configFlag)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
 	logger, err := log.Logger(conf, "sky-island")
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
 
-	statsd.Address(conf.Jails.MonitoringAddr)
-	metrics, err := statsd.New()
+	m, metricsHandler, metricsCloser, err := metrics.FromConfig(conf, logger)
 	if err != nil {
-		logger.Log("error", err.Error())
+		return err
 	}
-	defer metrics.Close()
+	defer metricsCloser.Close()
 
-	if initFlag {
-		jsvc := jail.NewJailService(conf, logger, metrics, utils.Wrap{})
-		if err := jsvc.InitializeSystem(); err != nil {
-			logger.Log("error", err.Error())
-			os.Exit(0)
-		}
-		os.Exit(0)
+	tracer, shutdownTracing, err := tracing.FromConfig(conf, logger)
+	if err != nil {
+		return err
 	}
+	defer shutdownTracing(context.Background())
 
 	logger.Log("msg", "starting API...")
 
-	params := handlers.Params{
-		Logger:  logger,
-		Conf:    conf,
-		StatsMW: stats.New(),
-		Metrics: metrics,
-	}
-	router, err := handlers.AddHandlers(&params)
-	if err != nil {
-		logger.Log("error", err.Error())
-		os.Exit(0)
-	}
+	go reloadOnHangup(conf, *configFlag, logger)
+
 	n := negroni.New(
 		negroni.NewRecovery(),
 		negroni.NewLogger(),
 	)
-	n.Use(params.StatsMW)
-	n.UseHandler(router)
+	if conf.Coordinator() {
+		logger.Log("msg", "running as cluster coordinator")
+		router := cluster.NewCoordinator(conf, logger, m.Prefix("cluster")).Router()
+		if metricsHandler != nil {
+			router.Handle("/metrics", metricsHandler).Methods(http.MethodGet)
+		}
+		n.UseHandler(router)
+	} else {
+		params := handlers.Params{
+			Logger:         logger,
+			Conf:           conf,
+			ConfigFile:     *configFlag,
+			StatsMW:        stats.New(),
+			Metrics:        m,
+			MetricsHandler: metricsHandler,
+			Tracer:         tracer,
+		}
+		router, err := handlers.AddHandlers(&params)
+		if err != nil {
+			return err
+		}
+		n.Use(params.StatsMW)
+		n.UseHandler(router)
+	}
 	n.Run(":" + strconv.Itoa(conf.HTTPPort))
+	return nil
+}
+
+// reloadOnHangup reloads the given config file each time SIGHUP is
+// received. Changes requiring a restart are logged and not applied
+func reloadOnHangup(conf *config.Config, file string, logger gklog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		changed, err := conf.ReloadFrom(file)
+		if err != nil {
+			logger.Log("error", "config reload failed: "+err.Error())
+			continue
+		}
+		logger.Log("msg", "config reloaded", "changed", strings.Join(changed, ","))
+	}
+}
+
+// initCmd initializes the system
+func initCmd(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	configFlag := fs.String("c", defaultConfigFile, "sky-island configuration file")
+	resumeFlag := fs.Bool("resume", false, "skip init steps completed by a previous run")
+	fromStepFlag := fs.String("from-step", "", "run init from the given step")
+	dryRunFlag := fs.Bool("dry-run", false, "print the init commands without running them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	if *fromStepFlag != "" {
+		if err := jail.ValidInitStep(*fromStepFlag); err != nil {
+			return err
+		}
+	}
+	conf, err := config.Load(*configFlag)
+	if err != nil {
+		return err
+	}
+	logger, err := log.Logger(conf, "sky-island")
+	if err != nil {
+		return err
+	}
+	m, _, metricsCloser, err := metrics.FromConfig(conf, logger)
+	if err != nil {
+		return err
+	}
+	defer metricsCloser.Close()
+
+	jsvc := jail.NewJailService(conf, logger, m.Prefix("jail"), utils.Wrap{})
+	return jsvc.InitializeSystem(jail.InitOptions{
+		Resume:   *resumeFlag,
+		FromStep: *fromStepFlag,
+		DryRun:   *dryRunFlag,
+		Out:      os.Stdout,
+	})
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+)
+
+// TestLegacyArgs verifies flags given without a subcommand still
+// serve, or with -i initialize the system
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-c", "conf.json"}, []string{"serve", "-c", "conf.json"}},
+		{[]string{"-c", "conf.json", "-i"}, []string{"init", "-c", "conf.json"}},
+		{[]string{"-i=true", "-c", "conf.json"}, []string{"init", "-c", "conf.json"}},
+	}
+	for _, tt := range tests {
+		if got := legacyArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("legacyArgs(%v) expected %v got %v", tt.args, tt.want, got)
+		}
+	}
+}
+
+// TestSubcommand verifies only the given subcommands are accepted
+func TestSubcommand(t *testing.T) {
+	sub, rest, err := subcommand([]string{"kill", "-server", "http://host", "all"}, "ls", "kill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != "kill" || !reflect.DeepEqual(rest, []string{"-server", "http://host", "all"}) {
+		t.Errorf("unexpected %s %v", sub, rest)
+	}
+	if _, _, err := subcommand([]string{"rm"}, "ls", "kill"); err == nil {
+		t.Error("expected error for unknown subcommand")
+	}
+	if _, _, err := subcommand(nil, "ls"); err == nil {
+		t.Error("expected error for missing subcommand")
+	}
+}
+
+// TestTargetHeader verifies the admin token header is taken from the
+// flag, then the configuration file, then the default
+func TestTargetHeader(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-c", "config/testdata/config.json"}, "X-Sky-Island-Token"},
+		{[]string{"-c", "config/testdata/config.json", "-token-header", "X-Token"}, "X-Token"},
+		{[]string{"-c", "missing.json"}, config.DefaultAdminTokenHeader},
+	}
+	for _, tt := range tests {
+		var tgt target
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		tgt.addFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := tgt.header(); got != tt.want {
+			t.Errorf("%v: expected %s got %s", tt.args, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+// Names of the supported backends
+const (
+	BackendStatsd     = "statsd"
+	BackendPrometheus = "prometheus"
+)
+
+// Backend records measurements. Labels are given as name/value pairs
+type Backend interface {
+	Counter(name string, labels []string)
+	Gauge(name string, v float64, labels []string)
+	Histogram(name string, d time.Duration, labels []string)
+}
+
+// Metrics records measurements to every backend it was created with.
+// Label values must come from a bounded set, e.g. registered function
+// names, never IP addresses or repo URLs. A nil Metrics records nothing
+type Metrics struct {
+	prefix   string
+	backends []Backend
+}
+
+// New creates a new value of type Metrics pointer recording to the
+// given backends
+func New(backends ...Backend) *Metrics {
+	return &Metrics{backends: backends}
+}
+
+// Discard returns a Metrics recording nothing
+func Discard() *Metrics {
+	return New()
+}
+
+// Prefix returns a copy of the Metrics prefixing every name with the
+// given prefix
+func (m *Metrics) Prefix(prefix string) *Metrics {
+	if m == nil {
+		return nil
+	}
+	p := *m
+	p.prefix = m.name(prefix)
+	return &p
+}
+
+// name returns the given name with the prefix
+func (m *Metrics) name(name string) string {
+	if m.prefix == "" {
+		return name
+	}
+	return m.prefix + "." + name
+}
+
+// Inc adds one to the named counter
+func (m *Metrics) Inc(name string, labels ...string) {
+	if m == nil {
+		return
+	}
+	for _, b := range m.backends {
+		b.Counter(m.name(name), labels)
+	}
+}
+
+// Set sets the named gauge to the given value
+func (m *Metrics) Set(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	for _, b := range m.backends {
+		b.Gauge(m.name(name), v, labels)
+	}
+}
+
+// ObserveDuration records the given duration in the named histogram
+func (m *Metrics) ObserveDuration(name string, d time.Duration, labels ...string) {
+	if m == nil {
+		return
+	}
+	for _, b := range m.backends {
+		b.Histogram(m.name(name), d, labels)
+	}
+}
+
+// NewTiming starts timing something
+func (m *Metrics) NewTiming() Timing {
+	return Timing{m: m, start: time.Now()}
+}
+
+// Timing measures the time since it was started
+type Timing struct {
+	m     *Metrics
+	start time.Time
+}
+
+// Send records the time since the timing was started in the named
+// histogram
+func (t Timing) Send(name string, labels ...string) {
+	t.m.ObserveDuration(name, time.Since(t.start), labels...)
+}
+
+// FromConfig creates the Metrics for the configured backends, statsd
+// only if none are configured. The returned handler serves the
+// Prometheus metrics, if enabled, and the closer flushes statsd. A
+// statsd client that can't connect is logged and muted
+func FromConfig(conf *config.Config, l gklog.Logger) (*Metrics, http.Handler, io.Closer, error) {
+	backends := []string{BackendStatsd}
+	if conf.Metrics != nil && len(conf.Metrics.Backends) > 0 {
+		backends = conf.Metrics.Backends
+	}
+	var (
+		bs      []Backend
+		handler http.Handler
+		closer  io.Closer = nopCloser{}
+	)
+	for _, b := range backends {
+		switch b {
+		case BackendStatsd:
+			var addr string
+			if conf.Jails != nil {
+				addr = conf.Jails.MonitoringAddr
+			}
+			c, err := statsd.New(statsd.Address(addr))
+			if err != nil {
+				l.Log("error", err.Error())
+			}
+			bs = append(bs, NewStatsd(c))
+			closer = statsdCloser{c}
+		case BackendPrometheus:
+			p := NewPrometheus("skyisland")
+			bs = append(bs, p)
+			handler = p.Handler()
+		default:
+			return nil, nil, nil, fmt.Errorf("unknown metrics backend %q, must be %s or %s", b, BackendStatsd, BackendPrometheus)
+		}
+	}
+	return New(bs...), handler, closer, nil
+}
+
+// nopCloser closes nothing
+type nopCloser struct{}
+
+// Close implements the io.Closer interface
+func (nopCloser) Close() error { return nil }
+
+// statsdCloser flushes and closes a statsd client
+type statsdCloser struct {
+	c *statsd.Client
+}
+
+// Close implements the io.Closer interface
+func (s statsdCloser) Close() error {
+	s.c.Close()
+	return nil
+}
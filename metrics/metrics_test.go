@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder is a backend recording the names it was given
+type recorder struct {
+	names []string
+}
+
+func (r *recorder) Counter(name string, labels []string)          { r.names = append(r.names, name) }
+func (r *recorder) Gauge(name string, v float64, labels []string) { r.names = append(r.names, name) }
+func (r *recorder) Histogram(name string, d time.Duration, labels []string) {
+	r.names = append(r.names, name)
+}
+
+// TestPrefix verifies prefixes are joined to the metric names
+func TestPrefix(t *testing.T) {
+	r := &recorder{}
+	m := New(r).Prefix("jail").Prefix("pool")
+	m.Inc("created")
+	if len(r.names) != 1 || r.names[0] != "jail.pool.created" {
+		t.Errorf("expected jail.pool.created got %v", r.names)
+	}
+}
+
+// TestNilMetrics verifies a nil Metrics records nothing
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.Inc("created")
+	m.Set("pool.available", 1)
+	m.Prefix("jail").NewTiming().Send("create")
+}
+
+// TestStatsdName verifies label values are appended to statsd names
+func TestStatsdName(t *testing.T) {
+	name := statsdName("invocation.phase", []string{"phase", "build", "function", "my.fn"})
+	if name != "invocation.phase.build.my_fn" {
+		t.Errorf("unexpected name %s", name)
+	}
+}
+
+// TestPrometheus verifies recorded metrics are served by the handler
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus("skyisland")
+	m := New(p)
+	m.Inc("invocations", "function", "hello", "status", "ok")
+	m.Set("pool.available", 5)
+	m.ObserveDuration("invocation.phase", time.Second, "phase", "build", "function", "hello")
+
+	w := httptest.NewRecorder()
+	p.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`skyisland_invocations_total{function="hello",status="ok"} 1`,
+		`skyisland_pool_available 5`,
+		`skyisland_invocation_phase_seconds_count{function="hello",phase="build"} 1`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %s in output", want)
+		}
+	}
+}
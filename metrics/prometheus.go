@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus records to its own Prometheus registry, served by its
+// handler. Metrics are registered on first use with the label names
+// they're first given, later uses with other label names are dropped
+type Prometheus struct {
+	mu         sync.Mutex
+	namespace  string
+	reg        *prometheus.Registry
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// NewPrometheus creates a new value of type Prometheus pointer naming
+// every metric in the given namespace. Go runtime and process metrics
+// are included
+func NewPrometheus(namespace string) *Prometheus {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGoCollector())
+	reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return &Prometheus{
+		namespace:  namespace,
+		reg:        reg,
+		counters:   make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+}
+
+// Handler returns the handler serving the metrics
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.reg, promhttp.HandlerOpts{})
+}
+
+// promName returns the Prometheus name of the given metric name
+func promName(name string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+// splitLabels splits the given name/value pairs into the label names
+// and values. A trailing name without a value is ignored
+func splitLabels(labels []string) ([]string, []string) {
+	n := len(labels) / 2
+	names := make([]string, 0, n)
+	values := make([]string, 0, n)
+	for i := 0; i+1 < len(labels); i += 2 {
+		names = append(names, labels[i])
+		values = append(values, labels[i+1])
+	}
+	return names, values
+}
+
+// Counter implements the Backend interface
+func (p *Prometheus) Counter(name string, labels []string) {
+	names, values := splitLabels(labels)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.counters[name]
+	if !ok {
+		c = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: p.namespace,
+			Name:      promName(name) + "_total",
+			Help:      "Total " + name,
+		}, names)
+		if err := p.reg.Register(c); err != nil {
+			return
+		}
+		p.counters[name] = c
+	}
+	if m, err := c.GetMetricWithLabelValues(values...); err == nil {
+		m.Inc()
+	}
+}
+
+// Gauge implements the Backend interface
+func (p *Prometheus) Gauge(name string, v float64, labels []string) {
+	names, values := splitLabels(labels)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.gauges[name]
+	if !ok {
+		g = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: p.namespace,
+			Name:      promName(name),
+			Help:      "Current " + name,
+		}, names)
+		if err := p.reg.Register(g); err != nil {
+			return
+		}
+		p.gauges[name] = g
+	}
+	if m, err := g.GetMetricWithLabelValues(values...); err == nil {
+		m.Set(v)
+	}
+}
+
+// Histogram implements the Backend interface, recording the duration
+// in seconds
+func (p *Prometheus) Histogram(name string, d time.Duration, labels []string) {
+	names, values := splitLabels(labels)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.histograms[name]
+	if !ok {
+		h = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: p.namespace,
+			Name:      promName(name) + "_seconds",
+			Help:      "Duration of " + name,
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, names)
+		if err := p.reg.Register(h); err != nil {
+			return
+		}
+		p.histograms[name] = h
+	}
+	if m, err := h.GetMetricWithLabelValues(values...); err == nil {
+		m.Observe(d.Seconds())
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"regexp"
+	"time"
+
+	"gopkg.in/alexcesaro/statsd.v2"
+)
+
+// unsafeRegexp matches the characters replaced in statsd names
+var unsafeRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// statsdBackend records to a statsd client. Statsd has no labels so
+// label values are appended to the name
+type statsdBackend struct {
+	c *statsd.Client
+}
+
+// NewStatsd creates a backend recording to the given statsd client
+func NewStatsd(c *statsd.Client) Backend {
+	return &statsdBackend{c: c}
+}
+
+// statsdName returns the name with each label value appended
+func statsdName(name string, labels []string) string {
+	for i := 1; i < len(labels); i += 2 {
+		name += "." + unsafeRegexp.ReplaceAllString(labels[i], "_")
+	}
+	return name
+}
+
+// Counter implements the Backend interface
+func (s *statsdBackend) Counter(name string, labels []string) {
+	s.c.Increment(statsdName(name, labels))
+}
+
+// Gauge implements the Backend interface
+func (s *statsdBackend) Gauge(name string, v float64, labels []string) {
+	s.c.Gauge(statsdName(name, labels), v)
+}
+
+// Histogram implements the Backend interface, recording the duration
+// as a timing in milliseconds
+func (s *statsdBackend) Histogram(name string, d time.Duration, labels []string) {
+	s.c.Timing(statsdName(name, labels), int(d/time.Millisecond))
+}
@@ -20,13 +20,13 @@ func (_m *FSServicer) CreateBaseJailDataset() error {
 	return r0
 }
 
-// CloneBaseToJail provides a mock function with given fields: _a0
-func (_m *FSServicer) CloneBaseToJail(_a0 string) error {
-	ret := _m.Called(_a0)
+// CloneBaseToJail provides a mock function with given fields: _a0, _a1
+func (_m *FSServicer) CloneBaseToJail(_a0 string, _a1 string) error {
+	ret := _m.Called(_a0, _a1)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(_a0, _a1)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -75,3 +75,24 @@ func (_m *FSServicer) RemoveDataset(_a0 string) error {
 
 	return r0
 }
+
+// DatasetExists provides a mock function with given fields: _a0
+func (_m *FSServicer) DatasetExists(_a0 string) (bool, error) {
+	ret := _m.Called(_a0)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,40 @@
+package mocks
+
+import "github.com/briandowns/sky-island/jail"
+import "github.com/stretchr/testify/mock"
+
+type HealthServicer struct {
+	mock.Mock
+}
+
+// Live provides a mock function with given fields:
+func (_m *HealthServicer) Live() *jail.HealthReport {
+	ret := _m.Called()
+
+	var r0 *jail.HealthReport
+	if rf, ok := ret.Get(0).(func() *jail.HealthReport); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jail.HealthReport)
+		}
+	}
+
+	return r0
+}
+
+// Ready provides a mock function with given fields:
+func (_m *HealthServicer) Ready() *jail.HealthReport {
+	ret := _m.Called()
+
+	var r0 *jail.HealthReport
+	if rf, ok := ret.Get(0).(func() *jail.HealthReport); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jail.HealthReport)
+		}
+	}
+
+	return r0
+}
@@ -2,18 +2,19 @@ package mocks
 
 import "github.com/briandowns/sky-island/jail"
 import "github.com/stretchr/testify/mock"
+import "time"
 
 type JailServicer struct {
 	mock.Mock
 }
 
-// InitializeSystem provides a mock function with given fields:
-func (_m *JailServicer) InitializeSystem() error {
-	ret := _m.Called()
+// InitializeSystem provides a mock function with given fields: _a0
+func (_m *JailServicer) InitializeSystem(_a0 jail.InitOptions) error {
+	ret := _m.Called(_a0)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(jail.InitOptions) error); ok {
+		r0 = rf(_a0)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -35,6 +36,20 @@ func (_m *JailServicer) CreateJail(_a0 string, _a1 bool) error {
 	return r0
 }
 
+// CreateFunctionJail provides a mock function with given fields: _a0, _a1
+func (_m *JailServicer) CreateFunctionJail(_a0 string, _a1 *jail.JailSpec) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *jail.JailSpec) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RemoveJail provides a mock function with given fields: _a0
 func (_m *JailServicer) RemoveJail(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -85,3 +100,54 @@ func (_m *JailServicer) JailDetails(_a0 int) (*jail.JLS, error) {
 
 	return r0, r1
 }
+
+// MountSecrets provides a mock function with given fields: _a0, _a1
+func (_m *JailServicer) MountSecrets(_a0 string, _a1 map[string][]byte) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, map[string][]byte) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UnmountSecrets provides a mock function with given fields: _a0
+func (_m *JailServicer) UnmountSecrets(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Orphans provides a mock function with given fields: _a0
+func (_m *JailServicer) Orphans(_a0 time.Duration) ([]string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(time.Duration) []string); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Duration) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,74 @@
+package mocks
+
+import "time"
+import "github.com/briandowns/sky-island/jail"
+import "github.com/stretchr/testify/mock"
+
+type PatchServicer struct {
+	mock.Mock
+}
+
+// Patch provides a mock function with given fields:
+func (_m *PatchServicer) Patch() (*jail.PatchRun, error) {
+	ret := _m.Called()
+
+	var r0 *jail.PatchRun
+	if rf, ok := ret.Get(0).(func() *jail.PatchRun); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jail.PatchRun)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Patching provides a mock function with given fields:
+func (_m *PatchServicer) Patching() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
+// History provides a mock function with given fields:
+func (_m *PatchServicer) History() ([]*jail.PatchRun, error) {
+	ret := _m.Called()
+
+	var r0 []*jail.PatchRun
+	if rf, ok := ret.Get(0).(func() []*jail.PatchRun); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*jail.PatchRun)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Schedule provides a mock function with given fields: _a0, _a1
+func (_m *PatchServicer) Schedule(_a0 time.Duration, _a1 <-chan struct{}) {
+	_m.Called(_a0, _a1)
+}
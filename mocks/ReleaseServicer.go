@@ -0,0 +1,133 @@
+package mocks
+
+import "github.com/briandowns/sky-island/jail"
+import "github.com/stretchr/testify/mock"
+
+type ReleaseServicer struct {
+	mock.Mock
+}
+
+// Releases provides a mock function with given fields:
+func (_m *ReleaseServicer) Releases() ([]*jail.Release, error) {
+	ret := _m.Called()
+
+	var r0 []*jail.Release
+	if rf, ok := ret.Get(0).(func() []*jail.Release); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*jail.Release)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Active provides a mock function with given fields:
+func (_m *ReleaseServicer) Active() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// Activate provides a mock function with given fields: _a0
+func (_m *ReleaseServicer) Activate(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Upgrade provides a mock function with given fields: _a0
+func (_m *ReleaseServicer) Upgrade(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Upgrading provides a mock function with given fields:
+func (_m *ReleaseServicer) Upgrading() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// UpgradeState provides a mock function with given fields: _a0
+func (_m *ReleaseServicer) UpgradeState(_a0 string) (*jail.InitState, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *jail.InitState
+	if rf, ok := ret.Get(0).(func(string) *jail.InitState); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*jail.InitState)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Prune provides a mock function with given fields: _a0
+func (_m *ReleaseServicer) Prune(_a0 []string) ([]string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func([]string) []string); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
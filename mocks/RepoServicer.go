@@ -1,18 +1,22 @@
 package mocks
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
 
 type RepoServicer struct {
 	mock.Mock
 }
 
-// CloneRepo provides a mock function with given fields: jpath, fname
-func (_m *RepoServicer) CloneRepo(jpath string, fname string) error {
-	ret := _m.Called(jpath, fname)
+// CloneRepo provides a mock function with given fields: ctx, jpath, fname
+func (_m *RepoServicer) CloneRepo(ctx context.Context, jpath string, fname string) error {
+	ret := _m.Called(ctx, jpath, fname)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(jpath, fname)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, jpath, fname)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -33,3 +37,24 @@ func (_m *RepoServicer) RemoveRepo(repo string) error {
 
 	return r0
 }
+
+// RepoCommit provides a mock function with given fields: repo
+func (_m *RepoServicer) RepoCommit(repo string) (string, error) {
+	ret := _m.Called(repo)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(repo)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(repo)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
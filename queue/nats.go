@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/nats-io/nats.go"
+)
+
+// natsDialTimeout is how long connecting to a NATS server may take
+// when the context has no deadline
+const natsDialTimeout = 5 * time.Second
+
+// errNATSClosed is returned by subscriptions of a closed connection
+var errNATSClosed = errors.New("nats: connection closed")
+
+// NATS is a connection to a NATS server. Messages are delivered at
+// most once, as NATS does without JetStream, and those arriving while
+// the subscriber's channel is full are dropped as a slow consumer's
+// would be. The connection isn't reconnected, the Manager dials again
+type NATS struct {
+	conn   *nats.Conn
+	closed chan struct{}
+
+	mu  sync.Mutex
+	seq int64
+}
+
+// DialNATS connects to the NATS server of the given config
+func DialNATS(ctx context.Context, conf *config.NATS) (*NATS, error) {
+	n := &NATS{closed: make(chan struct{})}
+	timeout := natsDialTimeout
+	if d, ok := ctx.Deadline(); ok {
+		timeout = time.Until(d)
+	}
+	opts := []nats.Option{
+		nats.Name("sky-island"),
+		nats.Timeout(timeout),
+		nats.NoReconnect(),
+		nats.ClosedHandler(func(*nats.Conn) { close(n.closed) }),
+	}
+	if conf.Token != "" {
+		opts = append(opts, nats.Token(conf.Token))
+	}
+	if conf.User != "" {
+		opts = append(opts, nats.UserInfo(conf.User, conf.Password))
+	}
+	conn, err := nats.Connect(conf.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.conn = conn
+	return n, nil
+}
+
+// Subscribe implements Broker
+func (n *NATS) Subscribe(ctx context.Context, source, group string, msgs chan<- *Message) error {
+	// the lock is held while sending so the channel isn't written to
+	// once the subscriber has returned
+	var mu sync.Mutex
+	done := false
+	handler := func(m *nats.Msg) {
+		msg := &Message{Source: m.Subject, Data: m.Data, Reply: m.Reply}
+		n.mu.Lock()
+		n.seq++
+		msg.ID = strconv.FormatInt(n.seq, 10)
+		n.mu.Unlock()
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+		select {
+		case msgs <- msg:
+		default:
+		}
+	}
+	var sub *nats.Subscription
+	var err error
+	if group != "" {
+		sub, err = n.conn.QueueSubscribe(source, group, handler)
+	} else {
+		sub, err = n.conn.Subscribe(source, handler)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		sub.Unsubscribe()
+		mu.Lock()
+		done = true
+		mu.Unlock()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.closed:
+		if err := n.conn.LastError(); err != nil {
+			return err
+		}
+		return errNATSClosed
+	}
+}
+
+// Ack implements Broker. Messages of NATS subjects aren't acknowledged
+func (n *NATS) Ack(ctx context.Context, m *Message) error {
+	return nil
+}
+
+// Publish implements Broker
+func (n *NATS) Publish(ctx context.Context, dest string, data []byte) error {
+	return n.conn.Publish(dest, data)
+}
+
+// Close implements Broker
+func (n *NATS) Close() error {
+	n.conn.Close()
+	return nil
+}
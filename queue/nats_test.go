@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+)
+
+// newNATSServer starts a NATS server on a random port requiring the
+// given token
+func newNATSServer(t *testing.T, token string) *server.Server {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.Authorization = token
+	s := natstest.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// TestNATS verifies messages published to a subject are delivered to
+// a single member of a queue group along with their reply inbox
+func TestNATS(t *testing.T) {
+	s := newNATSServer(t, "s3cr3t")
+	if _, err := DialNATS(context.Background(), &config.NATS{URL: s.ClientURL(), Token: "guess"}); err == nil || !strings.Contains(strings.ToLower(err.Error()), "authorization violation") {
+		t.Errorf("expected authorization error got %v", err)
+	}
+	conf := &config.NATS{URL: s.ClientURL(), Token: "s3cr3t"}
+	base := s.NumSubscriptions()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs := make(chan *Message, 10)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		n, err := DialNATS(ctx, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Close()
+		go func() { errs <- n.Subscribe(ctx, "jobs", "workers", msgs) }()
+	}
+	pub, err := DialNATS(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+	// wait for both subscriptions to reach the server
+	for s.NumSubscriptions() != base+2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := pub.Publish(ctx, "jobs", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.conn.PublishRequest("jobs", "_INBOX.42", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	// each member of the group receives one of the messages
+	received := make(map[string]*Message)
+	for i := 0; i < 2; i++ {
+		m := <-msgs
+		received[string(m.Data)] = m
+	}
+	if m := received["hello world"]; m == nil || m.Source != "jobs" || m.Reply != "" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m := received["hi"]; m == nil || m.Reply != "_INBOX.42" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	select {
+	case m := <-msgs:
+		t.Errorf("expected each message once got %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != context.Canceled {
+			t.Errorf("expected %v got %v", context.Canceled, err)
+		}
+	}
+}
+
+// TestNATS_ConnectionLost verifies subscribing fails once the server
+// goes away
+func TestNATS_ConnectionLost(t *testing.T) {
+	s := newNATSServer(t, "")
+	n, err := DialNATS(context.Background(), &config.NATS{URL: s.ClientURL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	base := s.NumSubscriptions()
+	errs := make(chan error)
+	go func() { errs <- n.Subscribe(context.Background(), "jobs", "", make(chan *Message)) }()
+	for s.NumSubscriptions() != base+1 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Shutdown()
+	if err := <-errs; err == nil {
+		t.Error("expected error but received none")
+	}
+}
@@ -0,0 +1,364 @@
+// Package queue runs functions with the messages of NATS subjects and
+// Redis streams
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// Brokers messages are consumed from
+const (
+	BrokerNATS  = "nats"
+	BrokerRedis = "redis"
+)
+
+// Defaults of a trigger
+const (
+	DefaultConcurrency = 1
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = time.Second
+)
+
+// maxReconnectWait is the longest wait between attempts to reconnect
+// to a broker
+const maxReconnectWait = 30 * time.Second
+
+// ErrNotConfigured is returned for triggers of brokers missing from
+// the config
+var ErrNotConfigured = errors.New("queue broker not configured")
+
+// Message is a message received from a broker
+type Message struct {
+	ID     string
+	Source string
+	Data   []byte
+	// Reply is the inbox of a NATS request
+	Reply string
+	// group is the consumer group the message was read by
+	group string
+}
+
+// Broker is a connection to a message broker
+type Broker interface {
+	// Subscribe sends the messages of the given subject or stream,
+	// shared between the subscribers of the group, on the given
+	// channel until the context is done or the connection fails
+	Subscribe(ctx context.Context, source, group string, msgs chan<- *Message) error
+	// Ack acknowledges the given message was handled
+	Ack(ctx context.Context, m *Message) error
+	// Publish publishes the given data to a subject or stream
+	Publish(ctx context.Context, dest string, data []byte) error
+	// Close closes the connection
+	Close() error
+}
+
+// DialFunc connects to a broker
+type DialFunc func(ctx context.Context) (Broker, error)
+
+// FromConfig returns the dial funcs of the brokers in the given
+// config. Redis consumers are named after the node
+func FromConfig(conf *config.Config, consumer string) map[string]DialFunc {
+	dials := make(map[string]DialFunc)
+	if conf.Queues == nil {
+		return dials
+	}
+	if n := conf.Queues.NATS; n != nil {
+		dials[BrokerNATS] = func(ctx context.Context) (Broker, error) {
+			return DialNATS(ctx, n)
+		}
+	}
+	if r := conf.Queues.Redis; r != nil {
+		dials[BrokerRedis] = func(ctx context.Context) (Broker, error) {
+			return NewRedis(r, consumer), nil
+		}
+	}
+	return dials
+}
+
+// Trigger runs a function with each message of a subject or stream.
+// A message whose run fails is retried up to MaxAttempts times in all,
+// waiting Backoff, doubled after every attempt, in between. Messages
+// that never succeed are published to DeadLetter, if set, and
+// acknowledged. The output of successful runs is published to Reply,
+// if set, and to the inbox of NATS requests. NATS subjects are
+// consumed without JetStream so their messages are delivered at most
+// once: those arriving while the runs are busy or the connection is
+// down are lost. Redis stream entries are delivered until acknowledged
+type Trigger struct {
+	Function    string
+	Broker      string
+	Source      string
+	Group       string
+	Concurrency int
+	MaxAttempts int
+	Backoff     time.Duration
+	DeadLetter  string
+	Reply       string
+}
+
+// InvokeFunc runs the named function with the given input, returning
+// its output
+type InvokeFunc func(ctx context.Context, function string, input []byte) ([]byte, error)
+
+// Status is the state of a trigger
+type Status struct {
+	Function     string `json:"function"`
+	Broker       string `json:"broker"`
+	Source       string `json:"source"`
+	Group        string `json:"group"`
+	Concurrency  int    `json:"concurrency"`
+	Connected    bool   `json:"connected"`
+	Processed    int64  `json:"processed"`
+	Failed       int64  `json:"failed"`
+	DeadLettered int64  `json:"dead_lettered"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// consumer is a running trigger
+type consumer struct {
+	t      *Trigger
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+// update changes the status of the consumer
+func (c *consumer) update(fn func(s *Status)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(&c.status)
+}
+
+// Manager runs triggers
+type Manager struct {
+	mu        sync.Mutex
+	logger    gklog.Logger
+	metrics   *metrics.Metrics
+	dials     map[string]DialFunc
+	invoke    InvokeFunc
+	consumers map[string]*consumer
+}
+
+// NewManager creates a new value of type Manager pointer running the
+// triggers of the brokers with the given dial funcs
+func NewManager(l gklog.Logger, m *metrics.Metrics, dials map[string]DialFunc, invoke InvokeFunc) *Manager {
+	return &Manager{
+		logger:    l,
+		metrics:   m,
+		dials:     dials,
+		invoke:    invoke,
+		consumers: make(map[string]*consumer),
+	}
+}
+
+// Configured reports whether the given broker is configured
+func (m *Manager) Configured(broker string) bool {
+	_, ok := m.dials[broker]
+	return ok
+}
+
+// Set starts the given trigger, replacing the trigger of the same
+// function, filling in the defaults of the fields not set
+func (m *Manager) Set(t *Trigger) error {
+	dial, ok := m.dials[t.Broker]
+	if !ok {
+		return fmt.Errorf("%s: %v", t.Broker, ErrNotConfigured)
+	}
+	if t.Concurrency == 0 {
+		t.Concurrency = DefaultConcurrency
+	}
+	if t.MaxAttempts == 0 {
+		t.MaxAttempts = DefaultMaxAttempts
+	}
+	if t.Backoff == 0 {
+		t.Backoff = DefaultBackoff
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &consumer{
+		t:      t,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: Status{
+			Function:    t.Function,
+			Broker:      t.Broker,
+			Source:      t.Source,
+			Group:       t.Group,
+			Concurrency: t.Concurrency,
+		},
+	}
+	m.Remove(t.Function)
+	m.mu.Lock()
+	m.consumers[t.Function] = c
+	m.mu.Unlock()
+	go m.run(ctx, c, dial)
+	return nil
+}
+
+// Remove stops the trigger of the given function, waiting for the
+// runs in progress to finish
+func (m *Manager) Remove(function string) {
+	m.mu.Lock()
+	c, ok := m.consumers[function]
+	delete(m.consumers, function)
+	m.mu.Unlock()
+	if ok {
+		c.cancel()
+		<-c.done
+	}
+}
+
+// Close stops every trigger
+func (m *Manager) Close() {
+	m.mu.Lock()
+	functions := make([]string, 0, len(m.consumers))
+	for fn := range m.consumers {
+		functions = append(functions, fn)
+	}
+	m.mu.Unlock()
+	for _, fn := range functions {
+		m.Remove(fn)
+	}
+}
+
+// Triggers returns the status of every trigger sorted by function
+func (m *Manager) Triggers() []*Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]*Status, 0, len(m.consumers))
+	for _, c := range m.consumers {
+		c.mu.Lock()
+		s := c.status
+		c.mu.Unlock()
+		statuses = append(statuses, &s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Function < statuses[j].Function })
+	return statuses
+}
+
+// run consumes the trigger's messages until the context is done,
+// reconnecting to the broker when the connection fails
+func (m *Manager) run(ctx context.Context, c *consumer, dial DialFunc) {
+	defer close(c.done)
+	wait := time.Second
+	for {
+		err := m.consume(ctx, c, dial)
+		c.update(func(s *Status) { s.Connected = false })
+		if ctx.Err() != nil {
+			return
+		}
+		m.logger.Log("error", "queue trigger of "+c.t.Function+": "+err.Error())
+		c.update(func(s *Status) { s.LastError = err.Error() })
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxReconnectWait {
+			wait = maxReconnectWait
+		}
+	}
+}
+
+// consume connects to the broker and runs the trigger's function with
+// each message received until the context is done or the connection
+// fails
+func (m *Manager) consume(ctx context.Context, c *consumer, dial DialFunc) error {
+	b, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	c.update(func(s *Status) { s.Connected = true })
+	msgs := make(chan *Message, c.t.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < c.t.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range msgs {
+				m.handle(ctx, c, b, msg)
+			}
+		}()
+	}
+	err = b.Subscribe(ctx, c.t.Source, c.t.Group, msgs)
+	close(msgs)
+	wg.Wait()
+	return err
+}
+
+// handle runs the trigger's function with the given message, retrying
+// and dead lettering it according to the trigger's policy. Messages
+// are left unacknowledged if the context is done first
+func (m *Manager) handle(ctx context.Context, c *consumer, b Broker, msg *Message) {
+	t := c.t
+	backoff := t.Backoff
+	var err error
+	for attempt := 1; attempt <= t.MaxAttempts; attempt++ {
+		var out []byte
+		if out, err = m.invoke(ctx, t.Function, msg.Data); err == nil {
+			m.metrics.Inc("messages", "function", t.Function, "status", "ok")
+			c.update(func(s *Status) { s.Processed++ })
+			m.reply(ctx, t, b, msg, out)
+			m.ack(ctx, t, b, msg)
+			return
+		}
+		m.metrics.Inc("messages", "function", t.Function, "status", "failed")
+		c.update(func(s *Status) {
+			s.Failed++
+			s.LastError = err.Error()
+		})
+		if attempt == t.MaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	m.logger.Log("error", fmt.Sprintf("message %s of %s failed %d times: %v", msg.ID, msg.Source, t.MaxAttempts, err))
+	if t.DeadLetter != "" {
+		if err := b.Publish(ctx, t.DeadLetter, msg.Data); err != nil {
+			m.logger.Log("error", "dead lettering message "+msg.ID+": "+err.Error())
+			return
+		}
+		m.metrics.Inc("messages", "function", t.Function, "status", "dead_lettered")
+		c.update(func(s *Status) { s.DeadLettered++ })
+	}
+	m.ack(ctx, t, b, msg)
+}
+
+// reply publishes the output of a run to the trigger's reply subject
+// or stream and the inbox of the message
+func (m *Manager) reply(ctx context.Context, t *Trigger, b Broker, msg *Message, out []byte) {
+	for _, dest := range []string{t.Reply, msg.Reply} {
+		if dest == "" {
+			continue
+		}
+		if err := b.Publish(ctx, dest, out); err != nil {
+			m.logger.Log("error", "replying to message "+msg.ID+": "+err.Error())
+		}
+	}
+}
+
+// ack acknowledges the given message, logging failures
+func (m *Manager) ack(ctx context.Context, t *Trigger, b Broker, msg *Message) {
+	if err := b.Ack(ctx, msg); err != nil {
+		m.logger.Log("error", "acknowledging message "+msg.ID+" of "+t.Function+": "+err.Error())
+	}
+}
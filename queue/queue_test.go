@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/briandowns/sky-island/metrics"
+	gklog "github.com/go-kit/kit/log"
+)
+
+// memBroker is a Broker delivering the messages sent on in
+type memBroker struct {
+	in chan *Message
+
+	mu        sync.Mutex
+	dials     int
+	acked     []string
+	published map[string][]string
+}
+
+// newMemBroker creates a broker whose first connection fails
+func newMemBroker() *memBroker {
+	return &memBroker{in: make(chan *Message), published: make(map[string][]string)}
+}
+
+// dial implements DialFunc, failing the first time
+func (b *memBroker) dial(ctx context.Context) (Broker, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dials++
+	if b.dials == 1 {
+		return nil, errors.New("connection refused")
+	}
+	return b, nil
+}
+
+// Subscribe implements Broker
+func (b *memBroker) Subscribe(ctx context.Context, source, group string, msgs chan<- *Message) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m := <-b.in:
+			msgs <- m
+		}
+	}
+}
+
+// Ack implements Broker
+func (b *memBroker) Ack(ctx context.Context, m *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.acked = append(b.acked, m.ID)
+	return nil
+}
+
+// Publish implements Broker
+func (b *memBroker) Publish(ctx context.Context, dest string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published[dest] = append(b.published[dest], string(data))
+	return nil
+}
+
+// Close implements Broker
+func (b *memBroker) Close() error {
+	return nil
+}
+
+// TestManager verifies messages are retried, replied to, dead lettered
+// and acknowledged, reconnecting after the broker can't be reached
+func TestManager(t *testing.T) {
+	b := newMemBroker()
+	var calls int32
+	invoke := func(ctx context.Context, function string, input []byte) ([]byte, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if string(input) == "poison" || n == 1 {
+			return nil, errors.New("boom")
+		}
+		return append([]byte(function+":"), input...), nil
+	}
+	m := NewManager(gklog.NewNopLogger(), metrics.Discard(), map[string]DialFunc{BrokerNATS: b.dial}, invoke)
+	defer m.Close()
+	if err := m.Set(&Trigger{Function: "resize", Broker: BrokerRedis, Source: "images"}); err == nil {
+		t.Error("expected error for a broker that isn't configured")
+	}
+	if err := m.Set(&Trigger{
+		Function:    "resize",
+		Broker:      BrokerNATS,
+		Source:      "images",
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		DeadLetter:  "images.dead",
+		Reply:       "images.done",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b.in <- &Message{ID: "1", Data: []byte("cat.png")}
+	b.in <- &Message{ID: "2", Data: []byte("poison"), Reply: "_INBOX.1"}
+	// sending a third message waits for the second to be taken
+	b.in <- &Message{ID: "3", Data: []byte("dog.png")}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		acked := len(b.acked)
+		b.mu.Unlock()
+		if acked == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 messages acknowledged got %d", acked)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dials != 2 {
+		t.Errorf("expected 2 dials got %d", b.dials)
+	}
+	if done := b.published["images.done"]; len(done) != 2 || done[0] != "resize:cat.png" || done[1] != "resize:dog.png" {
+		t.Errorf("unexpected replies %v", done)
+	}
+	if dead := b.published["images.dead"]; len(dead) != 1 || dead[0] != "poison" {
+		t.Errorf("unexpected dead letters %v", dead)
+	}
+	if len(b.published["_INBOX.1"]) != 0 {
+		t.Error("expected no reply to a failed request")
+	}
+	s := m.Triggers()
+	if len(s) != 1 || !s[0].Connected || s[0].Processed != 2 || s[0].Failed != 3 || s[0].DeadLettered != 1 {
+		t.Errorf("unexpected status %+v", s[0])
+	}
+}
+
+// TestManager_Concurrency verifies a trigger runs up to its
+// concurrency at once and waits for the runs when removed
+func TestManager_Concurrency(t *testing.T) {
+	b := newMemBroker()
+	b.dials = 1
+	var running, most int32
+	release := make(chan struct{})
+	invoke := func(ctx context.Context, function string, input []byte) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return nil, nil
+	}
+	m := NewManager(gklog.NewNopLogger(), metrics.Discard(), map[string]DialFunc{BrokerNATS: b.dial}, invoke)
+	if err := m.Set(&Trigger{Function: "resize", Broker: BrokerNATS, Source: "images", Concurrency: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		b.in <- &Message{ID: "m", Data: []byte("x")}
+	}
+	for atomic.LoadInt32(&running) != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	m.Remove("resize")
+	if most != 3 {
+		t.Errorf("expected 3 concurrent runs got %d", most)
+	}
+	if len(b.acked) != 3 {
+		t.Errorf("expected 3 messages acknowledged got %d", len(b.acked))
+	}
+	if len(m.Triggers()) != 0 {
+		t.Error("expected no triggers")
+	}
+}
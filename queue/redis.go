@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// DataField is the field of stream entries holding the message. The
+// fields of entries without it are given as a JSON object
+const DataField = "data"
+
+// redisBlock is how long a read of a stream waits for entries
+const redisBlock = 5 * time.Second
+
+// redisCount is the most entries read from a stream at once
+const redisCount = 10
+
+// Redis consumes Redis streams through consumer groups. Entries are
+// acknowledged once handled so those of a consumer that stopped are
+// delivered again when it restarts
+type Redis struct {
+	conf     *config.Redis
+	consumer string
+	client   *redis.Client
+}
+
+// NewRedis creates a new value of type Redis pointer reading streams
+// as the given consumer
+func NewRedis(conf *config.Redis, consumer string) *Redis {
+	return &Redis{
+		conf:     conf,
+		consumer: consumer,
+		client:   redis.NewClient(redisOptions(conf)),
+	}
+}
+
+// redisOptions returns the client options of the given config
+func redisOptions(conf *config.Redis) *redis.Options {
+	return &redis.Options{
+		Addr:     conf.Addr,
+		Password: conf.Password,
+		DB:       conf.DB,
+	}
+}
+
+// Subscribe implements Broker. The group is created if it doesn't
+// exist and the entries delivered to the consumer but never
+// acknowledged are read before new ones. Reads use a client of their
+// own, closed to unblock them when the context is done
+func (r *Redis) Subscribe(ctx context.Context, source, group string, msgs chan<- *Message) error {
+	c := redis.NewClient(redisOptions(r.conf))
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		c.Close()
+	}()
+	if err := c.XGroupCreateMkStream(ctx, source, group, "$").Err(); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	// the pending entries are paged through by ID, then new entries
+	// are waited for with >
+	id := "0"
+	for {
+		args := &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: r.consumer,
+			Streams:  []string{source, id},
+			Count:    redisCount,
+			Block:    -1,
+		}
+		if id == ">" {
+			args.Block = redisBlock
+		}
+		streams, err := c.XReadGroup(ctx, args).Result()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		var entries []redis.XMessage
+		if len(streams) > 0 {
+			entries = streams[0].Messages
+		}
+		if id != ">" {
+			id = ">"
+			if len(entries) > 0 {
+				id = entries[len(entries)-1].ID
+			}
+		}
+		for _, m := range streamEntries(entries) {
+			m.Source, m.group = source, group
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// streamEntries returns the messages of the given stream entries.
+// Entries deleted while pending have no fields and are skipped
+func streamEntries(entries []redis.XMessage) []*Message {
+	msgs := make([]*Message, 0, len(entries))
+	for _, e := range entries {
+		if e.Values == nil {
+			continue
+		}
+		m := &Message{ID: e.ID}
+		if data, ok := e.Values[DataField]; ok {
+			m.Data = []byte(fmt.Sprint(data))
+		} else {
+			m.Data, _ = json.Marshal(e.Values)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// Ack implements Broker
+func (r *Redis) Ack(ctx context.Context, m *Message) error {
+	return r.client.XAck(ctx, m.Source, m.group, m.ID).Err()
+}
+
+// Publish implements Broker, adding an entry holding the data in its
+// data field to the given stream
+func (r *Redis) Publish(ctx context.Context, dest string, data []byte) error {
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: dest,
+		Values: map[string]interface{}{DataField: data},
+	}).Err()
+}
+
+// Close implements Broker
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
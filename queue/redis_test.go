@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/briandowns/sky-island/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// receive returns the next message or fails the test
+func receive(t *testing.T, msgs <-chan *Message) *Message {
+	t.Helper()
+	select {
+	case m := <-msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+	return nil
+}
+
+// TestRedis verifies entries are delivered again after a restart until
+// they're acknowledged and published messages are added to streams
+func TestRedis(t *testing.T) {
+	s := miniredis.RunT(t)
+	s.RequireAuth("s3cr3t")
+	bad := NewRedis(&config.Redis{Addr: s.Addr(), Password: "guess"}, "node-1")
+	defer bad.Close()
+	if err := bad.Publish(context.Background(), "jobs", nil); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expected authentication error got %v", err)
+	}
+	conf := &config.Redis{Addr: s.Addr(), Password: "s3cr3t", DB: 2}
+	r := NewRedis(conf, "node-1")
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs := make(chan *Message, 10)
+	errs := make(chan error)
+	go func() { errs <- r.Subscribe(ctx, "jobs", "workers", msgs) }()
+	// wait for the group to be created
+	for !s.DB(2).Exists("jobs") {
+		time.Sleep(time.Millisecond)
+	}
+	if err := r.Publish(ctx, "jobs", []byte(`{"n":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DB(2).XAdd("jobs", "*", []string{"user", "bob", "action", "signup"}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := receive(t, msgs), receive(t, msgs)
+	if first.Source != "jobs" || string(first.Data) != `{"n":1}` {
+		t.Errorf("unexpected message %+v", first)
+	}
+	if string(second.Data) != `{"action":"signup","user":"bob"}` {
+		t.Errorf("unexpected message %+v", second)
+	}
+	if err := r.Ack(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+
+	// the unacknowledged entry is read again by the same consumer
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() { errs <- r.Subscribe(ctx, "jobs", "workers", msgs) }()
+	m := receive(t, msgs)
+	if m.ID != second.ID || string(m.Data) != string(second.Data) {
+		t.Errorf("expected %+v got %+v", second, m)
+	}
+	if err := r.Ack(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := r.client.XPending(ctx, "jobs", "workers").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("expected no pending entries got %+v", pending)
+	}
+}
+
+// TestStreamEntries verifies entries deleted while pending are skipped
+func TestStreamEntries(t *testing.T) {
+	msgs := streamEntries([]redis.XMessage{
+		{ID: "1-0"},
+		{ID: "2-0", Values: map[string]interface{}{"data": "hi"}},
+	})
+	if len(msgs) != 1 || msgs[0].ID != "2-0" || string(msgs[0].Data) != "hi" {
+		t.Errorf("unexpected entries %v", msgs)
+	}
+}
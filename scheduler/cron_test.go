@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseCron_Failure verifies invalid expressions are rejected
+func TestParseCron_Failure(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every",
+	}
+	for _, expr := range tests {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("expected error for %q but received none", expr)
+		}
+	}
+}
+
+// TestCron_Next verifies the next matching time is found for each
+// kind of field
+func TestCron_Next(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 17, 42, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17 * * mon-fri", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 feb *", time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"5,10 3 * * *", time.Date(2024, time.February, 1, 3, 5, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := c.Next(from); !next.Equal(tt.expected) {
+			t.Errorf("%s: expected %s got %s", tt.expr, tt.expected, next)
+		}
+	}
+}
+
+// TestCron_Next_Days verifies that when both day fields are
+// restricted a day matching either runs the cron
+func TestCron_Next_Days(t *testing.T) {
+	c, err := ParseCron("0 0 15 * fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 1st of March 2024 is a friday, before the 15th
+	from := time.Date(2024, time.February, 28, 0, 0, 0, 0, time.UTC)
+	expected := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if next := c.Next(from); !next.Equal(expected) {
+		t.Errorf("expected %s got %s", expected, next)
+	}
+}
+
+// TestCron_Next_Never verifies the zero time is returned for a cron
+// that never matches
+func TestCron_Next_Never(t *testing.T) {
+	c, err := ParseCron("0 0 30 feb *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := c.Next(time.Now()); !next.IsZero() {
+		t.Errorf("expected zero time got %s", next)
+	}
+}
+
+// TestCron_Next_Timezone verifies runs are computed in the location
+// of the given time, across a daylight saving change
+func TestCron_Next_Timezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	c, err := ParseCron("30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 02:30 doesn't exist on the 10th of March 2024 in New York
+	from := time.Date(2024, time.March, 9, 3, 0, 0, 0, loc)
+	next := c.Next(from)
+	if next.Day() != 11 || next.Hour() != 2 || next.Minute() != 30 {
+		t.Errorf("expected 2024-03-11 02:30 got %s", next)
+	}
+	from = time.Date(2024, time.June, 1, 0, 0, 0, 0, loc)
+	expected := time.Date(2024, time.June, 1, 6, 30, 0, 0, time.UTC)
+	if next := c.Next(from); !next.Equal(expected) {
+		t.Errorf("expected %s got %s", expected, next.UTC())
+	}
+}
+
+// TestCron_Next_MidnightGap verifies days are stepped over in zones
+// where midnight doesn't exist on the day the clocks change
+func TestCron_Next_MidnightGap(t *testing.T) {
+	loc, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	c, err := ParseCron("0 0 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// clocks went from 00:00 to 01:00 on the 8th of September 2024
+	from := time.Date(2024, time.September, 7, 12, 0, 0, 0, loc)
+	next := c.Next(from)
+	if next.Day() != 9 || next.Hour() != 0 {
+		t.Errorf("expected 2024-09-09 00:00 got %s", next)
+	}
+}
@@ -0,0 +1,368 @@
+// Package scheduler runs functions on cron schedules
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	gklog "github.com/go-kit/kit/log"
+)
+
+// Missed run policies, applied when the scheduler finds a run is
+// overdue, such as after a restart or the host being suspended
+const (
+	// MissedSkip records missed runs without running them
+	MissedSkip = "skip"
+	// MissedRunOnce runs a job once for all of its missed runs
+	MissedRunOnce = "run_once"
+)
+
+// Statuses of a run
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusMissed  = "missed"
+	StatusOverlap = "skipped_overlap"
+)
+
+// missedAfter is how late a run may start before it's missed
+const missedAfter = time.Minute
+
+// maxHistory is the number of runs kept per job
+const maxHistory = 50
+
+// Clock tells the time and waits, so the scheduler can be driven by
+// a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the system clock
+type realClock struct{}
+
+// Now implements Clock
+func (realClock) Now() time.Time { return time.Now() }
+
+// After implements Clock
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RunFunc runs the given job
+type RunFunc func(ctx context.Context, j *Job) error
+
+// Job is a function run on a schedule
+type Job struct {
+	Name     string
+	Cron     *Cron
+	Location *time.Location
+	Jitter   time.Duration
+	Missed   string
+	Input    json.RawMessage
+}
+
+// Run is the record of a scheduled run
+type Run struct {
+	Function   string    `json:"function"`
+	Scheduled  time.Time `json:"scheduled"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	// Missed is the number of runs due that never started, such as
+	// while the server was down
+	Missed int `json:"missed,omitempty"`
+}
+
+// Status is the state of a scheduled job
+type Status struct {
+	Function string    `json:"function"`
+	Cron     string    `json:"cron"`
+	Timezone string    `json:"timezone"`
+	Jitter   string    `json:"jitter,omitempty"`
+	Missed   string    `json:"missed"`
+	Next     time.Time `json:"next"`
+	LastRun  time.Time `json:"last_run,omitempty"`
+	Running  bool      `json:"running"`
+}
+
+// entry is a job and when it's next due
+type entry struct {
+	job *Job
+	// due is the scheduled time of the next run and fireAt that time
+	// with the jitter added
+	due    time.Time
+	fireAt time.Time
+}
+
+// state is what the scheduler persists across restarts
+type state struct {
+	// Last is the time each job has been handled up to, the scheduled
+	// time of its last run or when it was first scheduled
+	Last    map[string]time.Time `json:"last"`
+	History map[string][]*Run    `json:"history"`
+}
+
+// Scheduler runs jobs when they're due
+type Scheduler struct {
+	mu      sync.Mutex
+	logger  gklog.Logger
+	clock   Clock
+	run     RunFunc
+	path    string
+	entries map[string]*entry
+	running map[string]bool
+	state   state
+	wake    chan struct{}
+	wg      sync.WaitGroup
+	// jitter returns a random duration in [0, n)
+	jitter func(n time.Duration) time.Duration
+}
+
+// NewScheduler creates a new value of type Scheduler pointer running
+// jobs with the given func. The time of the last run of each job and
+// the run history are kept in the given file, in memory only if it's
+// empty. A nil clock is the system clock
+func NewScheduler(path string, l gklog.Logger, clock Clock, run RunFunc) (*Scheduler, error) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	s := &Scheduler{
+		logger:  l,
+		clock:   clock,
+		run:     run,
+		path:    path,
+		entries: make(map[string]*entry),
+		running: make(map[string]bool),
+		state: state{
+			Last:    make(map[string]time.Time),
+			History: make(map[string][]*Run),
+		},
+		wake: make(chan struct{}, 1),
+		jitter: func(n time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(n)))
+		},
+	}
+	if path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set adds or replaces the given job. A job that ran before continues
+// from its last run, so runs missed while it wasn't scheduled are
+// subject to its missed run policy
+func (s *Scheduler) Set(j *Job) {
+	if j.Location == nil {
+		j.Location = time.UTC
+	}
+	if j.Missed == "" {
+		j.Missed = MissedSkip
+	}
+	s.mu.Lock()
+	e := &entry{job: j}
+	from := s.clock.Now()
+	if last, ok := s.state.Last[j.Name]; ok && last.Before(from) {
+		from = last
+	} else if !ok {
+		s.state.Last[j.Name] = from
+		s.save()
+	}
+	s.schedule(e, from)
+	s.entries[j.Name] = e
+	s.mu.Unlock()
+	s.poke()
+}
+
+// Remove removes the job with the given name. Its history is kept
+func (s *Scheduler) Remove(name string) {
+	s.mu.Lock()
+	delete(s.entries, name)
+	s.mu.Unlock()
+	s.poke()
+}
+
+// poke wakes the scheduler to recompute when the next job is due
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// schedule sets when the given entry is next due after the given
+// time. The caller must hold the lock
+func (s *Scheduler) schedule(e *entry, after time.Time) {
+	e.due = e.job.Cron.Next(after.In(e.job.Location))
+	e.fireAt = e.due
+	if !e.due.IsZero() && e.job.Jitter > 0 {
+		e.fireAt = e.due.Add(s.jitter(e.job.Jitter))
+	}
+}
+
+// Run runs jobs as they're due until the given context is done and
+// the runs started have finished
+func (s *Scheduler) Run(ctx context.Context) {
+	defer s.wg.Wait()
+	for {
+		s.tick(ctx, s.clock.Now())
+		wait := time.Minute
+		s.mu.Lock()
+		now := s.clock.Now()
+		for _, e := range s.entries {
+			if !e.fireAt.IsZero() && e.fireAt.Sub(now) < wait {
+				wait = e.fireAt.Sub(now)
+			}
+		}
+		s.mu.Unlock()
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-s.clock.After(wait):
+		}
+	}
+}
+
+// tick starts the runs due at the given time, applying the missed run
+// policy to overdue runs and skipping runs of jobs still running
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for name, e := range s.entries {
+		if e.fireAt.IsZero() || e.fireAt.After(now) {
+			continue
+		}
+		changed = true
+		// catch up to the most recent of the runs due, counting those
+		// passed over and the most recent if it's also too late to start
+		due, fireAt, missed := e.due, e.fireAt, 0
+		for next := e.job.Cron.Next(due); !next.IsZero() && !next.After(now); next = e.job.Cron.Next(due) {
+			due, fireAt = next, next
+			missed++
+		}
+		late := now.Sub(fireAt) > missedAfter
+		if late {
+			missed++
+		}
+		s.state.Last[name] = due
+		s.schedule(e, now)
+		switch {
+		case late && e.job.Missed != MissedRunOnce:
+			s.record(&Run{Function: name, Scheduled: due, Status: StatusMissed, Missed: missed})
+		case s.running[name]:
+			s.record(&Run{Function: name, Scheduled: due, Status: StatusOverlap, Missed: missed})
+		default:
+			s.running[name] = true
+			r := &Run{Function: name, Scheduled: due, StartedAt: now, Missed: missed}
+			s.wg.Add(1)
+			go s.execute(ctx, e.job, r)
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// execute runs the given job and records the run
+func (s *Scheduler) execute(ctx context.Context, j *Job, r *Run) {
+	defer s.wg.Done()
+	err := s.run(ctx, j)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, j.Name)
+	r.FinishedAt = s.clock.Now()
+	r.Status = StatusOK
+	if err != nil {
+		r.Status = StatusFailed
+		r.Error = err.Error()
+		s.logger.Log("error", "scheduled run of "+j.Name+": "+err.Error())
+	}
+	s.record(r)
+	s.save()
+}
+
+// record adds the given run to its job's history. The caller must
+// hold the lock
+func (s *Scheduler) record(r *Run) {
+	h := append(s.state.History[r.Function], r)
+	if len(h) > maxHistory {
+		h = h[len(h)-maxHistory:]
+	}
+	s.state.History[r.Function] = h
+}
+
+// save writes the state to disk. The caller must hold the lock
+func (s *Scheduler) save() {
+	if s.path == "" {
+		return
+	}
+	b, err := json.Marshal(&s.state)
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		s.logger.Log("error", "saving schedule state: "+err.Error())
+	}
+}
+
+// Jobs returns the status of every job sorted by name
+func (s *Scheduler) Jobs() []*Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Status, 0, len(s.entries))
+	for name, e := range s.entries {
+		st := &Status{
+			Function: name,
+			Cron:     e.job.Cron.String(),
+			Timezone: e.job.Location.String(),
+			Missed:   e.job.Missed,
+			Next:     e.fireAt,
+			Running:  s.running[name],
+		}
+		if h := s.state.History[name]; len(h) > 0 {
+			st.LastRun = h[len(h)-1].Scheduled
+		}
+		if e.job.Jitter > 0 {
+			st.Jitter = e.job.Jitter.String()
+		}
+		jobs = append(jobs, st)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Function < jobs[j].Function })
+	return jobs
+}
+
+// History returns the runs of the given job, most recent first
+func (s *Scheduler) History(name string) []*Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.state.History[name]
+	runs := make([]*Run, len(h))
+	for i, r := range h {
+		c := *r
+		runs[len(h)-1-i] = &c
+	}
+	return runs
+}
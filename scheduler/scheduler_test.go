@@ -0,0 +1,262 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gklog "github.com/go-kit/kit/log"
+)
+
+// fakeClock is a Clock whose time only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a channel to send on once the clock reaches a time
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// Now implements Clock
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After implements Clock
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(chan time.Time, 1)
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// Advance moves the clock forward, waking the waiters that are due
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.c <- f.now
+	}
+	f.waiters = waiters
+}
+
+// start is when the tests' clocks start, 30 seconds past a minute
+var start = time.Date(2024, time.January, 1, 10, 0, 30, 0, time.UTC)
+
+// newTestScheduler creates a scheduler with a fake clock whose runs
+// are sent on the returned channel and fail with the given error
+func newTestScheduler(t *testing.T, path string, err error) (*Scheduler, *fakeClock, chan *Job) {
+	clock := &fakeClock{now: start}
+	runs := make(chan *Job, 10)
+	s, e := NewScheduler(path, gklog.NewNopLogger(), clock, func(ctx context.Context, j *Job) error {
+		runs <- j
+		return err
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return s, clock, runs
+}
+
+// everyMinute returns a job with the given name running every minute
+func everyMinute(t *testing.T, name string) *Job {
+	c, err := ParseCron("* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Job{Name: name, Cron: c}
+}
+
+// TestTick verifies a job runs once it's due and its run is recorded
+func TestTick(t *testing.T) {
+	s, _, runs := newTestScheduler(t, "", errors.New("boom"))
+	s.Set(everyMinute(t, "report"))
+	s.tick(context.Background(), start.Add(20*time.Second))
+	if len(runs) != 0 {
+		t.Fatal("expected no run before the job is due")
+	}
+	due := start.Add(30 * time.Second)
+	s.tick(context.Background(), due)
+	if j := <-runs; j.Name != "report" {
+		t.Errorf("expected report got %s", j.Name)
+	}
+	s.wg.Wait()
+	h := s.History("report")
+	if len(h) != 1 {
+		t.Fatalf("expected 1 run got %d", len(h))
+	}
+	if h[0].Status != StatusFailed || h[0].Error != "boom" || !h[0].Scheduled.Equal(due) {
+		t.Errorf("unexpected run %+v", h[0])
+	}
+	jobs := s.Jobs()
+	if len(jobs) != 1 || !jobs[0].Next.Equal(due.Add(time.Minute)) || !jobs[0].LastRun.Equal(due) {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+}
+
+// TestTick_Jitter verifies a run starts once its jitter has passed
+func TestTick_Jitter(t *testing.T) {
+	s, _, runs := newTestScheduler(t, "", nil)
+	s.jitter = func(n time.Duration) time.Duration { return n / 2 }
+	j := everyMinute(t, "report")
+	j.Jitter = 20 * time.Second
+	s.Set(j)
+	due := start.Add(30 * time.Second)
+	s.tick(context.Background(), due.Add(5*time.Second))
+	if len(runs) != 0 {
+		t.Fatal("expected no run before the jitter has passed")
+	}
+	s.tick(context.Background(), due.Add(10*time.Second))
+	<-runs
+	s.wg.Wait()
+	if h := s.History("report"); len(h) != 1 || h[0].Status != StatusOK || !h[0].Scheduled.Equal(due) {
+		t.Errorf("unexpected history %+v", h)
+	}
+}
+
+// TestTick_Missed verifies overdue runs are skipped or run once
+// according to the job's policy
+func TestTick_Missed(t *testing.T) {
+	c, err := ParseCron("*/5 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{MissedSkip, MissedRunOnce} {
+		s, _, runs := newTestScheduler(t, "", nil)
+		s.Set(&Job{Name: "report", Cron: c, Missed: policy})
+		// the runs of 10:05 to 10:15 are all overdue at 10:17
+		s.tick(context.Background(), start.Add(990*time.Second))
+		s.wg.Wait()
+		h := s.History("report")
+		if len(h) != 1 {
+			t.Fatalf("%s: expected 1 run got %d", policy, len(h))
+		}
+		expected := StatusMissed
+		if policy == MissedRunOnce {
+			expected = StatusOK
+			<-runs
+		}
+		if h[0].Status != expected || h[0].Missed != 3 || !h[0].Scheduled.Equal(start.Add(870*time.Second)) {
+			t.Errorf("%s: unexpected run %+v", policy, h[0])
+		}
+		if len(runs) != 0 {
+			t.Errorf("%s: expected a single run", policy)
+		}
+	}
+}
+
+// TestTick_CatchUp verifies the most recent run starts on time when
+// only the runs before it were missed
+func TestTick_CatchUp(t *testing.T) {
+	s, _, runs := newTestScheduler(t, "", nil)
+	s.Set(everyMinute(t, "report"))
+	s.tick(context.Background(), start.Add(150*time.Second))
+	<-runs
+	s.wg.Wait()
+	h := s.History("report")
+	if len(h) != 1 || h[0].Status != StatusOK || h[0].Missed != 2 {
+		t.Errorf("unexpected history %+v", h)
+	}
+}
+
+// TestTick_Overlap verifies a run is skipped while the previous run
+// of the job hasn't finished
+func TestTick_Overlap(t *testing.T) {
+	release := make(chan struct{})
+	s, err := NewScheduler("", gklog.NewNopLogger(), &fakeClock{now: start}, func(ctx context.Context, j *Job) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set(everyMinute(t, "report"))
+	due := start.Add(30 * time.Second)
+	s.tick(context.Background(), due)
+	s.tick(context.Background(), due.Add(time.Minute))
+	close(release)
+	s.wg.Wait()
+	h := s.History("report")
+	if len(h) != 2 {
+		t.Fatalf("expected 2 runs got %d", len(h))
+	}
+	if h[0].Status != StatusOK || !h[0].Scheduled.Equal(due) {
+		t.Errorf("unexpected run %+v", h[0])
+	}
+	if h[1].Status != StatusOverlap || !h[1].Scheduled.Equal(due.Add(time.Minute)) {
+		t.Errorf("unexpected run %+v", h[1])
+	}
+}
+
+// TestScheduler_Persist verifies a new scheduler using the same path
+// has the history and counts the runs due while it wasn't running
+func TestScheduler_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	s, _, runs := newTestScheduler(t, path, nil)
+	s.Set(everyMinute(t, "report"))
+	s.tick(context.Background(), start.Add(30*time.Second))
+	<-runs
+	s.wg.Wait()
+
+	s, clock, runs := newTestScheduler(t, path, nil)
+	clock.Advance(time.Hour)
+	s.Set(everyMinute(t, "report"))
+	s.tick(context.Background(), clock.Now())
+	<-runs
+	s.wg.Wait()
+	h := s.History("report")
+	if len(h) != 2 {
+		t.Fatalf("expected 2 runs got %d", len(h))
+	}
+	if h[0].Status != StatusOK || h[0].Missed != 58 || !h[1].Scheduled.Equal(start.Add(30*time.Second)) {
+		t.Errorf("unexpected history %+v %+v", h[0], h[1])
+	}
+}
+
+// TestScheduler_Run verifies jobs run as the clock moves until the
+// context is done
+func TestScheduler_Run(t *testing.T) {
+	s, clock, runs := newTestScheduler(t, "", nil)
+	s.Set(everyMinute(t, "report"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		// wait for the scheduler to be waiting on the clock
+		for {
+			clock.mu.Lock()
+			n := len(clock.waiters)
+			clock.mu.Unlock()
+			if n > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Minute)
+		<-runs
+	}
+	cancel()
+	<-done
+	if h := s.History("report"); len(h) != 3 {
+		t.Errorf("expected 3 runs got %d", len(h))
+	}
+}
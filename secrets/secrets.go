@@ -0,0 +1,252 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/sky-island/config"
+	"github.com/briandowns/sky-island/utils"
+)
+
+// keySize is the required size of the encryption key. Values
+// are encrypted with AES-256-GCM
+const keySize = 32
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ErrNotConfigured is returned when secrets are referenced but
+// no encryption key has been configured
+var ErrNotConfigured = errors.New("secrets store not configured")
+
+// Metadata describes a secret without exposing its value
+type Metadata struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Grants    []string  `json:"grants"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// secret is the stored form of a secret. The value is only
+// ever held encrypted
+type secret struct {
+	Metadata
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Store holds named secrets encrypted at rest and persists
+// them to disk on every change if given a path
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	aead    cipher.AEAD
+	secrets map[string]*secret
+}
+
+// LoadKey reads the encryption key from the configured key file or,
+// if no file is set, the configured key. The key is base64 encoded.
+// A nil key is returned if neither are configured
+func LoadKey(conf *config.Config) ([]byte, error) {
+	encoded := conf.SecretsKey
+	if conf.SecretsKeyFile != "" {
+		b, err := ioutil.ReadFile(conf.SecretsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// NewStore creates a new value of type Store pointer using the given
+// key and loads any previously persisted secrets from the given path.
+// An empty path keeps the store in memory only
+func NewStore(path string, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:    path,
+		aead:    aead,
+		secrets: make(map[string]*secret),
+	}
+	if path == "" || !utils.Exists(path) {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.secrets); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// List returns the metadata of all secrets sorted by name
+func (s *Store) List() []*Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	md := make([]*Metadata, 0, len(s.secrets))
+	for _, sec := range s.secrets {
+		m := sec.Metadata
+		md = append(md, &m)
+	}
+	sort.Slice(md, func(i, j int) bool {
+		return md[i].Name < md[j].Name
+	})
+	return md
+}
+
+// Set creates the named secret or, if it already exists, rotates it to
+// the given value. A nil grants list keeps the existing grants. The
+// store is left unchanged if the secret can't be saved
+func (s *Store) Set(name string, value []byte, grants []string) (*Metadata, error) {
+	if !nameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid secret name %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	sec := &secret{
+		Metadata: Metadata{
+			Name:      name,
+			CreatedAt: now,
+		},
+	}
+	if prev, ok := s.secrets[name]; ok {
+		sec.Metadata = prev.Metadata
+	}
+	ct, err := s.encrypt(name, value)
+	if err != nil {
+		return nil, err
+	}
+	sec.Ciphertext = ct
+	sec.Version++
+	sec.UpdatedAt = now
+	if grants != nil {
+		sec.Grants = grants
+	}
+	next := s.copySecrets()
+	next[name] = sec
+	if err := s.save(next); err != nil {
+		return nil, err
+	}
+	s.secrets = next
+	m := sec.Metadata
+	return &m, nil
+}
+
+// Delete removes the named secret. The store is left unchanged if the
+// removal can't be saved
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[name]; !ok {
+		return fmt.Errorf("secret %s not found", name)
+	}
+	next := s.copySecrets()
+	delete(next, name)
+	if err := s.save(next); err != nil {
+		return err
+	}
+	s.secrets = next
+	return nil
+}
+
+// copySecrets returns a copy of the secrets map to build a change on.
+// The caller must hold the lock
+func (s *Store) copySecrets() map[string]*secret {
+	next := make(map[string]*secret, len(s.secrets)+1)
+	for k, v := range s.secrets {
+		next[k] = v
+	}
+	return next
+}
+
+// Value decrypts and returns the named secret if the given registered
+// function has been granted it
+func (s *Store) Value(name, function string) ([]byte, error) {
+	s.mu.RLock()
+	sec, ok := s.secrets[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown secret %s", name)
+	}
+	var granted bool
+	for _, g := range sec.Grants {
+		if g == function {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return nil, fmt.Errorf("secret %s not granted to %s", name, function)
+	}
+	return s.decrypt(name, sec.Ciphertext)
+}
+
+// encrypt seals the value with a random nonce which is prepended to
+// the returned ciphertext. The name is used as additional data so a
+// ciphertext can't be moved to another secret
+func (s *Store) encrypt(name string, value []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, value, []byte(name)), nil
+}
+
+// decrypt opens the given ciphertext created by encrypt
+func (s *Store) decrypt(name string, ct []byte) ([]byte, error) {
+	ns := s.aead.NonceSize()
+	if len(ct) < ns {
+		return nil, errors.New("invalid ciphertext for secret " + name)
+	}
+	return s.aead.Open(nil, ct[:ns], ct[ns:], []byte(name))
+}
+
+// save writes the given secrets to disk. The caller must hold the lock
+func (s *Store) save(secrets map[string]*secret) error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(secrets, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/briandowns/sky-island/config"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, keySize)
+
+// TestLoadKey verifies the key is decoded from config and that
+// keys of the wrong size are rejected
+func TestLoadKey(t *testing.T) {
+	key, err := LoadKey(&config.Config{SecretsKey: base64.StdEncoding.EncodeToString(testKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testKey) {
+		t.Error("expected decoded key to match")
+	}
+	if _, err := LoadKey(&config.Config{SecretsKey: base64.StdEncoding.EncodeToString([]byte("short"))}); err == nil {
+		t.Error("expected error but received none")
+	}
+	key, err = LoadKey(&config.Config{})
+	if err != nil || key != nil {
+		t.Error("expected nil key and error when not configured")
+	}
+}
+
+// TestStore verifies secrets are encrypted at rest, rotated, and
+// only returned to granted functions
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+
+	s, err := NewStore(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("db_pass", []byte("hunter2"), []string{"billing"}); err != nil {
+		t.Fatal(err)
+	}
+	md, err := s.Set("db_pass", []byte("hunter3"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Version != 2 {
+		t.Errorf("expected version 2 got %d", md.Version)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("hunter")) {
+		t.Error("expected secret value to be encrypted at rest")
+	}
+
+	s2, err := NewStore(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s2.Value("db_pass", "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "hunter3" {
+		t.Errorf("expected rotated value got %s", string(v))
+	}
+	if _, err := s2.Value("db_pass", "github.com/someone/else"); err == nil {
+		t.Error("expected error for function without grant")
+	}
+	if err := s2.Delete("db_pass"); err != nil {
+		t.Error(err)
+	}
+	if len(s2.List()) != 0 {
+		t.Error("expected empty store")
+	}
+}
+
+// TestStore_WrongKey verifies values can't be read with another key
+func TestStore_WrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.json")
+
+	s, err := NewStore(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("token", []byte("abc"), []string{"fn"}); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := NewStore(path, bytes.Repeat([]byte{0x24}, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s2.Value("token", "fn"); err == nil {
+		t.Error("expected error decrypting with wrong key")
+	}
+}
+
+// TestStore_SaveFailure verifies a change that can't be saved leaves
+// the store as it was
+func TestStore_SaveFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewStore(filepath.Join(dir, "secrets.json"), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("db_pass", []byte("hunter2"), []string{"billing"}); err != nil {
+		t.Fatal(err)
+	}
+	s.path = filepath.Join(dir, "missing", "secrets.json")
+	if _, err := s.Set("db_pass", []byte("hunter3"), []string{"payroll"}); err == nil {
+		t.Fatal("expected error but received none")
+	}
+	if _, err := s.Set("api_key", []byte("abc"), []string{"billing"}); err == nil {
+		t.Fatal("expected error but received none")
+	}
+	if err := s.Delete("db_pass"); err == nil {
+		t.Fatal("expected error but received none")
+	}
+	md := s.List()
+	if len(md) != 1 || md[0].Version != 1 || md[0].Grants[0] != "billing" {
+		t.Fatalf("expected the first version of db_pass only got %+v", md)
+	}
+	v, err := s.Value("db_pass", "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "hunter2" {
+		t.Errorf("expected the saved value got %s", string(v))
+	}
+}
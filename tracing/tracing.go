@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/briandowns/sky-island/config"
+	gklog "github.com/go-kit/kit/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Name is the instrumentation name spans are created with
+const Name = "github.com/briandowns/sky-island"
+
+// DefaultServiceName is the service name spans are exported with when
+// none is configured
+const DefaultServiceName = "sky-island"
+
+// propagator reads and writes W3C trace context and baggage
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Shutdown flushes and stops exporting spans
+type Shutdown func(context.Context) error
+
+// FromConfig creates the tracer exporting spans over OTLP/HTTP to the
+// configured endpoint. Without an endpoint nothing is recorded
+func FromConfig(conf *config.Config, l gklog.Logger) (trace.Tracer, Shutdown, error) {
+	if conf.Tracing == nil || conf.Tracing.Endpoint == "" {
+		return noop.NewTracerProvider().Tracer(Name), func(context.Context) error { return nil }, nil
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Tracing.Endpoint)}
+	if conf.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exp, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := conf.Tracing.ServiceName
+	if name == "" {
+		name = DefaultServiceName
+	}
+	sampler := sdktrace.AlwaysSample()
+	if r := conf.Tracing.SampleRatio; r > 0 && r < 1 {
+		sampler = sdktrace.TraceIDRatioBased(r)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+	)
+	l.Log("msg", "exporting traces to "+conf.Tracing.Endpoint)
+	return tp.Tracer(Name), tp.Shutdown, nil
+}
+
+// Middleware starts a server span with the given name for every
+// request, continuing the trace given in the request headers if any
+func Middleware(tracer trace.Tracer, name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			),
+		)
+		defer span.End()
+		fn(w, r.WithContext(ctx))
+	}
+}
+
+// Env returns the trace context of the given context as environment
+// variables, e.g. TRACEPARENT, for a function to continue the trace
+func Env(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	env := make([]string, 0, len(carrier))
+	for k, v := range carrier {
+		env = append(env, strings.ToUpper(k)+"="+v)
+	}
+	return env
+}
+
+// End ends the given span, recording the given error if any
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectHeader writes the trace context of the given context to the
+// given headers
+func InjectHeader(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
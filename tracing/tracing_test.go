@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const incomingParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// TestMiddleware verifies the request span continues the trace given
+// in the request headers and is passed to the handler
+func TestMiddleware(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+
+	var env []string
+	fn := Middleware(tp.Tracer(Name), "function.run", func(w http.ResponseWriter, r *http.Request) {
+		env = Env(r.Context())
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/function", nil)
+	req.Header.Set("traceparent", incomingParent)
+	fn(httptest.NewRecorder(), req)
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span got %d", len(spans))
+	}
+	s := spans[0]
+	if s.Name != "function.run" || s.SpanKind != trace.SpanKindServer {
+		t.Errorf("unexpected span %s %s", s.Name, s.SpanKind)
+	}
+	if s.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the incoming trace got %s", s.SpanContext.TraceID())
+	}
+	if s.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the incoming parent got %s", s.Parent.SpanID())
+	}
+	want := "TRACEPARENT=00-4bf92f3577b34da6a3ce929d0e0e4736-" + s.SpanContext.SpanID().String() + "-01"
+	var found bool
+	for _, e := range env {
+		if e == want {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %s in %s", want, strings.Join(env, " "))
+	}
+}
+
+// TestEnv_NoTrace verifies nothing is injected without a trace
+func TestEnv_NoTrace(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if env := Env(req.Context()); len(env) != 0 {
+		t.Errorf("expected no env got %v", env)
+	}
+}
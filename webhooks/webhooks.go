@@ -0,0 +1,218 @@
+// Package webhooks verifies webhook deliveries signed with a shared
+// secret, GitHub and GitLab style or generic
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Algorithms deliveries can be signed with. AlgToken deliveries carry
+// the secret itself in the header, as GitLab does
+const (
+	AlgSHA1   = "sha1"
+	AlgSHA256 = "sha256"
+	AlgSHA512 = "sha512"
+	AlgToken  = "token"
+)
+
+// Defaults of a verifier, those of GitHub
+const (
+	DefaultAlgorithm = AlgSHA256
+	DefaultHeader    = "X-Hub-Signature-256"
+	DefaultTolerance = 5 * time.Minute
+)
+
+// Errors returned for deliveries that fail verification
+var (
+	ErrSignature = errors.New("invalid signature")
+	ErrTimestamp = errors.New("timestamp outside of tolerance")
+	ErrNonce     = errors.New("missing nonce")
+	ErrReplay    = errors.New("delivery already received")
+)
+
+// hashes are the hash functions of the HMAC algorithms
+var hashes = map[string]func() hash.Hash{
+	AlgSHA1:   sha1.New,
+	AlgSHA256: sha256.New,
+	AlgSHA512: sha512.New,
+}
+
+// Verifier checks the signature of deliveries. With a timestamp
+// header the signature covers the timestamp, a dot and the body and
+// deliveries older or newer than the tolerance are refused. With a
+// nonce header every delivery must carry a nonce. The nonce isn't
+// signed so it only narrows replays within the tolerance, it needs a
+// signed timestamp to bound them. Deliveries without a timestamp,
+// such as GitHub's, have no replay protection
+type Verifier struct {
+	Algorithm       string
+	Header          string
+	TimestampHeader string
+	NonceHeader     string
+	Tolerance       time.Duration
+}
+
+// NewVerifier creates a new value of type Verifier pointer, filling
+// in the defaults of the fields not set
+func NewVerifier(algorithm, header, timestampHeader, nonceHeader string, tolerance time.Duration) (*Verifier, error) {
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	if _, ok := hashes[algorithm]; !ok && algorithm != AlgToken {
+		return nil, fmt.Errorf("unknown webhook algorithm %q", algorithm)
+	}
+	if header == "" {
+		header = DefaultHeader
+	}
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	if tolerance < 0 {
+		return nil, fmt.Errorf("invalid webhook tolerance %s", tolerance)
+	}
+	if nonceHeader != "" && (timestampHeader == "" || algorithm == AlgToken) {
+		return nil, errors.New("webhook nonce header requires a signed timestamp header")
+	}
+	return &Verifier{
+		Algorithm:       algorithm,
+		Header:          header,
+		TimestampHeader: timestampHeader,
+		NonceHeader:     nonceHeader,
+		Tolerance:       tolerance,
+	}, nil
+}
+
+// Verify checks the given delivery was signed with the given secret
+// and, if the verifier has a timestamp header, was sent within the
+// tolerance of now
+func (v *Verifier) Verify(secret []byte, header http.Header, body []byte, now time.Time) error {
+	sig := header.Get(v.Header)
+	if sig == "" {
+		return ErrSignature
+	}
+	if v.Algorithm == AlgToken {
+		if !hmac.Equal([]byte(sig), secret) {
+			return ErrSignature
+		}
+	} else {
+		signed := body
+		if v.TimestampHeader != "" {
+			ts := header.Get(v.TimestampHeader)
+			signed = append([]byte(ts+"."), body...)
+		}
+		mac := hmac.New(hashes[v.Algorithm], secret)
+		mac.Write(signed)
+		// GitHub prefixes the signature with the algorithm
+		actual, err := hex.DecodeString(strings.TrimPrefix(sig, v.Algorithm+"="))
+		if err != nil || !hmac.Equal(actual, mac.Sum(nil)) {
+			return ErrSignature
+		}
+	}
+	if v.TimestampHeader != "" {
+		sec, err := strconv.ParseInt(header.Get(v.TimestampHeader), 10, 64)
+		if err != nil {
+			return ErrTimestamp
+		}
+		if d := now.Sub(time.Unix(sec, 0)); d > v.Tolerance || d < -v.Tolerance {
+			return ErrTimestamp
+		}
+	}
+	if v.NonceHeader != "" && header.Get(v.NonceHeader) == "" {
+		return ErrNonce
+	}
+	return nil
+}
+
+// Nonce returns the nonce of the given verified delivery, empty if the
+// verifier has no nonce header, and when it expires. A delivery can be
+// replayed until its timestamp is outside the tolerance, which may be
+// up to twice the tolerance after it was received
+func (v *Verifier) Nonce(header http.Header) (string, time.Time) {
+	if v.NonceHeader == "" {
+		return "", time.Time{}
+	}
+	sec, _ := strconv.ParseInt(header.Get(v.TimestampHeader), 10, 64)
+	return header.Get(v.NonceHeader), time.Unix(sec, 0).Add(v.Tolerance)
+}
+
+// Nonces remembers the nonces of verified deliveries so replays of
+// them are refused. Nonces are kept in memory only and forgotten on
+// restart, the timestamp tolerance still applies
+type Nonces struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewNonces creates a new value of type Nonces pointer
+func NewNonces() *Nonces {
+	return &Nonces{seen: make(map[string]time.Time)}
+}
+
+// Add records the given nonce until the given expiry, returning
+// ErrReplay if it's already recorded. Expired nonces are dropped
+// at most once a minute
+func (n *Nonces) Add(nonce string, now, expires time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.pruned) > time.Minute {
+		for k, exp := range n.seen {
+			if !exp.After(now) {
+				delete(n.seen, k)
+			}
+		}
+		n.pruned = now
+	}
+	if exp, ok := n.seen[nonce]; ok && exp.After(now) {
+		return ErrReplay
+	}
+	n.seen[nonce] = expires
+	return nil
+}
+
+// push holds the fields of GitHub and GitLab push events naming the
+// repo pushed to
+type push struct {
+	Repository struct {
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+	Project struct {
+		WebURL string `json:"web_url"`
+	} `json:"project"`
+}
+
+// PushedRepo returns the repo of the given delivery, without a scheme,
+// if it's a GitHub or GitLab push event
+func PushedRepo(header http.Header, body []byte) (string, bool) {
+	if header.Get("X-GitHub-Event") != "push" && header.Get("X-Gitlab-Event") != "Push Hook" {
+		return "", false
+	}
+	var p push
+	if err := json.Unmarshal(body, &p); err != nil {
+		return "", false
+	}
+	u := p.Repository.HTMLURL
+	if u == "" {
+		u = p.Project.WebURL
+	}
+	if u == "" {
+		return "", false
+	}
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git"), true
+}
@@ -0,0 +1,167 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	secret = []byte("It's a Secret to Everybody")
+	body   = []byte("Hello, World!")
+)
+
+// sign returns the hex encoded sha256 HMAC of the given payload
+func sign(payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestVerify_GitHub verifies deliveries signed as GitHub does, using
+// the example of its documentation
+func TestVerify_GitHub(t *testing.T) {
+	v, err := NewVerifier("", "", "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{}
+	h.Set(DefaultHeader, "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17")
+	if err := v.Verify(secret, h, body, time.Now()); err != nil {
+		t.Error(err)
+	}
+	if err := v.Verify(secret, h, []byte("Hello, World?"), time.Now()); err != ErrSignature {
+		t.Errorf("expected %v got %v", ErrSignature, err)
+	}
+	if err := v.Verify(secret, http.Header{}, body, time.Now()); err != ErrSignature {
+		t.Errorf("expected %v got %v", ErrSignature, err)
+	}
+}
+
+// TestVerify_Token verifies deliveries carrying the secret as GitLab
+// does
+func TestVerify_Token(t *testing.T) {
+	v, err := NewVerifier(AlgToken, "X-Gitlab-Token", "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{}
+	h.Set("X-Gitlab-Token", string(secret))
+	if err := v.Verify(secret, h, body, time.Now()); err != nil {
+		t.Error(err)
+	}
+	h.Set("X-Gitlab-Token", "guess")
+	if err := v.Verify(secret, h, body, time.Now()); err != ErrSignature {
+		t.Errorf("expected %v got %v", ErrSignature, err)
+	}
+}
+
+// TestVerify_Timestamp verifies the timestamp is signed and must be
+// within the tolerance
+func TestVerify_Timestamp(t *testing.T) {
+	v, err := NewVerifier("", "X-Signature", "X-Timestamp", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		sent     time.Time
+		signed   time.Time
+		expected error
+	}{
+		{now.Add(-30 * time.Second), now.Add(-30 * time.Second), nil},
+		{now.Add(30 * time.Second), now.Add(30 * time.Second), nil},
+		{now.Add(-2 * time.Minute), now.Add(-2 * time.Minute), ErrTimestamp},
+		{now, now.Add(-2 * time.Minute), ErrSignature},
+	}
+	for _, tt := range tests {
+		ts := strconv.FormatInt(tt.sent.Unix(), 10)
+		h := http.Header{}
+		h.Set("X-Timestamp", ts)
+		h.Set("X-Signature", sign(append([]byte(strconv.FormatInt(tt.signed.Unix(), 10)+"."), body...)))
+		if err := v.Verify(secret, h, body, now); err != tt.expected {
+			t.Errorf("sent %s: expected %v got %v", ts, tt.expected, err)
+		}
+	}
+}
+
+// TestNonces verifies a nonce is refused until the delivery's
+// timestamp is outside the tolerance
+func TestNonces(t *testing.T) {
+	v, err := NewVerifier("", "", "X-Timestamp", "X-Delivery", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(time.Now().Unix(), 0)
+	// sent with a clock ahead of ours, so replayable for longer than
+	// the tolerance after it's received
+	ts := strconv.FormatInt(now.Add(50*time.Second).Unix(), 10)
+	h := http.Header{}
+	h.Set(DefaultHeader, "sha256="+sign(append([]byte(ts+"."), body...)))
+	h.Set("X-Timestamp", ts)
+	if err := v.Verify(secret, h, body, now); err != ErrNonce {
+		t.Errorf("expected %v got %v", ErrNonce, err)
+	}
+	h.Set("X-Delivery", "72d3162e")
+	if err := v.Verify(secret, h, body, now); err != nil {
+		t.Fatal(err)
+	}
+	nonce, expires := v.Nonce(h)
+	if want := now.Add(110 * time.Second); !expires.Equal(want) {
+		t.Errorf("expected nonce to expire at %v got %v", want, expires)
+	}
+	n := NewNonces()
+	if err := n.Add(nonce, now, expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(secret, h, body, now.Add(90*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(nonce, now.Add(90*time.Second), expires); err != ErrReplay {
+		t.Errorf("expected %v got %v", ErrReplay, err)
+	}
+	if err := n.Add(nonce, now.Add(2*time.Minute), expires); err != nil {
+		t.Errorf("expected expired nonce to be accepted got %v", err)
+	}
+}
+
+// TestNewVerifier_Failure verifies unknown algorithms and nonces
+// without a signed timestamp are refused
+func TestNewVerifier_Failure(t *testing.T) {
+	if _, err := NewVerifier("md5", "", "", "", 0); err == nil {
+		t.Error("expected error but received none")
+	}
+	if _, err := NewVerifier("", "", "", "X-GitHub-Delivery", 0); err == nil {
+		t.Error("expected error for a nonce without a timestamp")
+	}
+	if _, err := NewVerifier(AlgToken, "X-Gitlab-Token", "X-Timestamp", "X-Delivery", 0); err == nil {
+		t.Error("expected error for a nonce with an unsigned timestamp")
+	}
+}
+
+// TestPushedRepo verifies the repo of GitHub and GitLab push events
+// is found
+func TestPushedRepo(t *testing.T) {
+	tests := []struct {
+		header, event, body string
+		repo                string
+		ok                  bool
+	}{
+		{"X-GitHub-Event", "push", `{"repository": {"html_url": "https://github.com/example/hello"}}`, "github.com/example/hello", true},
+		{"X-Gitlab-Event", "Push Hook", `{"project": {"web_url": "https://gitlab.com/example/hello.git"}}`, "gitlab.com/example/hello", true},
+		{"X-GitHub-Event", "issues", `{"repository": {"html_url": "https://github.com/example/hello"}}`, "", false},
+		{"X-GitHub-Event", "push", `not json`, "", false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set(tt.header, tt.event)
+		repo, ok := PushedRepo(h, []byte(tt.body))
+		if repo != tt.repo || ok != tt.ok {
+			t.Errorf("%s %s: expected %q %t got %q %t", tt.header, tt.event, tt.repo, tt.ok, repo, ok)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gklog "github.com/go-kit/kit/log"
+	"github.com/pborman/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// Statuses of runs and their steps
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+	StatusSkipped   = "skipped"
+)
+
+// maxFinished is the number of finished runs kept
+const maxFinished = 100
+
+var (
+	// ErrNotFound is returned for runs that don't exist
+	ErrNotFound = errors.New("workflow run not found")
+	// ErrFinished is returned when canceling a run that has finished
+	ErrFinished = errors.New("workflow run has finished")
+)
+
+// InvokeFunc runs the named function with the given input, returning
+// its output
+type InvokeFunc func(ctx context.Context, function string, input json.RawMessage) ([]byte, error)
+
+// Run is a run of a workflow
+type Run struct {
+	ID         string              `json:"id"`
+	Workflow   *Workflow           `json:"workflow"`
+	Input      json.RawMessage     `json:"input,omitempty"`
+	Status     string              `json:"status"`
+	Steps      map[string]*StepRun `json:"steps"`
+	Output     json.RawMessage     `json:"output,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	FinishedAt time.Time           `json:"finished_at,omitempty"`
+}
+
+// StepRun is the state of a step of a run. Attempts counts the runs
+// of the step's function, one per value of for_each steps
+type StepRun struct {
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  time.Time       `json:"started_at,omitempty"`
+	FinishedAt time.Time       `json:"finished_at,omitempty"`
+}
+
+// copy returns a copy of the run safe to hand out
+func (r *Run) copy() *Run {
+	c := *r
+	c.Steps = make(map[string]*StepRun, len(r.Steps))
+	for name, s := range r.Steps {
+		sc := *s
+		c.Steps[name] = &sc
+	}
+	return &c
+}
+
+// Engine runs workflows, persisting their state so the runs left
+// unfinished by a restart are resumed
+type Engine struct {
+	mu      sync.Mutex
+	logger  gklog.Logger
+	dir     string
+	invoke  InvokeFunc
+	runs    map[string]*Run
+	running map[string]*execution
+	ctx     context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewEngine creates a new value of type Engine pointer running steps
+// with the given func. Runs are kept in the given directory, one file
+// each, in memory only if it's empty
+func NewEngine(dir string, l gklog.Logger, invoke InvokeFunc) (*Engine, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	e := &Engine{
+		logger:  l,
+		dir:     dir,
+		invoke:  invoke,
+		runs:    make(map[string]*Run),
+		running: make(map[string]*execution),
+		ctx:     ctx,
+		stop:    stop,
+	}
+	if dir == "" {
+		return e, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var r Run
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		if r.Steps == nil {
+			r.Steps = make(map[string]*StepRun)
+		}
+		e.runs[r.ID] = &r
+	}
+	return e, nil
+}
+
+// Resume continues the runs that were unfinished when the engine was
+// created. Steps that finished are not run again
+func (e *Engine) Resume() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.runs {
+		if r.Status == StatusRunning {
+			e.logger.Log("msg", "resuming workflow run "+r.ID)
+			e.start(r)
+		}
+	}
+}
+
+// Start validates the given workflow and starts running it with the
+// given input
+func (e *Engine) Start(w *Workflow, input json.RawMessage) (*Run, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	if len(input) > 0 && !json.Valid(input) {
+		return nil, errors.New("invalid workflow input")
+	}
+	r := &Run{
+		ID:        uuid.NewUUID().String(),
+		Workflow:  w,
+		Input:     input,
+		Status:    StatusRunning,
+		Steps:     make(map[string]*StepRun),
+		CreatedAt: time.Now(),
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.runs[r.ID] = r
+	e.save(r)
+	e.start(r)
+	return r.copy(), nil
+}
+
+// Get returns the run with the given ID
+func (e *Engine) Get(id string) (*Run, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, ok := e.runs[id]
+	if !ok {
+		return nil, false
+	}
+	return r.copy(), true
+}
+
+// List returns the runs, most recent first
+func (e *Engine) List() []*Run {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	runs := make([]*Run, 0, len(e.runs))
+	for _, r := range e.runs {
+		runs = append(runs, r.copy())
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+	return runs
+}
+
+// Cancel cancels the run with the given ID. Its running functions
+// are stopped and no more steps are started
+func (e *Engine) Cancel(id string) (*Run, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, ok := e.runs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ex, ok := e.running[id]
+	if !ok || r.Status != StatusRunning {
+		return nil, ErrFinished
+	}
+	r.Status = StatusCanceled
+	e.save(r)
+	ex.cancel()
+	return r.copy(), nil
+}
+
+// Close stops the running workflows, leaving them to be resumed, and
+// waits for them to return
+func (e *Engine) Close() {
+	e.stop()
+	e.wg.Wait()
+}
+
+// start runs the given run in the background. The caller must hold
+// the lock
+func (e *Engine) start(r *Run) {
+	ctx, cancel := context.WithCancel(e.ctx)
+	ex := &execution{e: e, run: r, values: make(map[string]interface{}), cancel: cancel}
+	if len(r.Input) > 0 {
+		ex.input, _ = decode(r.Input)
+	}
+	for name, s := range r.Steps {
+		if s.Status == StatusSucceeded && len(s.Output) > 0 {
+			ex.values[name], _ = decode(s.Output)
+		}
+	}
+	e.running[r.ID] = ex
+	e.wg.Add(1)
+	go ex.execute(ctx)
+}
+
+// save writes the given run to disk. The caller must hold the lock
+func (e *Engine) save(r *Run) {
+	if e.dir == "" {
+		return
+	}
+	b, err := json.Marshal(r)
+	if err == nil {
+		path := filepath.Join(e.dir, r.ID+".json")
+		tmp := path + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		e.logger.Log("error", "saving workflow run "+r.ID+": "+err.Error())
+	}
+}
+
+// prune removes the oldest finished runs past the most kept. The
+// caller must hold the lock
+func (e *Engine) prune() {
+	var finished []*Run
+	for _, r := range e.runs {
+		if _, ok := e.running[r.ID]; !ok && r.Status != StatusRunning {
+			finished = append(finished, r)
+		}
+	}
+	if len(finished) <= maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].CreatedAt.Before(finished[j].CreatedAt) })
+	for _, r := range finished[:len(finished)-maxFinished] {
+		delete(e.runs, r.ID)
+		if e.dir == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(e.dir, r.ID+".json")); err != nil && !os.IsNotExist(err) {
+			e.logger.Log("error", "removing workflow run "+r.ID+": "+err.Error())
+		}
+	}
+}
+
+// execution is a run in progress. Its fields are guarded by the
+// engine's lock
+type execution struct {
+	e      *Engine
+	run    *Run
+	input  interface{}
+	values map[string]interface{}
+	cancel context.CancelFunc
+}
+
+// iteration is the value of a for_each step being run
+type iteration struct {
+	item  interface{}
+	index int
+}
+
+// execute runs the workflow's steps and records how the run ended
+func (ex *execution) execute(ctx context.Context) {
+	defer ex.e.wg.Done()
+	defer ex.cancel()
+	w := ex.run.Workflow
+	out, err := ex.sequence(ctx, w.Steps, ex.input)
+	if err == nil && w.Output != "" {
+		p, _ := ParsePath(w.Output)
+		out, err = p.Query(ex.doc(nil))
+	}
+	var b []byte
+	if err == nil {
+		b, err = json.Marshal(out)
+	}
+	e := ex.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.running, ex.run.ID)
+	r := ex.run
+	switch {
+	case r.Status == StatusCanceled:
+		r.Error = context.Canceled.Error()
+	case ctx.Err() != nil:
+		// the engine is closing, the run is resumed on restart
+		return
+	case err != nil:
+		r.Status = StatusFailed
+		r.Error = err.Error()
+	default:
+		r.Status = StatusSucceeded
+		r.Output = b
+	}
+	r.FinishedAt = time.Now()
+	for _, s := range r.Steps {
+		if s.Status == StatusRunning {
+			s.Status = StatusCanceled
+			s.FinishedAt = r.FinishedAt
+		}
+	}
+	e.logger.Log("msg", "workflow run "+r.ID+" "+r.Status)
+	e.save(r)
+	e.prune()
+}
+
+// sequence runs the given steps one after the other, each given the
+// output of the one before, and returns the last output
+func (ex *execution) sequence(ctx context.Context, steps []*Step, in interface{}) (interface{}, error) {
+	for _, s := range steps {
+		out, ran, err := ex.step(ctx, s, in)
+		if err != nil {
+			return nil, err
+		}
+		if ran {
+			in = out
+		}
+	}
+	return in, nil
+}
+
+// step runs the given step unless it finished before the run was
+// resumed or its condition doesn't hold, reporting whether it ran
+func (ex *execution) step(ctx context.Context, s *Step, in interface{}) (interface{}, bool, error) {
+	if out, status, ok := ex.finished(s.Name); ok {
+		return out, status == StatusSucceeded, nil
+	}
+	if s.When != "" {
+		c, _ := parseCondition(s.When)
+		if !c.eval(ex.doc(nil)) {
+			ex.record(s.Name, StatusSkipped, nil, nil)
+			return nil, false, nil
+		}
+	}
+	ex.record(s.Name, StatusRunning, nil, nil)
+	var out interface{}
+	var err error
+	switch {
+	case s.Function != "" && s.ForEach != "":
+		out, err = ex.forEach(ctx, s)
+	case s.Function != "":
+		out, err = ex.call(ctx, s, in, nil)
+	case len(s.Parallel) > 0:
+		out, err = ex.parallel(ctx, s.Parallel, in)
+	default:
+		out, err = ex.sequence(ctx, s.Steps, in)
+	}
+	if err != nil {
+		// steps stopped by the run ending are left to be marked
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		ex.record(s.Name, StatusFailed, nil, err)
+		if s.Function != "" {
+			err = fmt.Errorf("step %s: %v", s.Name, err)
+		}
+		return nil, false, err
+	}
+	ex.record(s.Name, StatusSucceeded, out, nil)
+	return out, true, nil
+}
+
+// call runs the function of the given step, retrying it as its policy
+// allows, and returns its output decoded if it's JSON
+func (ex *execution) call(ctx context.Context, s *Step, in interface{}, it *iteration) (interface{}, error) {
+	if len(s.Input) > 0 {
+		t, err := newTemplate(s.Input)
+		if err != nil {
+			return nil, err
+		}
+		if in, err = t.render(ex.doc(it)); err != nil {
+			return nil, err
+		}
+	}
+	var input json.RawMessage
+	if in != nil {
+		var err error
+		if input, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+	attempts, backoff, _ := s.Retry.policy()
+	for attempt := 1; ; attempt++ {
+		ex.attempt(s.Name)
+		b, err := ex.e.invoke(ctx, s.Function, input)
+		if err == nil {
+			return output(b), nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= attempts {
+			return nil, err
+		}
+		ex.e.logger.Log("error", "workflow run "+ex.run.ID+" step "+s.Name+" attempt "+strconv.Itoa(attempt)+": "+err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// forEach runs the function of the given step with each value of the
+// list at its for_each path and returns their outputs in order
+func (ex *execution) forEach(ctx context.Context, s *Step) (interface{}, error) {
+	p, _ := ParsePath(s.ForEach)
+	v, err := p.Query(ex.doc(nil))
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("for_each %s isn't a list", s.ForEach)
+	}
+	out := make([]interface{}, len(items))
+	var sem chan struct{}
+	if s.Concurrency > 0 {
+		sem = make(chan struct{}, s.Concurrency)
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	for i, item := range items {
+		i, item := i, item
+		g.Go(func() error {
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+				defer func() { <-sem }()
+			}
+			r, err := ex.call(gctx, s, item, &iteration{item: item, index: i})
+			if err != nil {
+				return fmt.Errorf("item %d: %v", i, err)
+			}
+			out[i] = r
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// parallel runs the given branches at once, each given the input, and
+// returns the outputs of those that ran by name. The first failure
+// stops the other branches
+func (ex *execution) parallel(ctx context.Context, branches []*Step, in interface{}) (interface{}, error) {
+	var mu sync.Mutex
+	out := make(map[string]interface{}, len(branches))
+	g, gctx := errgroup.WithContext(ctx)
+	for _, b := range branches {
+		b := b
+		g.Go(func() error {
+			r, ran, err := ex.step(gctx, b, in)
+			if err != nil {
+				return err
+			}
+			if ran {
+				mu.Lock()
+				out[b.Name] = r
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// output returns a function's output decoded if it's JSON, as a
+// string otherwise
+func output(b []byte) interface{} {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && json.Valid(trimmed) {
+		if v, err := decode(trimmed); err == nil {
+			return v
+		}
+	}
+	return string(b)
+}
+
+// doc returns the document step inputs and conditions are resolved
+// against, with the value of the given iteration of a for_each step
+func (ex *execution) doc(it *iteration) interface{} {
+	ex.e.mu.Lock()
+	defer ex.e.mu.Unlock()
+	steps := make(map[string]interface{}, len(ex.values))
+	for name, v := range ex.values {
+		steps[name] = v
+	}
+	doc := map[string]interface{}{"input": ex.input, "steps": steps}
+	if it != nil {
+		doc["item"] = it.item
+		doc["index"] = json.Number(strconv.Itoa(it.index))
+	}
+	return doc
+}
+
+// finished returns the output and status of the named step if it
+// succeeded or was skipped before the run was resumed
+func (ex *execution) finished(name string) (interface{}, string, bool) {
+	ex.e.mu.Lock()
+	defer ex.e.mu.Unlock()
+	s, ok := ex.run.Steps[name]
+	if !ok || (s.Status != StatusSucceeded && s.Status != StatusSkipped) {
+		return nil, "", false
+	}
+	return ex.values[name], s.Status, true
+}
+
+// attempt counts a run of the named step's function
+func (ex *execution) attempt(name string) {
+	ex.e.mu.Lock()
+	defer ex.e.mu.Unlock()
+	if s, ok := ex.run.Steps[name]; ok {
+		s.Attempts++
+		ex.e.save(ex.run)
+	}
+}
+
+// record sets the status of the named step, with its output once it
+// succeeded or its error once it failed, and saves the run
+func (ex *execution) record(name, status string, out interface{}, err error) {
+	ex.e.mu.Lock()
+	defer ex.e.mu.Unlock()
+	now := time.Now()
+	s := &StepRun{Status: status, StartedAt: now}
+	if prev, ok := ex.run.Steps[name]; ok && status != StatusRunning {
+		s = prev
+		s.Status = status
+	}
+	if status != StatusRunning {
+		s.FinishedAt = now
+	}
+	switch status {
+	case StatusSucceeded:
+		ex.values[name] = out
+		if b, merr := json.Marshal(out); merr == nil {
+			s.Output = b
+		}
+	case StatusFailed:
+		s.Error = strings.TrimSpace(err.Error())
+	}
+	ex.run.Steps[name] = s
+	ex.e.save(ex.run)
+}
@@ -0,0 +1,356 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gklog "github.com/go-kit/kit/log"
+)
+
+// fakeFunctions are the functions run by the engine in tests, each
+// recording its inputs
+type fakeFunctions struct {
+	mu     sync.Mutex
+	fns    map[string]func(ctx context.Context, input string) (string, error)
+	inputs map[string][]string
+}
+
+// newFakeFunctions creates the given functions
+func newFakeFunctions(fns map[string]func(ctx context.Context, input string) (string, error)) *fakeFunctions {
+	return &fakeFunctions{fns: fns, inputs: make(map[string][]string)}
+}
+
+// invoke implements InvokeFunc
+func (f *fakeFunctions) invoke(ctx context.Context, function string, input json.RawMessage) ([]byte, error) {
+	f.mu.Lock()
+	f.inputs[function] = append(f.inputs[function], string(input))
+	fn, ok := f.fns[function]
+	f.mu.Unlock()
+	if !ok {
+		return nil, errors.New("function " + function + " not found")
+	}
+	out, err := fn(ctx, string(input))
+	return []byte(out), err
+}
+
+// calls returns the inputs the named function was run with
+func (f *fakeFunctions) calls(function string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.inputs[function]...)
+}
+
+// echo returns its input
+func echo(ctx context.Context, input string) (string, error) {
+	return input, nil
+}
+
+// block waits for the context to be done
+func block(ctx context.Context, input string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// parseWorkflow parses the given workflow definition
+func parseWorkflow(t *testing.T, s string) *Workflow {
+	t.Helper()
+	var w Workflow
+	if err := json.Unmarshal([]byte(s), &w); err != nil {
+		t.Fatal(err)
+	}
+	return &w
+}
+
+// waitFor waits for the given run to reach the given status
+func waitFor(t *testing.T, e *Engine, id, status string) *Run {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, ok := e.Get(id)
+		if !ok {
+			t.Fatalf("run %s not found", id)
+		}
+		if r.Status == status && !r.FinishedAt.IsZero() {
+			return r
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected run %s got %s: %s", status, r.Status, r.Error)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForStep waits for the named step of the given run to start
+func waitForStep(t *testing.T, e *Engine, id, step string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, _ := e.Get(id)
+		if s, ok := r.Steps[step]; ok && s.Attempts > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("step %s never started", step)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestEngine verifies step inputs are mapped from earlier outputs,
+// steps are skipped by their conditions and fan out and in
+func TestEngine(t *testing.T) {
+	f := newFakeFunctions(map[string]func(ctx context.Context, input string) (string, error){
+		"fetch": func(ctx context.Context, input string) (string, error) {
+			return `{"urls": ["cat.png", "dog.png"]}` + "\n", nil
+		},
+		"resize": func(ctx context.Context, input string) (string, error) {
+			var in struct {
+				URL   string `json:"url"`
+				Index int    `json:"index"`
+			}
+			if err := json.Unmarshal([]byte(input), &in); err != nil {
+				return "", err
+			}
+			return "small-" + in.URL, nil
+		},
+		"store":  echo,
+		"report": echo,
+	})
+	e, err := NewEngine("", gklog.NewNopLogger(), f.invoke)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	w := parseWorkflow(t, `{
+		"name": "thumbnails",
+		"steps": [
+			{"name": "fetch", "function": "fetch"},
+			{"name": "resize", "function": "resize", "for_each": "$.steps.fetch.urls", "concurrency": 1, "input": {"url": "$.item", "index": "$.index"}},
+			{"name": "save", "parallel": [
+				{"name": "store", "function": "store", "when": "$.input.store == true", "input": "$.steps.resize[0]"},
+				{"name": "audit", "function": "store", "when": "$.input.audit"}
+			]},
+			{"name": "report", "function": "report"}
+		],
+		"output": "$.steps"
+	}`)
+	if _, err := e.Start(w, json.RawMessage(`{`)); err == nil {
+		t.Error("expected error for invalid input")
+	}
+	r, err := e.Start(w, json.RawMessage(`{"store": true, "audit": false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = waitFor(t, e, r.ID, StatusSucceeded)
+
+	if calls := f.calls("fetch"); len(calls) != 1 || calls[0] != `{"audit":false,"store":true}` {
+		t.Errorf("expected fetch to be given the workflow input got %v", calls)
+	}
+	calls := f.calls("resize")
+	sort.Strings(calls)
+	if len(calls) != 2 || calls[0] != `{"index":0,"url":"cat.png"}` || calls[1] != `{"index":1,"url":"dog.png"}` {
+		t.Errorf("unexpected resize inputs %v", calls)
+	}
+	if calls := f.calls("store"); len(calls) != 1 || calls[0] != `"small-cat.png"` {
+		t.Errorf("unexpected store inputs %v", calls)
+	}
+	if calls := f.calls("report"); len(calls) != 1 || calls[0] != `{"store":"small-cat.png"}` {
+		t.Errorf("expected report to be given the parallel outputs got %v", calls)
+	}
+	for name, status := range map[string]string{
+		"fetch":  StatusSucceeded,
+		"resize": StatusSucceeded,
+		"save":   StatusSucceeded,
+		"store":  StatusSucceeded,
+		"audit":  StatusSkipped,
+		"report": StatusSucceeded,
+	} {
+		if s := r.Steps[name]; s == nil || s.Status != status {
+			t.Errorf("expected step %s %s got %+v", name, status, s)
+		}
+	}
+	if s := r.Steps["resize"]; s.Attempts != 2 || string(s.Output) != `["small-cat.png","small-dog.png"]` {
+		t.Errorf("unexpected resize step %+v", s)
+	}
+	var out map[string]json.RawMessage
+	if err := json.Unmarshal(r.Output, &out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out["fetch"]) != `{"urls":["cat.png","dog.png"]}` || len(out) != 5 {
+		t.Errorf("unexpected output %s", r.Output)
+	}
+	if runs := e.List(); len(runs) != 1 || runs[0].ID != r.ID {
+		t.Errorf("unexpected runs %v", runs)
+	}
+}
+
+// TestEngine_Retry verifies failed steps are retried as their policy
+// allows and fail the run once out of attempts
+func TestEngine_Retry(t *testing.T) {
+	var mu sync.Mutex
+	failures := 2
+	f := newFakeFunctions(map[string]func(ctx context.Context, input string) (string, error){
+		"flaky": func(ctx context.Context, input string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures > 0 {
+				failures--
+				return "", errors.New("unavailable")
+			}
+			return "ok", nil
+		},
+		"broken": func(ctx context.Context, input string) (string, error) {
+			return "", errors.New("boom")
+		},
+		"never": echo,
+	})
+	e, err := NewEngine("", gklog.NewNopLogger(), f.invoke)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	r, err := e.Start(parseWorkflow(t, `{"steps": [
+		{"name": "flaky", "function": "flaky", "retry": {"max_attempts": 3, "backoff": "1ms"}},
+		{"name": "broken", "function": "broken", "retry": {"max_attempts": 2, "backoff": "1ms"}},
+		{"name": "never", "function": "never"}
+	]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = waitFor(t, e, r.ID, StatusFailed)
+	if s := r.Steps["flaky"]; s.Status != StatusSucceeded || s.Attempts != 3 || string(s.Output) != `"ok"` {
+		t.Errorf("unexpected flaky step %+v", s)
+	}
+	if s := r.Steps["broken"]; s.Status != StatusFailed || s.Attempts != 2 || s.Error != "boom" {
+		t.Errorf("unexpected broken step %+v", s)
+	}
+	if r.Error != "step broken: boom" {
+		t.Errorf("unexpected error %q", r.Error)
+	}
+	if _, ok := r.Steps["never"]; ok || len(f.calls("never")) != 0 {
+		t.Error("expected the steps after the failure not to run")
+	}
+}
+
+// TestEngine_ParallelFailure verifies a failed branch stops the other
+// branches
+func TestEngine_ParallelFailure(t *testing.T) {
+	f := newFakeFunctions(map[string]func(ctx context.Context, input string) (string, error){
+		"slow": block,
+		"broken": func(ctx context.Context, input string) (string, error) {
+			return "", errors.New("boom")
+		},
+	})
+	e, err := NewEngine("", gklog.NewNopLogger(), f.invoke)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	r, err := e.Start(parseWorkflow(t, `{"steps": [{"name": "both", "parallel": [
+		{"name": "slow", "function": "slow"},
+		{"name": "later", "steps": [{"name": "wait", "function": "slow", "when": "$.input"}, {"name": "broken", "function": "broken"}]}
+	]}]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = waitFor(t, e, r.ID, StatusFailed)
+	for name, status := range map[string]string{
+		"both":   StatusFailed,
+		"slow":   StatusCanceled,
+		"later":  StatusFailed,
+		"wait":   StatusSkipped,
+		"broken": StatusFailed,
+	} {
+		if s := r.Steps[name]; s == nil || s.Status != status {
+			t.Errorf("expected step %s %s got %+v", name, status, s)
+		}
+	}
+}
+
+// TestEngine_Resume verifies runs left unfinished by a restart are
+// resumed without running their finished steps again
+func TestEngine_Resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workflows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := parseWorkflow(t, `{"steps": [
+		{"name": "first", "function": "first"},
+		{"name": "second", "function": "second", "input": {"from": "$.steps.first"}}
+	]}`)
+	f := newFakeFunctions(map[string]func(ctx context.Context, input string) (string, error){
+		"first":  func(ctx context.Context, input string) (string, error) { return "1", nil },
+		"second": block,
+	})
+	e, err := NewEngine(dir, gklog.NewNopLogger(), f.invoke)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := e.Start(w, json.RawMessage(`"go"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStep(t, e, r.ID, "second")
+	e.Close()
+
+	f.fns["second"] = echo
+	if e, err = NewEngine(dir, gklog.NewNopLogger(), f.invoke); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if s, _ := e.Get(r.ID); s.Status != StatusRunning {
+		t.Fatalf("expected the run to be left running got %s", s.Status)
+	}
+	e.Resume()
+	r = waitFor(t, e, r.ID, StatusSucceeded)
+	if calls := f.calls("first"); len(calls) != 1 || calls[0] != `"go"` {
+		t.Errorf("expected first to run once got %v", calls)
+	}
+	if string(r.Output) != `{"from":1}` {
+		t.Errorf("unexpected output %s", r.Output)
+	}
+	if calls := f.calls("second"); len(calls) != 2 || calls[1] != `{"from":1}` {
+		t.Errorf("unexpected second inputs %v", calls)
+	}
+}
+
+// TestEngine_Cancel verifies canceled runs stop and can't be canceled
+// again
+func TestEngine_Cancel(t *testing.T) {
+	f := newFakeFunctions(map[string]func(ctx context.Context, input string) (string, error){"slow": block})
+	e, err := NewEngine("", gklog.NewNopLogger(), f.invoke)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if _, err := e.Cancel("missing"); err != ErrNotFound {
+		t.Errorf("expected %v got %v", ErrNotFound, err)
+	}
+	r, err := e.Start(parseWorkflow(t, `{"steps": [{"name": "slow", "function": "slow"}, {"name": "after", "function": "slow"}]}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStep(t, e, r.ID, "slow")
+	if r, err = e.Cancel(r.ID); err != nil || r.Status != StatusCanceled {
+		t.Fatalf("expected run canceled got %v %v", r, err)
+	}
+	r = waitFor(t, e, r.ID, StatusCanceled)
+	if s := r.Steps["slow"]; s.Status != StatusCanceled || !strings.Contains(r.Error, "canceled") {
+		t.Errorf("unexpected run %+v step %+v", r, s)
+	}
+	if _, ok := r.Steps["after"]; ok {
+		t.Error("expected no steps to start after canceling")
+	}
+	if _, err := e.Cancel(r.ID); err != ErrFinished {
+		t.Errorf("expected %v got %v", ErrFinished, err)
+	}
+}
@@ -0,0 +1,149 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// segment is a step of a path, a key, an index or a wildcard
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Path is a parsed JSONPath. The subset supported is the root $
+// followed by .key, ['key'], [index], counted from the end when
+// negative, and the wildcards .* and [*]
+type Path struct {
+	raw  string
+	segs []segment
+	// multi is set for paths with a wildcard, which match a list
+	multi bool
+}
+
+// ParsePath parses the given JSONPath
+func ParsePath(s string) (*Path, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("path %q must start with $", s)
+	}
+	p := &Path{raw: s}
+	rest := s[1:]
+	for rest != "" {
+		var seg segment
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			n := 0
+			for n < len(rest) && rest[n] != '.' && rest[n] != '[' {
+				n++
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("path %q has an empty key", s)
+			}
+			if rest[:n] == "*" {
+				seg.wildcard = true
+			} else {
+				seg.key = rest[:n]
+			}
+			rest = rest[n:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", s)
+			}
+			in := rest[1:end]
+			// a quoted key may hold a ]
+			if len(in) > 0 && (in[0] == '\'' || in[0] == '"') {
+				q := strings.IndexByte(rest[2:], in[0])
+				if q < 0 || len(rest) < q+4 || rest[q+3] != ']' {
+					return nil, fmt.Errorf("path %q has an unclosed quote", s)
+				}
+				seg.key, end = rest[2:q+2], q+3
+			} else if in == "*" {
+				seg.wildcard = true
+			} else {
+				i, err := strconv.Atoi(in)
+				if err != nil {
+					return nil, fmt.Errorf("path %q has an invalid index %q", s, in)
+				}
+				seg.index, seg.isIndex = i, true
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q has an unexpected %q", s, rest[0])
+		}
+		p.multi = p.multi || seg.wildcard
+		p.segs = append(p.segs, seg)
+	}
+	return p, nil
+}
+
+// String returns the path as it was given
+func (p *Path) String() string {
+	return p.raw
+}
+
+// Query returns the value at the path in the given document, decoded
+// JSON. Paths with a wildcard return the list of values they match
+func (p *Path) Query(doc interface{}) (interface{}, error) {
+	matches := match(doc, p.segs)
+	if p.multi {
+		if matches == nil {
+			matches = []interface{}{}
+		}
+		return matches, nil
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no value matches path %s", p.raw)
+	}
+	return matches[0], nil
+}
+
+// match returns the values the given segments match in v
+func match(v interface{}, segs []segment) []interface{} {
+	if len(segs) == 0 {
+		return []interface{}{v}
+	}
+	seg, rest := segs[0], segs[1:]
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if seg.wildcard {
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var out []interface{}
+			for _, k := range keys {
+				out = append(out, match(t[k], rest)...)
+			}
+			return out
+		}
+		if c, ok := t[seg.key]; ok && !seg.isIndex {
+			return match(c, rest)
+		}
+	case []interface{}:
+		if seg.wildcard {
+			var out []interface{}
+			for _, c := range t {
+				out = append(out, match(c, rest)...)
+			}
+			return out
+		}
+		if !seg.isIndex {
+			return nil
+		}
+		i := seg.index
+		if i < 0 {
+			i += len(t)
+		}
+		if i >= 0 && i < len(t) {
+			return match(t[i], rest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package workflow
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestParsePath_Failure verifies invalid paths are refused
+func TestParsePath_Failure(t *testing.T) {
+	for _, p := range []string{"", "steps.a", "$.", "$..a", "$[1", "$[x]", "$['a]", "$a"} {
+		if _, err := ParsePath(p); err == nil {
+			t.Errorf("expected error for %q", p)
+		}
+	}
+}
+
+// TestPath_Query verifies paths select the values of a document
+func TestPath_Query(t *testing.T) {
+	doc, err := decode([]byte(`{"steps": {"fetch": {"urls": ["a", "b", "c"], "meta.data": {"size": 2}}, "list": [{"id": 1}, {"id": 2}, {"name": "x"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"$", ``},
+		{"$.steps.fetch.urls", `["a","b","c"]`},
+		{"$.steps.fetch.urls[1]", `"b"`},
+		{"$.steps.fetch.urls[-1]", `"c"`},
+		{"$.steps['fetch'][\"meta.data\"].size", `2`},
+		{"$.steps.list[*].id", `[1,2]`},
+		{"$.steps.fetch.*", `[{"size":2},["a","b","c"]]`},
+		{"$.steps.nothing[*]", `[]`},
+	} {
+		v, err := mustParse(t, tc.path).Query(doc)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if tc.want == "" {
+			if !reflect.DeepEqual(v, doc) {
+				t.Errorf("%s: expected the document", tc.path)
+			}
+			continue
+		}
+		if b, _ := json.Marshal(v); string(b) != tc.want {
+			t.Errorf("%s: expected %s got %s", tc.path, tc.want, b)
+		}
+	}
+	for _, p := range []string{"$.steps.missing", "$.steps.fetch.urls[3]", "$.steps.fetch.urls.x", "$.steps.list.id"} {
+		if _, err := mustParse(t, p).Query(doc); err == nil {
+			t.Errorf("expected no match for %s", p)
+		}
+	}
+}
+
+// mustParse parses the given path or fails the test
+func mustParse(t *testing.T, s string) *Path {
+	t.Helper()
+	p, err := ParsePath(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,405 @@
+// Package workflow runs chains of registered functions, feeding the
+// output of each step to the next
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Defaults of step retries
+const (
+	DefaultMaxAttempts = 1
+	DefaultBackoff     = time.Second
+)
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Workflow is a sequence of steps. Each step is given the output of
+// the one before it, the first the workflow's input, unless it maps
+// its input from the document {"input": ..., "steps": {name: output}}
+// with JSONPath. The workflow's output is the value at Output in the
+// document once every step has finished, the last output by default
+type Workflow struct {
+	Name   string  `json:"name,omitempty"`
+	Steps  []*Step `json:"steps"`
+	Output string  `json:"output,omitempty"`
+}
+
+// Step runs a function, the branches of Parallel at once or a nested
+// sequence of Steps. Steps whose When condition doesn't hold are
+// skipped, passing their input on. Function steps with ForEach are
+// run for each value of the list it matches, up to Concurrency at
+// once, with the value at $.item and its index at $.index, and output
+// the list of results
+type Step struct {
+	Name        string          `json:"name"`
+	Function    string          `json:"function,omitempty"`
+	Input       json.RawMessage `json:"input,omitempty"`
+	When        string          `json:"when,omitempty"`
+	ForEach     string          `json:"for_each,omitempty"`
+	Concurrency int             `json:"concurrency,omitempty"`
+	Parallel    []*Step         `json:"parallel,omitempty"`
+	Steps       []*Step         `json:"steps,omitempty"`
+	Retry       *Retry          `json:"retry,omitempty"`
+}
+
+// Retry runs a failed function step again, up to MaxAttempts times in
+// all, waiting Backoff before the first retry and doubling it after
+type Retry struct {
+	MaxAttempts int    `json:"max_attempts"`
+	Backoff     string `json:"backoff,omitempty"`
+}
+
+// Validate checks the workflow's steps, that their names are unique
+// and their paths and conditions parse
+func (w *Workflow) Validate() error {
+	if len(w.Steps) == 0 {
+		return errors.New("workflow steps required")
+	}
+	if w.Output != "" {
+		if _, err := ParsePath(w.Output); err != nil {
+			return err
+		}
+	}
+	return validateSteps(w.Steps, make(map[string]bool))
+}
+
+// validateSteps validates the given steps and those they contain,
+// recording their names in seen
+func validateSteps(steps []*Step, seen map[string]bool) error {
+	for _, s := range steps {
+		if !nameRegexp.MatchString(s.Name) {
+			return fmt.Errorf("invalid step name %q", s.Name)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate step name %q", s.Name)
+		}
+		seen[s.Name] = true
+		kinds := 0
+		for _, set := range []bool{s.Function != "", len(s.Parallel) > 0, len(s.Steps) > 0} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return fmt.Errorf("step %s must have exactly one of function, parallel and steps", s.Name)
+		}
+		if s.Function == "" && (len(s.Input) > 0 || s.ForEach != "" || s.Retry != nil) {
+			return fmt.Errorf("step %s: input, for_each and retry require a function", s.Name)
+		}
+		if s.When != "" {
+			if _, err := parseCondition(s.When); err != nil {
+				return fmt.Errorf("step %s: %v", s.Name, err)
+			}
+		}
+		if s.ForEach != "" {
+			if _, err := ParsePath(s.ForEach); err != nil {
+				return fmt.Errorf("step %s: %v", s.Name, err)
+			}
+		}
+		if s.Concurrency < 0 {
+			return fmt.Errorf("step %s: concurrency can't be negative", s.Name)
+		}
+		if len(s.Input) > 0 {
+			if _, err := newTemplate(s.Input); err != nil {
+				return fmt.Errorf("step %s: %v", s.Name, err)
+			}
+		}
+		if s.Retry != nil {
+			if _, _, err := s.Retry.policy(); err != nil {
+				return fmt.Errorf("step %s: %v", s.Name, err)
+			}
+		}
+		if err := validateSteps(s.Parallel, seen); err != nil {
+			return err
+		}
+		if err := validateSteps(s.Steps, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Functions returns the names of the functions the workflow runs
+func (w *Workflow) Functions() []string {
+	var fns []string
+	seen := make(map[string]bool)
+	var walk func(steps []*Step)
+	walk = func(steps []*Step) {
+		for _, s := range steps {
+			if s.Function != "" && !seen[s.Function] {
+				seen[s.Function] = true
+				fns = append(fns, s.Function)
+			}
+			walk(s.Parallel)
+			walk(s.Steps)
+		}
+	}
+	walk(w.Steps)
+	return fns
+}
+
+// policy returns the attempts and first backoff of the retry
+func (r *Retry) policy() (int, time.Duration, error) {
+	if r == nil {
+		return DefaultMaxAttempts, DefaultBackoff, nil
+	}
+	if r.MaxAttempts < 1 {
+		return 0, 0, errors.New("retry max_attempts must be at least 1")
+	}
+	backoff := DefaultBackoff
+	if r.Backoff != "" {
+		var err error
+		if backoff, err = time.ParseDuration(r.Backoff); err != nil || backoff <= 0 {
+			return 0, 0, fmt.Errorf("invalid retry backoff %q", r.Backoff)
+		}
+	}
+	return r.MaxAttempts, backoff, nil
+}
+
+// decode decodes the given JSON keeping numbers as they're written
+func decode(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// template is a step input whose strings starting with $ are replaced
+// by the values at those paths
+type template struct {
+	v interface{}
+}
+
+// newTemplate parses the given input, checking its paths
+func newTemplate(b json.RawMessage) (*template, error) {
+	v, err := decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %v", err)
+	}
+	var check func(v interface{}) error
+	check = func(v interface{}) error {
+		switch t := v.(type) {
+		case string:
+			if strings.HasPrefix(t, "$") {
+				_, err := ParsePath(t)
+				return err
+			}
+		case map[string]interface{}:
+			for _, c := range t {
+				if err := check(c); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, c := range t {
+				if err := check(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := check(v); err != nil {
+		return nil, err
+	}
+	return &template{v: v}, nil
+}
+
+// render returns the input with its paths replaced by their values in
+// the given document
+func (t *template) render(doc interface{}) (interface{}, error) {
+	var walk func(v interface{}) (interface{}, error)
+	walk = func(v interface{}) (interface{}, error) {
+		switch t := v.(type) {
+		case string:
+			if !strings.HasPrefix(t, "$") {
+				return t, nil
+			}
+			p, err := ParsePath(t)
+			if err != nil {
+				return nil, err
+			}
+			return p.Query(doc)
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(t))
+			for k, c := range t {
+				r, err := walk(c)
+				if err != nil {
+					return nil, err
+				}
+				out[k] = r
+			}
+			return out, nil
+		case []interface{}:
+			out := make([]interface{}, len(t))
+			for i, c := range t {
+				r, err := walk(c)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = r
+			}
+			return out, nil
+		}
+		return v, nil
+	}
+	return walk(t.v)
+}
+
+// condition is a parsed When of a step, a path alone, which holds if
+// the value there is truthy, or a path compared to a JSON value with
+// ==, !=, <, <=, > or >=
+type condition struct {
+	path  *Path
+	op    string
+	value interface{}
+}
+
+// parseCondition parses the given condition
+func parseCondition(s string) (*condition, error) {
+	path, rest := cut(strings.TrimSpace(s))
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &condition{path: p}
+	if rest == "" {
+		return c, nil
+	}
+	op, value := cut(rest)
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		c.op = op
+	default:
+		return nil, fmt.Errorf("invalid condition operator %q", op)
+	}
+	if c.value, err = decode([]byte(value)); err != nil {
+		return nil, fmt.Errorf("invalid condition value %q", value)
+	}
+	return c, nil
+}
+
+// cut splits the given string at its first space, trimming the rest
+func cut(s string) (string, string) {
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// eval reports whether the condition holds in the given document. A
+// path that doesn't match is null
+func (c *condition) eval(doc interface{}) bool {
+	v, err := c.path.Query(doc)
+	if err != nil {
+		v = nil
+	}
+	v, want := normalize(v), normalize(c.value)
+	switch c.op {
+	case "":
+		return truthy(v)
+	case "==":
+		return reflect.DeepEqual(v, want)
+	case "!=":
+		return !reflect.DeepEqual(v, want)
+	}
+	cmp, ok := compare(v, want)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// normalize returns the value with its numbers as float64s so values
+// written differently, such as 1 and 1.0, are equal
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return t.String()
+		}
+		return f
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, c := range t {
+			out[k] = normalize(c)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, c := range t {
+			out[i] = normalize(c)
+		}
+		return out
+	}
+	return v
+}
+
+// compare orders two numbers or two strings
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+// truthy reports whether the value is set, anything but null, false,
+// 0, "" and empty lists and objects
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package workflow
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestValidate_Failure verifies workflows with invalid steps are
+// refused
+func TestValidate_Failure(t *testing.T) {
+	for name, w := range map[string]*Workflow{
+		"no-steps":       {},
+		"bad-name":       {Steps: []*Step{{Name: "a b", Function: "f"}}},
+		"duplicate":      {Steps: []*Step{{Name: "a", Function: "f"}, {Name: "p", Parallel: []*Step{{Name: "a", Function: "f"}}}}},
+		"no-kind":        {Steps: []*Step{{Name: "a"}}},
+		"two-kinds":      {Steps: []*Step{{Name: "a", Function: "f", Steps: []*Step{{Name: "b", Function: "f"}}}}},
+		"parallel-input": {Steps: []*Step{{Name: "a", Input: json.RawMessage(`1`), Parallel: []*Step{{Name: "b", Function: "f"}}}}},
+		"bad-when":       {Steps: []*Step{{Name: "a", Function: "f", When: "$.input.n ~ 1"}}},
+		"bad-when-value": {Steps: []*Step{{Name: "a", Function: "f", When: "$.input.n == one"}}},
+		"bad-for-each":   {Steps: []*Step{{Name: "a", Function: "f", ForEach: "items"}}},
+		"bad-input":      {Steps: []*Step{{Name: "a", Function: "f", Input: json.RawMessage(`{"x": "$.steps["}`)}}},
+		"bad-retry":      {Steps: []*Step{{Name: "a", Function: "f", Retry: &Retry{MaxAttempts: 0}}}},
+		"bad-backoff":    {Steps: []*Step{{Name: "a", Function: "f", Retry: &Retry{MaxAttempts: 2, Backoff: "soon"}}}},
+		"bad-output":     {Steps: []*Step{{Name: "a", Function: "f"}}, Output: "steps"},
+		"nested":         {Steps: []*Step{{Name: "a", Steps: []*Step{{Name: "b"}}}}},
+	} {
+		if err := w.Validate(); err == nil {
+			t.Errorf("%s: expected error but received none", name)
+		}
+	}
+}
+
+// TestWorkflow_Functions verifies the functions of nested steps are
+// listed once
+func TestWorkflow_Functions(t *testing.T) {
+	w := &Workflow{Steps: []*Step{
+		{Name: "a", Function: "fetch"},
+		{Name: "b", Parallel: []*Step{
+			{Name: "c", Function: "resize"},
+			{Name: "d", Steps: []*Step{{Name: "e", Function: "fetch"}, {Name: "f", Function: "store"}}},
+		}},
+	}}
+	if err := w.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if fns := w.Functions(); !reflect.DeepEqual(fns, []string{"fetch", "resize", "store"}) {
+		t.Errorf("unexpected functions %v", fns)
+	}
+}
+
+// TestCondition verifies conditions compare the value at their path
+func TestCondition(t *testing.T) {
+	doc, err := decode([]byte(`{"input": {"n": 3, "name": "bob", "ok": true, "tags": [], "big": 12345678901234567890}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for expr, want := range map[string]bool{
+		"$.input.ok":                          true,
+		"$.input.tags":                        false,
+		"$.input.missing":                     false,
+		"$.input.n == 3":                      true,
+		"$.input.n == 3.0":                    true,
+		"$.input.n != 3":                      false,
+		"$.input.n  >   2":                    true,
+		"$.input.n <= 2":                      false,
+		"$.input.n >= 3":                      true,
+		"$.input.n < \"4\"":                   false,
+		"$.input.name == \"bob\"":             true,
+		"$.input.name > \"alice\"":            true,
+		"$.input.missing == null":             true,
+		"$.input.tags == []":                  true,
+		"$.input.big == 12345678901234567890": true,
+	} {
+		c, err := parseCondition(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if got := c.eval(doc); got != want {
+			t.Errorf("%s: expected %v got %v", expr, want, got)
+		}
+	}
+}
+
+// TestTemplate verifies strings starting with $ in step inputs are
+// replaced by the values at those paths
+func TestTemplate(t *testing.T) {
+	doc, err := decode([]byte(`{"input": {"size": 200}, "steps": {"fetch": {"url": "http://example.com/cat.png"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := newTemplate(json.RawMessage(`{"src": "$.steps.fetch.url", "sizes": ["$.input.size", 400], "mode": "fit"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := tmpl.render(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := json.Marshal(v); string(b) != `{"mode":"fit","sizes":[200,400],"src":"http://example.com/cat.png"}` {
+		t.Errorf("unexpected input %s", b)
+	}
+	tmpl, _ = newTemplate(json.RawMessage(`"$.steps.resize"`))
+	if _, err := tmpl.render(doc); err == nil {
+		t.Error("expected error for a path without a value")
+	}
+}